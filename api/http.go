@@ -12,10 +12,16 @@ import (
 )
 
 func NewHTTPClient(logger Logger, transport http.RoundTripper) (*http.Client, error) {
+	return NewHTTPClientWithRetries(logger, transport, 3)
+}
+
+// NewHTTPClientWithRetries is NewHTTPClient retrying requests failing with temporary errors, 502 or 503
+// at most maxRetries times
+func NewHTTPClientWithRetries(logger Logger, transport http.RoundTripper, maxRetries int) (*http.Client, error) {
 	retryTransport := rehttp.NewTransport(
 		transport,
 		rehttp.RetryAll(
-			rehttp.RetryMaxRetries(3),
+			rehttp.RetryMaxRetries(maxRetries),
 			rehttp.RetryAny(
 				rehttp.RetryTemporaryErr(),
 				rehttp.RetryStatuses(502, 503),
@@ -47,6 +53,14 @@ type contextKey struct {
 
 var contextKeyRequestStart = &contextKey{"RequestStart"}
 
+var contextKeyLoggedBody = &contextKey{"LoggedBody"}
+
+// WithLoggedBody makes the debug logs of the requests made with ctx show their body as redact returns it,
+// the requests are still sent with their own body
+func WithLoggedBody(ctx context.Context, redact func(body []byte) []byte) context.Context {
+	return context.WithValue(ctx, contextKeyLoggedBody, redact)
+}
+
 func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := context.WithValue(req.Context(), contextKeyRequestStart, time.Now())
 	req = req.WithContext(ctx)
@@ -76,6 +90,8 @@ func (t *LoggingTransport) logRequest(req *http.Request) {
 
 	if err != nil {
 		t.Logger.Debug("error reading request body:", err)
+	} else if redact, ok := req.Context().Value(contextKeyLoggedBody).(func([]byte) []byte); ok {
+		t.Logger.Debug(string(redact(data)))
 	} else {
 		t.Logger.Debug(string(data))
 	}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type bufferLogger struct {
+	bytes.Buffer
+}
+
+func (l *bufferLogger) Debug(v ...interface{}) {
+	fmt.Fprintln(l, v...)
+}
+
+func (l *bufferLogger) Debugf(format string, v ...interface{}) {
+	fmt.Fprintf(l, format, v...)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestLoggingTransportRedactsLoggedBody(t *testing.T) {
+	var sent string
+	logger := &bufferLogger{}
+	transport := &LoggingTransport{
+		Logger: logger,
+		InnerTransport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			sent = string(body)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+		}),
+	}
+
+	ctx := WithLoggedBody(context.Background(), func(body []byte) []byte {
+		return bytes.ReplaceAll(body, []byte("hunter2"), []byte("[redacted]"))
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.fly.io/graphql", strings.NewReader(`{"password":"hunter2"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent != `{"password":"hunter2"}` {
+		t.Fatalf("expected the request to be sent with its body, got %s", sent)
+	}
+	if strings.Contains(logger.String(), "hunter2") || !strings.Contains(logger.String(), `{"password":"[redacted]"}`) {
+		t.Fatalf("expected the logged body to be redacted, got %s", logger.String())
+	}
+}
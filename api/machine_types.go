@@ -14,6 +14,14 @@ const (
 	MachineConfigMetadataKeyFlyReleaseVersion  = "fly_release_version"
 	MachineConfigMetadataKeyFlyProcessGroup    = "fly_process_group"
 	MachineConfigMetadataKeyFlyPreviousAlloc   = "fly_previous_alloc"
+	MachineConfigMetadataKeyFlyTomlKeys        = "fly_toml_metadata_keys"
+	MachineConfigMetadataKeyFlyTomlDNS         = "fly_toml_dns"
+	MachineConfigMetadataKeyFlyTomlEnvKeys     = "fly_toml_env_keys"
+	MachineConfigMetadataKeyFlyTomlStopConfig  = "fly_toml_stop_config"
+	MachineConfigMetadataKeyFlyPreviousImage   = "fly_previous_image"
+	MachineConfigMetadataKeyFlyPreviousConfig  = "fly_previous_config"
+	MachineConfigMetadataKeyFlyPreviousHash    = "fly_previous_config_hash"
+	MachineConfigMetadataKeyFlyDeployedConfig  = "fly_deployed_config"
 	MachineFlyPlatformVersion2                 = "v2"
 	MachineProcessGroupApp                     = "app"
 	MachineProcessGroupFlyAppReleaseCommand    = "fly_app_release_command"
@@ -229,6 +237,19 @@ type RemoveMachineInput struct {
 	Kill bool `json:"kill,omitempty"`
 }
 
+// File is written into the machine at GuestPath, either from RawValue (base64 encoded) or an app secret
+type File struct {
+	GuestPath  string  `json:"guest_path,omitempty"`
+	RawValue   *string `json:"raw_value,omitempty"`
+	SecretName *string `json:"secret_name,omitempty"`
+}
+
+// StopConfig sets how the machine main process is stopped
+type StopConfig struct {
+	Timeout *Duration `json:"timeout,omitempty"`
+	Signal  *string   `json:"signal,omitempty"`
+}
+
 type MachineRestartPolicy string
 
 var (
@@ -251,10 +272,45 @@ type MachineMount struct {
 	Name      string `json:"name,omitempty"`
 }
 
+// MachineVolume is a volume as the Machines API lists it
+type MachineVolume struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	State             string    `json:"state"`
+	SizeGb            int       `json:"size_gb"`
+	Region            string    `json:"region"`
+	Zone              string    `json:"zone"`
+	Encrypted         bool      `json:"encrypted"`
+	AttachedMachineID *string   `json:"attached_machine_id"`
+	AttachedAllocID   *string   `json:"attached_alloc_id"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// ToVolume converts v to the Volume of the GraphQL API
+func (v MachineVolume) ToVolume() Volume {
+	vol := Volume{
+		ID:        v.ID,
+		Name:      v.Name,
+		State:     v.State,
+		SizeGb:    v.SizeGb,
+		Region:    v.Region,
+		Encrypted: v.Encrypted,
+		CreatedAt: v.CreatedAt,
+	}
+	if v.AttachedMachineID != nil && *v.AttachedMachineID != "" {
+		vol.AttachedMachine = &GqlMachine{ID: *v.AttachedMachineID}
+	}
+	if v.AttachedAllocID != nil && *v.AttachedAllocID != "" {
+		vol.AttachedAllocation = &AllocationStatus{ID: *v.AttachedAllocID}
+	}
+	return vol
+}
+
 type MachineGuest struct {
 	CPUKind  string `json:"cpu_kind,omitempty"`
 	CPUs     int    `json:"cpus,omitempty"`
 	MemoryMB int    `json:"memory_mb,omitempty"`
+	GPUKind  string `json:"gpu_kind,omitempty"`
 
 	KernelArgs []string `json:"kernel_args,omitempty"`
 }
@@ -268,8 +324,10 @@ func (mg *MachineGuest) SetSize(size string) error {
 			machine_type = "shared"
 		} else if strings.HasPrefix(size, "performance") {
 			machine_type = "performance"
+		} else if strings.HasPrefix(size, "a100") {
+			machine_type = "a100"
 		} else {
-			return fmt.Errorf("invalid machine preset requested, '%s', expected to start with 'shared' or 'performance'", size)
+			return fmt.Errorf("invalid machine preset requested, '%s', expected to start with 'shared', 'performance' or 'a100'", size)
 		}
 
 		validSizes := []string{}
@@ -285,6 +343,7 @@ func (mg *MachineGuest) SetSize(size string) error {
 	mg.CPUs = guest.CPUs
 	mg.CPUKind = guest.CPUKind
 	mg.MemoryMB = guest.MemoryMB
+	mg.GPUKind = guest.GPUKind
 	return nil
 }
 
@@ -293,6 +352,14 @@ func (mg *MachineGuest) ToSize() string {
 	if mg == nil {
 		return ""
 	}
+	if mg.GPUKind != "" {
+		for size, preset := range MachinePresets {
+			if preset.GPUKind == mg.GPUKind {
+				return size
+			}
+		}
+		return "unknown"
+	}
 	switch mg.CPUKind {
 	case "shared":
 		return fmt.Sprintf("shared-cpu-%dx", mg.CPUs)
@@ -323,6 +390,16 @@ var MachinePresets map[string]*MachineGuest = map[string]*MachineGuest{
 	"performance-4x":  {CPUKind: "performance", CPUs: 4, MemoryMB: 4 * MIN_MEMORY_MB_PER_CPU},
 	"performance-8x":  {CPUKind: "performance", CPUs: 8, MemoryMB: 8 * MIN_MEMORY_MB_PER_CPU},
 	"performance-16x": {CPUKind: "performance", CPUs: 16, MemoryMB: 16 * MIN_MEMORY_MB_PER_CPU},
+
+	"a100-40gb": {GPUKind: "a100-pcie-40gb", CPUKind: "performance", CPUs: 8, MemoryMB: 16 * MIN_MEMORY_MB_PER_CPU},
+	"a100-80gb": {GPUKind: "a100-sxm4-80gb", CPUKind: "performance", CPUs: 8, MemoryMB: 16 * MIN_MEMORY_MB_PER_CPU},
+}
+
+// MachineGPURegions lists the regions having hosts with each GPU kind, machines with a GPU can
+// only run there
+var MachineGPURegions = map[string][]string{
+	"a100-pcie-40gb": {"ord"},
+	"a100-sxm4-80gb": {"ams", "iad", "mia", "sjc", "syd"},
 }
 
 type MachineMetrics struct {
@@ -418,13 +495,15 @@ type TlsOptions struct {
 }
 
 type MachineService struct {
-	Protocol     string                     `json:"protocol,omitempty" toml:"protocol,omitempty"`
-	InternalPort int                        `json:"internal_port,omitempty" toml:"internal_port,omitempty"`
-	Autostop     *bool                      `json:"autostop,omitempty"`
-	Autostart    *bool                      `json:"autostart,omitempty"`
-	Ports        []MachinePort              `json:"ports,omitempty" toml:"ports,omitempty"`
-	Checks       []MachineCheck             `json:"checks,omitempty" toml:"checks,omitempty"`
-	Concurrency  *MachineServiceConcurrency `json:"concurrency,omitempty" toml:"concurrency"`
+	Protocol     string `json:"protocol,omitempty" toml:"protocol,omitempty"`
+	InternalPort int    `json:"internal_port,omitempty" toml:"internal_port,omitempty"`
+	Autostop     *bool  `json:"autostop,omitempty"`
+	Autostart    *bool  `json:"autostart,omitempty"`
+	// MinMachinesRunning keeps that many machines running when autostop is enabled
+	MinMachinesRunning *int                       `json:"min_machines_running,omitempty"`
+	Ports              []MachinePort              `json:"ports,omitempty" toml:"ports,omitempty"`
+	Checks             []MachineCheck             `json:"checks,omitempty" toml:"checks,omitempty"`
+	Concurrency        *MachineServiceConcurrency `json:"concurrency,omitempty" toml:"concurrency"`
 }
 
 type MachineServiceConcurrency struct {
@@ -444,6 +523,9 @@ type MachineConfig struct {
 	Metrics  *MachineMetrics         `json:"metrics,omitempty"`
 	Checks   map[string]MachineCheck `json:"checks,omitempty"`
 	Statics  []*Static               `json:"statics,omitempty"`
+	Files    []*File                 `json:"files,omitempty"`
+
+	StopConfig *StopConfig `json:"stop_config,omitempty"`
 
 	// Set by fly deploy or fly machines commands
 	Image string `json:"image,omitempty"`
@@ -501,7 +583,9 @@ type MachineInit struct {
 }
 
 type DNSConfig struct {
-	SkipRegistration bool `json:"skip_registration,omitempty"`
+	SkipRegistration bool     `json:"skip_registration,omitempty" toml:"skip_registration,omitempty"`
+	Nameservers      []string `json:"nameservers,omitempty" toml:"nameservers,omitempty"`
+	Searches         []string `json:"searches,omitempty" toml:"searches,omitempty"`
 }
 
 type MachineLease struct {
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateSignal returns the normalized name of signal, or an error if machines can't be sent it
+func ValidateSignal(signal string) (string, error) {
+	sig := strings.ToUpper(signal)
+	if !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	if _, ok := signalSyscallMap[sig]; !ok {
+		return "", fmt.Errorf("invalid signal %s", signal)
+	}
+	return sig, nil
+}
+
+var signalSyscallMap = map[string]struct{}{
+	"SIGABRT": {},
+	"SIGALRM": {},
+	"SIGFPE":  {},
+	"SIGHUP":  {},
+	"SIGILL":  {},
+	"SIGINT":  {},
+	"SIGKILL": {},
+	"SIGPIPE": {},
+	"SIGQUIT": {},
+	"SIGSEGV": {},
+	"SIGTERM": {},
+	"SIGTRAP": {},
+	"SIGUSR1": {},
+	"SIGUSR2": {},
+}
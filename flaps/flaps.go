@@ -10,9 +10,11 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/go-querystring/query"
@@ -37,17 +39,63 @@ type Client struct {
 	authToken  string
 	httpClient *http.Client
 	userAgent  string
+	// requestTimeout bounds each request, waits on machines get their own deadline
+	requestTimeout time.Duration
+	// rateLimited counts the requests answered with 429 Too Many Requests
+	rateLimited atomic.Int64
+	// connsEstablished and connsReused count the connections requests got from the transport
+	connsEstablished atomic.Int64
+	connsReused      atomic.Int64
+}
+
+// newTransport returns a transport keeping connections alive between requests. Deployments run many
+// machine operations concurrently, the idle pool is sized so they don't pay connection setup again
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 32
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// RequestOptions tune the requests of a client
+type RequestOptions struct {
+	// Timeout bounds each request, zero doesn't bound them. Long polls waiting on a machine state
+	// get the time they wait on top of it
+	Timeout time.Duration
+	// MaxRetries is how many times requests failing with temporary errors, 502 or 503 are retried,
+	// zero defaults to 3 and negative values disable retries
+	MaxRetries int
+}
+
+const defaultMaxRetries = 3
+
+// EffectiveMaxRetries returns how many times requests are retried, with the default applied
+func (o RequestOptions) EffectiveMaxRetries() int {
+	switch {
+	case o.MaxRetries < 0:
+		return 0
+	case o.MaxRetries == 0:
+		return defaultMaxRetries
+	default:
+		return o.MaxRetries
+	}
 }
 
 func New(ctx context.Context, app *api.AppCompact) (*Client, error) {
-	return newFromAppOrAppName(ctx, app, app.Name)
+	return newFromAppOrAppName(ctx, app, app.Name, RequestOptions{})
+}
+
+// NewWithRequestOptions is New with requests tuned by opts
+func NewWithRequestOptions(ctx context.Context, app *api.AppCompact, opts RequestOptions) (*Client, error) {
+	return newFromAppOrAppName(ctx, app, app.Name, opts)
 }
 
 func NewFromAppName(ctx context.Context, appName string) (*Client, error) {
-	return newFromAppOrAppName(ctx, nil, appName)
+	return newFromAppOrAppName(ctx, nil, appName, RequestOptions{})
 }
 
-func newFromAppOrAppName(ctx context.Context, app *api.AppCompact, appName string) (*Client, error) {
+func newFromAppOrAppName(ctx context.Context, app *api.AppCompact, appName string, opts RequestOptions) (*Client, error) {
 	if app != nil {
 		appName = app.Name
 	}
@@ -61,7 +109,7 @@ func newFromAppOrAppName(ctx context.Context, app *api.AppCompact, appName strin
 		if err != nil {
 			return nil, fmt.Errorf("failed to get app '%s': %w", appName, err)
 		}
-		return newWithUsermodeWireguard(ctx, app)
+		return newWithUsermodeWireguard(ctx, app, opts)
 	} else if flapsBaseURL == "" {
 		flapsBaseURL = "https://api.machines.dev"
 	}
@@ -69,17 +117,51 @@ func newFromAppOrAppName(ctx context.Context, app *api.AppCompact, appName strin
 	if err != nil {
 		return nil, fmt.Errorf("invalid FLY_FLAPS_BASE_URL '%s' with error: %w", flapsBaseURL, err)
 	}
-	logger := logger.MaybeFromContext(ctx)
-	httpClient, err := api.NewHTTPClient(logger, http.DefaultTransport)
+	return NewWithOptions(ctx, NewClientOpts{AppName: appName, BaseURL: flapsUrl, RequestOptions: opts})
+}
+
+// NewClientOpts sets up clients talking to another flaps endpoint or through another transport,
+// like proxied connections or recorded fixtures
+type NewClientOpts struct {
+	AppName string
+	// BaseURL defaults to https://api.machines.dev
+	BaseURL *url.URL
+	// Transport defaults to a transport keeping connections alive, shared by the requests of the client
+	Transport http.RoundTripper
+	// AuthToken defaults to the API token of flyctl
+	AuthToken string
+	RequestOptions
+}
+
+func NewWithOptions(ctx context.Context, opts NewClientOpts) (*Client, error) {
+	flapsUrl := opts.BaseURL
+	if flapsUrl == nil {
+		flapsUrl, _ = url.Parse("https://api.machines.dev")
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = newTransport()
+	}
+	authToken := opts.AuthToken
+	if authToken == "" {
+		authToken = flyctl.GetAPIToken()
+	}
+	l := logger.MaybeFromContext(ctx)
+	if l == nil {
+		// Contexts of tests and embedders may come without logger
+		l = logger.FromEnv(io.Discard)
+	}
+	httpClient, err := api.NewHTTPClientWithRetries(l, transport, opts.EffectiveMaxRetries())
 	if err != nil {
 		return nil, fmt.Errorf("flaps: can't setup HTTP client to %s: %w", flapsUrl.String(), err)
 	}
 	return &Client{
-		appName:    appName,
-		baseUrl:    flapsUrl,
-		authToken:  flyctl.GetAPIToken(),
-		httpClient: httpClient,
-		userAgent:  strings.TrimSpace(fmt.Sprintf("fly-cli/%s", buildinfo.Version())),
+		appName:        opts.AppName,
+		baseUrl:        flapsUrl,
+		authToken:      authToken,
+		httpClient:     httpClient,
+		userAgent:      strings.TrimSpace(fmt.Sprintf("fly-cli/%s", buildinfo.Version())),
+		requestTimeout: opts.Timeout,
 	}, nil
 }
 
@@ -92,7 +174,7 @@ func resolveApp(ctx context.Context, app *api.AppCompact, appName string) (*api.
 	return app, err
 }
 
-func newWithUsermodeWireguard(ctx context.Context, app *api.AppCompact) (*Client, error) {
+func newWithUsermodeWireguard(ctx context.Context, app *api.AppCompact, opts RequestOptions) (*Client, error) {
 	logger := logger.MaybeFromContext(ctx)
 
 	client := client.FromContext(ctx).API()
@@ -106,13 +188,13 @@ func newWithUsermodeWireguard(ctx context.Context, app *api.AppCompact) (*Client
 		return nil, fmt.Errorf("flaps: can't build tunnel for %s: %w", app.Organization.Slug, err)
 	}
 
-	transport := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.DialContext(ctx, network, addr)
-		},
+	transport := newTransport()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
 	}
 
-	httpClient, err := api.NewHTTPClient(logger, transport)
+	httpClient, err := api.NewHTTPClientWithRetries(logger, transport, opts.EffectiveMaxRetries())
 	if err != nil {
 		return nil, fmt.Errorf("flaps: can't setup HTTP client for %s: %w", app.Organization.Slug, err)
 	}
@@ -124,11 +206,12 @@ func newWithUsermodeWireguard(ctx context.Context, app *api.AppCompact) (*Client
 	}
 
 	return &Client{
-		appName:    app.Name,
-		baseUrl:    flapsBaseUrl,
-		authToken:  flyctl.GetAPIToken(),
-		httpClient: httpClient,
-		userAgent:  strings.TrimSpace(fmt.Sprintf("fly-cli/%s", buildinfo.Version())),
+		appName:        app.Name,
+		baseUrl:        flapsBaseUrl,
+		authToken:      flyctl.GetAPIToken(),
+		httpClient:     httpClient,
+		userAgent:      strings.TrimSpace(fmt.Sprintf("fly-cli/%s", buildinfo.Version())),
+		requestTimeout: opts.Timeout,
 	}, nil
 }
 
@@ -219,6 +302,10 @@ func (f *Client) Wait(ctx context.Context, machine *api.Machine, state string, t
 		return fmt.Errorf("error making query string for wait request: %w", err)
 	}
 	waitEndpoint += fmt.Sprintf("?%s", qsVals.Encode())
+	if f.requestTimeout > 0 {
+		// The request lasts as long as the machine is waited on, it can't get the short request timeout
+		ctx = context.WithValue(ctx, requestTimeoutKey{}, timeout+f.requestTimeout)
+	}
 	if err := f.sendRequest(ctx, http.MethodGet, waitEndpoint, nil, nil, nil); err != nil {
 		return fmt.Errorf("failed to wait for VM %s in %s state: %w", machine.ID, state, err)
 	}
@@ -330,8 +417,13 @@ func (f *Client) ListFlyAppsMachines(ctx context.Context) ([]*api.Machine, *api.
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to list VMs: %w", err)
 	}
-	var releaseCmdMachine *api.Machine
-	machines := make([]*api.Machine, 0)
+	machines, releaseCmdMachine := SplitFlyAppsMachines(allMachines)
+	return machines, releaseCmdMachine, nil
+}
+
+// SplitFlyAppsMachines returns the active machines of the apps platform and its release command machine
+func SplitFlyAppsMachines(allMachines []*api.Machine) (machines []*api.Machine, releaseCmdMachine *api.Machine) {
+	machines = make([]*api.Machine, 0)
 	for _, m := range allMachines {
 		if m.IsFlyAppsPlatform() && m.IsActive() && !m.IsFlyAppsReleaseCommand() {
 			machines = append(machines, m)
@@ -339,7 +431,16 @@ func (f *Client) ListFlyAppsMachines(ctx context.Context) ([]*api.Machine, *api.
 			releaseCmdMachine = m
 		}
 	}
-	return machines, releaseCmdMachine, nil
+	return machines, releaseCmdMachine
+}
+
+// GetVolumes lists the volumes of the app, with the machines they are attached to
+func (f *Client) GetVolumes(ctx context.Context) ([]api.MachineVolume, error) {
+	out := make([]api.MachineVolume, 0)
+	if _, err := f.sendAppRequest(ctx, http.MethodGet, "/volumes", nil, &out, nil); err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	return out, nil
 }
 
 func (f *Client) Destroy(ctx context.Context, input api.RemoveMachineInput, nonce string) (err error) {
@@ -438,18 +539,50 @@ func (f *Client) Exec(ctx context.Context, machineID string, in *api.MachineExec
 	return out, nil
 }
 
+type requestTimeoutKey struct{}
+
+// requestTimeoutFor returns the timeout of the request sent with ctx, long polls set their own in it
+func (f *Client) requestTimeoutFor(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return f.requestTimeout
+}
+
 func (f *Client) sendRequest(ctx context.Context, method, endpoint string, in, out interface{}, headers map[string][]string) error {
-	req, err := f.NewRequest(ctx, method, endpoint, in, headers)
+	_, err := f.sendAppRequest(ctx, method, "/machines"+endpoint, in, out, headers)
+	return err
+}
+
+// sendAppRequest sends a request to path under the app, sendRequest sends them under its machines
+func (f *Client) sendAppRequest(ctx context.Context, method, path string, in, out interface{}, headers map[string][]string) (http.Header, error) {
+	if timeout := f.requestTimeoutFor(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := f.newAppRequest(ctx, method, path, in, headers)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", f.userAgent)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				f.connsReused.Add(1)
+			} else {
+				f.connsEstablished.Add(1)
+			}
+		},
+	}))
 
 	resp, err := f.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
+		// Connections only go back to the pool once their body is read to the end
+		_, _ = io.Copy(io.Discard, resp.Body)
 		err := resp.Body.Close()
 		if err != nil {
 			terminal.Debugf("error closing response body: %v\n", err)
@@ -461,19 +594,40 @@ func (f *Client) sendRequest(ctx context.Context, method, endpoint string, in, o
 		if err != nil {
 			responseBody = make([]byte, 0)
 		}
-		return &FlapsError{
+		if resp.StatusCode == http.StatusTooManyRequests {
+			f.rateLimited.Add(1)
+		}
+		return nil, &FlapsError{
 			OriginalError:      handleAPIError(resp.StatusCode, responseBody),
 			ResponseStatusCode: resp.StatusCode,
 			ResponseBody:       responseBody,
 			FlyRequestId:       resp.Header.Get(headerFlyRequestId),
+			RetryAfter:         parseRetryAfter(resp.Header.Get("Retry-After")),
 		}
 	}
 	if out != nil {
 		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return resp.Header, nil
+}
+
+// RateLimitedCount returns how many requests of the client were rate limited so far
+func (f *Client) RateLimitedCount() int64 {
+	if f == nil {
+		return 0
+	}
+	return f.rateLimited.Load()
+}
+
+// ConnectionStats returns how many connections the requests of the client established and how many
+// times they reused an idle one so far
+func (f *Client) ConnectionStats() (established, reused int64) {
+	if f == nil {
+		return 0, 0
+	}
+	return f.connsEstablished.Load(), f.connsReused.Load()
 }
 
 func (f *Client) urlFromBaseUrl(pathAndQueryString string) (*url.URL, error) {
@@ -486,13 +640,18 @@ func (f *Client) urlFromBaseUrl(pathAndQueryString string) (*url.URL, error) {
 }
 
 func (f *Client) NewRequest(ctx context.Context, method, path string, in interface{}, headers map[string][]string) (*http.Request, error) {
+	return f.newAppRequest(ctx, method, "/machines"+path, in, headers)
+}
+
+// newAppRequest builds a request to path under the app, like /volumes
+func (f *Client) newAppRequest(ctx context.Context, method, path string, in interface{}, headers map[string][]string) (*http.Request, error) {
 	var body io.Reader
 
 	if headers == nil {
 		headers = make(map[string][]string)
 	}
 
-	targetEndpoint, err := f.urlFromBaseUrl(fmt.Sprintf("/v1/apps/%s/machines%s", f.appName, path))
+	targetEndpoint, err := f.urlFromBaseUrl(fmt.Sprintf("/v1/apps/%s%s", f.appName, path))
 	if err != nil {
 		return nil, err
 	}
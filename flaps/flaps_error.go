@@ -1,10 +1,18 @@
 package flaps
 
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
 type FlapsError struct {
 	OriginalError      error
 	ResponseStatusCode int
 	ResponseBody       []byte
 	FlyRequestId       string
+	// RetryAfter is how long the API asked to wait before retrying, from the Retry-After header
+	RetryAfter time.Duration
 }
 
 func (fe *FlapsError) Error() string {
@@ -21,3 +29,19 @@ func (fe *FlapsError) Unwrap() error {
 func (fe *FlapsError) ResponseBodyString() string {
 	return string(fe.ResponseBody)
 }
+
+// parseRetryAfter reads a Retry-After header, either delay seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/superfly/flyctl/api"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -29,27 +30,39 @@ func NewConfig() *Config {
 // Config wraps the properties of app configuration.
 // NOTE: If you any new setting here, please also add a value for it at testdata/rull-reference.toml
 type Config struct {
-	AppName       string  `toml:"app,omitempty" json:"app,omitempty"`
-	PrimaryRegion string  `toml:"primary_region,omitempty" json:"primary_region,omitempty"`
-	KillSignal    *string `toml:"kill_signal,omitempty" json:"kill_signal,omitempty"`
-	KillTimeout   *int    `toml:"kill_timeout,omitempty" json:"kill_timeout,omitempty"`
+	AppName       string   `toml:"app,omitempty" json:"app,omitempty"`
+	PrimaryRegion string   `toml:"primary_region,omitempty" json:"primary_region,omitempty"`
+	Regions       []string `toml:"regions,omitempty" json:"regions,omitempty"`
+	KillSignal    *string  `toml:"kill_signal,omitempty" json:"kill_signal,omitempty"`
+	KillTimeout   *int     `toml:"kill_timeout,omitempty" json:"kill_timeout,omitempty"`
+
+	// MachineNamePrefix names the machines created by deploys like "<prefix>-<group>-<region>-01"
+	MachineNamePrefix string `toml:"machine_name_prefix,omitempty" json:"machine_name_prefix,omitempty"`
+	// RequiredSecrets lists the secrets that must be set for the app before deploying it
+	RequiredSecrets []string `toml:"required_secrets,omitempty" json:"required_secrets,omitempty"`
 
 	// Sections that are typically short and benefit from being on top
 	Experimental *Experimental     `toml:"experimental,omitempty" json:"experimental,omitempty"`
 	Build        *Build            `toml:"build,omitempty" json:"build,omitempty"`
 	Deploy       *Deploy           `toml:"deploy, omitempty" json:"deploy,omitempty"`
 	Env          map[string]string `toml:"env,omitempty" json:"env,omitempty"`
+	// MachineMetadata is set on every machine, keys starting with "fly_" or "fly-" are reserved
+	MachineMetadata map[string]string `toml:"machine_metadata,omitempty" json:"machine_metadata,omitempty"`
 
 	// Fields that are process group aware must come after Processes
-	Processes   map[string]string         `toml:"processes,omitempty" json:"processes,omitempty"`
+	Processes   map[string]Process        `toml:"processes,omitempty" json:"processes,omitempty"`
 	Mounts      []Mount                   `toml:"mounts,omitempty" json:"mounts,omitempty"`
+	Files       []File                    `toml:"files,omitempty" json:"files,omitempty"`
+	Restart     []Restart                 `toml:"restart,omitempty" json:"restart,omitempty"`
 	HTTPService *HTTPService              `toml:"http_service,omitempty" json:"http_service,omitempty"`
 	Services    []Service                 `toml:"services,omitempty" json:"services,omitempty"`
 	Checks      map[string]*ToplevelCheck `toml:"checks,omitempty" json:"checks,omitempty"`
+	Machines    []MachineSettings         `toml:"machines,omitempty" json:"machines,omitempty"`
 
 	// Others, less important.
-	Statics []Static            `toml:"statics,omitempty" json:"statics,omitempty"`
-	Metrics *api.MachineMetrics `toml:"metrics,omitempty" json:"metrics,omitempty"`
+	Statics []Static       `toml:"statics,omitempty" json:"statics,omitempty"`
+	Metrics []*Metrics     `toml:"metrics,omitempty" json:"metrics,omitempty"`
+	DNS     *api.DNSConfig `toml:"dns,omitempty" json:"dns,omitempty"`
 
 	// RawDefinition contains fly.toml parsed as-is
 	// If you add any config field that is v2 specific, be sure to remove it in SanitizeDefinition()
@@ -71,12 +84,34 @@ type Config struct {
 
 type Deploy struct {
 	ReleaseCommand string `toml:"release_command,omitempty" json:"release_command,omitempty"`
-	Strategy       string `toml:"strategy,omitempty" json:"strategy,omitempty"`
+	// ReleaseCommandArgs is set instead of ReleaseCommand when release_command is an array, it skips shell splitting
+	ReleaseCommandArgs []string `toml:"release_command,omitempty" json:"-"`
+	Strategy           string   `toml:"strategy,omitempty" json:"strategy,omitempty"`
+	// PostDeployProbe is requested once the machines are healthy, the release fails if it doesn't get the expected status
+	PostDeployProbe *PostDeployProbe `toml:"post_deploy_probe,omitempty" json:"post_deploy_probe,omitempty"`
+	// NoPublicIPs keeps the app private, deploys don't allocate public IPs nor warn about their absence
+	NoPublicIPs bool `toml:"no_public_ips,omitempty" json:"no_public_ips,omitempty"`
+}
+
+// PostDeployProbe is an HTTP request to the public URL of the app, through the proxy like users reach it
+type PostDeployProbe struct {
+	URL string `toml:"url,omitempty" json:"url,omitempty"`
+	// ExpectedStatus defaults to 200
+	ExpectedStatus int           `toml:"expected_status,omitempty" json:"expected_status,omitempty"`
+	Timeout        *api.Duration `toml:"timeout,omitempty" json:"timeout,omitempty"`
 }
 
 type Static struct {
-	GuestPath string `toml:"guest_path" json:"guest_path,omitempty" validate:"required"`
-	UrlPrefix string `toml:"url_prefix" json:"url_prefix,omitempty" validate:"required"`
+	GuestPath string   `toml:"guest_path" json:"guest_path,omitempty" validate:"required"`
+	UrlPrefix string   `toml:"url_prefix" json:"url_prefix,omitempty" validate:"required"`
+	Processes []string `toml:"processes,omitempty" json:"processes,omitempty"`
+}
+
+// Metrics sets where the metrics of machines are scraped from, sections without processes apply to every group
+type Metrics struct {
+	Port      int      `toml:"port" json:"port,omitempty"`
+	Path      string   `toml:"path" json:"path,omitempty"`
+	Processes []string `toml:"processes,omitempty" json:"processes,omitempty"`
 }
 
 type Mount struct {
@@ -85,6 +120,28 @@ type Mount struct {
 	Processes   []string `json:"processes,omitempty" toml:"processes,omitempty"`
 }
 
+// Restart sets the restart policy of machines, sections without processes apply to every group
+type Restart struct {
+	Policy string `toml:"policy,omitempty" json:"policy,omitempty"`
+	// MaxRetries is only relevant with the on-failure policy
+	MaxRetries int      `toml:"max_retries,omitempty" json:"max_retries,omitempty"`
+	Processes  []string `toml:"processes,omitempty" json:"processes,omitempty"`
+}
+
+// MachineSettings holds how many machines and how they are run for a set of process groups
+type MachineSettings struct {
+	// Count is the number of machines created for each group, zero skips provisioning machines
+	Count *int `toml:"count,omitempty" json:"count,omitempty"`
+	// Standby creates a stopped machine for every machine in the group that takes over if its host fails
+	Standby       *bool  `toml:"standby,omitempty" json:"standby,omitempty"`
+	StandbyRegion string `toml:"standby_region,omitempty" json:"standby_region,omitempty"`
+	// NamePrefix names the machines of the group like "<prefix>-<region>-01", overrides machine_name_prefix
+	NamePrefix string `toml:"name_prefix,omitempty" json:"name_prefix,omitempty"`
+	// Env is merged over the toplevel [env] for machines of the group
+	Env       map[string]string `toml:"env,omitempty" json:"env,omitempty"`
+	Processes []string          `toml:"processes,omitempty" json:"processes,omitempty"`
+}
+
 type Build struct {
 	Builder           string            `toml:"builder,omitempty" json:"builder,omitempty"`
 	Args              map[string]string `toml:"args,omitempty" json:"args,omitempty"`
@@ -110,6 +167,21 @@ func (c *Config) ConfigFilePath() string {
 	return c.configFilePath
 }
 
+// AllRegions returns the primary region followed by the other regions
+// listed in the regions setting, without duplicates
+func (c *Config) AllRegions() []string {
+	regions := []string{}
+	if c.PrimaryRegion != "" {
+		regions = append(regions, c.PrimaryRegion)
+	}
+	for _, r := range c.Regions {
+		if r != "" && !slices.Contains(regions, r) {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
 func (c *Config) HasNonHttpAndHttpsStandardServices() bool {
 	for _, service := range c.Services {
 		switch service.Protocol {
@@ -130,6 +202,28 @@ func (c *Config) HasNonHttpAndHttpsStandardServices() bool {
 	return false
 }
 
+// HasPublicServices is true when any service exposes ports to the internet
+func (c *Config) HasPublicServices() bool {
+	for _, service := range c.AllServices() {
+		if len(service.Ports) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasHttpPorts is true when any service exposes port 80 or 443
+func (c *Config) HasHttpPorts() bool {
+	for _, service := range c.AllServices() {
+		for _, p := range service.Ports {
+			if p.ContainsPort(80) || p.ContainsPort(443) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (c *Config) HasUdpService() bool {
 	for _, service := range c.Services {
 		if service.Protocol == "udp" {
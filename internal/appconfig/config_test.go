@@ -205,3 +205,90 @@ func TestHasNonHttpAndHttpsStandardServices(t *testing.T) {
 	}}}
 	assert.True(t, cfg6.HasNonHttpAndHttpsStandardServices())
 }
+
+func TestAllRegions(t *testing.T) {
+	cfg := NewConfig()
+	assert.Equal(t, []string{}, cfg.AllRegions())
+
+	cfg.PrimaryRegion = "fra"
+	assert.Equal(t, []string{"fra"}, cfg.AllRegions())
+
+	cfg.Regions = []string{"iad", "fra", "nrt", "iad"}
+	assert.Equal(t, []string{"fra", "iad", "nrt"}, cfg.AllRegions())
+}
+
+func TestHasPublicServices(t *testing.T) {
+	cfg := NewConfig()
+	assert.False(t, cfg.HasPublicServices())
+
+	cfg.Services = []Service{{Protocol: "tcp", InternalPort: 8080}}
+	assert.False(t, cfg.HasPublicServices())
+
+	cfg.HTTPService = &HTTPService{InternalPort: 8080}
+	assert.True(t, cfg.HasPublicServices())
+}
+
+func TestHasHttpPorts(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Services = []Service{{
+		Protocol: "tcp",
+		Ports:    []api.MachinePort{{Port: api.Pointer(5432)}},
+	}}
+	assert.False(t, cfg.HasHttpPorts())
+
+	cfg.Services[0].Ports = append(cfg.Services[0].Ports, api.MachinePort{Port: api.Pointer(443)})
+	assert.True(t, cfg.HasHttpPorts())
+}
+
+func TestValidateServicePorts(t *testing.T) {
+	cfg := Config{
+		HTTPService: &HTTPService{InternalPort: 8080, ForceHTTPS: true},
+		Services: []Service{{
+			Protocol:     "tcp",
+			InternalPort: 9090,
+			Ports: []api.MachinePort{
+				{Port: api.Pointer(443), Handlers: []string{"tls"}},
+				{StartPort: api.Pointer(5000), EndPort: api.Pointer(4000)},
+				{Port: api.Pointer(6000), Handlers: []string{"https"}, ForceHttps: true},
+			},
+		}},
+	}
+
+	r := newValidationResult()
+	cfg.validateServicePorts(r)
+	assert.False(t, r.Valid)
+	assert.Equal(t, []string{
+		"[http_service] and [[services]] #1 both use tcp port 443",
+		"[[services]] #1: port range 5000-4000 starts after it ends",
+		"[[services]] #1 port 6000 has unknown handler 'https', it must be one of http, tls, pg_tls, proxy_proto, edge_http",
+		"[[services]] #1 port 6000 sets force_https without the 'http' handler",
+	}, r.Errors)
+
+	cfg.Services = nil
+	r = newValidationResult()
+	cfg.validateServicePorts(r)
+	assert.True(t, r.Valid)
+	assert.Empty(t, r.Output)
+}
+
+func TestValidateForMachinesPlatform_result(t *testing.T) {
+	cfg := Config{
+		Restart: []Restart{{Policy: "always", MaxRetries: 3}},
+		Deploy:  &Deploy{ReleaseCommand: "a \"b"},
+	}
+	r := newValidationResult()
+	err := cfg.validateForMachinesPlatform(r)
+	assert.EqualError(t, err, "App configuration is not valid")
+	assert.False(t, r.Valid)
+	assert.Equal(t, []string{"restart max_retries is only used with the 'on-failure' policy"}, r.Warnings)
+	assert.Equal(t, []string{"Can't shell split release command: 'a \"b'"}, r.Errors)
+	assert.Contains(t, r.Output, "WARN")
+	assert.Contains(t, r.Output, "Can't shell split release command")
+
+	cfg.Deploy = nil
+	r = newValidationResult()
+	assert.NoError(t, cfg.validateForMachinesPlatform(r))
+	assert.True(t, r.Valid)
+	assert.Empty(t, r.Errors)
+	assert.Len(t, r.Warnings, 1)
+}
@@ -1,9 +1,13 @@
 package appconfig
 
 import (
+	"crypto/sha256"
+	"fmt"
+
 	"github.com/pelletier/go-toml"
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/helpers"
 )
 
 func (c *Config) ToDefinition() (*api.Definition, error) {
@@ -28,6 +32,30 @@ func FromDefinition(definition *api.Definition) (*Config, error) {
 	return unmarshalTOML(buf)
 }
 
+// WithRedactedEnv returns a copy of the config with [env] values replaced by their sha256 digest,
+// so they can be compared between releases without storing them
+func (c *Config) WithRedactedEnv() *Config {
+	dst := helpers.Clone(c)
+	dst.platformVersion = c.platformVersion
+	dst.configFilePath = c.configFilePath
+	dst.defaultGroupName = c.defaultGroupName
+
+	redact := func(env map[string]string) map[string]string {
+		return lo.MapValues(env, func(v string, _ string) string {
+			return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(v)))
+		})
+	}
+	if dst.Env != nil {
+		dst.Env = redact(dst.Env)
+	}
+	for idx := range dst.Machines {
+		if dst.Machines[idx].Env != nil {
+			dst.Machines[idx].Env = redact(dst.Machines[idx].Env)
+		}
+	}
+	return dst
+}
+
 // SanitizedDefinition returns a definition cleaned from any extra fields
 // not valid for Web API GQL endpoints.
 func (c *Config) SanitizedDefinition() map[string]any {
@@ -36,6 +64,14 @@ func (c *Config) SanitizedDefinition() map[string]any {
 	delete(definition, "app")
 	delete(definition, "build")
 	delete(definition, "primary_region")
+	delete(definition, "regions")
+	delete(definition, "machine_name_prefix")
+	delete(definition, "required_secrets")
 	delete(definition, "http_service")
+	delete(definition, "machines")
+	delete(definition, "files")
+	delete(definition, "restart")
+	delete(definition, "machine_metadata")
+	delete(definition, "dns")
 	return definition
 }
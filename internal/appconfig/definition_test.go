@@ -163,9 +163,13 @@ func TestToDefinition(t *testing.T) {
 	assert.Equal(t, &api.Definition{
 		"app":            "foo",
 		"primary_region": "sea",
+		"regions":        []any{"sea", "ord"},
 		"kill_signal":    "SIGTERM",
 		"kill_timeout":   int64(3),
 
+		"machine_name_prefix": "foo",
+		"required_secrets":    []any{"DATABASE_URL"},
+
 		"build": map[string]any{
 			"builder":      "dockerfile",
 			"image":        "foo/fighter",
@@ -187,8 +191,12 @@ func TestToDefinition(t *testing.T) {
 		"http_service": map[string]any{
 			"internal_port": int64(8080),
 			"force_https":   true,
+
+			"auto_stop_machines":   true,
+			"auto_start_machines":  false,
+			"min_machines_running": int64(1),
 			"concurrency": map[string]any{
-				"type":       "donuts",
+				"type":       "connections",
 				"hard_limit": int64(10),
 				"soft_limit": int64(4),
 			},
@@ -206,13 +214,26 @@ func TestToDefinition(t *testing.T) {
 		"deploy": map[string]any{
 			"release_command": "release command",
 			"strategy":        "rolling-eyes",
+			"no_public_ips":   true,
+			"post_deploy_probe": map[string]any{
+				"url":             "https://example.com/health",
+				"expected_status": int64(204),
+				"timeout":         "5s",
+			},
 		},
 		"env": map[string]any{
 			"FOO": "BAR",
 		},
-		"metrics": map[string]any{
+		"machine_metadata": map[string]any{
+			"team": "infra",
+		},
+		"metrics": []map[string]any{{
 			"port": int64(9999),
 			"path": "/metrics",
+		}},
+		"dns": map[string]any{
+			"nameservers": []any{"fdaa::3"},
+			"searches":    []any{"internal.example.com"},
 		},
 		"statics": []map[string]any{
 			{
@@ -224,6 +245,26 @@ func TestToDefinition(t *testing.T) {
 			"source":      "data",
 			"destination": "/data",
 		}},
+		"files": []map[string]any{
+			{
+				"guest_path": "/path/to/hello.txt",
+				"raw_value":  "aGVsbG8gd29ybGQK",
+			},
+			{
+				"guest_path":  "/path/to/secret.txt",
+				"secret_name": "SUPER_SECRET",
+			},
+			{
+				"guest_path": "/path/to/config.yaml",
+				"local_path": "/local/path/config.yaml",
+				"processes":  []any{"web"},
+			},
+		},
+		"restart": []map[string]any{{
+			"policy":      "on-failure",
+			"max_retries": int64(3),
+			"processes":   []any{"task"},
+		}},
 		"processes": map[string]any{
 			"web":  "run web",
 			"task": "task all day",
@@ -295,5 +336,27 @@ func TestToDefinition(t *testing.T) {
 				},
 			},
 		},
+		"machines": []map[string]any{{
+			"processes":      []any{"web"},
+			"count":          int64(2),
+			"standby":        true,
+			"standby_region": "ord",
+			"name_prefix":    "web",
+			"env":            map[string]any{"GOMAXPROCS": "2"},
+		}},
 	}, definition)
 }
+
+func TestWithRedactedEnv(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/full-reference.toml")
+	assert.NoError(t, err)
+
+	redacted := cfg.WithRedactedEnv()
+	assert.Equal(t, map[string]string{
+		"FOO": "sha256:81f5f5515e670645c30c6340fe397157bbd2d42caa6968fd296a725ec9fac4ed",
+	}, redacted.Env)
+	assert.Equal(t, "BAR", cfg.Env["FOO"])
+	assert.NotEqual(t, "2", redacted.Machines[0].Env["GOMAXPROCS"])
+	assert.Equal(t, "2", cfg.Machines[0].Env["GOMAXPROCS"])
+	assert.Equal(t, cfg.PrimaryRegion, redacted.PrimaryRegion)
+}
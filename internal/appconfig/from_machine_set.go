@@ -132,7 +132,9 @@ fly.toml only supports one mount per machine at this time. These mounts will be
 	cfg.AppName = appCompact.Name
 	cfg.PrimaryRegion = primaryRegion
 	cfg.Env = m.Machine().Config.Env
-	cfg.Metrics = m.Machine().Config.Metrics
+	if metrics := m.Machine().Config.Metrics; metrics != nil {
+		cfg.Metrics = []*Metrics{{Port: metrics.Port, Path: metrics.Path}}
+	}
 	cfg.Statics = statics
 	cfg.Mounts = mounts
 	cfg.Processes = processGroups.processes
@@ -193,8 +195,9 @@ func processGroupsFromMachineSet(ms machine.MachineSet) (*processGroupInfo, stri
 	}
 	report := counter.Report()
 	if report.mostCommon != "" {
-		processGroups.processes = make(map[string]string)
-		processGroups.processes[api.MachineProcessGroupApp] = report.mostCommon
+		processGroups.processes = map[string]Process{
+			api.MachineProcessGroupApp: {Command: report.mostCommon},
+		}
 	}
 	if len(report.otherValues) > 0 {
 		var otherMachineIds []string
@@ -326,7 +329,7 @@ type report[T any] struct {
 }
 
 type processGroupInfo struct {
-	processes map[string]string
+	processes map[string]Process
 	services  []Service
 }
 
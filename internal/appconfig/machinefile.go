@@ -0,0 +1,85 @@
+package appconfig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/superfly/flyctl/api"
+)
+
+// maxFileSize limits the size of the files written into machines from fly.toml
+const maxFileSize = 1024 * 1024
+
+// File is written into the machines at GuestPath from exactly one of RawValue (base64 encoded),
+// LocalPath (relative to fly.toml) or SecretName
+type File struct {
+	GuestPath  string   `toml:"guest_path" json:"guest_path,omitempty"`
+	RawValue   string   `toml:"raw_value,omitempty" json:"raw_value,omitempty"`
+	LocalPath  string   `toml:"local_path,omitempty" json:"local_path,omitempty"`
+	SecretName string   `toml:"secret_name,omitempty" json:"secret_name,omitempty"`
+	Processes  []string `toml:"processes,omitempty" json:"processes,omitempty"`
+}
+
+// localFilePath resolves p relative to the directory of the config file
+func (c *Config) localFilePath(p string) string {
+	if p == "" || filepath.IsAbs(p) || c.configFilePath == "" || c.configFilePath == "--flatten--" {
+		return p
+	}
+	return filepath.Join(filepath.Dir(c.configFilePath), p)
+}
+
+func (f *File) validate() error {
+	if !path.IsAbs(f.GuestPath) {
+		return fmt.Errorf("guest_path '%s' must be an absolute path", f.GuestPath)
+	}
+
+	sources := 0
+	for _, s := range []string{f.RawValue, f.LocalPath, f.SecretName} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("file '%s' must set exactly one of raw_value, local_path or secret_name", f.GuestPath)
+	}
+
+	switch {
+	case f.RawValue != "":
+		raw, err := base64.StdEncoding.DecodeString(f.RawValue)
+		if err != nil {
+			return fmt.Errorf("raw_value of file '%s' must be base64 encoded: %w", f.GuestPath, err)
+		}
+		if len(raw) > maxFileSize {
+			return fmt.Errorf("file '%s' is bigger than %d bytes", f.GuestPath, maxFileSize)
+		}
+	case f.LocalPath != "":
+		info, err := os.Stat(f.LocalPath)
+		if err != nil {
+			return fmt.Errorf("local_path of file '%s': %w", f.GuestPath, err)
+		}
+		if info.Size() > maxFileSize {
+			return fmt.Errorf("local file '%s' is bigger than %d bytes", f.LocalPath, maxFileSize)
+		}
+	}
+	return nil
+}
+
+func (f *File) toMachineFile() (*api.File, error) {
+	mf := &api.File{GuestPath: f.GuestPath}
+	switch {
+	case f.RawValue != "":
+		mf.RawValue = api.Pointer(f.RawValue)
+	case f.LocalPath != "":
+		content, err := os.ReadFile(f.LocalPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file '%s' for '%s': %w", f.LocalPath, f.GuestPath, err)
+		}
+		mf.RawValue = api.Pointer(base64.StdEncoding.EncodeToString(content))
+	case f.SecretName != "":
+		mf.SecretName = api.Pointer(f.SecretName)
+	}
+	return mf, nil
+}
@@ -2,11 +2,13 @@ package appconfig
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
-	"github.com/google/shlex"
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/helpers"
+	"golang.org/x/exp/slices"
 )
 
 func (c *Config) ToMachineConfig(processGroup string, src *api.MachineConfig) (*api.MachineConfig, error) {
@@ -18,7 +20,7 @@ func (c *Config) ToMachineConfig(processGroup string, src *api.MachineConfig) (*
 }
 
 func (c *Config) ToReleaseMachineConfig() (*api.MachineConfig, error) {
-	releaseCmd, err := shlex.Split(c.Deploy.ReleaseCommand)
+	releaseCmd, err := c.Deploy.ReleaseCmd()
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +63,13 @@ func (c *Config) updateMachineConfig(src *api.MachineConfig) (*api.MachineConfig
 	}
 
 	// Metrics
-	mConfig.Metrics = c.Metrics
+	mConfig.Metrics = nil
+	if len(c.Metrics) > 0 {
+		mConfig.Metrics = &api.MachineMetrics{
+			Port: c.Metrics[0].Port,
+			Path: c.Metrics[0].Path,
+		}
+	}
 
 	// Init
 	cmd, err := c.InitCmd(processGroup)
@@ -69,8 +77,49 @@ func (c *Config) updateMachineConfig(src *api.MachineConfig) (*api.MachineConfig
 		return nil, err
 	}
 	mConfig.Init.Cmd = cmd
+	// [experimental] settings are the fallback for what the process group doesn't set
+	if exp := c.Experimental; exp != nil {
+		if len(mConfig.Init.Cmd) == 0 {
+			mConfig.Init.Cmd = exp.Cmd
+		}
+		if len(exp.Entrypoint) > 0 {
+			mConfig.Init.Entrypoint = exp.Entrypoint
+		}
+		if len(exp.Exec) > 0 {
+			mConfig.Init.Exec = exp.Exec
+		}
+	}
+	if process := c.Processes[processGroup]; process.isTable() {
+		if len(process.Entrypoint) > 0 {
+			mConfig.Init.Entrypoint = process.Entrypoint
+		}
+		if len(process.Exec) > 0 {
+			mConfig.Init.Exec = process.Exec
+		}
+	}
+
+	// Schedule
+	// Only reset the schedules fly.toml could have set so removing one makes the machines always-on again
+	switch schedule := c.Processes[processGroup].Schedule; {
+	case schedule != "":
+		mConfig.Schedule = schedule
+	case slices.Contains(processSchedules, mConfig.Schedule):
+		mConfig.Schedule = ""
+	}
 
 	// Metadata
+	// Drop the keys set by fly.toml on the previous deploy so removed ones don't linger
+	for _, k := range strings.Split(mConfig.Metadata[api.MachineConfigMetadataKeyFlyTomlKeys], ",") {
+		delete(mConfig.Metadata, k)
+	}
+	delete(mConfig.Metadata, api.MachineConfigMetadataKeyFlyTomlKeys)
+	if len(c.MachineMetadata) > 0 {
+		keys := lo.Keys(c.MachineMetadata)
+		slices.Sort(keys)
+		mConfig.Metadata = lo.Assign(mConfig.Metadata, c.MachineMetadata, map[string]string{
+			api.MachineConfigMetadataKeyFlyTomlKeys: strings.Join(keys, ","),
+		})
+	}
 	mConfig.Metadata = lo.Assign(mConfig.Metadata, map[string]string{
 		api.MachineConfigMetadataKeyFlyPlatformVersion: api.MachineFlyPlatformVersion2,
 		api.MachineConfigMetadataKeyFlyProcessGroup:    processGroup,
@@ -107,11 +156,60 @@ func (c *Config) updateMachineConfig(src *api.MachineConfig) (*api.MachineConfig
 	}
 
 	// Env
+	// The keys set from fly.toml are recorded so deployments keeping env set on machines can tell them apart
 	mConfig.Env = lo.Assign(c.Env)
 	mConfig.Env["FLY_PROCESS_GROUP"] = processGroup
 	if c.PrimaryRegion != "" {
 		mConfig.Env["PRIMARY_REGION"] = c.PrimaryRegion
 	}
+	delete(mConfig.Metadata, api.MachineConfigMetadataKeyFlyTomlEnvKeys)
+	if envKeys := lo.Without(lo.Keys(mConfig.Env), "FLY_PROCESS_GROUP"); len(envKeys) > 0 {
+		slices.Sort(envKeys)
+		mConfig.Metadata = lo.Assign(mConfig.Metadata, map[string]string{
+			api.MachineConfigMetadataKeyFlyTomlEnvKeys: strings.Join(envKeys, ","),
+		})
+	}
+
+	// DNS
+	// Machines keep their own DNS settings unless fly.toml set them on a previous deploy
+	switch {
+	case c.DNS != nil:
+		mConfig.DNS = helpers.Clone(c.DNS)
+		mConfig.Metadata = lo.Assign(mConfig.Metadata, map[string]string{api.MachineConfigMetadataKeyFlyTomlDNS: "true"})
+	case mConfig.Metadata[api.MachineConfigMetadataKeyFlyTomlDNS] != "":
+		mConfig.DNS = nil
+		delete(mConfig.Metadata, api.MachineConfigMetadataKeyFlyTomlDNS)
+	}
+
+	// Restart
+	// Keep the policy set on existing machines unless fly.toml sets one
+	if len(c.Restart) > 0 {
+		mConfig.Restart = api.MachineRestart{
+			Policy:     api.MachineRestartPolicy(c.Restart[0].Policy),
+			MaxRetries: c.Restart[0].MaxRetries,
+		}
+	}
+
+	// StopConfig
+	// Machines keep their own stop settings unless fly.toml set them on a previous deploy
+	switch {
+	case c.KillSignal != nil || c.KillTimeout != nil:
+		mConfig.StopConfig = &api.StopConfig{}
+		if c.KillSignal != nil {
+			signal, err := api.ValidateSignal(*c.KillSignal)
+			if err != nil {
+				return nil, err
+			}
+			mConfig.StopConfig.Signal = &signal
+		}
+		if c.KillTimeout != nil {
+			mConfig.StopConfig.Timeout = &api.Duration{Duration: time.Duration(*c.KillTimeout) * time.Second}
+		}
+		mConfig.Metadata = lo.Assign(mConfig.Metadata, map[string]string{api.MachineConfigMetadataKeyFlyTomlStopConfig: "true"})
+	case mConfig.Metadata[api.MachineConfigMetadataKeyFlyTomlStopConfig] != "":
+		mConfig.StopConfig = nil
+		delete(mConfig.Metadata, api.MachineConfigMetadataKeyFlyTomlStopConfig)
+	}
 
 	// Statics
 	mConfig.Statics = nil
@@ -122,6 +220,16 @@ func (c *Config) updateMachineConfig(src *api.MachineConfig) (*api.MachineConfig
 		})
 	}
 
+	// Files
+	mConfig.Files = nil
+	for _, f := range c.Files {
+		mf, err := f.toMachineFile()
+		if err != nil {
+			return nil, err
+		}
+		mConfig.Files = append(mConfig.Files, mf)
+	}
+
 	// Mounts
 	mConfig.Mounts = nil
 	for _, m := range c.Mounts {
@@ -2,6 +2,7 @@ package appconfig
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,7 +25,7 @@ func TestToMachineConfig(t *testing.T) {
 				},
 			},
 		},
-		Metadata: map[string]string{"fly_platform_version": "v2", "fly_process_group": "app"},
+		Metadata: map[string]string{"fly_platform_version": "v2", "fly_process_group": "app", "fly_toml_env_keys": "FOO,PRIMARY_REGION"},
 		Metrics:  &api.MachineMetrics{Port: 9999, Path: "/metrics"},
 		Statics:  []*api.Static{{GuestPath: "/guest/path", UrlPrefix: "/url/prefix"}},
 		Mounts:   []api.MachineMount{{Name: "data", Path: "/data"}},
@@ -212,7 +213,7 @@ func TestToMachineConfig_defaultV2flytoml(t *testing.T) {
 				},
 			},
 		},
-		Metadata: map[string]string{"fly_platform_version": "v2", "fly_process_group": "app"},
+		Metadata: map[string]string{"fly_platform_version": "v2", "fly_process_group": "app", "fly_toml_env_keys": "PRIMARY_REGION"},
 		Checks: map[string]api.MachineCheck{
 			"alive": {
 				Port:        api.Pointer(8080),
@@ -256,6 +257,56 @@ func TestToReleaseMachineConfig_processGroupsAndMounts(t *testing.T) {
 	assert.Empty(t, got.Mounts)
 }
 
+func TestToMachineConfig_processGroupEnv(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-env.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FOO": "BAR", "GOMAXPROCS": "4", "FLY_PROCESS_GROUP": "web"}, got.Env)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"FOO": "BAR", "GOMAXPROCS": "1", "QUEUE": "default", "FLY_PROCESS_GROUP": "worker"}, got.Env)
+	assert.Equal(t, "4", cfg.Env["GOMAXPROCS"])
+}
+
+func TestToMachineConfig_files(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-files.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*api.File{
+		{GuestPath: "/etc/hello.txt", RawValue: api.Pointer("aGVsbG8gd29ybGQK")},
+	}, got.Files)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*api.File{
+		{GuestPath: "/etc/hello.txt", RawValue: api.Pointer("aGVsbG8gd29ybGQK")},
+		{GuestPath: "/etc/secret.txt", SecretName: api.Pointer("SUPER_SECRET")},
+	}, got.Files)
+}
+
+func TestToMachineConfig_statics(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-statics.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*api.Static{
+		{GuestPath: "/app/public", UrlPrefix: "/public"},
+		{GuestPath: "/app/assets", UrlPrefix: "/assets"},
+	}, got.Statics)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []*api.Static{
+		{GuestPath: "/app/public", UrlPrefix: "/public"},
+	}, got.Statics)
+}
+
 func TestToMachineConfig_services(t *testing.T) {
 	cfg, err := LoadConfig("./testdata/tomachine-services.toml")
 	require.NoError(t, err)
@@ -305,3 +356,206 @@ func TestToMachineConfig_services(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, want, got.Services)
 }
+
+func TestToMachineConfig_stopConfig(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-kill.toml")
+	require.NoError(t, err)
+	assert.Equal(t, api.Pointer(120), cfg.KillTimeout)
+
+	// Settings made outside fly.toml stay until fly.toml sets its own
+	got, err := cfg.ToMachineConfig("", &api.MachineConfig{
+		StopConfig: &api.StopConfig{Signal: api.Pointer("SIGKILL")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &api.StopConfig{
+		Signal:  api.Pointer("SIGINT"),
+		Timeout: &api.Duration{Duration: 2 * time.Minute},
+	}, got.StopConfig)
+
+	cfg.KillSignal = api.Pointer("usr1")
+	got, err = cfg.ToMachineConfig("", got)
+	require.NoError(t, err)
+	assert.Equal(t, api.Pointer("SIGUSR1"), got.StopConfig.Signal)
+
+	cfg.KillSignal = nil
+	cfg.KillTimeout = nil
+	got, err = cfg.ToMachineConfig("", got)
+	require.NoError(t, err)
+	assert.Nil(t, got.StopConfig)
+	assert.NotContains(t, got.Metadata, api.MachineConfigMetadataKeyFlyTomlStopConfig)
+
+	got, err = cfg.ToMachineConfig("", &api.MachineConfig{
+		StopConfig: &api.StopConfig{Signal: api.Pointer("SIGKILL")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &api.StopConfig{Signal: api.Pointer("SIGKILL")}, got.StopConfig)
+
+	cfg.KillSignal = api.Pointer("SIGSTOP")
+	_, err = cfg.ToMachineConfig("", nil)
+	assert.ErrorContains(t, err, "invalid signal SIGSTOP")
+}
+
+func TestToMachineConfig_restart(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-restart.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineRestart{Policy: api.MachineRestartPolicyAlways}, got.Restart)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineRestart{Policy: api.MachineRestartPolicyOnFailure, MaxRetries: 5}, got.Restart)
+
+	// Existing policies are kept when fly.toml doesn't set one
+	cfg.Restart = nil
+	got, err = cfg.ToMachineConfig("worker", &api.MachineConfig{
+		Restart: api.MachineRestart{Policy: api.MachineRestartPolicyNo},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineRestart{Policy: api.MachineRestartPolicyNo}, got.Restart)
+}
+
+func TestToMachineConfig_metrics(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-metrics.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &api.MachineMetrics{Port: 9091, Path: "/metrics"}, got.Metrics)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, &api.MachineMetrics{Port: 9092, Path: "/worker/metrics"}, got.Metrics)
+
+	// Removing the section clears the metrics of existing machines
+	cfg.Metrics = nil
+	got, err = cfg.ToMachineConfig("worker", got)
+	require.NoError(t, err)
+	assert.Nil(t, got.Metrics)
+}
+
+func TestToMachineConfig_serviceConcurrency(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/full-reference.toml")
+	require.NoError(t, err)
+
+	// http_service has no processes so it belongs to the default group
+	got, err := cfg.ToMachineConfig("", nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, got.Services)
+	assert.Equal(t, &api.MachineServiceConcurrency{Type: "connections", HardLimit: 10, SoftLimit: 4}, got.Services[0].Concurrency)
+}
+
+func TestToMachineConfig_processTables(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-processes.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"run", "web", "--port", "8080"}}, got.Init)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{
+		Cmd:        []string{"run", "worker", "--queue", "a b"},
+		Entrypoint: []string{"/bin/tini", "--"},
+	}, got.Init)
+
+	got, err = cfg.ToMachineConfig("task", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Exec: []string{"/bin/task"}}, got.Init)
+
+	got, err = cfg.ToMachineConfig("api", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"./server", "--config", `{"x": 1}`}}, got.Init)
+
+	got, err = cfg.ToReleaseMachineConfig()
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"./migrate", "--opts", `{"a": "b c"}`}}, got.Init)
+}
+
+func TestToMachineConfig_machineMetadata(t *testing.T) {
+	cfg := NewConfig()
+	cfg.MachineMetadata = map[string]string{"team": "infra", "cost_center": "42"}
+
+	got, err := cfg.ToMachineConfig("", &api.MachineConfig{
+		Metadata: map[string]string{"retain": "propagated", "fly_process_group": "other"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"retain":                 "propagated",
+		"team":                   "infra",
+		"cost_center":            "42",
+		"fly_toml_metadata_keys": "cost_center,team",
+		"fly_platform_version":   "v2",
+		"fly_process_group":      "app",
+	}, got.Metadata)
+
+	// Keys removed from fly.toml are removed from machines
+	cfg.MachineMetadata = map[string]string{"team": "web"}
+	got, err = cfg.ToMachineConfig("", got)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"retain":                 "propagated",
+		"team":                   "web",
+		"fly_toml_metadata_keys": "team",
+		"fly_platform_version":   "v2",
+		"fly_process_group":      "app",
+	}, got.Metadata)
+}
+
+func TestToMachineConfig_experimentalInit(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-experimental.toml")
+	require.NoError(t, err)
+
+	// Without a command for the group, [experimental] is used as is
+	got, err := cfg.ToMachineConfig("app", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"run", "default"}, Entrypoint: []string{"/entrypoint.sh"}}, got.Init)
+
+	// [processes] commands take precedence
+	got, err = cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"run", "web"}, Entrypoint: []string{"/entrypoint.sh"}}, got.Init)
+
+	got, err = cfg.ToMachineConfig("worker", nil)
+	require.NoError(t, err)
+	assert.Equal(t, api.MachineInit{Cmd: []string{"run", "worker"}, Entrypoint: []string{"/bin/tini", "--"}}, got.Init)
+}
+
+func TestToMachineConfig_dns(t *testing.T) {
+	cfg := NewConfig()
+	cfg.DNS = &api.DNSConfig{Nameservers: []string{"fdaa::3"}, Searches: []string{"internal.example.com"}}
+
+	got, err := cfg.ToMachineConfig("", &api.MachineConfig{DNS: &api.DNSConfig{SkipRegistration: true}})
+	require.NoError(t, err)
+	assert.Equal(t, &api.DNSConfig{Nameservers: []string{"fdaa::3"}, Searches: []string{"internal.example.com"}}, got.DNS)
+	assert.Equal(t, "true", got.Metadata["fly_toml_dns"])
+
+	// Removing [dns] resets the settings it made
+	cfg.DNS = nil
+	got, err = cfg.ToMachineConfig("", got)
+	require.NoError(t, err)
+	assert.Nil(t, got.DNS)
+	assert.NotContains(t, got.Metadata, "fly_toml_dns")
+}
+
+func TestToMachineConfig_schedule(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-schedule.toml")
+	require.NoError(t, err)
+
+	got, err := cfg.ToMachineConfig("cleanup", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "daily", got.Schedule)
+	assert.Equal(t, []string{"run", "cleanup"}, got.Init.Cmd)
+
+	got, err = cfg.ToMachineConfig("web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "", got.Schedule)
+
+	// Removing the schedule makes machines always-on again
+	cfg.Processes["cleanup"] = Process{Cmd: []string{"run", "cleanup"}}
+	got, err = cfg.ToMachineConfig("cleanup", &api.MachineConfig{Schedule: "daily"})
+	require.NoError(t, err)
+	assert.Equal(t, "", got.Schedule)
+}
@@ -0,0 +1,69 @@
+package appconfig
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+)
+
+// LoadConfigWithOverlay loads the app config at path merged with the overlay at overlayPath,
+// so environments like staging only keep the settings that differ from fly.toml:
+//   - values in the overlay replace the values of the base, including their type
+//   - tables like [env] or [http_service] are merged key by key, recursively
+//   - arrays, including arrays of tables like [[services]] or [[mounts]], are replaced as a whole,
+//     an overlay with a [[services]] section replaces every service of the base
+//
+// The merged config is used as if it were a single fly.toml, also for the release definition
+func LoadConfigWithOverlay(path, overlayPath string) (*Config, error) {
+	base, err := readTOMLMap(path)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := readTOMLMap(overlayPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(mergeTOML(base, overlay)); err != nil {
+		return nil, fmt.Errorf("failed to merge %s over %s: %w", overlayPath, path, err)
+	}
+	cfg, err := unmarshalTOML(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	cfg.configFilePath = path
+	return cfg, nil
+}
+
+func readTOMLMap(path string) (map[string]any, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	if err := toml.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// mergeTOML merges overlay into base following the rules of LoadConfigWithOverlay
+func mergeTOML(base, overlay map[string]any) map[string]any {
+	merged := make(map[string]any, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		overlayTable, overlayIsTable := v.(map[string]any)
+		baseTable, baseIsTable := merged[k].(map[string]any)
+		if overlayIsTable && baseIsTable {
+			merged[k] = mergeTOML(baseTable, overlayTable)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
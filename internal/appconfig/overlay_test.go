@@ -0,0 +1,69 @@
+package appconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func TestLoadConfigWithOverlay(t *testing.T) {
+	cfg, err := LoadConfigWithOverlay("./testdata/overlay-base.toml", "./testdata/overlay-staging.toml")
+	require.NoError(t, err)
+	assert.Equal(t, "./testdata/overlay-base.toml", cfg.ConfigFilePath())
+
+	// Scalars are replaced, missing ones are kept from the base
+	assert.Equal(t, "acme-staging", cfg.AppName)
+	assert.Equal(t, "iad", cfg.PrimaryRegion)
+	assert.Equal(t, api.Pointer(10), cfg.KillTimeout)
+
+	// Tables are merged key by key
+	assert.Equal(t, map[string]string{"LOG_LEVEL": "debug", "DATABASE_POOL": "20", "STAGING": "true"}, cfg.Env)
+	assert.Equal(t, map[string]Process{"web": {Command: "bin/server"}, "worker": {Command: "bin/worker"}}, cfg.Processes)
+	require.NotNil(t, cfg.HTTPService)
+	assert.Equal(t, 8080, cfg.HTTPService.InternalPort)
+	assert.True(t, cfg.HTTPService.ForceHTTPS)
+	assert.Equal(t, &api.MachineServiceConcurrency{Type: "requests", SoftLimit: 20, HardLimit: 250}, cfg.HTTPService.Concurrency)
+
+	// Arrays of tables are replaced as a whole
+	require.Len(t, cfg.Services, 1)
+	assert.Equal(t, 9100, cfg.Services[0].InternalPort)
+	assert.Equal(t, "tcp", cfg.Services[0].Protocol)
+	require.Len(t, cfg.Services[0].Ports, 1)
+	assert.Equal(t, api.Pointer(9100), cfg.Services[0].Ports[0].Port)
+
+	// The release definition is the merged config
+	assert.Equal(t, "acme-staging", cfg.RawDefinition["app"])
+	assert.Equal(t, map[string]any{"LOG_LEVEL": "debug", "DATABASE_POOL": "20", "STAGING": "true"}, cfg.RawDefinition["env"])
+}
+
+func TestLoadConfigWithOverlay_errors(t *testing.T) {
+	_, err := LoadConfigWithOverlay("./testdata/overlay-base.toml", "./testdata/does-not-exist.toml")
+	assert.Error(t, err)
+}
+
+func TestMergeTOML(t *testing.T) {
+	base := map[string]any{
+		"app":   "base",
+		"env":   map[string]any{"A": "1", "B": "2"},
+		"array": []any{"a", "b"},
+		"table": map[string]any{"nested": map[string]any{"x": int64(1), "y": int64(2)}},
+		"kind":  map[string]any{"was": "table"},
+	}
+	overlay := map[string]any{
+		"env":   map[string]any{"B": "overlay"},
+		"array": []any{"c"},
+		"table": map[string]any{"nested": map[string]any{"y": int64(3)}},
+		"kind":  "scalar",
+	}
+	assert.Equal(t, map[string]any{
+		"app":   "base",
+		"env":   map[string]any{"A": "1", "B": "overlay"},
+		"array": []any{"c"},
+		"table": map[string]any{"nested": map[string]any{"x": int64(1), "y": int64(3)}},
+		"kind":  "scalar",
+	}, mergeTOML(base, overlay))
+	// The base isn't modified
+	assert.Equal(t, map[string]any{"A": "1", "B": "2"}, base["env"])
+}
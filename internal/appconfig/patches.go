@@ -17,6 +17,9 @@ var configPatches = []patchFuncType{
 	patchExperimental,
 	patchTopLevelChecks,
 	patchMounts,
+	patchKillTimeout,
+	patchRestart,
+	patchMetrics,
 }
 
 func applyPatches(cfgMap map[string]any) (*Config, error) {
@@ -133,6 +136,28 @@ func patchMounts(cfg map[string]any) (map[string]any, error) {
 	return cfg, nil
 }
 
+func patchRestart(cfg map[string]any) (map[string]any, error) {
+	if raw, ok := cfg["restart"]; ok {
+		restarts, err := ensureArrayOfMap(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing restart: %w", err)
+		}
+		cfg["restart"] = restarts
+	}
+	return cfg, nil
+}
+
+func patchMetrics(cfg map[string]any) (map[string]any, error) {
+	if raw, ok := cfg["metrics"]; ok {
+		metrics, err := ensureArrayOfMap(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Error processing metrics: %w", err)
+		}
+		cfg["metrics"] = metrics
+	}
+	return cfg, nil
+}
+
 func patchTopLevelChecks(cfg map[string]any) (map[string]any, error) {
 	raw, ok := cfg["checks"]
 	if !ok {
@@ -375,3 +400,17 @@ func stringOrSliceToSlice(input any, fieldName string) ([]string, error) {
 		return nil, fmt.Errorf("could not cast %v of type %T to []string on %s", input, input, fieldName)
 	}
 }
+
+// patchKillTimeout converts duration strings like "30s" to the number of seconds
+func patchKillTimeout(cfg map[string]any) (map[string]any, error) {
+	if raw, ok := cfg["kill_timeout"]; ok {
+		if cast, ok := raw.(string); ok {
+			d, err := time.ParseDuration(cast)
+			if err != nil {
+				return nil, fmt.Errorf("Can't parse kill_timeout '%s': %w", cast, err)
+			}
+			cfg["kill_timeout"] = int(d.Seconds())
+		}
+	}
+	return cfg, nil
+}
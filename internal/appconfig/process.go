@@ -0,0 +1,216 @@
+package appconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/shlex"
+	"github.com/superfly/flyctl/api"
+)
+
+// processSchedules are the schedules supported by machines
+var processSchedules = []string{"hourly", "daily", "weekly", "monthly"}
+
+// Process is what a process group runs. It is either a command string split like a shell would,
+// an array of arguments, or a table with cmd, entrypoint and exec arrays that are passed to the
+// machine as they are. Arrays are read as tables with only cmd set.
+type Process struct {
+	Command    string
+	Cmd        []string
+	Entrypoint []string
+	Exec       []string
+	// Schedule runs the machines of the group periodically instead of always
+	Schedule string
+	// WaitTimeout overrides how long deployments wait for the machines of the group to start and pass health checks
+	WaitTimeout *api.Duration
+}
+
+type processTable struct {
+	Cmd         []string      `json:"cmd,omitempty" toml:"cmd,omitempty"`
+	Entrypoint  []string      `json:"entrypoint,omitempty" toml:"entrypoint,omitempty"`
+	Exec        []string      `json:"exec,omitempty" toml:"exec,omitempty"`
+	Schedule    string        `json:"schedule,omitempty" toml:"schedule,omitempty"`
+	WaitTimeout *api.Duration `json:"wait_timeout,omitempty" toml:"wait_timeout,omitempty"`
+}
+
+// isTable is true for processes set using the table format
+func (p Process) isTable() bool {
+	return len(p.Cmd) > 0 || len(p.Entrypoint) > 0 || len(p.Exec) > 0 || p.Schedule != "" || p.WaitTimeout != nil
+}
+
+// InitCmd returns the command to run, splitting the command string when the table format isn't used
+func (p Process) InitCmd() ([]string, error) {
+	if p.isTable() {
+		return p.Cmd, nil
+	}
+	if p.Command == "" {
+		return nil, nil
+	}
+	return shlex.Split(p.Command)
+}
+
+// String returns the command string or a representation of the table format
+func (p Process) String() string {
+	if !p.isTable() {
+		return p.Command
+	}
+	b, err := p.table().inlineTOML()
+	if err != nil {
+		return fmt.Sprintf("%v", p.table())
+	}
+	return string(b)
+}
+
+func (p Process) table() processTable {
+	return processTable{Cmd: p.Cmd, Entrypoint: p.Entrypoint, Exec: p.Exec, Schedule: p.Schedule, WaitTimeout: p.WaitTimeout}
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (p Process) MarshalJSON() ([]byte, error) {
+	if p.isTable() {
+		return json.Marshal(p.table())
+	}
+	return json.Marshal(p.Command)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (p *Process) UnmarshalJSON(data []byte) error {
+	var cmdStr string
+	if err := json.Unmarshal(data, &cmdStr); err == nil {
+		*p = Process{Command: cmdStr}
+		return nil
+	}
+
+	var cmdArgs []string
+	if err := json.Unmarshal(data, &cmdArgs); err == nil {
+		*p = Process{Cmd: cmdArgs}
+		return nil
+	}
+
+	var t processTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("process must be a command string, an array of arguments or a table with cmd, entrypoint, exec, schedule and wait_timeout: %w", err)
+	}
+	*p = Process{Cmd: t.Cmd, Entrypoint: t.Entrypoint, Exec: t.Exec, Schedule: t.Schedule, WaitTimeout: t.WaitTimeout}
+	return nil
+}
+
+// MarshalTOML implements the toml.Marshaler interface, tables are written inline
+func (p Process) MarshalTOML() ([]byte, error) {
+	if p.isTable() {
+		return p.table().inlineTOML()
+	}
+	return tomlValue(p.Command)
+}
+
+// inlineTOML encodes the table in the inline format so it fits in the [processes] section
+func (t processTable) inlineTOML() ([]byte, error) {
+	var parts [][]byte
+	for _, f := range []struct {
+		key   string
+		value []string
+	}{{"cmd", t.Cmd}, {"entrypoint", t.Entrypoint}, {"exec", t.Exec}} {
+		if len(f.value) == 0 {
+			continue
+		}
+		value, err := tomlValue(f.value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, append([]byte(f.key+" = "), value...))
+	}
+	if t.Schedule != "" {
+		value, err := tomlValue(t.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, append([]byte("schedule = "), value...))
+	}
+	if t.WaitTimeout != nil {
+		value, err := t.WaitTimeout.MarshalTOML()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, append([]byte("wait_timeout = "), value...))
+	}
+	return []byte("{ " + string(bytes.Join(parts, []byte(", "))) + " }"), nil
+}
+
+// tomlValue encodes a string or array as a TOML value
+func tomlValue(v any) ([]byte, error) {
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(map[string]any{"v": v}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(bytes.TrimPrefix(b.Bytes(), []byte("v = "))), nil
+}
+
+// HasReleaseCommand is true when release_command is set
+func (d *Deploy) HasReleaseCommand() bool {
+	return d != nil && (d.ReleaseCommand != "" || len(d.ReleaseCommandArgs) > 0)
+}
+
+// ReleaseCmd returns the release command arguments, splitting release_command like a shell
+// would unless it is an array
+func (d *Deploy) ReleaseCmd() ([]string, error) {
+	switch {
+	case d == nil:
+		return nil, nil
+	case len(d.ReleaseCommandArgs) > 0:
+		return d.ReleaseCommandArgs, nil
+	default:
+		return shlex.Split(d.ReleaseCommand)
+	}
+}
+
+// ReleaseCommandString returns release_command as written in fly.toml
+func (d *Deploy) ReleaseCommandString() string {
+	if d == nil {
+		return ""
+	}
+	if len(d.ReleaseCommandArgs) == 0 {
+		return d.ReleaseCommand
+	}
+	b, err := tomlValue(d.ReleaseCommandArgs)
+	if err != nil {
+		return fmt.Sprintf("%v", d.ReleaseCommandArgs)
+	}
+	return string(b)
+}
+
+// MarshalJSON implements the json.Marshaler interface, release_command is an array when set from one
+func (d Deploy) MarshalJSON() ([]byte, error) {
+	type plain Deploy
+	if len(d.ReleaseCommandArgs) == 0 {
+		return json.Marshal(plain(d))
+	}
+	return json.Marshal(struct {
+		plain
+		ReleaseCommand []string `json:"release_command"`
+	}{plain(d), d.ReleaseCommandArgs})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, release_command is a command string or an array of arguments
+func (d *Deploy) UnmarshalJSON(data []byte) error {
+	type plain Deploy
+	var raw struct {
+		plain
+		ReleaseCommand json.RawMessage `json:"release_command"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*d = Deploy(raw.plain)
+	if len(raw.ReleaseCommand) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw.ReleaseCommand, &d.ReleaseCommand); err == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw.ReleaseCommand, &d.ReleaseCommandArgs); err != nil {
+		return fmt.Errorf("release_command must be a command string or an array of arguments: %w", err)
+	}
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/google/shlex"
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/helpers"
@@ -117,11 +116,11 @@ func (c *Config) Flatten(groupName string) (*Config, error) {
 
 	// [processes]
 	dst.Processes = nil
-	for name, cmdStr := range c.Processes {
+	for name, process := range c.Processes {
 		if !matchesGroup(name) {
 			continue
 		}
-		dst.Processes = map[string]string{dst.defaultGroupName: cmdStr}
+		dst.Processes = map[string]Process{dst.defaultGroupName: process}
 		break
 	}
 
@@ -146,22 +145,78 @@ func (c *Config) Flatten(groupName string) (*Config, error) {
 		return matchesGroups(x.Processes)
 	})
 
+	// [[statics]]
+	// Statics without processes apply to every group as they always did
+	dst.Statics = lo.Filter(c.Statics, func(x Static, _ int) bool {
+		return len(x.Processes) == 0 || matchesGroups(x.Processes)
+	})
+
+	// [[files]]
+	// Files without processes apply to every group, local paths are relative to fly.toml,
+	// resolve them while its path is known
+	dst.Files = lo.FilterMap(c.Files, func(x File, _ int) (File, bool) {
+		x.LocalPath = c.localFilePath(x.LocalPath)
+		return x, len(x.Processes) == 0 || matchesGroups(x.Processes)
+	})
+
+	// [[metrics]]
+	// Sections scoped to the group come first so they take precedence over unscoped ones
+	dst.Metrics = append(
+		lo.Filter(c.Metrics, func(x *Metrics, _ int) bool {
+			return len(x.Processes) > 0 && matchesGroups(x.Processes)
+		}),
+		lo.Filter(c.Metrics, func(x *Metrics, _ int) bool {
+			return len(x.Processes) == 0
+		})...,
+	)
+
+	// [[restart]]
+	// Sections scoped to the group come first so they take precedence over unscoped ones
+	dst.Restart = append(
+		lo.Filter(c.Restart, func(x Restart, _ int) bool {
+			return len(x.Processes) > 0 && matchesGroups(x.Processes)
+		}),
+		lo.Filter(c.Restart, func(x Restart, _ int) bool {
+			return len(x.Processes) == 0
+		})...,
+	)
+
+	// [[machines]]
+	dst.Machines = lo.Filter(c.Machines, func(x MachineSettings, _ int) bool {
+		return matchesGroups(x.Processes)
+	})
+
+	// [env] overridden by the group's [machines.env]
+	if len(dst.Machines) > 0 && len(dst.Machines[0].Env) > 0 {
+		dst.Env = lo.Assign(c.Env, dst.Machines[0].Env)
+	}
+
 	return dst, nil
 }
 
+// MachineSettingsFor returns the [[machines]] settings that apply to groupName,
+// or empty settings if none do
+func (c *Config) MachineSettingsFor(groupName string) (*MachineSettings, error) {
+	fc, err := c.Flatten(groupName)
+	if err != nil {
+		return nil, err
+	}
+	if len(fc.Machines) == 0 {
+		return &MachineSettings{}, nil
+	}
+	return &fc.Machines[0], nil
+}
+
 func (c *Config) InitCmd(groupName string) ([]string, error) {
 	if groupName == "" {
 		groupName = c.DefaultProcessName()
 	}
-	cmdStr, ok := c.Processes[groupName]
+	process, ok := c.Processes[groupName]
 	if !ok {
 		return nil, nil
 	}
-	if cmdStr == "" {
-		return nil, nil
-	}
 
-	cmd, err := shlex.Split(cmdStr)
+	cmd, err := process.InitCmd()
 	if err != nil {
 		return nil, fmt.Errorf("could not parse command for %s process group: %w", groupName, err)
 	}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
 )
 
 func TestProcessNames(t *testing.T) {
@@ -101,3 +102,23 @@ func TestProcessNames(t *testing.T) {
 		})
 	}
 }
+
+func TestMachineSettingsFor(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/full-reference.toml")
+	require.NoError(t, err)
+
+	settings, err := cfg.MachineSettingsFor("web")
+	require.NoError(t, err)
+	assert.Equal(t, &MachineSettings{
+		Processes:     []string{"web"},
+		Count:         api.Pointer(2),
+		Standby:       api.Pointer(true),
+		StandbyRegion: "ord",
+		NamePrefix:    "web",
+		Env:           map[string]string{"GOMAXPROCS": "2"},
+	}, settings)
+
+	settings, err = cfg.MachineSettingsFor("task")
+	require.NoError(t, err)
+	assert.Equal(t, &MachineSettings{}, settings)
+}
@@ -141,9 +141,30 @@ func (c *Config) rawSections() ([]map[string]any, error) {
 	if c.PrimaryRegion != "" {
 		rawData["primary_region"] = c.PrimaryRegion
 	}
+	if len(c.Regions) > 0 {
+		rawData["regions"] = c.Regions
+	}
+	if c.MachineNamePrefix != "" {
+		rawData["machine_name_prefix"] = c.MachineNamePrefix
+	}
+	if len(c.RequiredSecrets) > 0 {
+		rawData["required_secrets"] = c.RequiredSecrets
+	}
 	if c.HTTPService != nil {
 		rawData["http_service"] = c.HTTPService
 	}
+	if len(c.Files) > 0 {
+		rawData["files"] = c.Files
+	}
+	if len(c.Restart) > 0 {
+		rawData["restart"] = c.Restart
+	}
+	if len(c.MachineMetadata) > 0 {
+		rawData["machine_metadata"] = c.MachineMetadata
+	}
+	if c.DNS != nil {
+		rawData["dns"] = c.DNS
+	}
 
 	if len(rawData) > 0 {
 		// roundtrip through json encoder to convert float64 numbers to json.Number,
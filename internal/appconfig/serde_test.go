@@ -159,6 +159,38 @@ func TestLoadTOMLAppConfigMountsArray(t *testing.T) {
 	}, cfg)
 }
 
+func TestLoadTOMLAppConfigProcessTables(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/tomachine-processes.toml")
+	require.NoError(t, err)
+	want := map[string]Process{
+		"web": {Command: "run web --port 8080"},
+		"worker": {
+			Cmd:         []string{"run", "worker", "--queue", "a b"},
+			Entrypoint:  []string{"/bin/tini", "--"},
+			WaitTimeout: api.MustParseDuration("6m"),
+		},
+		"task": {Exec: []string{"/bin/task"}},
+		// Arrays are passed as they are, without shell splitting
+		"api": {Cmd: []string{"./server", "--config", `{"x": 1}`}},
+	}
+	assert.Equal(t, want, cfg.Processes)
+	wantDeploy := &Deploy{ReleaseCommandArgs: []string{"./migrate", "--opts", `{"a": "b c"}`}}
+	assert.Equal(t, wantDeploy, cfg.Deploy)
+
+	// Tables are written back inline in the [processes] section
+	require.NoError(t, cfg.SetMachinesPlatform())
+	buf, err := cfg.marshalTOML()
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `task = { exec = ["/bin/task"] }`)
+	assert.Contains(t, string(buf), `wait_timeout = "6m0s"`)
+	assert.Contains(t, string(buf), `release_command = ["./migrate", "--opts", "{\"a\": \"b c\"}"]`)
+
+	cfg, err = unmarshalTOML(buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, cfg.Processes)
+	assert.Equal(t, wantDeploy, cfg.Deploy)
+}
+
 func TestLoadTOMLAppConfigOldFormat(t *testing.T) {
 	const path = "./testdata/old-format.toml"
 	cfg, err := LoadConfig(path)
@@ -286,6 +318,11 @@ func TestLoadTOMLAppConfigReferenceFormat(t *testing.T) {
 		KillSignal:       api.Pointer("SIGTERM"),
 		KillTimeout:      api.Pointer(3),
 		PrimaryRegion:    "sea",
+		Regions:          []string{"sea", "ord"},
+
+		MachineNamePrefix: "foo",
+		RequiredSecrets:   []string{"DATABASE_URL"},
+
 		Experimental: &Experimental{
 			Cmd:          []string{"cmd"},
 			Entrypoint:   []string{"entrypoint"},
@@ -317,22 +354,41 @@ func TestLoadTOMLAppConfigReferenceFormat(t *testing.T) {
 		Deploy: &Deploy{
 			ReleaseCommand: "release command",
 			Strategy:       "rolling-eyes",
+			NoPublicIPs:    true,
+			PostDeployProbe: &PostDeployProbe{
+				URL:            "https://example.com/health",
+				ExpectedStatus: 204,
+				Timeout:        api.MustParseDuration("5s"),
+			},
 		},
 
 		Env: map[string]string{
 			"FOO": "BAR",
 		},
 
-		Metrics: &api.MachineMetrics{
+		MachineMetadata: map[string]string{
+			"team": "infra",
+		},
+
+		Metrics: []*Metrics{{
 			Port: 9999,
 			Path: "/metrics",
+		}},
+
+		DNS: &api.DNSConfig{
+			Nameservers: []string{"fdaa::3"},
+			Searches:    []string{"internal.example.com"},
 		},
 
 		HTTPService: &HTTPService{
 			InternalPort: 8080,
 			ForceHTTPS:   true,
+
+			AutoStopMachines:   api.Pointer(true),
+			AutoStartMachines:  api.Pointer(false),
+			MinMachinesRunning: api.Pointer(1),
 			Concurrency: &api.MachineServiceConcurrency{
-				Type:      "donuts",
+				Type:      "connections",
 				HardLimit: 10,
 				SoftLimit: 4,
 			},
@@ -350,9 +406,31 @@ func TestLoadTOMLAppConfigReferenceFormat(t *testing.T) {
 			Destination: "/data",
 		}},
 
-		Processes: map[string]string{
-			"web":  "run web",
-			"task": "task all day",
+		Files: []File{
+			{
+				GuestPath: "/path/to/hello.txt",
+				RawValue:  "aGVsbG8gd29ybGQK",
+			},
+			{
+				GuestPath:  "/path/to/secret.txt",
+				SecretName: "SUPER_SECRET",
+			},
+			{
+				GuestPath: "/path/to/config.yaml",
+				LocalPath: "/local/path/config.yaml",
+				Processes: []string{"web"},
+			},
+		},
+
+		Restart: []Restart{{
+			Policy:     "on-failure",
+			MaxRetries: 3,
+			Processes:  []string{"task"},
+		}},
+
+		Processes: map[string]Process{
+			"web":  {Command: "run web"},
+			"task": {Command: "task all day"},
 		},
 
 		Checks: map[string]*ToplevelCheck{
@@ -427,5 +505,14 @@ func TestLoadTOMLAppConfigReferenceFormat(t *testing.T) {
 				},
 			},
 		},
+
+		Machines: []MachineSettings{{
+			Processes:     []string{"web"},
+			Count:         api.Pointer(2),
+			Standby:       api.Pointer(true),
+			StandbyRegion: "ord",
+			NamePrefix:    "web",
+			Env:           map[string]string{"GOMAXPROCS": "2"},
+		}},
 	}, cfg)
 }
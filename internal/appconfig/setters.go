@@ -119,6 +119,7 @@ func (c *Config) SetReleaseCommand(cmd string) {
 		c.Deploy = &Deploy{}
 	}
 	c.Deploy.ReleaseCommand = cmd
+	c.Deploy.ReleaseCommandArgs = nil
 }
 
 func (c *Config) v1SetReleaseCommand(cmd string) {
@@ -233,9 +234,9 @@ func (c *Config) v1GetEnvVariables() map[string]string {
 func (c *Config) SetProcess(name, value string) {
 	c.v1SetProcess(name, value)
 	if c.Processes == nil {
-		c.Processes = make(map[string]string)
+		c.Processes = make(map[string]Process)
 	}
-	c.Processes[name] = value
+	c.Processes[name] = Process{Command: value}
 }
 
 func (c *Config) v1SetProcess(name, value string) {
@@ -257,6 +258,7 @@ func (c *Config) SetStatics(statics []Static) {
 		c.Statics = append(c.Statics, Static{
 			GuestPath: static.GuestPath,
 			UrlPrefix: static.UrlPrefix,
+			Processes: static.Processes,
 		})
 	}
 }
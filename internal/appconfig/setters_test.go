@@ -209,10 +209,10 @@ func TestSetProcesses_SectionExists(t *testing.T) {
 
 	cfg.SetProcess("app", "run-web")
 	cfg.SetProcess("back", "run-back")
-	assert.Equal(t, cfg.Processes, map[string]string{
-		"app":  "run-web",
-		"back": "run-back",
-		"foo":  "bar",
+	assert.Equal(t, cfg.Processes, map[string]Process{
+		"app":  {Command: "run-web"},
+		"back": {Command: "run-back"},
+		"foo":  {Command: "bar"},
 	})
 	assert.Equal(t, cfg.RawDefinition, map[string]any{
 		"app": "setters",
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"strings"
 
 	"github.com/google/shlex"
 	"github.com/logrusorgru/aurora"
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/client"
 	"github.com/superfly/flyctl/internal/sentry"
 	"golang.org/x/exp/slices"
@@ -15,15 +19,55 @@ import (
 
 var ValidationError = errors.New("invalid app configuration")
 
+// ValidationResult holds the errors and warnings found validating an app config
+type ValidationResult struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+	// Output is the validation as shown to humans, with colors
+	Output string `json:"-"`
+}
+
+func newValidationResult() *ValidationResult {
+	return &ValidationResult{Valid: true, Errors: []string{}, Warnings: []string{}}
+}
+
+func (r *ValidationResult) infof(format string, a ...any) {
+	r.Output += fmt.Sprintf(format, a...) + "\n"
+}
+
+func (r *ValidationResult) warnf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	r.Warnings = append(r.Warnings, msg)
+	r.Output += fmt.Sprintf("%s %s\n", aurora.Yellow("WARN"), msg)
+}
+
+func (r *ValidationResult) errorf(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	r.Valid = false
+	r.Errors = append(r.Errors, msg)
+	r.Output += msg + "\n"
+}
+
 func (cfg *Config) Validate(ctx context.Context) (err error, extra_info string) {
+	result, err := cfg.ValidateWithResult(ctx)
+	return err, result.Output
+}
+
+// ValidateWithResult validates the config like Validate, returning what it found apart.
+// The error is set when the config is invalid or couldn't be validated
+func (cfg *Config) ValidateWithResult(ctx context.Context) (*ValidationResult, error) {
 	appName := NameFromContext(ctx)
 	apiClient := client.FromContext(ctx).API()
+	r := newValidationResult()
 
 	if cfg == nil {
-		return errors.New("App config file not found"), ""
+		err := errors.New("App config file not found")
+		r.Valid, r.Errors = false, []string{err.Error()}
+		return r, err
 	}
 
-	extra_info = fmt.Sprintf("Validating %s\n", cfg.ConfigFilePath())
+	r.infof("Validating %s", cfg.ConfigFilePath())
 
 	platformVersion := cfg.platformVersion
 	if platformVersion == "" {
@@ -31,125 +75,203 @@ func (cfg *Config) Validate(ctx context.Context) (err error, extra_info string)
 		switch {
 		case err == nil:
 			platformVersion = app.PlatformVersion
-			extra_info += fmt.Sprintf("Platform: %s\n", platformVersion)
+			r.infof("Platform: %s", platformVersion)
 		case strings.Contains(err.Error(), "Could not find App"):
 			platformVersion = NomadPlatform
-			extra_info += fmt.Sprintf("WARNING: Failed to fetch platform version: %s\n", err)
+			r.Warnings = append(r.Warnings, fmt.Sprintf("Failed to fetch platform version: %s", err))
+			r.infof("WARNING: Failed to fetch platform version: %s", err)
 		default:
-			return err, extra_info
+			r.Valid, r.Errors = false, []string{err.Error()}
+			return r, err
 		}
 	} else {
-		extra_info += fmt.Sprintf("Platform: %s\n", platformVersion)
+		r.infof("Platform: %s", platformVersion)
 	}
 
 	switch platformVersion {
 	case MachinesPlatform:
-		platErr, platExtra := cfg.ValidateForMachinesPlatform(ctx)
-		return platErr, extra_info + platExtra
+		return r, cfg.validateForMachinesPlatform(r)
 	case NomadPlatform:
-		platErr, platExtra := cfg.ValidateForNomadPlatform(ctx)
-		return platErr, extra_info + platExtra
+		return r, cfg.validateForNomadPlatform(ctx, r)
 	case "", DetachedPlatform:
-		return nil, ""
+		return newValidationResult(), nil
 	default:
-		return fmt.Errorf("Unknown platform version '%s' for app '%s'", platformVersion, appName), extra_info
+		err := fmt.Errorf("Unknown platform version '%s' for app '%s'", platformVersion, appName)
+		r.Valid, r.Errors = false, []string{err.Error()}
+		return r, err
 	}
 }
 
 func (cfg *Config) ValidateForNomadPlatform(ctx context.Context) (err error, extra_info string) {
-	if extra, _ := cfg.validateBuildStrategies(); extra != "" {
-		extra_info += extra
-	}
+	r := newValidationResult()
+	err = cfg.validateForNomadPlatform(ctx, r)
+	return err, r.Output
+}
+
+func (cfg *Config) validateForNomadPlatform(ctx context.Context, r *ValidationResult) error {
+	cfg.validateBuildStrategies(r)
 
 	appName := NameFromContext(ctx)
 	apiClient := client.FromContext(ctx).API()
 	serverCfg, err := apiClient.ValidateConfig(ctx, appName, cfg.SanitizedDefinition())
 	if err != nil {
-		return err, extra_info
+		r.Valid = false
+		r.Errors = append(r.Errors, err.Error())
+		return err
 	}
 
 	if _, haveHTTPService := cfg.RawDefinition["http_service"]; haveHTTPService {
 		// TODO: eventually make this fail validation
-		msg := fmt.Sprintf("%s the http_service section is ignored for Nomad apps", aurora.Yellow("WARN"))
-		extra_info += msg + "\n"
-		sentry.CaptureException(errors.New(msg))
+		r.warnf("the http_service section is ignored for Nomad apps")
+		sentry.CaptureException(errors.New("WARN the http_service section is ignored for Nomad apps"))
 	}
 
 	if serverCfg.Valid {
-		extra_info += fmt.Sprintf("%s Configuration is valid\n", aurora.Green("✓"))
-		return nil, extra_info
+		r.infof("%s Configuration is valid", aurora.Green("✓"))
+		return nil
 	} else {
+		r.Valid = false
 		for _, errStr := range serverCfg.Errors {
-			extra_info += fmt.Sprintf("   %s%s\n", aurora.Red("✘"), errStr)
+			r.Errors = append(r.Errors, errStr)
+			r.infof("   %s%s", aurora.Red("✘"), errStr)
 		}
-		extra_info += "\n"
-		return errors.New("App configuration is not valid"), extra_info
+		r.infof("")
+		return errors.New("App configuration is not valid")
 	}
 }
 
 func (cfg *Config) ValidateForMachinesPlatform(ctx context.Context) (err error, extra_info string) {
-	validators := []func() (string, error){
+	r := newValidationResult()
+	err = cfg.validateForMachinesPlatform(r)
+	return err, r.Output
+}
+
+func (cfg *Config) validateForMachinesPlatform(r *ValidationResult) error {
+	validators := []func(*ValidationResult){
 		cfg.validateBuildStrategies,
+		cfg.validateRegionsSection,
+		cfg.validateKillSettings,
+		cfg.validateMachineMetadata,
 		cfg.validateDeploySection,
+		cfg.validateExperimentalSection,
 		cfg.validateChecksSection,
 		cfg.validateServicesSection,
+		cfg.validateServicePorts,
 		cfg.validateProcessesSection,
+		cfg.validateMachinesSection,
+		cfg.validateMountsSection,
+		cfg.validateFilesSection,
+		cfg.validateStaticsSection,
+		cfg.validateRestartSection,
+		cfg.validateMetricsSection,
+		cfg.validateDNSSection,
 		cfg.validateMachineConversion,
 	}
 
 	for _, vFunc := range validators {
-		info, vErr := vFunc()
-		extra_info += info
-		if vErr != nil {
-			err = vErr
-		}
+		vFunc(r)
 	}
 
+	var err error
+	if !r.Valid {
+		err = ValidationError
+	}
 	if vErr := cfg.EnsureV2Config(); vErr != nil {
+		r.Valid = false
+		r.Errors = append(r.Errors, vErr.Error())
 		err = vErr
 	}
 
 	if err != nil {
-		extra_info += fmt.Sprintf("\n   %s%s\n", aurora.Red("✘"), err)
-		return errors.New("App configuration is not valid"), extra_info
+		r.infof("\n   %s%s", aurora.Red("✘"), err)
+		return errors.New("App configuration is not valid")
 	}
 
-	extra_info += fmt.Sprintf("%s Configuration is valid\n", aurora.Green("✓"))
-	return nil, extra_info
+	r.infof("%s Configuration is valid", aurora.Green("✓"))
+	return nil
 }
 
-func (cfg *Config) validateBuildStrategies() (extraInfo string, err error) {
+func (cfg *Config) validateBuildStrategies(r *ValidationResult) {
 	buildStrats := cfg.BuildStrategies()
 	if len(buildStrats) > 1 {
 		// TODO: validate that most users are not affected by this and/or fixing this, then make it fail validation
-		msg := fmt.Sprintf("%s more than one build configuration found: [%s]", aurora.Yellow("WARN"), strings.Join(buildStrats, ", "))
-		extraInfo += msg + "\n"
-		sentry.CaptureException(errors.New(msg))
+		msg := fmt.Sprintf("more than one build configuration found: [%s]", strings.Join(buildStrats, ", "))
+		r.warnf("%s", msg)
+		sentry.CaptureException(errors.New("WARN " + msg))
+	}
+}
+
+func (cfg *Config) validateRegionsSection(r *ValidationResult) {
+	if len(cfg.Regions) == 0 {
+		return
+	}
+	if cfg.PrimaryRegion == "" {
+		r.errorf("The regions setting requires primary_region to be set too")
+	} else if !slices.Contains(cfg.Regions, cfg.PrimaryRegion) {
+		r.warnf("primary region '%s' is not listed in regions, it will be used anyway", cfg.PrimaryRegion)
+	}
+	for _, region := range cfg.Regions {
+		if region == "" {
+			r.errorf("The regions setting can't contain empty region names")
+		}
 	}
-	return
 }
 
-func (cfg *Config) validateDeploySection() (extraInfo string, err error) {
+func (cfg *Config) validateKillSettings(r *ValidationResult) {
+	if cfg.KillSignal != nil {
+		if _, vErr := api.ValidateSignal(*cfg.KillSignal); vErr != nil {
+			r.errorf("Invalid kill_signal '%s': %s", *cfg.KillSignal, vErr)
+		}
+	}
+	if cfg.KillTimeout != nil && *cfg.KillTimeout < 0 {
+		r.errorf("kill_timeout can't be negative")
+	}
+}
+
+func (cfg *Config) validateMachineMetadata(r *ValidationResult) {
+	for key := range cfg.MachineMetadata {
+		switch {
+		case key == "":
+			r.errorf("Machine metadata keys can't be empty")
+		case strings.HasPrefix(key, "fly_") || strings.HasPrefix(key, "fly-"):
+			r.errorf("Machine metadata key '%s' uses the reserved 'fly_' or 'fly-' prefix, rename it", key)
+		case strings.Contains(key, ","):
+			r.errorf("Machine metadata key '%s' can't contain commas", key)
+		}
+	}
+}
+
+func (cfg *Config) validateExperimentalSection(r *ValidationResult) {
+	if exp := cfg.Experimental; exp != nil && (len(exp.Cmd) > 0 || len(exp.Entrypoint) > 0 || len(exp.Exec) > 0) {
+		r.warnf("[experimental] cmd, entrypoint and exec are deprecated, set them for each group in the [processes] section instead")
+	}
+}
+
+func (cfg *Config) validateDeploySection(r *ValidationResult) {
 	if cfg.Deploy != nil {
-		if _, vErr := shlex.Split(cfg.Deploy.ReleaseCommand); vErr != nil {
-			extraInfo += fmt.Sprintf("Can't shell split release command: '%s'\n", cfg.Deploy.ReleaseCommand)
-			err = ValidationError
+		if _, vErr := cfg.Deploy.ReleaseCmd(); vErr != nil {
+			r.errorf("Can't shell split release command: '%s'", cfg.Deploy.ReleaseCommand)
+		}
+		if probe := cfg.Deploy.PostDeployProbe; probe != nil {
+			if u, uErr := url.Parse(probe.URL); uErr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				r.errorf("post_deploy_probe url must be an http or https URL, got '%s'", probe.URL)
+			}
+			if probe.ExpectedStatus != 0 && (probe.ExpectedStatus < 100 || probe.ExpectedStatus > 599) {
+				r.errorf("post_deploy_probe expected_status must be an HTTP status code, got %d", probe.ExpectedStatus)
+			}
 		}
 	}
-	return
 }
 
-func (cfg *Config) validateChecksSection() (extraInfo string, err error) {
+func (cfg *Config) validateChecksSection(r *ValidationResult) {
 	for name, check := range cfg.Checks {
 		if _, vErr := check.toMachineCheck(); vErr != nil {
-			extraInfo += fmt.Sprintf("Can't process top level check '%s': %s\n", name, vErr)
-			err = ValidationError
+			r.errorf("Can't process top level check '%s': %s", name, vErr)
 		}
 	}
-	return
 }
 
-func (cfg *Config) validateServicesSection() (extraInfo string, err error) {
+func (cfg *Config) validateServicesSection(r *ValidationResult) {
 	validGroupNames := cfg.ProcessNames()
 	// The following is different than len(validGroupNames) because
 	// it can be zero when there is no [processes] section
@@ -158,52 +280,372 @@ func (cfg *Config) validateServicesSection() (extraInfo string, err error) {
 	for _, service := range cfg.AllServices() {
 		switch {
 		case len(service.Processes) == 0 && processCount > 0:
-			extraInfo += fmt.Sprintf(
-				"Service has no processes set but app has %d processes defined; update fly.toml to set processes for each service\n",
+			r.errorf(
+				"Service has no processes set but app has %d processes defined; update fly.toml to set processes for each service",
 				processCount,
 			)
-			err = ValidationError
 		default:
 			for _, processName := range service.Processes {
 				if !slices.Contains(validGroupNames, processName) {
-					extraInfo += fmt.Sprintf(
+					r.errorf(
 						"Service specifies '%s' as one of its processes, but no processes are defined with that name; "+
-							"update fly.toml [processes] to add '%s' process or remove it from service's processes list\n",
+							"update fly.toml [processes] to add '%s' process or remove it from service's processes list",
 						processName, processName,
 					)
-					err = ValidationError
 				}
 			}
 		}
+
+		if c := service.Concurrency; c != nil {
+			switch {
+			case c.Type != "" && c.Type != "connections" && c.Type != "requests":
+				r.errorf(
+					"Service on port %d has concurrency type '%s', it must be 'connections' or 'requests'",
+					service.InternalPort, c.Type,
+				)
+			case c.HardLimit < 0 || c.SoftLimit < 0:
+				r.errorf("Service on port %d can't have negative concurrency limits", service.InternalPort)
+			case c.HardLimit > 0 && c.SoftLimit > c.HardLimit:
+				r.errorf(
+					"Service on port %d has a concurrency soft_limit (%d) above its hard_limit (%d), lower soft_limit or raise hard_limit",
+					service.InternalPort, c.SoftLimit, c.HardLimit,
+				)
+			}
+		}
+
+		if service.MinMachinesRunning != nil {
+			switch {
+			case *service.MinMachinesRunning < 0:
+				r.errorf("Service on port %d has a negative min_machines_running", service.InternalPort)
+			case service.AutoStopMachines == nil || !*service.AutoStopMachines:
+				r.warnf(
+					"service on port %d sets min_machines_running but it only applies when auto_stop_machines is enabled",
+					service.InternalPort,
+				)
+			}
+		}
+	}
+}
+
+var validPortHandlers = []string{"http", "tls", "pg_tls", "proxy_proto", "edge_http"}
+
+// validateServicePorts catches port settings the proxy would reject or misroute once deployed
+func (cfg *Config) validateServicePorts(r *ValidationResult) {
+	type usedPort struct {
+		section    string
+		start, end int
+	}
+	used := map[string][]usedPort{}
+
+	// AllServices puts http_service first
+	offset := lo.Ternary(cfg.HTTPService != nil, 1, 0)
+	for i, service := range cfg.AllServices() {
+		section := "[http_service]"
+		if i >= offset {
+			section = fmt.Sprintf("[[services]] #%d", i-offset+1)
+		}
+
+		if service.InternalPort < 1 || service.InternalPort > 65535 {
+			r.errorf("%s has internal_port %d, it must be between 1 and 65535", section, service.InternalPort)
+		}
+
+		for _, port := range service.Ports {
+			start, end, pErr := portRange(port)
+			if pErr != nil {
+				r.errorf("%s: %s", section, pErr)
+				continue
+			}
+			portStr := lo.Ternary(start == end, fmt.Sprint(start), fmt.Sprintf("%d-%d", start, end))
+
+			for _, h := range port.Handlers {
+				if !slices.Contains(validPortHandlers, h) {
+					r.errorf(
+						"%s port %s has unknown handler '%s', it must be one of %s",
+						section, portStr, h, strings.Join(validPortHandlers, ", "),
+					)
+				}
+			}
+			if port.ForceHttps && !slices.Contains(port.Handlers, "http") {
+				r.errorf("%s port %s sets force_https without the 'http' handler", section, portStr)
+			}
+			if start == 443 && slices.Contains(port.Handlers, "http") && !slices.Contains(port.Handlers, "tls") {
+				r.warnf("%s port 443 has the 'http' handler without 'tls'", section)
+			}
+
+			// External ports are shared by all the process groups of the app
+			key := lo.Ternary(service.Protocol == "", "tcp", service.Protocol)
+			for _, u := range used[key] {
+				if start <= u.end && u.start <= end {
+					r.errorf("%s and %s both use %s port %s", u.section, section, key, portStr)
+				}
+			}
+			used[key] = append(used[key], usedPort{section: section, start: start, end: end})
+		}
 	}
-	return extraInfo, err
 }
 
-func (cfg *Config) validateProcessesSection() (extraInfo string, err error) {
-	for processName, cmdStr := range cfg.Processes {
-		if cmdStr == "" {
+// portRange returns the external ports a service port listens on
+func portRange(port api.MachinePort) (start, end int, err error) {
+	switch {
+	case port.Port != nil:
+		start, end = *port.Port, *port.Port
+	case port.StartPort != nil && port.EndPort != nil:
+		start, end = *port.StartPort, *port.EndPort
+		if start > end {
+			return 0, 0, fmt.Errorf("port range %d-%d starts after it ends", start, end)
+		}
+	default:
+		return 0, 0, fmt.Errorf("ports need either port or start_port and end_port set")
+	}
+	if start < 1 || end > 65535 {
+		return 0, 0, fmt.Errorf("port %d-%d is outside the 1-65535 range", start, end)
+	}
+	return start, end, nil
+}
+
+func (cfg *Config) validateProcessesSection(r *ValidationResult) {
+	for processName, process := range cfg.Processes {
+		if process.Schedule != "" && !slices.Contains(processSchedules, process.Schedule) {
+			r.errorf(
+				"Process group '%s' has schedule '%s', it must be one of %s",
+				processName, process.Schedule, strings.Join(processSchedules, ", "),
+			)
+		}
+		if process.WaitTimeout != nil && process.WaitTimeout.Duration <= 0 {
+			r.errorf("Process group '%s' has wait_timeout %s, it must be positive", processName, process.WaitTimeout)
+		}
+		if fc, fErr := cfg.Flatten(processName); process.Schedule != "" && fErr == nil && len(fc.AllServices()) > 0 {
+			r.warnf(
+				"process group '%s' is scheduled but has services, its machines won't serve requests between runs",
+				processName,
+			)
+		}
+		if process.isTable() || process.Command == "" {
 			continue
 		}
 
-		_, vErr := shlex.Split(cmdStr)
+		_, vErr := shlex.Split(process.Command)
 		if vErr != nil {
-			extraInfo += fmt.Sprintf(
-				"Could not parse command for '%s' process group; check [processes] section: %s\n",
+			r.errorf(
+				"Could not parse command for '%s' process group; check [processes] section: %s",
 				processName, vErr,
 			)
-			err = ValidationError
+		}
+	}
+}
+
+func (cfg *Config) validateMachinesSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	processCount := len(cfg.Processes)
+	seen := map[string]bool{}
+
+	for _, m := range cfg.Machines {
+		if m.Count != nil && *m.Count < 0 {
+			r.errorf("Machines count can't be negative, got %d", *m.Count)
+		}
+
+		groups := m.Processes
+		switch {
+		case len(groups) == 0 && processCount > 0:
+			r.errorf(
+				"Machines section has no processes set but app has %d processes defined; update fly.toml to set processes for each machines section",
+				processCount,
+			)
+			continue
+		case len(groups) == 0:
+			groups = []string{cfg.DefaultProcessName()}
+		}
+
+		for _, processName := range groups {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf(
+					"Machines section specifies '%s' as one of its processes, but no processes are defined with that name",
+					processName,
+				)
+				continue
+			}
+			if seen[processName] {
+				r.errorf("Process group '%s' is referenced by more than one machines section", processName)
+			}
+			seen[processName] = true
+
+			// Standbys take over machines of groups without services, deployments ignore them elsewhere
+			if m.Standby != nil && *m.Standby {
+				groupConfig, fErr := cfg.Flatten(processName)
+				switch {
+				case fErr != nil:
+				case len(groupConfig.AllServices()) > 0:
+					r.warnf("process group '%s' has services, its standby setting is ignored", processName)
+				case len(groupConfig.Mounts) > 0:
+					r.warnf("process group '%s' has mounts, its standby setting is ignored", processName)
+				}
+			}
+		}
+	}
+}
+
+func (cfg *Config) validateMountsSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	for _, m := range cfg.Mounts {
+		for _, processName := range m.Processes {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf("Mount '%s' specifies '%s' as one of its processes, but no processes are defined with that name", m.Source, processName)
+			}
+		}
+	}
+
+	for _, groupName := range validGroupNames {
+		fc, fErr := cfg.Flatten(groupName)
+		if fErr != nil {
+			continue
+		}
+		if len(fc.Mounts) > 1 {
+			r.errorf("Process group '%s' has %d mounts but machines only support one, set processes on each mount", groupName, len(fc.Mounts))
+		}
+	}
+}
+
+func (cfg *Config) validateFilesSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	for _, f := range cfg.Files {
+		f.LocalPath = cfg.localFilePath(f.LocalPath)
+		if vErr := f.validate(); vErr != nil {
+			r.errorf("Invalid file: %s", vErr)
+		}
+		for _, processName := range f.Processes {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf("File '%s' specifies '%s' as one of its processes, but no processes are defined with that name", f.GuestPath, processName)
+			}
+		}
+	}
+}
+
+func (cfg *Config) validateStaticsSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	for _, s := range cfg.Statics {
+		for _, processName := range s.Processes {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf("Static '%s' specifies '%s' as one of its processes, but no processes are defined with that name", s.UrlPrefix, processName)
+			}
+		}
+	}
+
+	for _, groupName := range validGroupNames {
+		fc, fErr := cfg.Flatten(groupName)
+		if fErr != nil || len(fc.Statics) == 0 {
+			continue
+		}
+		if !fc.HasHttpPorts() {
+			r.warnf("process group '%s' has statics but doesn't serve HTTP, they won't be reachable", groupName)
+		}
+		for i, a := range fc.Statics {
+			for _, b := range fc.Statics[i+1:] {
+				switch {
+				case a.UrlPrefix == b.UrlPrefix:
+					r.errorf("Statics url_prefix '%s' is used more than once in process group '%s'", a.UrlPrefix, groupName)
+				case staticPrefixContains(a.UrlPrefix, b.UrlPrefix) || staticPrefixContains(b.UrlPrefix, a.UrlPrefix):
+					r.warnf("statics url_prefix '%s' and '%s' overlap in process group '%s'", a.UrlPrefix, b.UrlPrefix, groupName)
+				}
+			}
+		}
+	}
+}
+
+// staticPrefixContains is true when url prefix b is nested under prefix a
+func staticPrefixContains(a, b string) bool {
+	return strings.HasPrefix(b, strings.TrimSuffix(a, "/")+"/")
+}
+
+func (cfg *Config) validateRestartSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	validPolicies := []api.MachineRestartPolicy{
+		api.MachineRestartPolicyNo,
+		api.MachineRestartPolicyOnFailure,
+		api.MachineRestartPolicyAlways,
+	}
+
+	unscoped := 0
+	scoped := map[string]int{}
+	for _, restart := range cfg.Restart {
+		if !slices.Contains(validPolicies, api.MachineRestartPolicy(restart.Policy)) {
+			r.errorf("Invalid restart policy '%s', it must be one of 'no', 'on-failure' or 'always'", restart.Policy)
+		}
+		switch {
+		case restart.MaxRetries < 0:
+			r.errorf("Restart max_retries can't be negative")
+		case restart.MaxRetries > 0 && restart.Policy != string(api.MachineRestartPolicyOnFailure):
+			r.warnf("restart max_retries is only used with the 'on-failure' policy")
+		}
+		if len(restart.Processes) == 0 {
+			unscoped++
+		}
+		for _, processName := range restart.Processes {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf("Restart section specifies '%s' as one of its processes, but no processes are defined with that name", processName)
+			}
+			scoped[processName]++
+		}
+	}
+
+	if unscoped > 1 {
+		r.errorf("Only one restart section can omit processes")
+	}
+	for _, groupName := range validGroupNames {
+		if scoped[groupName] > 1 {
+			r.errorf("Process group '%s' has more than one restart section", groupName)
+		}
+	}
+}
+
+func (cfg *Config) validateMetricsSection(r *ValidationResult) {
+	validGroupNames := cfg.ProcessNames()
+	unscoped := 0
+	scoped := map[string]int{}
+	for _, m := range cfg.Metrics {
+		if m.Port <= 0 || m.Port > 65535 {
+			r.errorf("Metrics port %d is not a valid port number", m.Port)
+		}
+		if len(m.Processes) == 0 {
+			unscoped++
+		}
+		for _, processName := range m.Processes {
+			if !slices.Contains(validGroupNames, processName) {
+				r.errorf("Metrics section specifies '%s' as one of its processes, but no processes are defined with that name", processName)
+			}
+			scoped[processName]++
 		}
 	}
 
-	return extraInfo, err
+	if unscoped > 1 {
+		r.errorf("Only one metrics section can omit processes")
+	}
+	for _, groupName := range validGroupNames {
+		if scoped[groupName] > 1 {
+			r.errorf("Process group '%s' has more than one metrics section", groupName)
+		}
+	}
+}
+
+func (cfg *Config) validateDNSSection(r *ValidationResult) {
+	if cfg.DNS == nil {
+		return
+	}
+	for _, ns := range cfg.DNS.Nameservers {
+		if net.ParseIP(ns) == nil {
+			r.errorf("DNS nameserver '%s' is not a valid IP address", ns)
+		}
+	}
+	for _, search := range cfg.DNS.Searches {
+		if search == "" || strings.ContainsAny(search, " \t") {
+			r.errorf("DNS search domain '%s' is not valid", search)
+		}
+	}
 }
 
-func (cfg *Config) validateMachineConversion() (extraInfo string, err error) {
+func (cfg *Config) validateMachineConversion(r *ValidationResult) {
 	for _, name := range cfg.ProcessNames() {
-		if _, vErr := cfg.ToMachineConfig(name, nil); err != nil {
-			extraInfo += fmt.Sprintf("Converting to machine in process group '%s' will fail because of: %s", name, vErr)
-			err = ValidationError
+		if _, vErr := cfg.ToMachineConfig(name, nil); vErr != nil {
+			r.errorf("Converting to machine in process group '%s' will fail because of: %s", name, vErr)
 		}
 	}
-	return
 }
@@ -1,6 +1,8 @@
 package imgsrc
 
 import (
+	"encoding/base64"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,3 +31,39 @@ func TestAllowedDockerDaemonMode(t *testing.T) {
 		assert.Equal(t, test.expected, m)
 	}
 }
+
+func TestRegistryCredentials(t *testing.T) {
+	_, err := ParseRegistryCredentials("octocat")
+	assert.Error(t, err)
+	_, err = ParseRegistryCredentials("octocat:")
+	assert.Error(t, err)
+
+	creds, err := ParseRegistryCredentials("octocat:ghp_secret:with:colons")
+	assert.NoError(t, err)
+	assert.Equal(t, &RegistryCredentials{Username: "octocat", Password: "ghp_secret:with:colons"}, creds)
+
+	// The password never shows up when credentials are printed
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		assert.NotContains(t, fmt.Sprintf(format, *creds), "ghp_secret", format)
+		assert.NotContains(t, fmt.Sprintf(format, creds), "ghp_secret", format)
+	}
+
+	encoded, err := creds.encode("ghcr.io")
+	assert.NoError(t, err)
+	decoded, err := base64.URLEncoding.DecodeString(encoded)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"username":"octocat","password":"ghp_secret:with:colons","serveraddress":"ghcr.io"}`, string(decoded))
+}
+
+func TestCheckPlatforms(t *testing.T) {
+	assert.NoError(t, checkPlatforms("my-image", []string{"linux/arm64/v8", "linux/amd64"}))
+	assert.NoError(t, checkPlatforms("my-image", []string{formatPlatform("linux", "amd64", "v3")}))
+	// Platforms the registry or daemon didn't report aren't checked
+	assert.NoError(t, checkPlatforms("my-image", []string{formatPlatform("", "", "")}))
+
+	err := checkPlatforms("my-image", []string{"linux/arm64/v8", "linux/arm/v7", "linux/arm64/v8"})
+	var platformErr *UnsupportedPlatformError
+	assert.ErrorAs(t, err, &platformErr)
+	assert.Equal(t, []string{"linux/arm64/v8", "linux/arm/v7"}, platformErr.Platforms)
+	assert.Contains(t, err.Error(), "image my-image has no linux/amd64 variant, it was built for linux/arm64/v8, linux/arm/v7")
+}
@@ -1,6 +1,9 @@
 package imgsrc
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type RegistryUnauthorizedError struct {
 	Tag string
@@ -9,3 +12,14 @@ type RegistryUnauthorizedError struct {
 func (err *RegistryUnauthorizedError) Error() string {
 	return fmt.Sprintf("you are not authorized to push \"%s\"", err.Tag)
 }
+
+// UnsupportedPlatformError is returned for images without a variant for the platform machines run on
+type UnsupportedPlatformError struct {
+	ImageRef  string
+	Platforms []string
+}
+
+func (err *UnsupportedPlatformError) Error() string {
+	return fmt.Sprintf("image %s has no %s variant, it was built for %s. Rebuild it with `docker build --platform %s` or skip this check with --skip-arch-check",
+		err.ImageRef, MachinesPlatform, strings.Join(err.Platforms, ", "), MachinesPlatform)
+}
@@ -68,6 +68,15 @@ func (*localImageResolver) Run(ctx context.Context, dockerFactory *dockerClientF
 	build.BuildFinish()
 	fmt.Fprintf(streams.ErrOut, "image found: %s\n", img.ID)
 
+	if !opts.SkipArchCheck {
+		inspect, _, err := docker.ImageInspectWithRaw(ctx, img.ID)
+		if err != nil {
+			terminal.Debugf("error inspecting image %s, its platform isn't checked: %s\n", img.ID, err)
+		} else if err := checkPlatforms(opts.ImageRef, []string{formatPlatform(inspect.Os, inspect.Architecture, inspect.Variant)}); err != nil {
+			return nil, "", err
+		}
+	}
+
 	if opts.Publish {
 		build.PushStart()
 		err = docker.ImageTag(ctx, img.ID, opts.Tag)
@@ -0,0 +1,40 @@
+package imgsrc
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// MachinesPlatform is the platform of the hosts running machines, images must have a variant for it
+const MachinesPlatform = "linux/amd64"
+
+// formatPlatform formats the platform of an image like docker does, as os/arch[/variant]
+func formatPlatform(os, arch, variant string) string {
+	if os == "" && arch == "" {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Join([]string{os, arch, variant}, "/"), "/")
+}
+
+// checkPlatforms fails unless one of the platforms of the image at ref is the one of machines.
+// Images with unknown platforms pass, the registry or daemon didn't report them
+func checkPlatforms(ref string, platforms []string) error {
+	var found []string
+	for _, p := range platforms {
+		if p == "" {
+			continue
+		}
+		// The amd64 architecture has no variants that matter to machines
+		if p == MachinesPlatform || strings.HasPrefix(p, MachinesPlatform+"/") {
+			return nil
+		}
+		if !slices.Contains(found, p) {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return &UnsupportedPlatformError{ImageRef: ref, Platforms: found}
+}
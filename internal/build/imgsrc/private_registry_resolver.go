@@ -0,0 +1,149 @@
+package imgsrc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	dockerparser "github.com/novln/docker-parser"
+	"github.com/pkg/errors"
+	"github.com/superfly/flyctl/internal/cmdfmt"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/terminal"
+)
+
+// RegistryCredentials authenticate the pull of an image from a private registry
+type RegistryCredentials struct {
+	Username string
+	Password string
+}
+
+// ParseRegistryCredentials parses registry credentials in the user:token format
+func ParseRegistryCredentials(s string) (*RegistryCredentials, error) {
+	username, password, ok := strings.Cut(s, ":")
+	if !ok || username == "" || password == "" {
+		return nil, errors.New("registry credentials must be in the user:token format")
+	}
+	return &RegistryCredentials{Username: username, Password: password}, nil
+}
+
+// String hides the password, credentials must never end up in logs
+func (c RegistryCredentials) String() string {
+	return c.Username + ":<redacted>"
+}
+
+// GoString hides the password like String does
+func (c RegistryCredentials) GoString() string {
+	return c.String()
+}
+
+// encode returns the credentials for serverAddress in the format of the registry auth of the docker API
+func (c RegistryCredentials) encode(serverAddress string) (string, error) {
+	encodedJSON, err := json.Marshal(types.AuthConfig{
+		Username:      c.Username,
+		Password:      c.Password,
+		ServerAddress: serverAddress,
+	})
+	if err != nil {
+		return "", errors.New("error encoding registry credentials")
+	}
+	return base64.URLEncoding.EncodeToString(encodedJSON), nil
+}
+
+// privateRegistryImageResolver pulls images from private registries with the credentials of the deployment
+// and pushes them to the Fly registry, machines can't pull images needing other credentials than Fly ones
+type privateRegistryImageResolver struct{}
+
+func (*privateRegistryImageResolver) Name() string {
+	return "Private Registry Image Reference"
+}
+
+func (*privateRegistryImageResolver) Run(ctx context.Context, dockerFactory *dockerClientFactory, streams *iostreams.IOStreams, opts RefOptions, build *build) (*DeploymentImage, string, error) {
+	if opts.RegistryCredentials == nil {
+		return nil, "no registry credentials, skipping", nil
+	}
+
+	ref, err := dockerparser.Parse(opts.ImageRef)
+	if err != nil {
+		return nil, "", err
+	}
+
+	build.BuildStart()
+	build.BuilderInitStart()
+	docker, err := dockerFactory.buildFn(ctx, build)
+	build.BuilderInitFinish()
+	if err != nil {
+		build.BuildFinish()
+		return nil, "", err
+	}
+
+	registryAuth, err := opts.RegistryCredentials.encode(ref.Registry())
+	if err != nil {
+		build.BuildFinish()
+		return nil, "", err
+	}
+
+	// Fetching the manifest validates the credentials before pulling anything
+	fmt.Fprintf(streams.ErrOut, "Fetching the manifest of '%s' from %s...\n", opts.ImageRef, ref.Registry())
+	distribution, err := docker.DistributionInspect(ctx, ref.Remote(), registryAuth)
+	if err != nil {
+		build.BuildFinish()
+		return nil, "", fmt.Errorf("failed to fetch the manifest of %s with the registry credentials of %s: %w", opts.ImageRef, opts.RegistryCredentials.Username, err)
+	}
+	// The manifest list tells the platforms of the image without pulling it
+	if !opts.SkipArchCheck {
+		var platforms []string
+		for _, p := range distribution.Platforms {
+			platforms = append(platforms, formatPlatform(p.OS, p.Architecture, p.Variant))
+		}
+		if err := checkPlatforms(opts.ImageRef, platforms); err != nil {
+			build.BuildFinish()
+			return nil, "", err
+		}
+	}
+
+	pullResp, err := docker.ImagePull(ctx, ref.Remote(), types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		build.BuildFinish()
+		return nil, "", errors.Wrap(err, "error pulling image")
+	}
+	defer pullResp.Close() //skipcq: GO-S2307
+	if err := jsonmessage.DisplayJSONMessagesStream(pullResp, streams.ErrOut, streams.StderrFd(), streams.IsStderrTTY(), nil); err != nil {
+		build.BuildFinish()
+		return nil, "", errors.Wrap(err, "error rendering pull status stream")
+	}
+
+	img, _, err := docker.ImageInspectWithRaw(ctx, ref.Remote())
+	build.BuildFinish()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "error inspecting pulled image")
+	}
+	terminal.Debugf("Pulled image %s from %s\n", img.ID, ref.Registry())
+
+	if !opts.Publish {
+		return &DeploymentImage{ID: img.ID, Tag: opts.ImageRef, Size: img.Size}, "", nil
+	}
+
+	if opts.Tag == "" {
+		opts.Tag = NewDeploymentTag(opts.AppName, opts.ImageLabel)
+	}
+
+	build.PushStart()
+	defer build.PushFinish()
+	if err := docker.ImageTag(ctx, img.ID, opts.Tag); err != nil {
+		return nil, "", errors.Wrap(err, "error tagging image")
+	}
+	defer clearDeploymentTags(ctx, docker, opts.Tag)
+
+	cmdfmt.PrintBegin(streams.ErrOut, "Pushing image to fly")
+	if err := pushToFly(ctx, docker, streams, opts.Tag); err != nil {
+		return nil, "", err
+	}
+	cmdfmt.PrintDone(streams.ErrOut, "Pushing image done")
+
+	return &DeploymentImage{ID: img.ID, Tag: opts.Tag, Size: img.Size}, "", nil
+}
@@ -0,0 +1,206 @@
+package imgsrc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	dockerparser "github.com/novln/docker-parser"
+	"github.com/superfly/flyctl/flyctl"
+)
+
+// manifestMediaTypes are the manifests asked to registries, lists of multi-platform images come first
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// registryClient reads the manifests of images with the HTTP API of their registry, without a docker daemon
+type registryClient struct {
+	client *http.Client
+	// scheme is https, tests serve registries over http
+	scheme string
+	// flyToken authenticates to the Fly registry, other registries are read anonymously
+	flyToken string
+	// authorization is the header accepted by the registry, once it challenged a request
+	authorization string
+}
+
+func newRegistryClient(flyToken string) *registryClient {
+	return &registryClient{client: http.DefaultClient, scheme: "https", flyToken: flyToken}
+}
+
+// imagePlatform is the platform of a manifest list entry or of an image config
+type imagePlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+}
+
+// platforms returns the platforms of the image at imageRef, from the manifest list of multi-platform images
+// or from the config of single platform ones
+func (c *registryClient) platforms(ctx context.Context, imageRef string) ([]string, error) {
+	ref, err := dockerparser.Parse(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	base := c.repositoryURL(ref)
+	tag := ref.Tag()
+	if tag == "" {
+		tag = "latest"
+	}
+
+	var manifest struct {
+		Manifests []struct {
+			Platform *imagePlatform `json:"platform"`
+		} `json:"manifests"`
+		Config *struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := c.get(ctx, ref.Registry(), base+"/manifests/"+tag, strings.Join(manifestMediaTypes, ", "), &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Config == nil {
+		var platforms []string
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil {
+				platforms = append(platforms, formatPlatform(m.Platform.OS, m.Platform.Architecture, m.Platform.Variant))
+			}
+		}
+		return platforms, nil
+	}
+
+	var config imagePlatform
+	if err := c.get(ctx, ref.Registry(), base+"/blobs/"+manifest.Config.Digest, "", &config); err != nil {
+		return nil, err
+	}
+	return []string{formatPlatform(config.OS, config.Architecture, config.Variant)}, nil
+}
+
+// ImageTags lists the tags of the repository of imageRef with the HTTP API of its registry
+func ImageTags(ctx context.Context, imageRef string) ([]string, error) {
+	return newRegistryClient(flyctl.GetAPIToken()).tags(ctx, imageRef)
+}
+
+func (c *registryClient) tags(ctx context.Context, imageRef string) ([]string, error) {
+	ref, err := dockerparser.Parse(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.get(ctx, ref.Registry(), c.repositoryURL(ref)+"/tags/list", "", &list); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+// repositoryURL is the base URL of the registry API for the repository of ref
+func (c *registryClient) repositoryURL(ref *dockerparser.Reference) string {
+	host := ref.Registry()
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s", c.scheme, host, ref.ShortName())
+}
+
+// get decodes the JSON document at u, authorizing with the registry when it challenges the request
+func (c *registryClient) get(ctx context.Context, registry, u, accept string, v any) error {
+	resp, err := c.do(ctx, u, accept)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && c.authorization == "" {
+		resp.Body.Close()
+		if c.authorization, err = c.authorize(ctx, registry, resp.Header.Get("WWW-Authenticate")); err != nil {
+			return err
+		}
+		if resp, err = c.do(ctx, u, accept); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close() //skipcq: GO-S2307
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry returned %s for %s", resp.Status, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *registryClient) do(ctx context.Context, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Do(req)
+}
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize answers the WWW-Authenticate challenge of the registry, getting a token for bearer challenges
+func (c *registryClient) authorize(ctx context.Context, registry, challenge string) (string, error) {
+	username, password := "", ""
+	if registry == "registry.fly.io" && c.flyToken != "" {
+		username, password = "x", c.flyToken
+	}
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch {
+	case strings.EqualFold(scheme, "basic") && username != "":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case !strings.EqualFold(scheme, "bearer"):
+		return "", fmt.Errorf("registry %s needs credentials to read manifests", registry)
+	}
+
+	query := url.Values{}
+	realm := ""
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(params, -1) {
+		if m[1] == "realm" {
+			realm = m[2]
+		} else {
+			query.Set(m[1], m[2])
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("registry %s sent a bearer challenge without realm", registry)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() //skipcq: GO-S2307
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry %s refused a token to read manifests: %s", registry, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
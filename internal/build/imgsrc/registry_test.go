@@ -0,0 +1,105 @@
+package imgsrc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// fakeRegistry serves the Fly API resolving images and a registry with a bearer token challenge
+func fakeRegistry(t *testing.T, manifests map[string]any) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/graphql":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"app": map[string]any{
+				"id":    "my-app",
+				"image": map[string]any{"id": "img_1", "ref": "my-image", "compressedSize": 100},
+			}}})
+		case r.URL.Path == "/token":
+			assert.Equal(t, "repository:my/image:pull", r.URL.Query().Get("scope"))
+			json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:my/image:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		case manifests[r.URL.Path] != nil:
+			json.NewEncoder(w).Encode(manifests[r.URL.Path])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRemoteImageResolverChecksPlatforms(t *testing.T) {
+	server := fakeRegistry(t, map[string]any{
+		"/v2/my/image/manifests/arm": map[string]any{"manifests": []map[string]any{
+			{"platform": map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"}},
+		}},
+		"/v2/my/image/manifests/multi": map[string]any{"manifests": []map[string]any{
+			{"platform": map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"}},
+			{"platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+		}},
+		"/v2/my/image/manifests/single": map[string]any{"config": map[string]string{"digest": "sha256:abc"}},
+		"/v2/my/image/blobs/sha256:abc": map[string]string{"os": "linux", "architecture": "arm", "variant": "v7"},
+	})
+	api.SetBaseURL(server.URL)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	run := func(tag string, skipArchCheck bool) (*DeploymentImage, error) {
+		registry := newRegistryClient("")
+		registry.scheme = "http"
+		resolver := &remoteImageResolver{flyApi: client.FromToken("test").API(), registry: registry}
+		ios, _, _, _ := iostreams.Test()
+		opts := RefOptions{AppName: "my-app", ImageRef: host + "/my/image:" + tag, SkipArchCheck: skipArchCheck}
+		img, _, err := resolver.Run(context.Background(), nil, ios, opts, newBuild("", false))
+		return img, err
+	}
+
+	var platformErr *UnsupportedPlatformError
+	_, err := run("arm", false)
+	require.ErrorAs(t, err, &platformErr)
+	assert.Equal(t, []string{"linux/arm64/v8"}, platformErr.Platforms)
+
+	_, err = run("single", false)
+	require.ErrorAs(t, err, &platformErr)
+	assert.Equal(t, []string{"linux/arm/v7"}, platformErr.Platforms)
+
+	img, err := run("multi", false)
+	require.NoError(t, err)
+	assert.Equal(t, "img_1", img.ID)
+
+	_, err = run("arm", true)
+	assert.NoError(t, err)
+
+	// Images whose manifest can't be read are deployed, Fly resolved them
+	_, err = run("missing", false)
+	assert.NoError(t, err)
+}
+
+func TestRegistryClientListsTags(t *testing.T) {
+	server := fakeRegistry(t, map[string]any{
+		"/v2/my/image/tags/list": map[string]any{"name": "my/image", "tags": []string{"v1", "v2"}},
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+	registry := newRegistryClient("")
+	registry.scheme = "http"
+
+	tags, err := registry.tags(context.Background(), host+"/my/image:v3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v1", "v2"}, tags)
+
+	_, err = registry.tags(context.Background(), host+"/other/image:v3")
+	assert.Error(t, err)
+}
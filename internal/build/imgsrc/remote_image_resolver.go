@@ -6,10 +6,13 @@ import (
 
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/terminal"
 )
 
 type remoteImageResolver struct {
 	flyApi *api.Client
+	// registry reads the platforms of the image, the Fly API doesn't report them
+	registry *registryClient
 }
 
 func (*remoteImageResolver) Name() string {
@@ -31,6 +34,15 @@ func (s *remoteImageResolver) Run(ctx context.Context, _ *dockerClientFactory, s
 
 	fmt.Fprintf(streams.ErrOut, "image found: %s\n", img.ID)
 
+	if !opts.SkipArchCheck {
+		platforms, err := s.registry.platforms(ctx, opts.ImageRef)
+		if err != nil {
+			terminal.Debugf("error reading the manifest of %s, its platform isn't checked: %s\n", opts.ImageRef, err)
+		} else if err := checkPlatforms(opts.ImageRef, platforms); err != nil {
+			return nil, "", err
+		}
+	}
+
 	di := &DeploymentImage{
 		ID:   img.ID,
 		Tag:  img.Ref,
@@ -52,6 +52,10 @@ type RefOptions struct {
 	ImageLabel string
 	Publish    bool
 	Tag        string
+	// RegistryCredentials pull the image from a private registry, it is pushed to the Fly registry for machines to pull it
+	RegistryCredentials *RegistryCredentials
+	// SkipArchCheck doesn't fail for images without a variant for the platform of machines
+	SkipArchCheck bool
 }
 
 type DeploymentImage struct {
@@ -76,8 +80,9 @@ const logLimit int = 4096
 // ResolveReference returns an Image give an reference using either the local docker daemon or remote registry
 func (r *Resolver) ResolveReference(ctx context.Context, streams *iostreams.IOStreams, opts RefOptions) (img *DeploymentImage, err error) {
 	strategies := []imageResolver{
+		&privateRegistryImageResolver{},
 		&localImageResolver{},
-		&remoteImageResolver{flyApi: r.apiClient},
+		&remoteImageResolver{flyApi: r.apiClient, registry: newRegistryClient(flyctl.GetAPIToken())},
 	}
 
 	bld, err := r.createImageBuild(ctx, strategies, opts)
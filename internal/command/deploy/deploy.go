@@ -2,10 +2,15 @@ package deploy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/samber/lo"
 	"github.com/spf13/cobra"
 
 	"github.com/superfly/flyctl/iostreams"
@@ -15,14 +20,17 @@ import (
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/build/imgsrc"
 	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/config"
 	"github.com/superfly/flyctl/internal/flag"
 	"github.com/superfly/flyctl/internal/render"
 	"github.com/superfly/flyctl/internal/sentry"
+	"github.com/superfly/flyctl/internal/tracing"
 
 	"github.com/superfly/flyctl/client"
 	"github.com/superfly/flyctl/internal/cmdutil"
 	"github.com/superfly/flyctl/internal/logger"
 	"github.com/superfly/flyctl/internal/watch"
+	"github.com/superfly/flyctl/terminal"
 )
 
 var CommonFlags = flag.Set{
@@ -43,6 +51,14 @@ var CommonFlags = flag.Set{
 	flag.NoCache(),
 	flag.Nixpacks(),
 	flag.BuildOnly(),
+	flag.String{
+		Name:        "image-registry-auth",
+		Description: "Credentials in the user:token format to pull --image from a private registry, it is copied to the Fly registry for machines to pull it. Defaults to FLY_IMAGE_REGISTRY_AUTH when set",
+	},
+	flag.Bool{
+		Name:        "skip-arch-check",
+		Description: "Deploy --image even if it has no " + imgsrc.MachinesPlatform + " variant, the platform machines run on",
+	},
 	flag.StringSlice{
 		Name:        "env",
 		Shorthand:   "e",
@@ -52,6 +68,11 @@ var CommonFlags = flag.Set{
 		Name:        "auto-confirm",
 		Description: "Will automatically confirm changes when running non-interactively.",
 	},
+	flag.Yes(),
+	flag.Bool{
+		Name:        "skip-health-checks",
+		Description: "Don't wait for machines to pass their health checks. Defaults to FLY_DEPLOY_SKIP_HEALTH_CHECKS when set",
+	},
 	flag.Int{
 		Name:        "wait-timeout",
 		Description: "Seconds to wait for individual machines to transition states and become healthy.",
@@ -76,6 +97,139 @@ var CommonFlags = flag.Set{
 		Name:        "vm-size",
 		Description: `The VM size to use when deploying for the first time. See "fly platform vm-sizes" for valid values`,
 	},
+	flag.Bool{
+		Name:        "ha",
+		Description: "Create spare machines that increases app availability",
+		Default:     true,
+	},
+	flag.String{
+		Name:        "ha-region",
+		Description: "Region where spare machines are created when --ha is set. Defaults to the primary region",
+	},
+	flag.Bool{
+		Name:        "require-all-regions",
+		Description: "Fail the deployment if new machines can't be created in any of the configured regions, not only the primary one",
+	},
+	flag.Bool{
+		Name:        "reconcile-counts",
+		Description: "Create the missing machines for process groups with less machines than its count in fly.toml",
+	},
+	flag.Bool{
+		Name:        "no-public-ips",
+		Description: "Keep the app private: don't allocate public IP addresses nor warn about their absence. Set deploy.no_public_ips in fly.toml to always do so",
+	},
+	flag.Bool{
+		Name:        "auto-allocate-ips",
+		Description: "Allocate dedicated IP addresses on first deploy without prompting, for apps with services other than http and https",
+	},
+	flag.Bool{
+		Name:        "first-deploy",
+		Description: "Treat this deployment as the first one, provisioning IPs, volumes and machines for every process group",
+	},
+	flag.Bool{
+		Name:        "not-first-deploy",
+		Description: "Treat this deployment as an update of an existing app, even if it has no machines",
+	},
+	flag.Bool{
+		Name:        "skip-secret-check",
+		Description: "Deploy even if secrets listed in required_secrets are not set",
+	},
+	flag.Duration{
+		Name:        "deploy-timeout",
+		Description: "Time limit for the whole deployment, no more machines are updated once reached and the release is marked failed. Unlimited by default",
+	},
+	flag.Duration{
+		Name:        "flaps-timeout",
+		Description: "Time limit for each Machines API request, waits for machine states get --wait-timeout on top of it. Unlimited by default, defaults to FLY_FLAPS_TIMEOUT when set",
+	},
+	flag.Int{
+		Name:        "immediate-max-concurrent",
+		Description: "Dispatch up to this many machine updates at once with the immediate strategy, machines being replaced and standbys are still updated one by one. By default updates are dispatched one after the other",
+	},
+	flag.Bool{
+		Name:        "immediate-wait",
+		Description: "Wait for the machines updated with the immediate strategy to start and pass their health checks once every update is dispatched, up to --immediate-max-concurrent machines at once",
+	},
+	flag.Int{
+		Name:        "flaps-retries",
+		Description: "Times a Machines API request failing with a temporary error, 502 or 503 is retried, 0 uses the default of 3 and -1 disables retries. Defaults to FLY_FLAPS_RETRIES when set",
+	},
+	flag.Bool{
+		Name:        "watch-logs",
+		Description: "Show the logs of each updated machine until it passes its health checks or fails",
+	},
+	flag.Bool{
+		Name:        "dry-run",
+		Description: "Show the machines the deployment would create, update and remove without changing them",
+	},
+	flag.Bool{
+		Name:        "migrate-primary-region",
+		Description: "Replace the machines left in the previous primary region with machines in the primary region of fly.toml",
+	},
+	flag.String{
+		Name:        "machine-config",
+		Description: "Path to a JSON machine config merged over the config of every machine, its process_groups key holds configs for specific process groups",
+	},
+	flag.String{
+		Name:        "notify-webhook",
+		Description: "URL receiving a JSON POST with the app, release, image, outcome, duration and failed machines when the deployment finishes. Defaults to FLY_DEPLOY_NOTIFY_WEBHOOK",
+	},
+	flag.String{
+		Name:        "probe-url",
+		Description: "URL requested once machines are healthy, the deployment fails if it doesn't answer 200. Overrides the url of [deploy.post_deploy_probe]",
+	},
+	flag.Bool{
+		Name:        "keep-machine-env",
+		Description: "Keep the env vars set on machines that aren't in fly.toml, like with `fly machine update --env`",
+	},
+	flag.Bool{
+		Name:        "replace-metadata",
+		Description: "Remove the metadata of machines that isn't set by fly.toml or the platform, by default metadata added to machines is kept",
+	},
+	flag.Bool{
+		Name:        "skip-unchanged",
+		Description: "Don't deploy when the image and config are the ones of the current release and its machines",
+	},
+	flag.StringSlice{
+		Name:        "keep-drift",
+		Description: "Keep these config fields on machines where they were changed outside of fly deploy, like env or services",
+	},
+	flag.Bool{
+		Name:        "verify",
+		Description: "Check the machines match fly.toml and pass their health checks without deploying, fails if anything is off",
+	},
+	flag.Bool{
+		Name:        "continue-on-error",
+		Description: "Attempt every machine update even if some fail, the deployment fails at the end with the failed machines. Always on for the immediate strategy",
+	},
+	flag.Bool{
+		Name:        "start-stopped",
+		Description: "Start the stopped machines being updated and wait for their checks, by default they are updated and left stopped",
+	},
+	flag.Bool{
+		Name:        "skip-stopped",
+		Description: "Leave stopped machines out of the deployment, they keep their current config and image. Standbys and scheduled machines are still updated",
+	},
+	flag.Bool{
+		Name:        "ignore-newer-release",
+		Description: "Keep updating machines when a newer release of the app is created during the deployment",
+	},
+	flag.Bool{
+		Name:        "replace-on-failure",
+		Description: "Replace machines that fail to update with new machines using the same config, machines with volumes aren't replaced",
+	},
+	flag.Bool{
+		Name:        "no-digest-pin",
+		Description: "Deploy the image tag as it is instead of pinning it to the digest it resolves to",
+	},
+	flag.Bool{
+		Name:        "skip-release-command",
+		Description: "Don't run the release command of the app config",
+	},
+	flag.Bool{
+		Name:        "redact-env",
+		Description: "Store digests instead of [env] values in the release history, debug logs of the release have the digests either way. Configs fetched from the app, like with `fly config save`, will have the digests too",
+	},
 }
 
 func New() (cmd *cobra.Command) {
@@ -99,23 +253,92 @@ func New() (cmd *cobra.Command) {
 		CommonFlags,
 		flag.App(),
 		flag.AppConfig(),
+		flag.JSONOutput(),
+		flag.String{
+			Name:        "config-overlay",
+			Description: "Path to a config file merged over fly.toml, its tables are merged and its other values, arrays included, replace the ones of fly.toml",
+		},
+		flag.Bool{
+			Name:        "strict",
+			Description: "Fail when the app config has warnings, not only errors",
+		},
+		flag.String{
+			Name:        flag.OrgName,
+			Shorthand:   "o",
+			Description: "Fail unless the app belongs to the organization with this slug",
+		},
+		flag.Bool{
+			Name:        "resume",
+			Description: "Resume the app before deploying when it is suspended, the deployment fails otherwise",
+		},
+		flag.Int{
+			Name:        "watch",
+			Description: "Follow the machines release with this version until its machines are healthy instead of deploying, 0 follows the latest release",
+		},
 	)
 
 	return
 }
 
-func run(ctx context.Context) error {
+func run(ctx context.Context) (err error) {
+	ctx = separateJSONOutput(ctx)
+	if overlay := flag.GetString(ctx, "config-overlay"); overlay != "" {
+		base := appconfig.ConfigFromContext(ctx)
+		if base == nil || base.ConfigFilePath() == "" {
+			return fmt.Errorf("--config-overlay needs a base fly.toml to merge %s over", overlay)
+		}
+		merged, err := appconfig.LoadConfigWithOverlay(base.ConfigFilePath(), overlay)
+		if err != nil {
+			return fmt.Errorf("failed to load config overlay: %w", err)
+		}
+		ctx = appconfig.WithConfig(ctx, merged)
+		// The overlay usually targets another app, --app still wins
+		if !flag.IsSpecified(ctx, "app") && merged.AppName != "" {
+			ctx = appconfig.WithName(ctx, merged.AppName)
+		}
+	}
+
 	appName := appconfig.NameFromContext(ctx)
+
+	// Spans are only recorded when an OTLP endpoint is set, they are exported once the deployment ends
+	tracer, err := tracing.NewTracerFromEnv()
+	if err != nil {
+		return err
+	}
+	if tracer != nil {
+		ctx = tracing.NewContext(ctx, tracer)
+		defer func() {
+			if err := tracer.Flush(context.Background()); err != nil {
+				terminal.Debugf("failed to export deployment spans: %s\n", err)
+			}
+		}()
+	}
+	ctx, span := tracing.Start(ctx, "deploy", tracing.String("app.name", appName))
+	defer func() { span.End(err) }()
+
 	flapsClient, err := flaps.NewFromAppName(ctx, appName)
 	if err != nil {
 		return fmt.Errorf("could not create flaps client: %w", err)
 	}
 	ctx = flaps.NewContext(ctx, flapsClient)
 
+	// Check the app exists before building anything, a stale --app is the usual cause of a missing app
+	appCompact, err := client.FromContext(ctx).API().GetAppCompact(ctx, appName)
+	if err != nil {
+		if strings.Contains(err.Error(), "Could not find App") {
+			return appNotFoundError(ctx, appName)
+		}
+		return err
+	}
+
+	if flag.IsSpecified(ctx, "watch") {
+		return WatchMachinesRelease(ctx, appCompact, flag.GetInt(ctx, "watch"), time.Duration(flag.GetInt(ctx, "wait-timeout"))*time.Second)
+	}
+
 	appConfig, err := determineAppConfig(ctx)
 	if err != nil {
 		if strings.Contains(err.Error(), "Could not find App") {
-			return fmt.Errorf("the app name %s could not be found, did you create the app or misspell it in the fly.toml file or via -a?", appName)
+			return appNotFoundError(ctx, appName)
 		}
 		return err
 	}
@@ -127,6 +350,23 @@ func run(ctx context.Context) error {
 	})
 }
 
+// appNotFoundError names the organizations the app was searched in and points out an --app flag
+// that doesn't match the app of fly.toml
+func appNotFoundError(ctx context.Context, appName string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "app '%s' was not found", appName)
+	if orgs, err := client.FromContext(ctx).API().GetOrganizations(ctx); err == nil && len(orgs) > 0 {
+		slugs := lo.Map(orgs, func(o api.Organization, _ int) string { return o.Slug })
+		fmt.Fprintf(&b, " in your organizations (%s)", strings.Join(slugs, ", "))
+	}
+	b.WriteString(", it may not exist or belong to an organization you don't have access to")
+	if cfg := appconfig.ConfigFromContext(ctx); cfg != nil && cfg.AppName != "" && cfg.AppName != appName {
+		fmt.Fprintf(&b, "\n--app %s differs from app = '%s' in %s, check which one you meant to deploy", appName, cfg.AppName, cfg.ConfigFilePath())
+	}
+	b.WriteString("\nRun `fly apps list` to see the apps you can deploy")
+	return errors.New(b.String())
+}
+
 type DeployWithConfigArgs struct {
 	ForceMachines bool
 	ForceNomad    bool
@@ -141,6 +381,20 @@ func DeployWithConfig(ctx context.Context, appConfig *appconfig.Config, args Dep
 		return err
 	}
 
+	if flag.GetBool(ctx, "verify") {
+		switch isV2App, err := useMachines(ctx, appConfig, appCompact, args, apiClient); {
+		case err != nil:
+			return err
+		case !isV2App:
+			return fmt.Errorf("--verify is only supported for apps running on machines")
+		}
+		if err := appConfig.EnsureV2Config(); err != nil {
+			return fmt.Errorf("Can't verify an invalid v2 app config: %s", err)
+		}
+		// Nothing is built, machines are checked against the image of the current release
+		return deployToMachines(ctx, appConfig, appCompact, &imgsrc.DeploymentImage{})
+	}
+
 	// Fetch an image ref or build from source to get the final image reference to deploy
 	img, err := determineImage(ctx, appConfig)
 	if err != nil {
@@ -164,30 +418,171 @@ func DeployWithConfig(ctx context.Context, appConfig *appconfig.Config, args Dep
 	}
 }
 
+// flagDefaultFromEnv returns the value of envName when flagName isn't specified, flags always win
+func flagDefaultFromEnv(ctx context.Context, flagName, envName string) (string, bool) {
+	if flag.IsSpecified(ctx, flagName) {
+		terminal.Debugf("Using --%s from command line\n", flagName)
+		return "", false
+	}
+	v, ok := os.LookupEnv(envName)
+	if ok {
+		terminal.Debugf("Using %s=%s as default for --%s\n", envName, v, flagName)
+	}
+	return v, ok
+}
+
+// skipsHealthChecks returns --skip-health-checks, FLY_DEPLOY_SKIP_HEALTH_CHECKS when the flag isn't specified.
+// Detached deployments don't wait for health checks either way
+func skipsHealthChecks(ctx context.Context) (bool, error) {
+	skip := flag.GetBool(ctx, "skip-health-checks")
+	if v, ok := flagDefaultFromEnv(ctx, "skip-health-checks", "FLY_DEPLOY_SKIP_HEALTH_CHECKS"); ok {
+		var err error
+		if skip, err = strconv.ParseBool(v); err != nil {
+			return false, fmt.Errorf("invalid FLY_DEPLOY_SKIP_HEALTH_CHECKS '%s', it must be true or false", v)
+		}
+	}
+	return skip || flag.GetDetach(ctx), nil
+}
+
 func deployToMachines(ctx context.Context, appConfig *appconfig.Config, appCompact *api.AppCompact, img *imgsrc.DeploymentImage) error {
 	// It's important to push appConfig into context because MachineDeployment will fetch it from there
 	ctx = appconfig.WithConfig(ctx, appConfig)
 
+	strategy := flag.GetString(ctx, "strategy")
+	if v, ok := flagDefaultFromEnv(ctx, "strategy", "FLY_DEPLOY_STRATEGY"); ok {
+		strategy = v
+	}
+	waitTimeout := flag.GetInt(ctx, "wait-timeout")
+	if v, ok := flagDefaultFromEnv(ctx, "wait-timeout", "FLY_DEPLOY_WAIT_TIMEOUT"); ok {
+		var err error
+		if waitTimeout, err = strconv.Atoi(v); err != nil || waitTimeout <= 0 {
+			return fmt.Errorf("invalid FLY_DEPLOY_WAIT_TIMEOUT '%s', it must be a positive number of seconds", v)
+		}
+	}
+	skipHealthChecks, err := skipsHealthChecks(ctx)
+	if err != nil {
+		return err
+	}
+
+	flapsTimeout := flag.GetDuration(ctx, "flaps-timeout")
+	if v, ok := flagDefaultFromEnv(ctx, "flaps-timeout", "FLY_FLAPS_TIMEOUT"); ok {
+		var err error
+		if flapsTimeout, err = time.ParseDuration(v); err != nil || flapsTimeout < 0 {
+			return fmt.Errorf("invalid FLY_FLAPS_TIMEOUT '%s', it must be a duration like 30s", v)
+		}
+	}
+	flapsRetries := flag.GetInt(ctx, "flaps-retries")
+	if v, ok := flagDefaultFromEnv(ctx, "flaps-retries", "FLY_FLAPS_RETRIES"); ok {
+		var err error
+		if flapsRetries, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid FLY_FLAPS_RETRIES '%s', it must be a number", v)
+		}
+	}
+
+	notifyWebhook := flag.GetString(ctx, "notify-webhook")
+	if v, ok := flagDefaultFromEnv(ctx, "notify-webhook", "FLY_DEPLOY_NOTIFY_WEBHOOK"); ok {
+		notifyWebhook = v
+	}
+
+	var configMutator func(string, *api.MachineConfig) error
+	if path := flag.GetString(ctx, "machine-config"); path != "" {
+		overrides, err := loadMachineConfigOverrides(path)
+		if err != nil {
+			return err
+		}
+		configMutator = overrides.apply
+	}
+
 	md, err := NewMachineDeployment(ctx, MachineDeploymentArgs{
-		AppCompact:        appCompact,
-		DeploymentImage:   img.Tag,
-		Strategy:          flag.GetString(ctx, "strategy"),
-		EnvFromFlags:      flag.GetStringSlice(ctx, "env"),
-		PrimaryRegionFlag: appConfig.PrimaryRegion,
-		SkipHealthChecks:  flag.GetDetach(ctx),
-		WaitTimeout:       time.Duration(flag.GetInt(ctx, "wait-timeout")) * time.Second,
-		LeaseTimeout:      time.Duration(flag.GetInt(ctx, "lease-timeout")) * time.Second,
-		VMSize:            flag.GetString(ctx, "vm-size"),
+		AppCompact:            appCompact,
+		DeploymentImage:       img.Tag,
+		Strategy:              strategy,
+		EnvFromFlags:          flag.GetStringSlice(ctx, "env"),
+		PrimaryRegionFlag:     flag.GetRegion(ctx),
+		SkipHealthChecks:      skipHealthChecks,
+		WaitTimeout:           time.Duration(waitTimeout) * time.Second,
+		LeaseTimeout:          time.Duration(flag.GetInt(ctx, "lease-timeout")) * time.Second,
+		VMSize:                flag.GetString(ctx, "vm-size"),
+		IncreasedAvailability: flag.GetBool(ctx, "ha"),
+		SpareRegion:           flag.GetString(ctx, "ha-region"),
+		RequireAllRegions:     flag.GetBool(ctx, "require-all-regions"),
+		ReconcileCounts:       flag.GetBool(ctx, "reconcile-counts"),
+		NoPublicIPs:           flag.GetBool(ctx, "no-public-ips"),
+		AutoAllocateIPs:       flag.GetBool(ctx, "auto-allocate-ips"),
+		FirstDeploy:           flag.GetBool(ctx, "first-deploy"),
+		NotFirstDeploy:        flag.GetBool(ctx, "not-first-deploy"),
+		SkipSecretCheck:       flag.GetBool(ctx, "skip-secret-check"),
+		RedactEnv:             flag.GetBool(ctx, "redact-env"),
+		Detach:                flag.GetDetach(ctx),
+		SkipReleaseCommand:    flag.GetBool(ctx, "skip-release-command"),
+		DeployTimeout:         flag.GetDuration(ctx, "deploy-timeout"),
+		FlapsRequestTimeout:   flapsTimeout,
+		FlapsMaxRetries:       flapsRetries,
+		NoDigestPin:           flag.GetBool(ctx, "no-digest-pin"),
+		ContinueOnError:       flag.GetBool(ctx, "continue-on-error"),
+		IgnoreNewerRelease:    flag.GetBool(ctx, "ignore-newer-release"),
+		ReplaceOnFailure:      flag.GetBool(ctx, "replace-on-failure"),
+		ImmediateConcurrency:  flag.GetInt(ctx, "immediate-max-concurrent"),
+		ImmediateWait:         flag.GetBool(ctx, "immediate-wait"),
+		DryRun:                flag.GetBool(ctx, "dry-run"),
+		Verify:                flag.GetBool(ctx, "verify"),
+		WatchLogs:             flag.GetBool(ctx, "watch-logs"),
+		MigratePrimaryRegion:  flag.GetBool(ctx, "migrate-primary-region"),
+		KeepDrift:             flag.GetStringSlice(ctx, "keep-drift"),
+		SkipUnchanged:         flag.GetBool(ctx, "skip-unchanged"),
+		Strict:                flag.GetBool(ctx, "strict"),
+		ReplaceMetadata:       flag.GetBool(ctx, "replace-metadata"),
+		KeepMachineEnv:        flag.GetBool(ctx, "keep-machine-env"),
+		StartStopped:          flag.GetBool(ctx, "start-stopped"),
+		SkipStopped:           flag.GetBool(ctx, "skip-stopped"),
+		ProbeURL:              flag.GetString(ctx, "probe-url"),
+		NotifyWebhook:         notifyWebhook,
+		ExpectedOrg:           flag.GetOrg(ctx),
+		Yes:                   flag.GetYes(ctx),
+		ResumeSuspended:       flag.GetBool(ctx, "resume"),
+		ConfigMutator:         configMutator,
 	})
 	if err != nil {
 		sentry.CaptureExceptionWithAppInfo(err, "deploy", appCompact)
 		return err
 	}
 
-	err = md.DeployMachinesApp(ctx)
+	if flag.GetBool(ctx, "verify") {
+		report, err := md.Verify(ctx)
+		if err != nil {
+			return err
+		}
+		if config.FromContext(ctx).JSONOutput {
+			if err := render.JSON(jsonOut(ctx), report); err != nil {
+				return err
+			}
+		} else {
+			renderVerifyReport(iostreams.FromContext(ctx).Out, report)
+		}
+		return verifyError(report)
+	}
+
+	if flag.GetBool(ctx, "dry-run") {
+		plan, err := md.Plan(ctx)
+		if err != nil {
+			return err
+		}
+		if config.FromContext(ctx).JSONOutput {
+			return render.JSON(jsonOut(ctx), plan)
+		}
+		renderPlan(iostreams.FromContext(ctx).Out, plan)
+		return nil
+	}
+
+	result, err := md.DeployMachinesAppWithResult(ctx)
 	if err != nil {
 		sentry.CaptureExceptionWithAppInfo(err, "deploy", appCompact)
 	}
+	if config.FromContext(ctx).JSONOutput {
+		if jsonErr := render.JSON(jsonOut(ctx), result); jsonErr != nil && err == nil {
+			err = jsonErr
+		}
+	}
 	return err
 }
 
@@ -260,6 +655,8 @@ func determineAppConfig(ctx context.Context) (cfg *appconfig.Config, err error)
 	io := iostreams.FromContext(ctx)
 	tb := render.NewTextBlock(ctx, "Verifying app config")
 	appName := appconfig.NameFromContext(ctx)
+	ctx, span := tracing.Start(ctx, "resolve config", tracing.String("app.name", appName))
+	defer func() { span.End(err) }()
 
 	if cfg = appconfig.ConfigFromContext(ctx); cfg == nil {
 		cfg, err = appconfig.FromRemoteApp(ctx, appName)
@@ -285,9 +682,10 @@ func determineAppConfig(ctx context.Context) (cfg *appconfig.Config, err error)
 		cfg.AppName = appName
 	}
 
-	err, extraInfo := cfg.Validate(ctx)
-	if extraInfo != "" {
-		fmt.Fprintf(io.Out, extraInfo)
+	result, err := cfg.ValidateWithResult(ctx)
+	err = validationError(err, result, flag.GetBool(ctx, "strict"))
+	if renderErr := renderValidation(ctx, io, result, err != nil); renderErr != nil {
+		return nil, renderErr
 	}
 	if err != nil {
 		return nil, err
@@ -297,6 +695,58 @@ func determineAppConfig(ctx context.Context) (cfg *appconfig.Config, err error)
 	return cfg, nil
 }
 
+// jsonOutKey is the context key of the writer of the JSON documents of a deployment
+type jsonOutKey struct{}
+
+// separateJSONOutput sends the human output of the deployment to stderr in JSON mode, stdout is kept for
+// the JSON documents written with jsonOut so it can be parsed
+func separateJSONOutput(ctx context.Context) context.Context {
+	streams := iostreams.FromContext(ctx)
+	if streams == nil || !config.FromContext(ctx).JSONOutput {
+		return ctx
+	}
+	human := *streams
+	human.Out = streams.ErrOut
+	// Prompting still depends on stdout being a terminal
+	human.SetStdoutTTY(streams.IsStdoutTTY())
+	ctx = context.WithValue(ctx, jsonOutKey{}, streams.Out)
+	return iostreams.NewContext(ctx, &human)
+}
+
+// jsonOut is where the JSON documents of the deployment are written
+func jsonOut(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(jsonOutKey{}).(io.Writer); ok {
+		return w
+	}
+	return iostreams.FromContext(ctx).Out
+}
+
+// validationError is the error of an app config validation, warnings are errors too with strict
+func validationError(err error, result *appconfig.ValidationResult, strict bool) error {
+	if err == nil && strict && len(result.Warnings) > 0 {
+		return fmt.Errorf("app configuration has %d warnings, they are errors with --strict", len(result.Warnings))
+	}
+	return err
+}
+
+// renderValidation shows the messages of an app config validation. Failed validations go to stderr,
+// or to stdout as JSON in JSON mode where only the warnings of valid configs are shown
+func renderValidation(ctx context.Context, io *iostreams.IOStreams, result *appconfig.ValidationResult, failed bool) error {
+	switch {
+	case config.FromContext(ctx).JSONOutput && failed:
+		return render.JSON(jsonOut(ctx), result)
+	case config.FromContext(ctx).JSONOutput:
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(io.ErrOut, "WARN %s\n", warning)
+		}
+	case failed:
+		fmt.Fprint(io.ErrOut, result.Output)
+	default:
+		fmt.Fprint(io.Out, result.Output)
+	}
+	return nil
+}
+
 func createRelease(ctx context.Context, appConfig *appconfig.Config, img *imgsrc.DeploymentImage) (*api.Release, *api.ReleaseCommand, error) {
 	tb := render.NewTextBlock(ctx, "Creating release")
 
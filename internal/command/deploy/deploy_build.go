@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/dustin/go-humanize"
 	"github.com/superfly/flyctl/client"
@@ -45,12 +47,19 @@ func determineImage(ctx context.Context, appConfig *appconfig.Config) (img *imgs
 
 	// we're using a pre-built Docker image
 	if imageRef != "" {
+		if flag.GetString(ctx, "image") == "" {
+			tb.Printf("Using image %s from fly.toml\n", imageRef)
+		}
 		opts := imgsrc.RefOptions{
-			AppName:    appConfig.AppName,
-			WorkingDir: state.WorkingDirectory(ctx),
-			Publish:    !flag.GetBuildOnly(ctx),
-			ImageRef:   imageRef,
-			ImageLabel: flag.GetString(ctx, "image-label"),
+			AppName:       appConfig.AppName,
+			WorkingDir:    state.WorkingDirectory(ctx),
+			Publish:       !flag.GetBuildOnly(ctx),
+			ImageRef:      imageRef,
+			ImageLabel:    flag.GetString(ctx, "image-label"),
+			SkipArchCheck: flag.GetBool(ctx, "skip-arch-check"),
+		}
+		if opts.RegistryCredentials, err = registryCredentials(ctx); err != nil {
+			return
 		}
 
 		img, err = resolver.ResolveReference(ctx, io, opts)
@@ -179,15 +188,49 @@ func mergeBuildArgs(ctx context.Context, args map[string]string) (map[string]str
 }
 
 func fetchImageRef(ctx context.Context, cfg *appconfig.Config) (ref string, err error) {
+	label := flag.GetString(ctx, "image-label")
 	if ref = flag.GetString(ctx, "image"); ref != "" {
+		if label != "" {
+			return "", errors.New("--image-label can't be used with --image, it picks a tag of the image set in fly.toml [build] section")
+		}
 		return
 	}
 
 	if cfg != nil && cfg.Build != nil {
 		if ref = cfg.Build.Image; ref != "" {
+			// The label is the tag of the image pushed separately to the configured repository
+			if label != "" {
+				ref = imageRepository(ref) + ":" + label
+			}
 			return
 		}
 	}
 
 	return ref, nil
 }
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// registryCredentials returns the credentials to pull the image from a private registry, if any.
+// They are never printed, not even in debug logs
+func registryCredentials(ctx context.Context) (*imgsrc.RegistryCredentials, error) {
+	auth := flag.GetString(ctx, "image-registry-auth")
+	if !flag.IsSpecified(ctx, "image-registry-auth") {
+		auth = os.Getenv("FLY_IMAGE_REGISTRY_AUTH")
+	}
+	if auth == "" {
+		return nil, nil
+	}
+	creds, err := imgsrc.ParseRegistryCredentials(auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --image-registry-auth: %w", err)
+	}
+	return creds, nil
+}
@@ -0,0 +1,44 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/flag"
+)
+
+func Test_fetchImageRef(t *testing.T) {
+	cfg := &appconfig.Config{Build: &appconfig.Build{Image: "ghcr.io/acme/app:stable"}}
+	ctxWith := func(args ...string) context.Context {
+		fs := pflag.NewFlagSet("deploy", pflag.ContinueOnError)
+		fs.String("image", "", "")
+		fs.String("image-label", "", "")
+		require.NoError(t, fs.Parse(args))
+		return flag.NewContext(context.Background(), fs)
+	}
+
+	ref, err := fetchImageRef(ctxWith(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "ghcr.io/acme/app:stable", ref)
+
+	// Flags win over fly.toml
+	ref, err = fetchImageRef(ctxWith("--image", "ghcr.io/acme/app:canary"), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "ghcr.io/acme/app:canary", ref)
+
+	ref, err = fetchImageRef(ctxWith("--image-label", "v2"), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "ghcr.io/acme/app:v2", ref)
+
+	_, err = fetchImageRef(ctxWith("--image", "ghcr.io/acme/app:canary", "--image-label", "v2"), cfg)
+	assert.Error(t, err)
+
+	// Without either the image is built
+	ref, err = fetchImageRef(ctxWith(), &appconfig.Config{})
+	require.NoError(t, err)
+	assert.Empty(t, ref)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/terminal"
 )
 
 func (md *machineDeployment) provisionFirstDeploy(ctx context.Context) error {
@@ -14,7 +15,7 @@ func (md *machineDeployment) provisionFirstDeploy(ctx context.Context) error {
 		return nil
 	}
 	if err := md.provisionIpsOnFirstDeploy(ctx); err != nil {
-		fmt.Fprintf(md.io.ErrOut, "Failed to provision IP addresses, use `fly ips` commands to remmediate it. ERROR: %s", err)
+		fmt.Fprintf(md.io.ErrOut, "Failed to provision IP addresses, use `fly ips` commands to remediate it. ERROR: %s\n", err)
 	}
 	if err := md.provisionVolumesOnFirstDeploy(ctx); err != nil {
 		return fmt.Errorf("failed to provision seed volumes: %w", err)
@@ -23,8 +24,8 @@ func (md *machineDeployment) provisionFirstDeploy(ctx context.Context) error {
 }
 
 func (md *machineDeployment) provisionIpsOnFirstDeploy(ctx context.Context) error {
-	// Provision only if the app hasn't been deployed and have services defined
-	if !md.isFirstDeploy || len(md.appConfig.AllServices()) == 0 {
+	// Provision only if the app hasn't been deployed and have public services defined
+	if !md.isFirstDeploy || md.noPublicIPs || !md.appConfig.HasPublicServices() {
 		return nil
 	}
 
@@ -46,7 +47,10 @@ func (md *machineDeployment) provisionIpsOnFirstDeploy(ctx context.Context) erro
 			ipStuffStr = "dedicated ipv4 and ipv6 addresses"
 		}
 
-		confirmDedicatedIp, err := prompt.Confirmf(ctx, "Would you like to allocate %s now?", ipStuffStr)
+		confirmDedicatedIp := md.autoAllocateIPs
+		if !confirmDedicatedIp {
+			confirmDedicatedIp, err = prompt.Confirmf(ctx, "Would you like to allocate %s now?", ipStuffStr)
+		}
 		if confirmDedicatedIp && err == nil {
 			v4Dedicated, err := md.apiClient.AllocateIPAddress(ctx, md.app.Name, "v4", "", nil, "")
 			if err != nil {
@@ -61,6 +65,8 @@ func (md *machineDeployment) provisionIpsOnFirstDeploy(ctx context.Context) erro
 				}
 				fmt.Fprintf(md.io.Out, "Allocated dedicated ipv6: %s\n", v6Dedicated.Address)
 			}
+		} else {
+			fmt.Fprintf(md.io.Out, "No IP addresses were allocated, your services won't be reachable until you run: fly ips allocate-v4\n")
 		}
 
 	case false:
@@ -82,6 +88,34 @@ func (md *machineDeployment) provisionIpsOnFirstDeploy(ctx context.Context) erro
 	return nil
 }
 
+// warnAboutMissingPublicIPs checks on every deploy that apps serving http or https
+// have public ips to be reachable, unless they are private on purpose. It never fails the deployment.
+func (md *machineDeployment) warnAboutMissingPublicIPs(ctx context.Context) {
+	if md.restartOnly || md.noPublicIPs || !md.appConfig.HasHttpPorts() {
+		return
+	}
+
+	ipAddrs, err := md.apiClient.GetIPAddresses(ctx, md.app.Name)
+	if err != nil {
+		terminal.Debugf("error detecting ip addresses allocated to %s app: %s\n", md.app.Name, err)
+		return
+	}
+
+	hasPublicIP := lo.ContainsBy(ipAddrs, func(ip api.IPAddress) bool {
+		return ip.Type != "private_v6"
+	})
+	if hasPublicIP {
+		return
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "\n%s App '%s' has services on ports 80 or 443 but no public IP addresses, it won't be reachable from the internet.\n",
+		md.colorize.Red("WARNING"), md.app.Name)
+	fmt.Fprintf(md.io.ErrOut, "Allocate them with: %s and %s\n\n",
+		md.colorize.Bold(fmt.Sprintf("fly ips allocate-v4 --shared -a %s", md.app.Name)),
+		md.colorize.Bold(fmt.Sprintf("fly ips allocate-v6 -a %s", md.app.Name)),
+	)
+}
+
 func (md *machineDeployment) provisionVolumesOnFirstDeploy(ctx context.Context) error {
 	// Provision only if the app hasn't been deployed and have mounts defined
 	if !md.isFirstDeploy || len(md.appConfig.Mounts) == 0 {
@@ -89,39 +123,56 @@ func (md *machineDeployment) provisionVolumesOnFirstDeploy(ctx context.Context)
 	}
 
 	// md.setVolumes already queried for existent unattached volumes, do not create more
-	existentVolumes := lo.MapValues(md.volumes, func(vs []api.Volume, _ string) int {
-		return len(vs)
-	})
+	// volumes are counted by region unless machines can be created where its volume is
+	anyRegion := md.volumesInAnyRegion()
+	existentVolumes := map[string]map[string]int{}
+	for name, vs := range md.volumes {
+		existentVolumes[name] = map[string]int{}
+		for _, v := range vs {
+			existentVolumes[name][lo.Ternary(anyRegion, "", v.Region)]++
+		}
+	}
 
-	// The logic here is to provision one volume per process group that needs it only on the primary region
+	// The logic here is to provision one volume per machine created for each process group that needs it
 	for _, groupName := range md.appConfig.ProcessNames() {
 		groupConfig, err := md.appConfig.Flatten(groupName)
 		if err != nil {
 			return err
 		}
 
-		for _, m := range groupConfig.Mounts {
-			if v := existentVolumes[m.Source]; v > 0 {
-				existentVolumes[m.Source]--
-				continue
-			}
+		placements, _, err := md.machineRegionsFor(groupName)
+		if err != nil {
+			return err
+		}
 
-			fmt.Fprintf(md.io.Out, "Creating 1GB volume '%s' for process group '%s'. Use 'fly vol extend' to increase its size\n", m.Source, groupName)
+		for idx, region := range placements {
+			for _, m := range groupConfig.Mounts {
+				if key := lo.Ternary(anyRegion, "", region); existentVolumes[m.Source][key] > 0 {
+					existentVolumes[m.Source][key]--
+					continue
+				}
 
-			input := api.CreateVolumeInput{
-				AppID:     md.app.ID,
-				Name:      m.Source,
-				Region:    groupConfig.PrimaryRegion,
-				SizeGb:    1,
-				Encrypted: true,
-			}
+				fmt.Fprintf(md.io.Out, "Creating 1GB volume '%s' for process group '%s' in region %s. Use 'fly vol extend' to increase its size\n", m.Source, groupName, region)
 
-			vol, err := md.apiClient.CreateVolume(ctx, input)
-			if err != nil {
-				return fmt.Errorf("failed creating volume: %w", err)
-			}
+				input := api.CreateVolumeInput{
+					AppID:     md.app.ID,
+					Name:      m.Source,
+					Region:    region,
+					SizeGb:    1,
+					Encrypted: true,
+				}
 
-			md.volumes[m.Source] = append(md.volumes[m.Source], *vol)
+				vol, err := md.apiClient.CreateVolume(ctx, input)
+				switch {
+				case err != nil && (idx == 0 || md.requireAllRegions):
+					return fmt.Errorf("failed creating volume in region %s: %w", region, err)
+				case err != nil:
+					terminal.Warnf("Failed creating volume '%s' in region %s, no machines will be created there: %s\n", m.Source, region, err)
+					continue
+				}
+
+				md.volumes[m.Source] = append(md.volumes[m.Source], *vol)
+			}
 		}
 	}
 	return nil
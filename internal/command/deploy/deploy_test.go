@@ -0,0 +1,67 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/internal/flag"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func Test_separateJSONOutput(t *testing.T) {
+	ios, _, out, errOut := iostreams.Test()
+	ctx := iostreams.NewContext(context.Background(), ios)
+	ctx = config.NewContext(ctx, &config.Config{JSONOutput: true})
+
+	ctx = separateJSONOutput(ctx)
+	fmt.Fprintln(iostreams.FromContext(ctx).Out, "Watch your deployment")
+	fmt.Fprintln(jsonOut(ctx), `{"status":"complete"}`)
+	assert.Equal(t, "Watch your deployment\n", errOut.String())
+	assert.Equal(t, "{\"status\":\"complete\"}\n", out.String())
+
+	// Without JSON the streams are left alone
+	ios, _, out, _ = iostreams.Test()
+	ctx = iostreams.NewContext(context.Background(), ios)
+	ctx = separateJSONOutput(config.NewContext(ctx, &config.Config{}))
+	fmt.Fprintln(iostreams.FromContext(ctx).Out, "Watch your deployment")
+	assert.Equal(t, "Watch your deployment\n", out.String())
+}
+
+func Test_skipsHealthChecks(t *testing.T) {
+	ctxWith := func(args ...string) context.Context {
+		fs := pflag.NewFlagSet("deploy", pflag.ContinueOnError)
+		fs.Bool("skip-health-checks", false, "")
+		fs.Bool("detach", false, "")
+		require.NoError(t, fs.Parse(args))
+		return flag.NewContext(context.Background(), fs)
+	}
+	skips := func(args ...string) bool {
+		skip, err := skipsHealthChecks(ctxWith(args...))
+		require.NoError(t, err)
+		return skip
+	}
+
+	assert.False(t, skips())
+	assert.True(t, skips("--skip-health-checks"))
+	assert.True(t, skips("--detach"))
+
+	t.Setenv("FLY_DEPLOY_SKIP_HEALTH_CHECKS", "true")
+	assert.True(t, skips())
+	// The flag wins over the environment when it is specified
+	assert.False(t, skips("--skip-health-checks=false"))
+	// Specifying --detach doesn't hide the environment
+	assert.True(t, skips("--detach=false"))
+
+	t.Setenv("FLY_DEPLOY_SKIP_HEALTH_CHECKS", "false")
+	assert.False(t, skips())
+	assert.True(t, skips("--skip-health-checks"))
+
+	t.Setenv("FLY_DEPLOY_SKIP_HEALTH_CHECKS", "maybe")
+	_, err := skipsHealthChecks(ctxWith())
+	assert.EqualError(t, err, "invalid FLY_DEPLOY_SKIP_HEALTH_CHECKS 'maybe', it must be true or false")
+}
@@ -2,12 +2,22 @@ package deploy
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Khan/genqlient/graphql"
-	"github.com/google/shlex"
+	"github.com/agnivade/levenshtein"
+	"github.com/jpillora/backoff"
 	"github.com/morikuni/aec"
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
@@ -15,10 +25,16 @@ import (
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/gql"
 	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/build/imgsrc"
 	"github.com/superfly/flyctl/internal/cmdutil"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/prompt"
+	"github.com/superfly/flyctl/internal/spinner"
+	"github.com/superfly/flyctl/internal/tracing"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/terminal"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -28,6 +44,12 @@ const (
 
 type MachineDeployment interface {
 	DeployMachinesApp(context.Context) error
+	// DeployMachinesAppWithResult deploys like DeployMachinesApp and reports what happened to the release and each machine
+	DeployMachinesAppWithResult(context.Context) (*DeploymentResult, error)
+	// Plan returns what the deployment would do without doing it
+	Plan(context.Context) (*DeploymentPlan, error)
+	// Verify checks the machines against the app config without changing them
+	Verify(context.Context) (*VerifyReport, error)
 }
 
 type MachineDeploymentArgs struct {
@@ -41,6 +63,129 @@ type MachineDeploymentArgs struct {
 	WaitTimeout       time.Duration
 	LeaseTimeout      time.Duration
 	VMSize            string
+	// IncreasedAvailability creates a spare machine for each new process group
+	IncreasedAvailability bool
+	// SpareRegion overrides the region used for spare machines, defaults to the primary region
+	SpareRegion string
+	// RequireAllRegions fails the deployment if a new machine can't be created in any region
+	RequireAllRegions bool
+	// ReconcileCounts creates the missing machines for groups with less machines than its configured count
+	ReconcileCounts bool
+	// NoPublicIPs keeps the app private, no public IPs are allocated and their absence isn't warned about.
+	// The deploy.no_public_ips key of the app config does the same
+	NoPublicIPs bool
+	// AutoAllocateIPs allocates dedicated IPs on first deploy without prompting, it can't be used with NoPublicIPs
+	AutoAllocateIPs bool
+	// FirstDeploy and NotFirstDeploy skip the first deploy detection
+	FirstDeploy    bool
+	NotFirstDeploy bool
+	// RestartSignal stops machines with this signal before restarting them, defaults to the kill_signal of the app config
+	RestartSignal string
+	// ProcessGroups restricts restartOnly deployments to the machines in these groups
+	ProcessGroups []string
+	// WithReleaseCommand runs the release command on restartOnly deployments, skipped by default
+	WithReleaseCommand bool
+	// MaxConcurrent restarts this many machines at once on restartOnly deployments
+	MaxConcurrent int
+	// ImmediateConcurrency bounds how many machine updates of the immediate strategy are in flight at once,
+	// zero dispatches them one after the other
+	ImmediateConcurrency int
+	// ImmediateWait waits for the machines the immediate strategy updated once every update was dispatched,
+	// up to ImmediateConcurrency of them at once and all of them when it is zero
+	ImmediateWait bool
+	// UseLatestRelease restarts machines with the image of the latest release instead of the one they run
+	UseLatestRelease bool
+	// SkipSecretCheck doesn't verify the required_secrets of the app config are set
+	SkipSecretCheck bool
+	// RedactEnv stores [env] values digests instead of their values in the release definition
+	RedactEnv bool
+	// Detach dispatches the machine updates without waiting for machines to start or pass health checks.
+	// The release is marked complete once every update was dispatched, it doesn't mean machines are healthy.
+	Detach bool
+	// SkipReleaseCommand doesn't run the release command of the app config
+	SkipReleaseCommand bool
+	// DeployTimeout bounds the whole deployment, no new machine is updated once it expires.
+	// Detached deployments only spend it dispatching updates.
+	DeployTimeout time.Duration
+	// NoDigestPin deploys the image tag as it is instead of the digest it resolves to
+	NoDigestPin bool
+	// ContinueOnError attempts every machine update and fails at the end if any failed, always on for the immediate strategy
+	ContinueOnError bool
+	// IgnoreNewerRelease keeps deploying when someone else creates a newer release meanwhile
+	IgnoreNewerRelease bool
+	// ReplaceOnFailure replaces machines that fail to update with new ones using the same config
+	ReplaceOnFailure bool
+	// Hooks run code of the caller at points of the deployment
+	Hooks DeploymentHooks
+	// IOStreams receives the output of the deployment instead of the streams of the context.
+	// Progress lines are only redrawn when it is interactive
+	IOStreams *iostreams.IOStreams
+	// MigratePrimaryRegion replaces the machines of the default process group left in the previous
+	// primary region with machines in the new one, otherwise they are only reported
+	MigratePrimaryRegion bool
+	// SkipUnchanged doesn't deploy when the image and config match the current release and machines
+	SkipUnchanged bool
+	// Strict fails the deployment when the app config has warnings
+	Strict bool
+	// NotifyWebhook receives a JSON POST with the outcome of the deployment when it finishes
+	NotifyWebhook string
+	// ProbeURL is requested once machines are healthy, overriding the url of the post deploy probe of fly.toml
+	ProbeURL string
+	// KeepMachineEnv keeps the env vars of updated machines that fly.toml doesn't set
+	KeepMachineEnv bool
+	// ReplaceMetadata drops the metadata keys of updated machines that fly.toml and the platform don't set
+	ReplaceMetadata bool
+	// KeepDrift lists the config fields changed out of band on machines that the deployment keeps
+	KeepDrift []string
+	// DryRun prepares the deployment only to compute its plan, no IPs are allocated and no release is created
+	DryRun bool
+	// Verify prepares the deployment like DryRun to check the machines against the app config with Verify.
+	// Without DeploymentImage machines are checked against the image of the current release
+	Verify bool
+	// ConfigMutator changes the config built from fly.toml for each machine to create or update, an error aborts the deployment.
+	// The fly_* metadata keys are re-asserted after it runs. It isn't called for release command machines
+	ConfigMutator func(groupName string, cfg *api.MachineConfig) error
+	// FlapsClient is used to manage the machines of the app instead of a client built for it,
+	// see flaps.NewWithOptions to build clients with a custom endpoint or transport
+	FlapsClient *flaps.Client
+	// ListImageTags lists the tags of the repository of the deployment image, some close to its own tag are
+	// suggested when it isn't found. It defaults to asking the registry of the image
+	ListImageTags func(ctx context.Context, imageRef string) ([]string, error)
+	// Events receives what happens during the deployment as it happens. It is never closed.
+	// Sends don't block, events are dropped when the channel buffer is full so consumers should use a buffered channel
+	Events chan<- DeployEvent
+	// WatchLogs shows the logs of each updated machine until it passes its checks or fails
+	WatchLogs bool
+	// ExpectedOrg fails the deployment unless the app belongs to the organization with this slug
+	ExpectedOrg string
+	// Yes confirms the deployment when interactive ones would prompt, like for rolling without health checks
+	Yes bool
+	// FlapsRequestTimeout bounds each Machines API request, unlike WaitTimeout it doesn't cover waiting
+	// for machine states. Zero doesn't bound them. It isn't used with FlapsClient
+	FlapsRequestTimeout time.Duration
+	// FlapsMaxRetries is how many times Machines API requests failing with temporary errors are retried,
+	// zero uses the flaps default and negative values disable retries. It isn't used with FlapsClient
+	FlapsMaxRetries int
+	// StartStopped starts the stopped machines the deployment updates and waits for them like the others.
+	// By default they are updated and left stopped, their next start boots the new image
+	StartStopped bool
+	// SkipStopped leaves the stopped machines of the app out of the deployment, they keep their config and image.
+	// Standbys and scheduled machines are stopped by design and deployed as usual
+	SkipStopped bool
+	// ResumeSuspended resumes suspended apps before deploying them, the deployment fails for suspended apps otherwise
+	ResumeSuspended bool
+}
+
+// DeploymentHooks are called synchronously with the context of the deployment, nil hooks are skipped.
+// An error returned by a hook aborts the deployment
+type DeploymentHooks struct {
+	// BeforeReleaseCommand runs before the release command machine is launched
+	BeforeReleaseCommand func(ctx context.Context) error
+	// AfterMachineUpdate runs after each existing machine is updated, with the error of the update if it failed.
+	// Returning the update error keeps failing the deployment, returning nil ignores it
+	AfterMachineUpdate func(ctx context.Context, m *api.Machine, updateErr error) error
+	// OnFinish runs once the final status of the release is set, with the error the deployment finished with
+	OnFinish func(ctx context.Context, status string, err error)
 }
 
 type machineDeployment struct {
@@ -55,46 +200,149 @@ type machineDeployment struct {
 	machineSet            machine.MachineSet
 	releaseCommandMachine machine.MachineSet
 	volumes               map[string][]api.Volume
+	// machines are the machines of the app listed or changed by the deployment, nil until they are listed
+	machines *machineCache
+	// volumesMu serializes refreshing the volumes and taking them for new machines
+	volumesMu sync.Mutex
+	// claimedVolumes are the volumes taken for machines of this deployment, they stay taken when volumes are listed again
+	claimedVolumes map[string]bool
+	// warned are the warnings shown with warnOncef
+	warned                map[string]bool
+	volumesListedAt       time.Time
 	strategy              string
 	releaseId             string
 	releaseVersion        int
 	skipHealthChecks      bool
 	restartOnly           bool
+	resumeSuspended       bool
 	waitTimeout           time.Duration
+	groupWaitTimeouts     map[string]time.Duration
 	leaseTimeout          time.Duration
 	leaseDelayBetween     time.Duration
 	isFirstDeploy         bool
 	machineGuest          *api.MachineGuest
+	regionForced          bool
+	usedMachineNames      map[string]bool
+	increasedAvailability bool
+	spareRegion           string
+	requireAllRegions     bool
+	reconcileCounts       bool
+	noPublicIPs           bool
+	autoAllocateIPs       bool
+	firstDeploy           bool
+	notFirstDeploy        bool
+	restartSignal         string
+	processGroups         []string
+	withReleaseCommand    bool
+	maxConcurrent         int
+	immediateConcurrency  int
+	immediateWait         bool
+	useLatestRelease      bool
+	skipSecretCheck       bool
+	secrets               []api.Secret
+	stagedSecrets         []string
+	redactEnv             bool
+	detach                bool
+	skipReleaseCommand    bool
+	deployTimeout         time.Duration
+	noDigestPin           bool
+	continueOnError       bool
+	ignoreNewerRelease    bool
+	replaceOnFailure      bool
+	hooks                 DeploymentHooks
+	configMutator         func(groupName string, cfg *api.MachineConfig) error
+	listImageTags         func(ctx context.Context, imageRef string) ([]string, error)
+	dryRun                bool
+	migratePrimaryRegion  bool
+	keepDrift             []string
+	skipUnchanged         bool
+	unchanged             bool
+	replaceMetadata       bool
+	keepMachineEnv        bool
+	startStopped          bool
+	skipStopped           bool
+	skippedMachines       []*api.Machine
+	probe                 *postDeployProbe
+	notifyWebhookURL      string
+	outcomes              []MachineOutcome
+	outcomesMu            sync.Mutex
+	events                chan<- DeployEvent
+	// outputMu serializes the rendering and sending of events, machines updated concurrently publish them at once
+	outputMu      sync.Mutex
+	droppedEvents atomic.Int64
+	watchLogs     bool
+	logWatcher    *machineLogWatcher
+	// concurrentUpdates is set while machines are updated concurrently, their lines interleave and aren't cleared
+	concurrentUpdates bool
+	// deferredWaits collects the machines updated by the immediate strategy while it dispatches updates, they are
+	// waited for afterwards with --immediate-wait
+	deferredWaits *deferredWaits
 }
 
 func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (MachineDeployment, error) {
-	if !args.RestartOnly && args.DeploymentImage == "" {
+	if !args.RestartOnly && !args.Verify && args.DeploymentImage == "" {
 		return nil, fmt.Errorf("BUG: machines deployment created without specifying the image")
 	}
 	if args.RestartOnly && args.DeploymentImage != "" {
 		return nil, fmt.Errorf("BUG: restartOnly machines deployment created and specified an image")
 	}
+	io := args.IOStreams
+	if io == nil {
+		io = iostreams.FromContext(ctx)
+	}
 	appConfig, err := determineAppConfigForMachines(ctx, args.EnvFromFlags, args.PrimaryRegionFlag)
 	if err != nil {
 		return nil, err
 	}
-	err, _ = appConfig.Validate(ctx)
-	if err != nil {
+	// Valid configs were already shown by the caller, like `fly deploy` does, only failures are rendered
+	validation, err := appConfig.ValidateWithResult(ctx)
+	if err := validationError(err, validation, args.Strict); err != nil {
+		if renderErr := renderValidation(ctx, io, validation, true); renderErr != nil {
+			return nil, renderErr
+		}
 		return nil, err
 	}
+	if args.FirstDeploy && args.NotFirstDeploy {
+		return nil, fmt.Errorf("--first-deploy and --not-first-deploy can't be used together")
+	}
+	if args.RestartSignal != "" && !args.RestartOnly {
+		return nil, fmt.Errorf("BUG: restart signal can only be used with restartOnly machines deployments")
+	}
+	if len(args.ProcessGroups) > 0 && !args.RestartOnly {
+		return nil, fmt.Errorf("BUG: process groups can only be selected for restartOnly machines deployments")
+	}
+	if args.MaxConcurrent > 1 && !args.RestartOnly {
+		return nil, fmt.Errorf("BUG: concurrent updates can only be used with restartOnly machines deployments")
+	}
 	if args.AppCompact == nil {
 		return nil, fmt.Errorf("BUG: args.AppCompact should be set when calling this method")
 	}
-	flapsClient, err := flaps.New(ctx, args.AppCompact)
-	if err != nil {
+	if err := checkOrganization(args.AppCompact, args.ExpectedOrg); err != nil {
 		return nil, err
 	}
-	if appConfig.Deploy != nil {
-		_, err = shlex.Split(appConfig.Deploy.ReleaseCommand)
-		if err != nil {
+	var restartSignal string
+	if args.RestartOnly {
+		if restartSignal = args.RestartSignal; restartSignal == "" && appConfig.KillSignal != nil {
+			restartSignal = *appConfig.KillSignal
+		}
+		if restartSignal != "" {
+			if restartSignal, err = api.ValidateSignal(restartSignal); err != nil {
+				return nil, err
+			}
+		}
+	}
+	flapsClient := args.FlapsClient
+	if flapsClient == nil {
+		requestOpts := flaps.RequestOptions{Timeout: args.FlapsRequestTimeout, MaxRetries: args.FlapsMaxRetries}
+		terminal.Debugf("Machines API request timeout: %s, retries: %d\n",
+			lo.Ternary(requestOpts.Timeout > 0, requestOpts.Timeout.String(), "none"), requestOpts.EffectiveMaxRetries())
+		if flapsClient, err = flaps.NewWithRequestOptions(ctx, args.AppCompact, requestOpts); err != nil {
 			return nil, err
 		}
 	}
+	if _, err = appConfig.Deploy.ReleaseCmd(); err != nil {
+		return nil, err
+	}
 	waitTimeout := args.WaitTimeout
 	if waitTimeout == 0 {
 		waitTimeout = DefaultWaitTimeout
@@ -104,71 +352,250 @@ func NewMachineDeployment(ctx context.Context, args MachineDeploymentArgs) (Mach
 		leaseTimeout = DefaultLeaseTtl
 	}
 	leaseDelayBetween := (leaseTimeout - 1*time.Second) / 3
-	if waitTimeout != DefaultWaitTimeout || leaseTimeout != DefaultLeaseTtl || args.WaitTimeout == 0 || args.LeaseTimeout == 0 {
-		terminal.Infof("Using wait timeout: %s lease timeout: %s delay between lease refreshes: %s\n", waitTimeout, leaseTimeout, leaseDelayBetween)
+	groupWaitTimeouts := processWaitTimeouts(appConfig)
+	if waitTimeout != DefaultWaitTimeout || leaseTimeout != DefaultLeaseTtl || args.WaitTimeout == 0 || args.LeaseTimeout == 0 || len(groupWaitTimeouts) > 0 {
+		fmt.Fprintf(io.ErrOut, "INFO Using wait timeout: %s lease timeout: %s delay between lease refreshes: %s\n", formatWaitTimeouts(waitTimeout, groupWaitTimeouts), leaseTimeout, leaseDelayBetween)
 	}
-	io := iostreams.FromContext(ctx)
 	apiClient := client.FromContext(ctx).API()
 	md := &machineDeployment{
-		apiClient:         apiClient,
-		gqlClient:         apiClient.GenqClient,
-		flapsClient:       flapsClient,
-		io:                io,
-		colorize:          io.ColorScheme(),
-		app:               args.AppCompact,
-		appConfig:         appConfig,
-		img:               args.DeploymentImage,
-		skipHealthChecks:  args.SkipHealthChecks,
-		restartOnly:       args.RestartOnly,
-		waitTimeout:       waitTimeout,
-		leaseTimeout:      leaseTimeout,
-		leaseDelayBetween: leaseDelayBetween,
+		apiClient:             apiClient,
+		gqlClient:             apiClient.GenqClient,
+		flapsClient:           flapsClient,
+		io:                    io,
+		colorize:              io.ColorScheme(),
+		app:                   args.AppCompact,
+		appConfig:             appConfig,
+		img:                   args.DeploymentImage,
+		skipHealthChecks:      args.SkipHealthChecks,
+		restartOnly:           args.RestartOnly,
+		resumeSuspended:       args.ResumeSuspended,
+		waitTimeout:           waitTimeout,
+		groupWaitTimeouts:     groupWaitTimeouts,
+		leaseTimeout:          leaseTimeout,
+		leaseDelayBetween:     leaseDelayBetween,
+		increasedAvailability: args.IncreasedAvailability,
+		spareRegion:           args.SpareRegion,
+		requireAllRegions:     args.RequireAllRegions,
+		reconcileCounts:       args.ReconcileCounts,
+		noPublicIPs:           args.NoPublicIPs || (appConfig.Deploy != nil && appConfig.Deploy.NoPublicIPs),
+		autoAllocateIPs:       args.AutoAllocateIPs,
+		firstDeploy:           args.FirstDeploy,
+		notFirstDeploy:        args.NotFirstDeploy,
+		restartSignal:         restartSignal,
+		processGroups:         args.ProcessGroups,
+		withReleaseCommand:    args.WithReleaseCommand,
+		maxConcurrent:         args.MaxConcurrent,
+		immediateConcurrency:  args.ImmediateConcurrency,
+		immediateWait:         args.ImmediateWait,
+		useLatestRelease:      args.UseLatestRelease,
+		skipSecretCheck:       args.SkipSecretCheck,
+		redactEnv:             args.RedactEnv,
+		detach:                args.Detach,
+		skipReleaseCommand:    args.SkipReleaseCommand,
+		deployTimeout:         args.DeployTimeout,
+		noDigestPin:           args.NoDigestPin,
+		continueOnError:       args.ContinueOnError,
+		ignoreNewerRelease:    args.IgnoreNewerRelease,
+		replaceOnFailure:      args.ReplaceOnFailure,
+		hooks:                 args.Hooks,
+		configMutator:         args.ConfigMutator,
+		listImageTags:         lo.Ternary(args.ListImageTags != nil, args.ListImageTags, imgsrc.ImageTags),
+		events:                args.Events,
+		watchLogs:             args.WatchLogs,
+		dryRun:                args.DryRun || args.Verify,
+		migratePrimaryRegion:  args.MigratePrimaryRegion,
+		keepDrift:             args.KeepDrift,
+		skipUnchanged:         args.SkipUnchanged,
+		replaceMetadata:       args.ReplaceMetadata,
+		keepMachineEnv:        args.KeepMachineEnv,
+		startStopped:          args.StartStopped,
+		skipStopped:           args.SkipStopped,
+		probe:                 newPostDeployProbe(appConfig.Deploy, args.ProbeURL),
+		notifyWebhookURL:      args.NotifyWebhook,
+		regionForced:          args.PrimaryRegionFlag != "",
+	}
+	if md.noPublicIPs && md.autoAllocateIPs {
+		return nil, fmt.Errorf("--auto-allocate-ips can't be used on private apps, drop --no-public-ips or deploy.no_public_ips of %s", appconfig.DefaultConfigFileName)
+	}
+	if md.startStopped && md.skipStopped {
+		return nil, errors.New("--start-stopped can't be used with --skip-stopped, stopped machines are either started or left out of the deployment")
 	}
 	if err := md.setStrategy(args.Strategy); err != nil {
 		return nil, err
 	}
+	if md.immediateConcurrency > 0 && md.strategy != "immediate" {
+		md.warnf("--immediate-max-concurrent is ignored with the %s strategy\n", md.strategy)
+	}
+	if md.immediateWait && (md.strategy != "immediate" || md.detach) {
+		md.warnf("--immediate-wait is ignored %s\n", lo.Ternary(md.detach, "with --detach", "with the "+md.strategy+" strategy"))
+	}
+	if err := md.confirmRollingWithoutHealthChecks(ctx, args.Yes); err != nil {
+		return nil, err
+	}
+	if err := machine.ValidateDriftFields(args.KeepDrift); err != nil {
+		return nil, fmt.Errorf("invalid --keep-drift: %w", err)
+	}
 	if err := md.setMachineGuest(args.VMSize); err != nil {
 		return nil, err
 	}
+	if err := md.checkRequiredSecrets(ctx); err != nil {
+		return nil, err
+	}
+	if err := md.warnAboutEnvShadowingSecrets(ctx); err != nil {
+		return nil, err
+	}
 	if err := md.setMachinesForDeployment(ctx); err != nil {
 		return nil, err
 	}
+	if err := md.checkSuspended(ctx); err != nil {
+		return nil, err
+	}
 	if err := md.setVolumes(ctx); err != nil {
 		return nil, err
 	}
+	md.setStagedSecrets(ctx)
 	if err := md.setImg(ctx); err != nil {
 		return nil, err
 	}
+	// A typo'd image fails before anything is provisioned for it
+	if err := md.verifyImage(ctx); err != nil {
+		return nil, err
+	}
 	if err := md.setFirstDeploy(ctx); err != nil {
 		return nil, err
 	}
 
+	// A dry run only computes the plan, nothing is provisioned or recorded
+	if md.dryRun {
+		if err := md.validateVolumeConfig(); err != nil {
+			return nil, err
+		}
+		if err := md.validateGPURegions(); err != nil {
+			return nil, err
+		}
+		return md, nil
+	}
+
 	// Provisioning must come after setVolumes
 	if err := md.provisionFirstDeploy(ctx); err != nil {
 		return nil, err
 	}
+	md.warnAboutMissingPublicIPs(ctx)
 
-	// validations must happen after every else
+	// validations must happen after every else, with the volumes as they are now
+	if err := md.refreshVolumes(ctx); err != nil {
+		return nil, fmt.Errorf("Error refreshing application volumes: %w", err)
+	}
 	if err := md.validateVolumeConfig(); err != nil {
 		return nil, err
 	}
+	if err := md.validateGPURegions(); err != nil {
+		return nil, err
+	}
+	if md.skipUnchanged {
+		if md.unchanged, err = md.isUnchanged(ctx); err != nil {
+			return nil, err
+		}
+		if md.unchanged {
+			return md, nil
+		}
+	}
 	if err = md.createReleaseInBackend(ctx); err != nil {
 		return nil, err
 	}
 	return md, nil
 }
 
+// checkSuspended fails for suspended apps, their machines don't start and checks never pass, unless
+// --resume is set and the app is resumed first. The status comes with the app, nothing is requested for
+// apps that aren't suspended. Machines apps without machines are reported suspended too, deploying them is
+// how they come back, so they aren't checked. Neither are restarts, `fly secrets` has no --resume to offer
+func (md *machineDeployment) checkSuspended(ctx context.Context) error {
+	if md.app.Status != "suspended" || md.machineSet.IsEmpty() || md.restartOnly {
+		return nil
+	}
+	if !md.resumeSuspended {
+		return fmt.Errorf("app %s is suspended; run `fly apps resume %s` or deploy with --resume", md.app.Name, md.app.Name)
+	}
+	fmt.Fprintf(md.io.ErrOut, "App %s is suspended, resuming it\n", md.app.Name)
+	resumed, err := md.apiClient.ResumeApp(ctx, md.app.Name)
+	if err != nil {
+		return fmt.Errorf("failed resuming %s: %w", md.app.Name, err)
+	}
+	md.app.Status = resumed.Status
+	return nil
+}
+
+// checkOrganization fails when the app doesn't belong to the expected organization, apps with similar
+// names in other organizations are easily deployed to by mistake. Nothing is checked without expectation
+func checkOrganization(app *api.AppCompact, expectedOrg string) error {
+	if expectedOrg == "" {
+		return nil
+	}
+	if app.Organization == nil {
+		return fmt.Errorf("app %s was expected in organization %s but its organization is unknown", app.Name, expectedOrg)
+	}
+	if org := app.Organization; org.Slug != expectedOrg && org.RawSlug != expectedOrg {
+		return fmt.Errorf("app %s belongs to organization %s, not %s; check the app name and FLY_APP", app.Name, lo.Ternary(org.RawSlug != "", org.RawSlug, org.Slug), expectedOrg)
+	}
+	return nil
+}
+
 func (md *machineDeployment) setFirstDeploy(ctx context.Context) error {
+	switch {
+	case md.firstDeploy:
+		md.isFirstDeploy = true
+		return nil
+	case md.notFirstDeploy:
+		md.isFirstDeploy = false
+		return nil
+	}
+
 	// Due to https://github.com/superfly/web/issues/1397 we have to be extra careful
 	// by checking for any existent machine.
-	// This is not exaustive as the app could still be scaled down to zero but the
-	// workaround works better for now until it is fixed
 	md.isFirstDeploy = !md.app.Deployed && md.machineSet.IsEmpty()
-	return nil
+	if !md.isFirstDeploy {
+		return nil
+	}
+
+	// The app could still be scaled down to zero, in which case a previous successful release exists
+	// and its volumes are likely around waiting for new machines
+	releases, err := md.apiClient.GetAppReleasesMachines(ctx, md.app.Name, 25)
+	if err != nil {
+		return fmt.Errorf("failed to fetch app releases: %w", err)
+	}
+	deployedBefore := lo.ContainsBy(releases, func(r api.Release) bool {
+		return r.Status == "complete"
+	})
+	if !deployedBefore {
+		return nil
+	}
+
+	volumes, err := md.fetchVolumes(ctx)
+	if err != nil {
+		return fmt.Errorf("Error fetching application volumes: %w", err)
+	}
+
+	msg := fmt.Sprintf("App '%s' has no machines but was successfully deployed before", md.app.Name)
+	if len(volumes) > 0 {
+		msg += fmt.Sprintf(" and has %d volumes", len(volumes))
+	}
+	if md.dryRun {
+		// Nothing is provisioned by dry runs, the question is left to the deployment
+		fmt.Fprintf(md.io.ErrOut, "%s, deploying it has to confirm provisioning it again as a first deploy.\n", msg)
+		return nil
+	}
+	if !md.io.IsInteractive() {
+		return fmt.Errorf("%s. Use --first-deploy to provision it again or --not-first-deploy to only update existing machines", msg)
+	}
+	fmt.Fprintf(md.io.ErrOut, "%s, it may have been scaled down to zero.\n", msg)
+	md.isFirstDeploy, err = prompt.Confirm(ctx, "Provision IPs, volumes and machines as a first deploy?")
+	return err
 }
 
 func (md *machineDeployment) setMachinesForDeployment(ctx context.Context) error {
-	machines, releaseCmdMachine, err := md.flapsClient.ListFlyAppsMachines(ctx)
+	// List the machines once, the rest of the deployment reads them from the machine sets
+	machines, releaseCmdMachine, activeMachines, err := md.listMachines(ctx)
 	if err != nil {
 		return err
 	}
@@ -176,14 +603,10 @@ func (md *machineDeployment) setMachinesForDeployment(ctx context.Context) error
 	// migrate non-platform machines into fly platform
 	if len(machines) == 0 {
 		terminal.Debug("Found no machines that are part of Fly Apps Platform. Checking for active machines...")
-		activeMachines, err := md.flapsClient.ListActive(ctx)
-		if err != nil {
-			return err
-		}
-		if len(activeMachines) > 0 {
+		if activeMachines > 0 {
 			return fmt.Errorf(
 				"found %d machines that are unmanaged. `fly deploy` only updates machines with %s=%s in their metadata. Use `fly machine list` to list machines and `fly machine update --metadata %s=%s <machine id>` to update individual machines with the metadata. Once done, `fly deploy` will update machines with the metadata based on your %s app configuration",
-				len(activeMachines),
+				activeMachines,
 				api.MachineConfigMetadataKeyFlyPlatformVersion,
 				api.MachineFlyPlatformVersion2,
 				api.MachineConfigMetadataKeyFlyPlatformVersion,
@@ -201,6 +624,21 @@ func (md *machineDeployment) setMachinesForDeployment(ctx context.Context) error
 		}
 	}
 
+	if len(md.processGroups) > 0 {
+		machines, err = filterMachinesByProcessGroups(machines, md.processGroups)
+		if err != nil {
+			return err
+		}
+	}
+
+	if md.skipStopped {
+		md.skippedMachines = lo.Filter(machines, func(m *api.Machine, _ int) bool { return isParkedMachine(m) })
+		machines = lo.Filter(machines, func(m *api.Machine, _ int) bool { return !isParkedMachine(m) })
+		for _, m := range md.skippedMachines {
+			terminal.Debugf("Skipping stopped machine %s running %s\n", m.ID, m.FullImageRef())
+		}
+	}
+
 	md.machineSet = machine.NewMachineSet(md.flapsClient, md.io, machines)
 	var releaseCmdSet []*api.Machine
 	if releaseCmdMachine != nil {
@@ -210,52 +648,463 @@ func (md *machineDeployment) setMachinesForDeployment(ctx context.Context) error
 	return nil
 }
 
+// isParkedMachine tells if m is stopped outside of being a standby or a scheduled machine
+func isParkedMachine(m *api.Machine) bool {
+	if m.State != api.MachineStateStopped {
+		return false
+	}
+	return m.Config == nil || (len(m.Config.Standbys) == 0 && m.Config.Schedule == "")
+}
+
+// machineListAttempts is how many times listing the machines is tried before the deployment fails
+const machineListAttempts = 3
+
+// listMachines lists the machines of the app and only keeps the ones the deployment needs, with the count of
+// active machines besides release command ones. Every machine listed is cached for the deployment.
+// The Machines API lists every machine in one response, listings failing temporarily are tried again
+func (md *machineDeployment) listMachines(ctx context.Context) (machines []*api.Machine, releaseCmdMachine *api.Machine, activeMachines int, err error) {
+	sp := spinner.Run(md.io, "Listing machines")
+	defer sp.Stop()
+
+	var allMachines []*api.Machine
+	b := &backoff.Backoff{Min: 500 * time.Millisecond, Max: 5 * time.Second, Factor: 2}
+	for attempt := 1; ; attempt++ {
+		if allMachines, err = md.flapsClient.List(ctx, ""); err == nil {
+			break
+		}
+		if attempt == machineListAttempts || ctx.Err() != nil || !isTemporaryListError(err) {
+			return nil, nil, 0, err
+		}
+		terminal.Debugf("Listing machines failed, retrying: %s\n", err)
+		sp.Set(fmt.Sprintf("Listing machines (attempt %d of %d)", attempt+1, machineListAttempts))
+		select {
+		case <-ctx.Done():
+			return nil, nil, 0, ctx.Err()
+		case <-time.After(b.Duration()):
+		}
+	}
+
+	machines, releaseCmdMachine = flaps.SplitFlyAppsMachines(allMachines)
+	activeMachines = lo.CountBy(allMachines, func(m *api.Machine) bool {
+		return !m.IsReleaseCommandMachine() && m.IsActive()
+	})
+	md.machines = newMachineCache()
+	md.machines.put(allMachines...)
+	return machines, releaseCmdMachine, activeMachines, nil
+}
+
+// isTemporaryListError tells if listing machines may succeed when tried again: network errors, the request
+// timing out and 5xx responses. Other responses fail the same way every time
+func isTemporaryListError(err error) bool {
+	var flapsErr *flaps.FlapsError
+	if errors.As(err, &flapsErr) {
+		return flapsErr.ResponseStatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// filterMachinesByProcessGroups returns the machines that belong to any of groups,
+// failing if a group has no machines
+func filterMachinesByProcessGroups(machines []*api.Machine, groups []string) ([]*api.Machine, error) {
+	byGroup := lo.GroupBy(machines, func(m *api.Machine) string {
+		return m.ProcessGroup()
+	})
+	var filtered []*api.Machine
+	for _, group := range lo.Uniq(groups) {
+		ms, ok := byGroup[group]
+		if !ok {
+			return nil, fmt.Errorf("process group '%s' has no machines, existing groups are [%s]",
+				group, strings.Join(lo.Uniq(lo.Map(machines, func(m *api.Machine, _ int) string { return m.ProcessGroup() })), ", "))
+		}
+		filtered = append(filtered, ms...)
+	}
+	return filtered, nil
+}
+
+// checkRequiredSecrets fails when any of the required_secrets of the app config isn't set for the app
+func (md *machineDeployment) checkRequiredSecrets(ctx context.Context) error {
+	if md.skipSecretCheck || len(md.appConfig.RequiredSecrets) == 0 {
+		return nil
+	}
+	secrets, err := md.appSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("Error fetching application secrets: %w", err)
+	}
+	if missing := missingSecrets(md.appConfig.RequiredSecrets, secrets); len(missing) > 0 {
+		return fmt.Errorf("required secrets are not set: %s. Set them with `fly secrets set` or use --skip-secret-check to deploy anyway",
+			strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// appSecrets fetches the app secrets once per deployment
+func (md *machineDeployment) appSecrets(ctx context.Context) ([]api.Secret, error) {
+	if md.secrets != nil {
+		return md.secrets, nil
+	}
+	secrets, err := md.apiClient.GetAppSecrets(ctx, md.app.Name)
+	if err != nil {
+		return nil, err
+	}
+	md.secrets = secrets
+	return secrets, nil
+}
+
+// stagedSecretsReleases is how many of the latest releases are looked at for the latest complete one
+const stagedSecretsReleases = 25
+
+// setStagedSecrets finds the secrets set since the latest complete release, like the ones set with
+// `fly secrets set --stage`. Setting secrets without --stage restarts the machines with a release of its own, so
+// secrets older than a complete release already reached every machine. Machines boot with the staged ones once
+// updated by this deployment. It doesn't depend on --skip-secret-check, which only skips required secrets.
+// It is best effort: without a complete release among the latest stagedSecretsReleases nothing is found, and
+// secrets are told apart by their CreatedAt, so a secret set again without a new CreatedAt isn't staged
+func (md *machineDeployment) setStagedSecrets(ctx context.Context) {
+	if md.machineSet.IsEmpty() {
+		return
+	}
+	secrets, err := md.appSecrets(ctx)
+	if err != nil {
+		terminal.Debugf("Could not fetch secrets to find staged ones: %v\n", err)
+		return
+	}
+	if len(secrets) == 0 {
+		return
+	}
+	releases, err := md.apiClient.GetAppReleasesMachines(ctx, md.app.Name, stagedSecretsReleases)
+	if err != nil {
+		terminal.Debugf("Could not fetch releases to find staged secrets: %v\n", err)
+		return
+	}
+	if !lo.ContainsBy(releases, func(r api.Release) bool { return r.Status == "complete" }) {
+		terminal.Debugf("No complete release among the latest %d, staged secrets aren't looked for\n", len(releases))
+		return
+	}
+	md.stagedSecrets = stagedSecretNames(secrets, releases)
+	if len(md.stagedSecrets) > 0 {
+		fmt.Fprintf(md.io.Out, "Staged secrets will be applied with this release: %s\n", strings.Join(md.stagedSecrets, ", "))
+	}
+}
+
+// stagedSecretNames returns the secrets created after the latest complete release, none without one.
+// A secret set again is only found when its CreatedAt is after the release
+func stagedSecretNames(secrets []api.Secret, releases []api.Release) []string {
+	complete := lo.Filter(releases, func(r api.Release, _ int) bool { return r.Status == "complete" })
+	if len(complete) == 0 {
+		return nil
+	}
+	latest := lo.MaxBy(complete, func(a, b api.Release) bool { return a.Version > b.Version })
+	staged := lo.FilterMap(secrets, func(s api.Secret, _ int) (string, bool) {
+		return s.Name, s.CreatedAt.After(latest.CreatedAt)
+	})
+	slices.Sort(staged)
+	return staged
+}
+
+func missingSecrets(required []string, secrets []api.Secret) []string {
+	names := lo.Map(secrets, func(s api.Secret, _ int) string { return s.Name })
+	return lo.Without(lo.Uniq(required), names...)
+}
+
+// warnAboutEnvShadowingSecrets warns about [env] values that take precedence over secrets with the same name,
+// asking for confirmation in interactive sessions. Failing to fetch the secrets only warns
+func (md *machineDeployment) warnAboutEnvShadowingSecrets(ctx context.Context) error {
+	if md.skipSecretCheck || md.restartOnly {
+		return nil
+	}
+	envKeys := maps.Keys(md.appConfig.Env)
+	for _, ms := range md.appConfig.Machines {
+		envKeys = append(envKeys, maps.Keys(ms.Env)...)
+	}
+	if len(envKeys) == 0 {
+		return nil
+	}
+
+	secrets, err := md.appSecrets(ctx)
+	if err != nil {
+		md.warnf("Could not fetch secrets to check they are not overridden by [env] values: %v\n", err)
+		return nil
+	}
+	shadowed := shadowedSecrets(envKeys, secrets)
+	if len(shadowed) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(md.io.ErrOut, "%s [env] values override secrets with the same name: %s\n",
+		md.colorize.WarningIcon(), md.colorize.Bold(strings.Join(shadowed, ", ")))
+	if !md.io.IsInteractive() || md.dryRun {
+		return nil
+	}
+	confirmed, err := prompt.Confirm(ctx, "Deploy anyway?")
+	switch {
+	case err != nil:
+		return err
+	case !confirmed:
+		return fmt.Errorf("deployment aborted, remove the secrets from [env] to use their values")
+	}
+	return nil
+}
+
+func shadowedSecrets(envKeys []string, secrets []api.Secret) []string {
+	names := lo.Map(secrets, func(s api.Secret, _ int) string { return s.Name })
+	shadowed := lo.Uniq(lo.Intersect(names, envKeys))
+	slices.Sort(shadowed)
+	return shadowed
+}
+
+// fetchVolumes lists the volumes of the app from the Machines API like its machines, the GraphQL API
+// is only used when the Machines API fails to list them
+func (md *machineDeployment) fetchVolumes(ctx context.Context) ([]api.Volume, error) {
+	machineVolumes, err := md.flapsClient.GetVolumes(ctx)
+	if err == nil {
+		volumes := lo.Map(machineVolumes, func(v api.MachineVolume, _ int) api.Volume { return v.ToVolume() })
+		return lo.Filter(volumes, func(v api.Volume, _ int) bool {
+			return !strings.Contains(v.State, "destroy")
+		}), nil
+	}
+	terminal.Debugf("Failed to list volumes with the Machines API, falling back to the GraphQL API: %s\n", err)
+	return md.apiClient.GetVolumes(ctx, md.app.Name)
+}
+
+// volumeAttached reports whether a volume is attached according to the machines just listed, the attachment
+// of volumes may lag behind, like right after destroying the machine of a volume. Without machines listed,
+// the attachment of the volume is trusted
+func (md *machineDeployment) volumeAttached(v api.Volume) bool {
+	if md.machines == nil {
+		return v.IsAttached()
+	}
+	if machineID, ok := md.machines.mountedBy(v.ID); ok {
+		if !v.IsAttached() {
+			terminal.Debugf("Volume %s is mounted by machine %s but isn't reported attached\n", v.ID, machineID)
+		}
+		return true
+	}
+	if v.IsAttached() {
+		terminal.Debugf("Volume %s is reported attached but no active machine mounts it, it is considered unattached\n", v.ID)
+	}
+	return false
+}
+
 func (md *machineDeployment) setVolumes(ctx context.Context) error {
 	if len(md.appConfig.Mounts) == 0 {
 		return nil
 	}
 
-	volumes, err := md.apiClient.GetVolumes(ctx, md.app.Name)
+	volumes, err := md.fetchVolumes(ctx)
 	if err != nil {
 		return fmt.Errorf("Error fetching application volumes: %w", err)
 	}
+	md.setUnattachedVolumes(volumes)
+	return nil
+}
 
+func (md *machineDeployment) setUnattachedVolumes(volumes []api.Volume) {
 	unattached := lo.Filter(volumes, func(v api.Volume, _ int) bool {
-		return v.AttachedAllocation == nil && v.AttachedMachine == nil
+		return !md.volumeAttached(v) && !md.claimedVolumes[v.ID]
 	})
+	md.volumesListedAt = time.Now()
 
 	md.volumes = lo.GroupBy(unattached, func(v api.Volume) string {
 		return v.Name
 	})
+}
+
+// popVolumeFor takes an unattached volume by name, restricted to region if not empty. Volumes already
+// taken are skipped for the next candidate
+func (md *machineDeployment) popVolumeFor(name, region string) *api.Volume {
+	volumes := md.volumes[name]
+	for idx, vol := range volumes {
+		if (region != "" && vol.Region != region) || md.claimedVolumes[vol.ID] {
+			continue
+		}
+		md.volumes[name] = append(volumes[:idx:idx], volumes[idx+1:]...)
+		if md.claimedVolumes == nil {
+			md.claimedVolumes = map[string]bool{}
+		}
+		md.claimedVolumes[vol.ID] = true
+		return &vol
+	}
 	return nil
 }
 
-func (md *machineDeployment) popVolumeFor(name string) *api.Volume {
-	volumes, ok := md.volumes[name]
-	if !ok {
+// volumesRefreshInterval is how old the unattached volumes can be before taking one for a new machine
+const volumesRefreshInterval = 30 * time.Second
+
+// refreshVolumes lists the volumes again, volumes attached or freed by others since they were listed are
+// accounted for. The machines of the app aren't listed again, only the ones attached to a volume that
+// the deployment hasn't seen yet are fetched. Volumes taken by the deployment stay taken
+func (md *machineDeployment) refreshVolumes(ctx context.Context) error {
+	if len(md.appConfig.Mounts) == 0 {
 		return nil
 	}
-	var vol api.Volume
-	vol, md.volumes[name] = volumes[0], volumes[1:]
-	return &vol
+	volumes, err := md.fetchVolumes(ctx)
+	if err != nil {
+		return err
+	}
+	if md.machines != nil {
+		for _, v := range volumes {
+			if v.AttachedMachine == nil {
+				continue
+			}
+			if _, err := md.machines.get(ctx, md.flapsClient, v.AttachedMachine.ID); err != nil {
+				return err
+			}
+		}
+	}
+	md.setUnattachedVolumes(volumes)
+	return nil
 }
 
-func (md *machineDeployment) validateVolumeConfig() error {
-	machineGroups := lo.GroupBy(
-		lo.Map(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) *api.Machine {
-			return lm.Machine()
-		}),
-		func(m *api.Machine) string {
-			return m.ProcessGroup()
-		})
+// refreshVolumesIfStale refreshes the unattached volumes of long deployments before one is taken for a new
+// machine, failing to refresh them keeps using the volumes listed before
+func (md *machineDeployment) refreshVolumesIfStale(ctx context.Context) {
+	if len(md.appConfig.Mounts) == 0 || time.Since(md.volumesListedAt) < volumesRefreshInterval {
+		return
+	}
+	if err := md.refreshVolumes(ctx); err != nil {
+		terminal.Debugf("Failed to refresh the volumes of the app, using the ones listed before: %s\n", err)
+	}
+}
+
+// machineNameFor returns a name for a new machine in groupName when a name prefix is configured,
+// like "web-fra-01", picking the first free number among the app machines. Empty means a random name.
+func (md *machineDeployment) machineNameFor(groupName, region string) string {
+	settings, err := md.appConfig.MachineSettingsFor(groupName)
+	if err != nil {
+		return ""
+	}
+	prefix := settings.NamePrefix
+	if prefix == "" && md.appConfig.MachineNamePrefix != "" {
+		prefix = md.appConfig.MachineNamePrefix + "-" + groupName
+	}
+	if prefix == "" {
+		return ""
+	}
+	if region != "" {
+		prefix += "-" + region
+	}
+
+	if md.usedMachineNames == nil {
+		md.usedMachineNames = map[string]bool{}
+		for _, lm := range md.machineSet.GetMachines() {
+			md.usedMachineNames[lm.Machine().Name] = true
+		}
+	}
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s-%02d", prefix, n)
+		if !md.usedMachineNames[name] {
+			md.usedMachineNames[name] = true
+			return name
+		}
+	}
+}
+
+var replacementNameSuffix = regexp.MustCompile(`-r(\d+)$`)
+
+// replacementNameFor returns the name of a machine replacing the machine named name, like "web-fra-01-r1".
+// Replacing a replacement bumps its number, unnamed machines are replaced by machines with random names
+func (md *machineDeployment) replacementNameFor(name string) string {
+	if name == "" {
+		return ""
+	}
+	base, n := name, 1
+	if m := replacementNameSuffix.FindStringSubmatch(name); m != nil {
+		base = strings.TrimSuffix(name, m[0])
+		n, _ = strconv.Atoi(m[1])
+		n++
+	}
+	taken := lo.SliceToMap(md.machineSet.GetMachines(), func(lm machine.LeasableMachine) (string, bool) {
+		return lm.Machine().Name, true
+	})
+	for taken[fmt.Sprintf("%s-r%d", base, n)] {
+		n++
+	}
+	return fmt.Sprintf("%s-r%d", base, n)
+}
 
+// volumesInAnyRegion is true when new machines can be created in the region of the volume they attach,
+// that is when the primary region wasn't forced and no other regions are configured
+func (md *machineDeployment) volumesInAnyRegion() bool {
+	return !md.regionForced && len(md.appConfig.AllRegions()) <= 1
+}
+
+// deployRegions returns the regions where new process groups get machines, the primary region first
+func (md *machineDeployment) deployRegions() []string {
+	if regions := md.appConfig.AllRegions(); len(regions) > 0 {
+		return regions
+	}
+	return []string{""}
+}
+
+// machineRegionsFor returns the region of every machine expected in a process group,
+// and whether the number of machines was set explicitly by the group's count
+func (md *machineDeployment) machineRegionsFor(groupName string) ([]string, bool, error) {
+	settings, err := md.appConfig.MachineSettingsFor(groupName)
+	if err != nil {
+		return nil, false, err
+	}
+	regions := md.deployRegions()
+	if settings.Count == nil {
+		return regions, false, nil
+	}
+	placements := make([]string, *settings.Count)
+	for idx := range placements {
+		placements[idx] = regions[idx%len(regions)]
+	}
+	return placements, true, nil
+}
+
+// skipsProvisioning is true for process groups with a count of zero, no machines are created for them
+// but their existing machines are still updated
+func (md *machineDeployment) skipsProvisioning(groupName string) bool {
+	settings, err := md.appConfig.MachineSettingsFor(groupName)
+	return err == nil && settings.Count != nil && *settings.Count == 0
+}
+
+// checkUnattachedVolumes verifies there are enough unattached volumes for the mounts
+// of a group to create a machine in each of the given regions
+func (md *machineDeployment) checkUnattachedVolumes(groupName string, mounts []appconfig.Mount, regions []string) error {
+	needed := map[string]int{}
+	for _, region := range regions {
+		needed[region]++
+	}
+	for _, m := range mounts {
+		for region, n := range needed {
+			vs := lo.Filter(md.volumes[m.Source], func(v api.Volume, _ int) bool {
+				return region == "" || v.Region == region
+			})
+			if len(vs) >= n {
+				continue
+			}
+			if region == "" {
+				return fmt.Errorf(
+					"creating %d new machines in group '%s' requires %d unattached '%s' volumes. Create them with `fly volume create %s`",
+					n, groupName, n, m.Source, m.Source)
+			}
+			otherRegions := lo.Uniq(lo.Map(md.volumes[m.Source], func(v api.Volume, _ int) string { return v.Region }))
+			if len(otherRegions) > 0 {
+				return fmt.Errorf(
+					"creating %d new machines in group '%s' requires %d unattached '%s' volumes in region %s but they are in [%s]. "+
+						"Deploy to their region or create them with `fly volume create %s -r %s`",
+					n, groupName, n, m.Source, region, strings.Join(otherRegions, ", "), m.Source, region)
+			}
+			return fmt.Errorf(
+				"creating %d new machines in group '%s' requires %d unattached '%s' volumes in region %s. Create them with `fly volume create %s -r %s`",
+				n, groupName, n, m.Source, region, m.Source, region)
+		}
+	}
+	return nil
+}
+
+func (md *machineDeployment) validateVolumeConfig() error {
 	for _, groupName := range md.appConfig.ProcessNames() {
 		groupConfig, err := md.appConfig.Flatten(groupName)
 		if err != nil {
 			return err
 		}
 
-		switch ms := machineGroups[groupName]; len(ms) > 0 {
+		switch ms := md.machineSet.FilterByProcessGroup(groupName).GetMachines(); len(ms) > 0 {
 		case true:
 			// For groups with machines, check the attached volumes match expected mounts
 			var mntSrc, mntDst string
@@ -264,7 +1113,8 @@ func (md *machineDeployment) validateVolumeConfig() error {
 				mntDst = groupConfig.Mounts[0].Destination
 			}
 
-			for _, m := range ms {
+			for _, lm := range ms {
+				m := lm.Machine()
 				if mntDst == "" && len(m.Config.Mounts) != 0 {
 					// TODO: Detaching a volume from a machine is possible, but it usually means a missconfiguration.
 					// We should show a warning and ask the user for confirmation and let it happen instead of failing here.
@@ -295,15 +1145,34 @@ func (md *machineDeployment) validateVolumeConfig() error {
 				}
 			}
 
-		case false:
-			// Check if there are unattached volumes for new groups with mounts
-			for _, m := range groupConfig.Mounts {
-				if vs := md.volumes[m.Source]; len(vs) == 0 {
-					return fmt.Errorf(
-						"creating a new machine in group '%s' requires an unattached '%s' volume. Create it with `fly volume create %s`",
-						groupName, m.Source, m.Source)
+			if md.reconcileCounts && len(groupConfig.Mounts) > 0 {
+				countDiffs, err := md.resolveMachineCountChanges()
+				if err != nil {
+					return err
+				}
+				if diff, ok := countDiffs[groupName]; ok {
+					if err := md.checkUnattachedVolumes(groupName, groupConfig.Mounts, diff.missingRegions); err != nil {
+						return err
+					}
 				}
 			}
+
+		case false:
+			// Check if there are unattached volumes for new groups with mounts, only the first
+			// machine is mandatory unless all regions are required
+			placements, _, err := md.machineRegionsFor(groupName)
+			if err != nil {
+				return err
+			}
+			if !md.requireAllRegions && len(placements) > 1 {
+				placements = placements[:1]
+			}
+			if md.volumesInAnyRegion() {
+				placements = lo.Map(placements, func(string, int) string { return "" })
+			}
+			if err := md.checkUnattachedVolumes(groupName, groupConfig.Mounts, placements); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -314,18 +1183,113 @@ func (md *machineDeployment) setImg(ctx context.Context) error {
 	if md.img != "" {
 		return nil
 	}
+	if md.restartOnly && !md.useLatestRelease && !md.machineSet.IsEmpty() {
+		// Restarted machines keep their own image, the release is recorded with the first one
+		md.img = md.machineSet.GetMachines()[0].Machine().Config.Image
+		return nil
+	}
+	// Without a deployment image the current release has the image, or else a running machine
 	latestImg, err := md.latestImage(ctx)
 	if err == nil {
 		md.img = latestImg
+		fmt.Fprintf(md.io.Out, "Using image %s of the current release\n", md.img)
 		return nil
 	}
 	if !md.machineSet.IsEmpty() {
-		md.img = md.machineSet.GetMachines()[0].Machine().Config.Image
+		m := md.machineSet.GetMachines()[0].Machine()
+		md.img = m.Config.Image
+		fmt.Fprintf(md.io.Out, "Using image %s of machine %s, the current release has none\n", md.img, m.ID)
 		return nil
 	}
 	return fmt.Errorf("could not find image to use for deployment; backend error was: %w", err)
 }
 
+// verifyImage checks the deployment image exists before anything runs with it, then pins its tag to the digest
+// so machines updated late in the rollout don't pick up a newer build of a moving tag.
+// Only images in the Fly registry must resolve, others may be in private registries the backend can't reach
+func (md *machineDeployment) verifyImage(ctx context.Context) error {
+	if md.restartOnly || md.dryRun {
+		return nil
+	}
+	img, err := md.apiClient.ResolveImageForApp(ctx, md.app.Name, md.img)
+	switch {
+	case err == nil && img != nil:
+		if pinned := pinImageDigest(md.img, img.Digest); !md.noDigestPin && pinned != md.img {
+			fmt.Fprintf(md.io.Out, "Deploying image %s at digest %s\n", md.img, img.Digest)
+			md.img = pinned
+		}
+		return nil
+	case !isFlyRegistryImage(md.img):
+		md.warnf("Could not verify image %s exists, deploying it anyway\n", md.img)
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to verify image %s: %w", md.img, err)
+	}
+
+	notFoundErr := fmt.Errorf("image not found: %s", md.img)
+	if refs := md.nearbyImageRefs(ctx); len(refs) > 0 {
+		return fmt.Errorf("%w, did you mean %s?", notFoundErr, strings.Join(refs, ", "))
+	}
+	if current, err := md.latestImage(ctx); err == nil && current != md.img {
+		return fmt.Errorf("%w, the current release uses %s", notFoundErr, current)
+	}
+	return notFoundErr
+}
+
+// nearbyImageRefs suggests images of the repository of the deployment image with a tag close to its own,
+// there are none when the tags can't be listed
+func (md *machineDeployment) nearbyImageRefs(ctx context.Context) []string {
+	repo := imageRepository(md.img)
+	tag, ok := strings.CutPrefix(md.img, repo+":")
+	if !ok || md.listImageTags == nil {
+		return nil
+	}
+	tags, err := md.listImageTags(ctx, md.img)
+	if err != nil {
+		terminal.Debugf("Could not list the tags of %s: %v\n", repo, err)
+		return nil
+	}
+	return lo.Map(nearbyTags(tag, tags), func(t string, _ int) string { return repo + ":" + t })
+}
+
+// maxTagSuggestions is how many tags are suggested for a deployment image that isn't found
+const maxTagSuggestions = 3
+
+// nearbyTags returns the tags closest to tag, a few edits away at most
+func nearbyTags(tag string, tags []string) []string {
+	maxDistance := lo.Max([]int{2, len(tag) / 3})
+	distances := map[string]int{}
+	for _, t := range tags {
+		if d := levenshtein.ComputeDistance(tag, t); t != tag && d <= maxDistance {
+			distances[t] = d
+		}
+	}
+	nearby := lo.Keys(distances)
+	sort.Slice(nearby, func(i, j int) bool {
+		if distances[nearby[i]] != distances[nearby[j]] {
+			return distances[nearby[i]] < distances[nearby[j]]
+		}
+		return nearby[i] < nearby[j]
+	})
+	if len(nearby) > maxTagSuggestions {
+		nearby = nearby[:maxTagSuggestions]
+	}
+	return nearby
+}
+
+// pinImageDigest replaces the tag of an image reference with its digest, references already using one are kept
+func pinImageDigest(ref, digest string) string {
+	if digest == "" || strings.Contains(ref, "@") {
+		return ref
+	}
+	return imageRepository(ref) + "@" + digest
+}
+
+// isFlyRegistryImage tells if an image reference points to the Fly registry
+func isFlyRegistryImage(ref string) bool {
+	return strings.HasPrefix(ref, "registry.fly.io/")
+}
+
 func (md *machineDeployment) latestImage(ctx context.Context) (string, error) {
 	_ = `# @genqlient
 	       query FlyctlDeployGetLatestImage($appName:String!) {
@@ -356,6 +1320,32 @@ func (md *machineDeployment) setMachineGuest(vmSize string) error {
 	return md.machineGuest.SetSize(vmSize)
 }
 
+// rollsWithoutHealthChecks tells if a rolling deployment skips the health checks it relies on
+func (md *machineDeployment) rollsWithoutHealthChecks() bool {
+	return md.strategy == "rolling" && md.skipHealthChecks && !md.detach && !md.restartOnly
+}
+
+// confirmRollingWithoutHealthChecks warns that rolling deployments without health checks don't stop on broken
+// machines, they are slow immediate deployments. Interactive deployments must be confirmed unless yes is set
+func (md *machineDeployment) confirmRollingWithoutHealthChecks(ctx context.Context, yes bool) error {
+	if !md.rollsWithoutHealthChecks() || md.dryRun {
+		return nil
+	}
+	fmt.Fprintf(md.io.ErrOut, "\n%s Health checks are skipped with the rolling strategy, machines are updated one by one but broken ones won't stop the deployment.\n", md.colorize.Red("WARNING"))
+	fmt.Fprintf(md.io.ErrOut, "Use %s to update them all at once if you don't need health checks\n\n", md.colorize.Bold("--strategy immediate"))
+	if yes || !md.io.IsInteractive() {
+		return nil
+	}
+	confirmed, err := prompt.Confirm(ctx, "Deploy without health checks?")
+	switch {
+	case err != nil:
+		return err
+	case !confirmed:
+		return fmt.Errorf("deployment canceled, pass --yes to deploy with the rolling strategy without health checks")
+	}
+	return nil
+}
+
 func (md *machineDeployment) setStrategy(passedInStrategy string) error {
 	if passedInStrategy != "" {
 		md.strategy = passedInStrategy
@@ -370,7 +1360,12 @@ func (md *machineDeployment) setStrategy(passedInStrategy string) error {
 	return nil
 }
 
-func (md *machineDeployment) createReleaseInBackend(ctx context.Context) error {
+func (md *machineDeployment) createReleaseInBackend(ctx context.Context) (err error) {
+	// The release version is only known from here on, the enclosing deploy span records it too
+	deploySpan := tracing.SpanFromContext(ctx)
+	ctx, span := tracing.Start(ctx, "create release", md.spanAttributes()...)
+	defer func() { span.End(err) }()
+
 	_ = `# @genqlient
 	mutation MachinesCreateRelease($input:CreateReleaseInput!) {
 		createRelease(input:$input) {
@@ -381,11 +1376,21 @@ func (md *machineDeployment) createReleaseInBackend(ctx context.Context) error {
 		}
 	}
 	`
+	config := md.appConfig
+	if md.redactEnv {
+		config = md.appConfig.WithRedactedEnv()
+	}
+	definition, err := md.releaseDefinition(config)
+	if err != nil {
+		return err
+	}
+	// Debug logs of the request never show [env] values, even when the release stores them
+	ctx = api.WithLoggedBody(ctx, md.redactReleaseRequest)
 	input := gql.CreateReleaseInput{
 		AppId:           md.app.Name,
 		PlatformVersion: "machines",
 		Strategy:        gql.DeploymentStrategy(strings.ToUpper(md.strategy)),
-		Definition:      md.appConfig,
+		Definition:      definition,
 		Image:           md.img,
 	}
 	resp, err := gql.MachinesCreateRelease(ctx, md.gqlClient, input)
@@ -394,9 +1399,55 @@ func (md *machineDeployment) createReleaseInBackend(ctx context.Context) error {
 	}
 	md.releaseId = resp.CreateRelease.Release.Id
 	md.releaseVersion = resp.CreateRelease.Release.Version
+	span.SetAttributes(tracing.Int("release.version", md.releaseVersion))
+	deploySpan.SetAttributes(tracing.Int("release.version", md.releaseVersion))
 	return nil
 }
 
+// releaseDefinition returns the definition the release records for config. The release inputs have no
+// description or metadata, so a release command the deployment skips is noted in the definition as
+// skipped_release_command, next to the release_command of [deploy] it didn't run
+func (md *machineDeployment) releaseDefinition(config *appconfig.Config) (any, error) {
+	if !md.appConfig.Deploy.HasReleaseCommand() || md.runsReleaseCommand() {
+		return config, nil
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	definition := map[string]any{}
+	if err := json.Unmarshal(raw, &definition); err != nil {
+		return nil, err
+	}
+	definition["skipped_release_command"] = md.appConfig.Deploy.ReleaseCommandString()
+	return definition, nil
+}
+
+// redactReleaseRequest replaces the definition of a release creation request with the app config
+// having its [env] values redacted, requests it can't read are left out entirely
+func (md *machineDeployment) redactReleaseRequest(body []byte) []byte {
+	var req struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return []byte("(release request not logged, it has [env] values)")
+	}
+	if input, ok := req.Variables["input"].(map[string]any); ok {
+		definition, err := md.releaseDefinition(md.appConfig.WithRedactedEnv())
+		if err != nil {
+			return []byte("(release request not logged, it has [env] values)")
+		}
+		input["definition"] = definition
+	}
+	redacted, err := json.Marshal(req)
+	if err != nil {
+		return []byte("(release request not logged, it has [env] values)")
+	}
+	return redacted
+}
+
 func (md *machineDeployment) updateReleaseInBackend(ctx context.Context, status string) error {
 	_ = `# @genqlient
 	mutation MachinesUpdateRelease($input:UpdateReleaseInput!) {
@@ -419,7 +1470,7 @@ func (md *machineDeployment) updateReleaseInBackend(ctx context.Context, status
 }
 
 func (md *machineDeployment) logClearLinesAbove(count int) {
-	if md.io.IsInteractive() {
+	if md.io.IsInteractive() && !md.concurrentUpdates {
 		builder := aec.EmptyBuilder
 		str := builder.Up(uint(count)).EraseLine(aec.EraseModes.All).ANSI
 		fmt.Fprint(md.io.ErrOut, str.String())
@@ -454,3 +1505,35 @@ func determineAppConfigForMachines(ctx context.Context, envFromFlags []string, p
 
 	return appConfig, nil
 }
+
+// processWaitTimeouts returns the wait timeouts process groups set in the app config
+func processWaitTimeouts(appConfig *appconfig.Config) map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	for name, p := range appConfig.Processes {
+		if p.WaitTimeout != nil {
+			timeouts[name] = p.WaitTimeout.Duration
+		}
+	}
+	return timeouts
+}
+
+// formatWaitTimeouts shows the default wait timeout followed by the per group overrides
+func formatWaitTimeouts(waitTimeout time.Duration, groupWaitTimeouts map[string]time.Duration) string {
+	if len(groupWaitTimeouts) == 0 {
+		return waitTimeout.String()
+	}
+	groups := lo.Keys(groupWaitTimeouts)
+	slices.Sort(groups)
+	overrides := lo.Map(groups, func(g string, _ int) string {
+		return fmt.Sprintf("%s=%s", g, groupWaitTimeouts[g])
+	})
+	return fmt.Sprintf("%s (%s)", waitTimeout, strings.Join(overrides, ", "))
+}
+
+// waitTimeoutFor returns how long to wait for the machines of a process group to start and be healthy
+func (md *machineDeployment) waitTimeoutFor(groupName string) time.Duration {
+	if d, ok := md.groupWaitTimeouts[groupName]; ok {
+		return d
+	}
+	return md.waitTimeout
+}
@@ -0,0 +1,318 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/config"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// fakeBackend serves the GraphQL API and the Machines API of one app, for tests deploying it through
+// NewMachineDeployment. Machines and volumes change with the requests it gets like they would on the platform
+type fakeBackend struct {
+	t      *testing.T
+	server *httptest.Server
+	app    *api.AppCompact
+
+	mu       sync.Mutex
+	machines []*api.Machine
+	volumes  []api.MachineVolume
+	// requests lists the Machines API requests received, like "POST /machines/m1" or "GET /volumes"
+	requests []string
+	launched int
+	graphql  []fakeGraphQL
+	// intercept answers Machines API requests instead of the fake when it returns true
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+
+	// Out and ErrOut receive the output of deployments using the streams of the backend
+	ios    *iostreams.IOStreams
+	Out    *lockedBuffer
+	ErrOut *lockedBuffer
+}
+
+// lockedBuffer is a bytes.Buffer machines updated concurrently can write their progress lines to
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// fakeGraphQL answers GraphQL requests for the operation or the query containing match with data
+type fakeGraphQL struct {
+	match string
+	data  func(vars map[string]any) any
+}
+
+func newFakeBackend(t *testing.T, machines ...*api.Machine) *fakeBackend {
+	ios, _, _, _ := iostreams.Test()
+	out, errOut := &lockedBuffer{}, &lockedBuffer{}
+	ios.Out, ios.ErrOut = out, errOut
+	fb := &fakeBackend{
+		t: t,
+		app: &api.AppCompact{
+			ID:              "my-cool-app",
+			Name:            "my-cool-app",
+			PlatformVersion: appconfig.MachinesPlatform,
+			Deployed:        true,
+			Organization:    &api.OrganizationBasic{ID: "my-dangling-org", Slug: "my-org"},
+		},
+		machines: machines,
+		ios:      ios,
+		Out:      out,
+		ErrOut:   errOut,
+	}
+	fb.onGraphQL("secrets", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"secrets": []api.Secret{}}}
+	})
+	fb.onGraphQL("MachinesCreateRelease", func(map[string]any) any {
+		return map[string]any{"createRelease": map[string]any{"release": map[string]any{"id": "rel_1", "version": 2}}}
+	})
+	fb.onGraphQL("MachinesUpdateRelease", func(map[string]any) any {
+		return map[string]any{"updateRelease": map[string]any{"release": map[string]any{"id": "rel_1"}}}
+	})
+	fb.onGraphQL("releasesUnprocessed", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"releases": map[string]any{"nodes": []api.Release{}}}}
+	})
+	fb.onGraphQL("image(ref", func(vars map[string]any) any {
+		return map[string]any{"app": map[string]any{"id": fb.app.ID, "image": map[string]any{"id": "img_1", "ref": vars["imageRef"]}}}
+	})
+	fb.onGraphQL("FlyctlDeployGetLatestImage", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"currentReleaseUnprocessed": nil}}
+	})
+	fb.server = httptest.NewServer(http.HandlerFunc(fb.serveHTTP))
+	t.Cleanup(fb.server.Close)
+	return fb
+}
+
+// onGraphQL answers GraphQL requests for the operation or the query containing match, the latest
+// registered answer wins. Answering with an error fails the request with its message
+func (fb *fakeBackend) onGraphQL(match string, data func(vars map[string]any) any) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.graphql = append([]fakeGraphQL{{match: match, data: data}}, fb.graphql...)
+}
+
+// context returns a context deploying cfg with the API client talking to the backend
+func (fb *fakeBackend) context(cfg *appconfig.Config) context.Context {
+	api.SetBaseURL(fb.server.URL)
+	cfg.AppName = fb.app.Name
+	require.NoError(fb.t, cfg.SetMachinesPlatform())
+	ctx := client.NewContext(context.Background(), client.FromToken("test"))
+	ctx = iostreams.NewContext(ctx, fb.ios)
+	ctx = config.NewContext(ctx, &config.Config{})
+	ctx = appconfig.WithName(ctx, fb.app.Name)
+	return appconfig.WithConfig(ctx, cfg)
+}
+
+// args returns the arguments of a deployment of the app managing its machines through the backend
+func (fb *fakeBackend) args() MachineDeploymentArgs {
+	baseURL, err := url.Parse(fb.server.URL)
+	require.NoError(fb.t, err)
+	flapsClient, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:   fb.app.Name,
+		BaseURL:   baseURL,
+		AuthToken: "test",
+	})
+	require.NoError(fb.t, err)
+	return MachineDeploymentArgs{
+		AppCompact:      fb.app,
+		DeploymentImage: "registry.fly.io/my-cool-app:deployment-1",
+		FlapsClient:     flapsClient,
+		IOStreams:       fb.ios,
+	}
+}
+
+// machine returns the machine with id as the backend has it now
+func (fb *fakeBackend) machine(id string) *api.Machine {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.findLocked(id)
+}
+
+// requested returns the Machines API requests received so far
+func (fb *fakeBackend) requested() []string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]string(nil), fb.requests...)
+}
+
+func (fb *fakeBackend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/graphql" {
+		fb.serveGraphQL(w, r)
+		return
+	}
+	prefix := "/v1/apps/" + fb.app.Name
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		fb.t.Errorf("unexpected request to %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	fb.mu.Lock()
+	fb.requests = append(fb.requests, r.Method+" "+path)
+	intercept := fb.intercept
+	fb.mu.Unlock()
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if path == "/volumes" {
+		json.NewEncoder(w).Encode(fb.volumes)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/machines"), "/")
+	id, action := "", ""
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	if len(parts) > 2 {
+		action = parts[2]
+	}
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(fb.machines)
+	case id == "" && r.Method == http.MethodPost:
+		var in api.LaunchMachineInput
+		json.NewDecoder(r.Body).Decode(&in)
+		fb.launched++
+		m := &api.Machine{
+			ID:         fmt.Sprintf("new%d", fb.launched),
+			Name:       in.Name,
+			Region:     in.Region,
+			Config:     in.Config,
+			InstanceID: "v1",
+			State:      machineStateAfter(in.SkipLaunch),
+		}
+		fb.machines = append(fb.machines, m)
+		json.NewEncoder(w).Encode(m)
+	default:
+		m := fb.findLocked(id)
+		if m == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "machine not found"})
+			return
+		}
+		fb.serveMachine(w, r, m, action)
+	}
+}
+
+func (fb *fakeBackend) serveMachine(w http.ResponseWriter, r *http.Request, m *api.Machine, action string) {
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(m)
+	case action == "" && r.Method == http.MethodPost:
+		var in api.LaunchMachineInput
+		json.NewDecoder(r.Body).Decode(&in)
+		m.Config = in.Config
+		m.InstanceID += "+"
+		m.State = machineStateAfter(in.SkipLaunch && m.State == api.MachineStateStopped)
+		json.NewEncoder(w).Encode(m)
+	case action == "" && r.Method == http.MethodDelete:
+		fb.machines = lo.Without(fb.machines, m)
+	case action == "lease":
+		json.NewEncoder(w).Encode(api.MachineLease{Status: "success", Data: &api.MachineLeaseData{Nonce: "nonce-" + m.ID}})
+	case action == "start":
+		m.State = api.MachineStateStarted
+		json.NewEncoder(w).Encode(api.MachineStartResponse{Status: "ok"})
+	case action == "stop":
+		m.State = api.MachineStateStopped
+	case action == "wait", action == "signal", action == "restart":
+	default:
+		fb.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (fb *fakeBackend) findLocked(id string) *api.Machine {
+	for _, m := range fb.machines {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (fb *fakeBackend) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	require.NoError(fb.t, json.NewDecoder(r.Body).Decode(&req))
+	fb.mu.Lock()
+	answers := fb.graphql
+	fb.mu.Unlock()
+	for _, a := range answers {
+		if req.OperationName == a.match || strings.Contains(req.Query, a.match) {
+			data := a.data(req.Variables)
+			if err, ok := data.(error); ok {
+				json.NewEncoder(w).Encode(map[string]any{"errors": []map[string]string{{"message": err.Error()}}})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": data})
+			return
+		}
+	}
+	fb.t.Errorf("unexpected GraphQL request %s%s", req.OperationName, req.Query)
+	json.NewEncoder(w).Encode(map[string]any{"errors": []map[string]string{{"message": "unexpected request"}}})
+}
+
+// machineStateAfter is the state of a machine once launched or updated, skipLaunch leaves it stopped
+func machineStateAfter(skipLaunch bool) string {
+	if skipLaunch {
+		return api.MachineStateStopped
+	}
+	return api.MachineStateStarted
+}
+
+// platformMachine returns a started machine of the apps platform in group, with image and config env
+func platformMachine(id, group string) *api.Machine {
+	return &api.Machine{
+		ID:         id,
+		State:      api.MachineStateStarted,
+		Region:     "fra",
+		InstanceID: "v1",
+		Config: &api.MachineConfig{
+			Image: "registry.fly.io/my-cool-app:deployment-0",
+			Metadata: map[string]string{
+				api.MachineConfigMetadataKeyFlyPlatformVersion: api.MachineFlyPlatformVersion2,
+				api.MachineConfigMetadataKeyFlyProcessGroup:    group,
+			},
+		},
+	}
+}
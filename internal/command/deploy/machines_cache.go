@@ -0,0 +1,107 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+)
+
+// machineCache keeps the machines of the app seen during a deployment, keyed by ID, so reading one again
+// doesn't get it from the Machines API. Mutations replace the entry with the machine they return, destroyed
+// machines are recorded as gone and machines in an unknown state are invalidated to be fetched again.
+// It is safe for concurrent use, changes to a nil cache are ignored
+type machineCache struct {
+	mu sync.Mutex
+	// machines has nil for machines known to be gone
+	machines map[string]*api.Machine
+	// mounts maps the volumes mounted by active machines to their machine
+	mounts map[string]string
+}
+
+func newMachineCache(machines ...*api.Machine) *machineCache {
+	c := &machineCache{machines: map[string]*api.Machine{}, mounts: map[string]string{}}
+	c.put(machines...)
+	return c
+}
+
+// put records machines as the Machines API returned them
+func (c *machineCache) put(machines ...*api.Machine) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range machines {
+		c.setLocked(m.ID, m)
+	}
+}
+
+// destroyed records the machine with id as gone, its volumes are free
+func (c *machineCache) destroyed(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(id, nil)
+}
+
+// invalidate drops the machine with id, it is fetched again next time it is read
+func (c *machineCache) invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(id, nil)
+	delete(c.machines, id)
+}
+
+func (c *machineCache) setLocked(id string, m *api.Machine) {
+	if old := c.machines[id]; old != nil && old.Config != nil {
+		for _, mount := range old.Config.Mounts {
+			if c.mounts[mount.Volume] == id {
+				delete(c.mounts, mount.Volume)
+			}
+		}
+	}
+	c.machines[id] = m
+	if m != nil && m.IsActive() && m.Config != nil {
+		for _, mount := range m.Config.Mounts {
+			c.mounts[mount.Volume] = id
+		}
+	}
+}
+
+// get returns the machine with id, fetching it only when it isn't cached. Machines that are gone are nil
+func (c *machineCache) get(ctx context.Context, flapsClient *flaps.Client, id string) (*api.Machine, error) {
+	c.mu.Lock()
+	m, ok := c.machines[id]
+	c.mu.Unlock()
+	if ok {
+		return m, nil
+	}
+	m, err := flapsClient.Get(ctx, id)
+	var flapsErr *flaps.FlapsError
+	switch {
+	case errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusNotFound:
+		c.destroyed(id)
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	c.put(m)
+	return m, nil
+}
+
+// mountedBy returns the active machine mounting the volume with id
+func (c *machineCache) mountedBy(volumeID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.mounts[volumeID]
+	return id, ok
+}
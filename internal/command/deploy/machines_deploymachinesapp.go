@@ -5,18 +5,29 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
 	machcmd "github.com/superfly/flyctl/internal/command/machine"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/render"
+	"github.com/superfly/flyctl/internal/tracing"
 	"github.com/superfly/flyctl/terminal"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
 )
 
+// ErrDeployTimeout is returned when a deployment takes longer than its DeployTimeout
+var ErrDeployTimeout = errors.New("deployment timed out")
+
+// ErrNewerRelease is returned when another deployment created a newer release while this one was running
+var ErrNewerRelease = errors.New("a newer release was created")
+
 type ProcessGroupsDiff struct {
 	machinesToRemove      []machine.LeasableMachine
 	groupsToRemove        map[string]int
@@ -24,55 +35,153 @@ type ProcessGroupsDiff struct {
 }
 
 func (md *machineDeployment) DeployMachinesApp(ctx context.Context) error {
+	_, err := md.DeployMachinesAppWithResult(ctx)
+	return err
+}
+
+func (md *machineDeployment) DeployMachinesAppWithResult(ctx context.Context) (*DeploymentResult, error) {
+	if md.dryRun {
+		return nil, fmt.Errorf("BUG: dry run machines deployments can only be planned")
+	}
+	if md.unchanged {
+		fmt.Fprintf(md.io.Out, "No changes detected, image %s and the app config are already deployed\n", md.img)
+		return md.result("unchanged", nil), nil
+	}
 	ctx = flaps.NewContext(ctx, md.flapsClient)
+	started := time.Now()
 
 	if err := md.updateReleaseInBackend(ctx, "running"); err != nil {
-		return fmt.Errorf("failed to set release status to 'running': %w", err)
+		err = fmt.Errorf("failed to set release status to 'running': %w", err)
+		result := md.result("failed", err)
+		md.notifyWebhook(result, time.Since(started))
+		return result, err
+	}
+
+	deployCtx := ctx
+	if md.deployTimeout > 0 {
+		var cancel context.CancelFunc
+		deployCtx, cancel = context.WithTimeout(ctx, md.deployTimeout)
+		defer cancel()
 	}
 
 	var err error
 	if md.restartOnly {
-		err = md.restartMachinesApp(ctx)
+		err = md.restartMachinesApp(deployCtx)
 	} else {
-		err = md.deployMachinesApp(ctx)
+		err = md.deployMachinesApp(deployCtx)
+	}
+	if err == nil && md.probe != nil && md.waitsForMachines() {
+		err = md.runPostDeployProbe(deployCtx)
+	}
+	if err != nil && ctx.Err() == nil && errors.Is(deployCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %s: %v", ErrDeployTimeout, md.deployTimeout, err)
 	}
 
-	var status string
-	switch {
-	case err == nil:
-		status = "complete"
-	case errors.Is(err, context.Canceled):
+	if n := md.flapsClient.RateLimitedCount(); n > 0 {
+		terminal.Debugf("Machines API rate limited %d requests during the deployment\n", n)
+	}
+	established, reused := md.flapsClient.ConnectionStats()
+	terminal.Debugf("Machines API connections during the deployment: %d established, %d reused\n", established, reused)
+
+	status := releaseStatusFor(err)
+	if status == "interrupted" {
 		// Provide an extra second to try to update the release status.
-		status = "interrupted"
 		var cancel func()
 		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
+	}
+
+	switch n := len(md.stagedSecrets); {
+	case n == 0:
+	case err == nil:
+		fmt.Fprintf(md.io.Out, "%d staged secret%s applied with release v%d\n", n, lo.Ternary(n == 1, " was", "s were"), md.releaseVersion)
 	default:
-		status = "failed"
+		// Secrets are already set for the app, only the machines updated before the failure use them. The release
+		// isn't complete so the next deployment finds them staged again
+		md.warnf("%d staged secret%s set but only machines updated before the failure use them, they will be applied by the next deployment\n",
+			n, lo.Ternary(n == 1, " remains", "s remain"))
 	}
 
-	if updateErr := md.updateReleaseInBackend(ctx, status); updateErr != nil {
+	updateErr := md.updateReleaseInBackend(ctx, status)
+	if updateErr != nil {
 		if err == nil {
 			err = fmt.Errorf("failed to set final release status: %w", updateErr)
 		} else {
-			terminal.Warnf("failed to set final release status after deployment failure: %v\n", updateErr)
+			md.warnf("failed to set final release status after deployment failure: %v\n", updateErr)
 		}
 	}
-	return err
+
+	if errors.Is(err, ErrDeployTimeout) {
+		if updateErr == nil {
+			fmt.Fprintf(md.io.ErrOut, "Release v%d was marked failed, timed out after %s\n", md.releaseVersion, md.deployTimeout)
+		} else {
+			fmt.Fprintf(md.io.ErrOut, "Release v%d timed out after %s, its status could not be updated\n", md.releaseVersion, md.deployTimeout)
+		}
+	}
+	if md.hooks.OnFinish != nil {
+		md.hooks.OnFinish(ctx, status, err)
+	}
+	md.publish(DeployEvent{Type: DeployEventPhase, Phase: PhaseFinished, State: status})
+	md.logDroppedEvents()
+
+	result := md.result(status, err)
+	if len(result.Machines) > 0 || len(result.Skipped) > 0 {
+		fmt.Fprintln(md.io.ErrOut, result.Summary())
+	}
+	for _, s := range result.Skipped {
+		fmt.Fprintf(md.io.ErrOut, "  Skipped stopped machine %s in '%s' running %s\n", s.ID, s.ProcessGroup, s.Image)
+	}
+	if md.rollsWithoutHealthChecks() {
+		fmt.Fprintf(md.io.ErrOut, "%s Machines were rolled out without health checks, make sure they are healthy with: %s\n",
+			md.colorize.Yellow("NOTE:"), md.colorize.Bold(fmt.Sprintf("fly checks list -a %s", md.app.Name)))
+	}
+	md.notifyWebhook(result, time.Since(started))
+	if err == nil && md.detach {
+		fmt.Fprintf(md.io.Out, "Release v%d was dispatched, machines may still be starting. Follow it with `fly deploy --watch %d -a %s`\n",
+			md.releaseVersion, md.releaseVersion, md.app.Name)
+	}
+	return result, err
+}
+
+// releaseStatusFor returns the final release status for the error a deployment finished with
+func releaseStatusFor(err error) string {
+	switch {
+	case err == nil:
+		return "complete"
+	case errors.Is(err, ErrDeployTimeout):
+		return "failed"
+	case errors.Is(err, ErrNewerRelease):
+		return "superseded"
+	case errors.Is(err, context.Canceled):
+		return "interrupted"
+	default:
+		return "failed"
+	}
 }
 
 // restartMachinesApp only restarts existing machines but updates their release metadata
 func (md *machineDeployment) restartMachinesApp(ctx context.Context) error {
-	if err := md.machineSet.AcquireLeases(ctx, md.leaseTimeout); err != nil {
+	if err := md.runReleaseCommand(ctx); err != nil {
+		return fmt.Errorf("release command failed - aborting restart. %w", err)
+	}
+
+	if err := md.acquireLeases(ctx); err != nil {
 		return err
 	}
 	defer md.machineSet.ReleaseLeases(ctx) // skipcq: GO-S2307
 	md.machineSet.StartBackgroundLeaseRefresh(ctx, md.leaseTimeout, md.leaseDelayBetween)
 
 	machineUpdateEntries := lo.Map(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) *machineUpdateEntry {
-		return &machineUpdateEntry{leasableMachine: lm, launchInput: md.launchInputForRestart(lm.Machine())}
+		return &machineUpdateEntry{leasableMachine: lm, launchInput: md.launchInputForRestart(lm.Machine()), stopSignal: md.restartSignal}
 	})
+	for _, e := range machineUpdateEntries {
+		fmt.Fprintf(md.io.ErrOut, "  Machine %s will restart with image %s\n",
+			md.colorize.Bold(e.leasableMachine.FormattedMachineId()), e.launchInput.Config.Image)
+	}
 
+	if md.maxConcurrent > 1 {
+		return md.updateMachinesInBatches(ctx, machineUpdateEntries, md.maxConcurrent)
+	}
 	return md.updateExistingMachines(ctx, machineUpdateEntries)
 }
 
@@ -86,42 +195,103 @@ func (md *machineDeployment) deployMachinesApp(ctx context.Context) error {
 		return fmt.Errorf("release command failed - aborting deployment. %w", err)
 	}
 
-	if err := md.machineSet.AcquireLeases(ctx, md.leaseTimeout); err != nil {
+	if err := md.acquireLeases(ctx); err != nil {
 		return err
 	}
 	defer md.machineSet.ReleaseLeases(ctx) // skipcq: GO-S2307
 	md.machineSet.StartBackgroundLeaseRefresh(ctx, md.leaseTimeout, md.leaseDelayBetween)
 
-	processGroupMachineDiff := md.resolveProcessGroupChanges()
+	if md.watchLogs {
+		logsCtx, cancelLogs := context.WithCancel(ctx)
+		defer cancelLogs()
+		md.startLogWatcher(logsCtx)
+	}
+
+	plan, err := md.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	processGroupMachineDiff := plan.processGroupsDiff
+	machineCountDiffs := plan.machineCountDiffs
 	md.warnAboutProcessGroupChanges(ctx, processGroupMachineDiff)
+	md.warnAboutMachineCountChanges(machineCountDiffs)
+	md.warnAboutRegionStragglers(plan)
 
 	if len(processGroupMachineDiff.machinesToRemove) > 0 {
 		// Destroy machines that don't fit the current process groups
-		if err := md.machineSet.RemoveMachines(ctx, processGroupMachineDiff.machinesToRemove); err != nil {
+		md.phasef(PhaseDestroyMachines, "")
+		removeIDs := lo.Map(processGroupMachineDiff.machinesToRemove, func(lm machine.LeasableMachine, _ int) string {
+			return lm.Machine().ID
+		})
+		toRemove, _ := md.machineSet.Partition(func(lm machine.LeasableMachine) bool {
+			return slices.Contains(removeIDs, lm.Machine().ID)
+		})
+		started := time.Now()
+		results, err := toRemove.DestroyMachines(ctx, md.destroyOptions())
+		for _, r := range results {
+			md.recordOutcome(r.Machine.Machine(), "destroyed", started, r.Err)
+			if r.Err == nil {
+				md.machinef(r.Machine.Machine().ID, MachineStateDestroyed, "  Machine %s was destroyed\n", md.colorize.Bold(r.Machine.FormattedMachineId()))
+			}
+		}
+		if err != nil {
 			return err
 		}
-		for _, mach := range processGroupMachineDiff.machinesToRemove {
-			if err := machcmd.Destroy(ctx, md.app, mach.Machine(), true); err != nil {
-				return err
-			}
+		if err := md.machineSet.RemoveMachines(ctx, processGroupMachineDiff.machinesToRemove); err != nil {
+			return err
 		}
 	}
 
 	// Create machines for new process groups
 	if total := len(processGroupMachineDiff.groupsNeedingMachines); total > 0 {
+		md.phasef(PhaseCreateMachines, "")
 		groupsWithAutostopEnabled := make(map[string]bool)
+		var createdMachines []*api.Machine
 
-		for idx, name := range maps.Keys(processGroupMachineDiff.groupsNeedingMachines) {
-			fmt.Fprintf(md.io.Out, "No machines in group %s, launching one new machine\n", md.colorize.Bold(name))
-			machineID, err := md.spawnMachineInGroup(ctx, name, idx, total, nil)
+		var failedRegions []string
+
+		groupNames := maps.Keys(processGroupMachineDiff.groupsNeedingMachines)
+		slices.Sort(groupNames)
+		for idx, name := range groupNames {
+			groupConfig, err := md.appConfig.Flatten(name)
 			if err != nil {
 				return err
 			}
 
-			groupConfig, err := md.appConfig.Flatten(name)
+			placements, hasCount, err := md.machineRegionsFor(name)
+			if err != nil {
+				return err
+			}
+
+			settings, err := md.appConfig.MachineSettingsFor(name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(md.io.Out, "No machines in group %s, launching a new machine\n", md.colorize.Bold(name))
+			newMachine, err := md.spawnMachineInGroup(ctx, name, placements[0], idx, total, nil)
 			if err != nil {
 				return err
 			}
+			createdMachines = append(createdMachines, newMachine)
+			activeMachines := []*api.Machine{newMachine}
+
+			// The first machine must succeed, the failure of any other is tolerated
+			// unless all regions are required
+			for _, region := range placements[1:] {
+				fmt.Fprintf(md.io.Out, "Launching a machine in group %s on region %s\n", md.colorize.Bold(name), region)
+				regionMachine, err := md.spawnMachineInGroup(ctx, name, region, idx, total, nil)
+				if err != nil {
+					if md.requireAllRegions {
+						return err
+					}
+					md.warnf("Failed to create a machine in group %s on region %s: %s\n", name, region, err)
+					failedRegions = append(failedRegions, fmt.Sprintf("%s:%s", name, region))
+					continue
+				}
+				createdMachines = append(createdMachines, regionMachine)
+				activeMachines = append(activeMachines, regionMachine)
+			}
 
 			services := groupConfig.AllServices()
 			for _, s := range services {
@@ -130,27 +300,45 @@ func (md *machineDeployment) deployMachinesApp(ctx context.Context) error {
 				}
 			}
 
-			// We strive to provide a HA setup according to:
-			// - Create only 1 machine if the group has mounts
-			// - Create 2 machines for groups with services
-			// - Create 1 always-on and 1 standby machine for groups without services
-			switch {
-			case len(groupConfig.Mounts) > 0:
+			// Groups asking for standbys get one for each of its machines
+			if wantsStandbys(groupConfig, settings) {
+				for _, active := range activeMachines {
+					standbyRegion := md.standbyRegionFor(settings, active.Region)
+					fmt.Fprintf(md.io.Out, "Creating a standby machine for %s on region %s\n", md.colorize.Bold(active.ID), standbyRegion)
+					standbyMachine, err := md.spawnMachineInGroup(ctx, name, standbyRegion, idx, total, []string{active.ID})
+					if err != nil {
+						return err
+					}
+					createdMachines = append(createdMachines, standbyMachine)
+				}
 				continue
-			case len(services) > 0:
+			}
+
+			switch md.haSpareFor(groupConfig, settings, hasCount, placements) {
+			case haSpareNoVolume:
+				fmt.Fprintf(md.io.Out, "Skipping the spare machine for %s because there are no more unattached volumes\n", md.colorize.Bold(name))
+			case haSpareMachine:
 				fmt.Fprintf(md.io.Out, "Creating a second machine to increase service availability\n")
-				if _, err := md.spawnMachineInGroup(ctx, name, idx, total, nil); err != nil {
+				spareMachine, err := md.spawnMachineInGroup(ctx, name, md.haSpareRegion(placements), idx, total, nil)
+				if err != nil {
 					return err
 				}
-			default:
-				fmt.Fprintf(md.io.Out, "Creating a standby machine for %s\n", md.colorize.Bold(machineID))
-				standbyFor := []string{machineID}
-				if _, err := md.spawnMachineInGroup(ctx, name, idx, total, standbyFor); err != nil {
+				createdMachines = append(createdMachines, spareMachine)
+			case haSpareStandby:
+				fmt.Fprintf(md.io.Out, "Creating a standby machine for %s\n", md.colorize.Bold(newMachine.ID))
+				standbyFor := []string{newMachine.ID}
+				standbyMachine, err := md.spawnMachineInGroup(ctx, name, md.haSpareRegion(placements), idx, total, standbyFor)
+				if err != nil {
 					return err
 				}
+				createdMachines = append(createdMachines, standbyMachine)
 			}
 		}
 		fmt.Fprintf(md.io.ErrOut, "Finished launching new machines\n")
+		md.logCreatedMachines(createdMachines)
+		if len(failedRegions) > 0 {
+			md.warnf("Could not create machines for [%s], use `fly machine clone` to add them later\n", strings.Join(failedRegions, ", "))
+		}
 
 		if len(groupsWithAutostopEnabled) > 0 {
 			groupNames := lo.Keys(groupsWithAutostopEnabled)
@@ -163,21 +351,330 @@ func (md *machineDeployment) deployMachinesApp(ctx context.Context) error {
 		}
 	}
 
-	var machineUpdateEntries []*machineUpdateEntry
-	for _, lm := range md.machineSet.GetMachines() {
-		li, err := md.launchInputForUpdate(lm.Machine())
-		if err != nil {
-			return fmt.Errorf("failed to update machine configuration for %s: %w", lm.FormattedMachineId(), err)
+	// Create the missing machines for groups below their configured count
+	if md.reconcileCounts {
+		groupNames := lo.Keys(machineCountDiffs)
+		slices.Sort(groupNames)
+		for idx, name := range groupNames {
+			for _, region := range machineCountDiffs[name].missingRegions {
+				fmt.Fprintf(md.io.Out, "Launching a machine in group %s on region %s to match its count\n", md.colorize.Bold(name), region)
+				if _, err := md.spawnMachineInGroup(ctx, name, region, idx, len(groupNames), nil); err != nil {
+					return err
+				}
+			}
 		}
-		machineUpdateEntries = append(machineUpdateEntries, &machineUpdateEntry{leasableMachine: lm, launchInput: li})
 	}
 
-	return md.updateExistingMachines(ctx, machineUpdateEntries)
+	// Volumes are taken for the machines created above before the updated machines get theirs,
+	// the machines removed above are no longer part of the machine set
+	updateEntries, err := md.updateEntriesFor(md.machineSet.GetMachines())
+	if err != nil {
+		return err
+	}
+	updateEntries, err = md.handlePrimaryRegionChange(ctx, updateEntries)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range updateEntries {
+		if len(e.keptEnv) > 0 {
+			md.infof("Keeping env %s set on machine %s outside of fly.toml\n", strings.Join(e.keptEnv, ", "), e.leasableMachine.Machine().ID)
+		}
+	}
+	if err := md.checkConfigDrift(ctx, updateEntries); err != nil {
+		return err
+	}
+	md.logConfigChanges(plan)
+	if md.strategy == "immediate" && (md.immediateConcurrency > 1 || md.immediateWait) {
+		return md.updateMachinesImmediately(ctx, updateEntries, lo.Max([]int{md.immediateConcurrency, 1}))
+	}
+	return md.updateExistingMachines(ctx, updateEntries)
+}
+
+// logConfigChanges shows, once per process group, the changes to settings that aren't
+// obvious from fly.toml like the autostop ones that affect how fast requests are served
+func (md *machineDeployment) logConfigChanges(plan *DeploymentPlan) {
+	seen := map[string]bool{}
+	for _, u := range plan.Update {
+		if seen[u.ProcessGroup] {
+			continue
+		}
+		seen[u.ProcessGroup] = true
+		for _, change := range u.Changes {
+			fmt.Fprintf(md.io.Out, "Process group %s: %s\n", md.colorize.Bold(u.ProcessGroup), change)
+		}
+	}
+}
+
+// autostopChanges lists the autostop, autostart and min_machines_running changes
+// between the services of two machine configs, matched by internal port
+func autostopChanges(from, to *api.MachineConfig) (changes []string) {
+	if from == nil || to == nil {
+		return nil
+	}
+	format := func(v any) string {
+		switch cast := v.(type) {
+		case *bool:
+			if cast != nil {
+				return fmt.Sprint(*cast)
+			}
+		case *int:
+			if cast != nil {
+				return fmt.Sprint(*cast)
+			}
+		}
+		return "unset"
+	}
+	for _, toSvc := range to.Services {
+		fromSvc, ok := lo.Find(from.Services, func(s api.MachineService) bool {
+			return s.InternalPort == toSvc.InternalPort
+		})
+		if !ok {
+			continue
+		}
+		fields := []struct {
+			name     string
+			old, new any
+		}{
+			{"auto_stop_machines", fromSvc.Autostop, toSvc.Autostop},
+			{"auto_start_machines", fromSvc.Autostart, toSvc.Autostart},
+			{"min_machines_running", fromSvc.MinMachinesRunning, toSvc.MinMachinesRunning},
+		}
+		for _, f := range fields {
+			if o, n := format(f.old), format(f.new); o != n {
+				changes = append(changes, fmt.Sprintf("service on port %d changes %s from %s to %s", toSvc.InternalPort, f.name, o, n))
+			}
+		}
+	}
+	return changes
+}
+
+// concurrencyChanges lists the concurrency changes between the services of two machine configs, matched by internal port
+func concurrencyChanges(from, to *api.MachineConfig) (changes []string) {
+	if from == nil || to == nil {
+		return nil
+	}
+	format := func(c *api.MachineServiceConcurrency) string {
+		if c == nil {
+			return "unset"
+		}
+		return fmt.Sprintf("type=%s soft_limit=%d hard_limit=%d", c.Type, c.SoftLimit, c.HardLimit)
+	}
+	for _, toSvc := range to.Services {
+		fromSvc, ok := lo.Find(from.Services, func(s api.MachineService) bool {
+			return s.InternalPort == toSvc.InternalPort
+		})
+		if !ok {
+			continue
+		}
+		if o, n := format(fromSvc.Concurrency), format(toSvc.Concurrency); o != n {
+			changes = append(changes, fmt.Sprintf("service on port %d changes concurrency from %s to %s", toSvc.InternalPort, o, n))
+		}
+	}
+	return changes
+}
+
+// metricsChange describes how the metrics scraping config changes between two machine configs
+func metricsChange(from, to *api.MachineConfig) string {
+	if from == nil || to == nil {
+		return ""
+	}
+	format := func(m *api.MachineMetrics) string {
+		if m == nil {
+			return "unset"
+		}
+		return fmt.Sprintf("port %d path %s", m.Port, m.Path)
+	}
+	if o, n := format(from.Metrics), format(to.Metrics); o != n {
+		return fmt.Sprintf("metrics change from %s to %s", o, n)
+	}
+	return ""
+}
+
+// waitsForMachines is false when machines are updated without waiting for them to start
+func (md *machineDeployment) waitsForMachines() bool {
+	return md.strategy != "immediate" && !md.detach
+}
+
+// checkNewerRelease stops the deployment when a newer release exists, so machines another deployment
+// already updated don't get the older image back
+func (md *machineDeployment) checkNewerRelease(ctx context.Context) error {
+	if md.ignoreNewerRelease || md.releaseVersion == 0 {
+		return nil
+	}
+	releases, err := md.apiClient.GetAppReleasesMachines(ctx, md.app.Name, 5)
+	if err != nil {
+		terminal.Debugf("failed to check for newer releases: %v\n", err)
+		return nil
+	}
+	return newerReleaseError(releases, md.releaseVersion)
+}
+
+// newerReleaseError returns an ErrNewerRelease when a release newer than version exists
+func newerReleaseError(releases []api.Release, version int) error {
+	latest, err := findRelease(releases, 0)
+	if err != nil || latest.Version <= version {
+		return nil
+	}
+	return fmt.Errorf("%w: v%d was created while deploying v%d, use --ignore-newer-release to keep deploying anyway",
+		ErrNewerRelease, latest.Version, version)
+}
+
+// continuesOnError is true when a failed machine update doesn't stop the others
+func (md *machineDeployment) continuesOnError() bool {
+	return md.continueOnError || md.strategy == "immediate"
+}
+
+type machineUpdateFailure struct {
+	machineID string
+	err       error
+}
+
+// updateFailuresError lists the machines that failed to update grouped by the kind of error,
+// and returns the error failing the deployment
+func (md *machineDeployment) updateFailuresError(failures []machineUpdateFailure, total int) error {
+	byCategory := lo.GroupBy(failures, func(f machineUpdateFailure) machineErrorCategory {
+		return machineErrorCategoryOf(f.err)
+	})
+	categories := maps.Keys(byCategory)
+	slices.Sort(categories)
+	var rows [][]string
+	for _, category := range categories {
+		for _, f := range byCategory[category] {
+			rows = append(rows, []string{string(category), f.machineID, f.err.Error()})
+		}
+	}
+	if err := render.Table(md.io.ErrOut, "Failed machine updates", rows, "Category", "Machine", "Error"); err != nil {
+		return err
+	}
+	summary := lo.Map(categories, func(c machineErrorCategory, _ int) string {
+		return fmt.Sprintf("%d %s", len(byCategory[c]), c)
+	})
+	return fmt.Errorf("%d of %d machines failed to update (%s)", len(failures), total, strings.Join(summary, ", "))
 }
 
 type machineUpdateEntry struct {
 	leasableMachine machine.LeasableMachine
 	launchInput     *api.LaunchMachineInput
+	// stopSignal stops a started machine with this signal before updating it
+	stopSignal string
+	// keptEnv are the env keys set on the machine outside of fly.toml that --keep-machine-env keeps
+	keptEnv []string
+	// batch waits for the machine with the others of its batch, it waits on its own without one
+	batch *batchMember
+}
+
+// canReplaceOnFailure is false for machines a replacement can't stand in for:
+// machines with volumes, which are bound to their host, and standbys
+func canReplaceOnFailure(e *machineUpdateEntry) bool {
+	return len(e.launchInput.Config.Mounts) == 0 && len(e.launchInput.Config.Standbys) == 0
+}
+
+// failureReplacements are the machines replaced after failing to update, it is safe for concurrent use
+type failureReplacements struct {
+	mu sync.Mutex
+	// ids maps the IDs of the failed machines to the ones of their replacements
+	ids   map[string]string
+	order []string
+}
+
+func (r *failureReplacements) add(oldID, newID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ids == nil {
+		r.ids = map[string]string{}
+	}
+	r.ids[oldID] = newID
+	r.order = append(r.order, oldID)
+}
+
+// replacedIDs returns the IDs of the replacements keyed by the IDs of the machines they replaced
+func (r *failureReplacements) replacedIDs() map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := map[string]string{}
+	maps.Copy(ids, r.ids)
+	return ids
+}
+
+func (md *machineDeployment) showFailureReplacements(r *failureReplacements) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.order) == 0 {
+		return
+	}
+	rows := lo.Map(r.order, func(id string, _ int) []string { return []string{id, r.ids[id]} })
+	_ = render.Table(md.io.ErrOut, "Machines replaced after failing to update", rows, "Failed Machine", "Replaced By")
+}
+
+// updateOrReplaceMachine updates the machine like updateMachine, and with --replace-on-failure replaces it
+// with a new machine when the update fails. Replacements are recorded in replacedIDs when set and in replaced
+func (md *machineDeployment) updateOrReplaceMachine(ctx context.Context, e *machineUpdateEntry, indexStr string, replacedIDs map[string]string, replaced *failureReplacements) error {
+	err := md.updateMachine(ctx, e, indexStr, replacedIDs)
+	if err == nil || ctx.Err() != nil || !md.replaceOnFailure || !canReplaceOnFailure(e) {
+		return err
+	}
+	oldID := e.leasableMachine.Machine().ID
+	md.progressf("  %s Machine %s failed to update, replacing it: %s\n", indexStr, md.colorize.Bold(oldID), err)
+	newID, err := md.replaceFailedMachine(ctx, e, indexStr)
+	if err != nil {
+		return err
+	}
+	if replacedIDs != nil {
+		replacedIDs[oldID] = newID
+	}
+	replaced.add(oldID, newID)
+	return nil
+}
+
+// replaceFailedMachine creates a machine with the config the failed one was updated to, in the same region,
+// waits for it and destroys the failed one. It returns the ID of the new machine
+func (md *machineDeployment) replaceFailedMachine(ctx context.Context, e *machineUpdateEntry, indexStr string) (_ string, err error) {
+	lm := e.leasableMachine
+	launchInput := *e.launchInput
+	launchInput.ID = ""
+	launchInput.Name = md.replacementNameFor(lm.Machine().Name)
+	launchInput.Region = lm.Machine().Region
+
+	started := time.Now()
+	newMachineRaw, err := md.flapsClient.Launch(ctx, launchInput)
+	if err != nil {
+		return "", fmt.Errorf("failed to replace machine %s: %w", lm.Machine().ID, err)
+	}
+	md.machines.put(newMachineRaw)
+	defer func() { md.recordOutcome(newMachineRaw, "replaced", started, err) }()
+	newLm := machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
+	md.progressf("  %s Created machine %s to replace %s\n", indexStr, md.colorize.Bold(newLm.FormattedMachineId()), lm.Machine().ID)
+
+	if md.waitsForMachines() && launchInput.Config.Schedule == "" {
+		waitTimeout := md.waitTimeoutFor(newMachineRaw.ProcessGroup())
+		if err := newLm.WaitForState(ctx, api.MachineStateStarted, waitTimeout, indexStr); err != nil {
+			return "", err
+		}
+		if !md.skipHealthChecks {
+			if err := newLm.WaitForHealthchecksToPass(ctx, waitTimeout, indexStr); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	failed, _ := md.machineSet.Partition(func(m machine.LeasableMachine) bool { return m.Machine().ID == lm.Machine().ID })
+	if _, err := failed.DestroyMachines(ctx, md.destroyOptions()); err != nil {
+		return "", fmt.Errorf("machine %s replaced %s but destroying it failed: %w", newMachineRaw.ID, lm.Machine().ID, err)
+	}
+	return newMachineRaw.ID, nil
+}
+
+// destroyOptions gives started machines the wait timeout to stop before destroying them, then runs
+// the post-deletion hook of fly machine destroy that unregisters Postgres members
+func (md *machineDeployment) destroyOptions() machine.DestroyOptions {
+	return machine.DestroyOptions{
+		StopTimeout: md.waitTimeout,
+		OnDestroy: func(ctx context.Context, m *api.Machine) {
+			md.machines.destroyed(m.ID)
+			machcmd.RunOnDeletionHook(ctx, md.app, m)
+		},
+	}
 }
 
 func formatIndex(n, total int) string {
@@ -190,130 +687,446 @@ func formatIndex(n, total int) string {
 
 func (md *machineDeployment) updateExistingMachines(ctx context.Context, updateEntries []*machineUpdateEntry) error {
 	// FIXME: handle deploy strategy: rolling, immediate, canary, bluegreen
-	fmt.Fprintf(md.io.Out, "Updating existing machines in '%s' with %s strategy\n", md.colorize.Bold(md.app.Name), md.strategy)
+	md.phasef(PhaseUpdateMachines, "Updating existing machines in '%s' with %s strategy\n", md.colorize.Bold(md.app.Name), md.strategy)
+	// Machines replaced during the update, standbys pointing to them are updated with the new ID
+	replacedIDs := map[string]string{}
+	var failures []machineUpdateFailure
+	replaced := &failureReplacements{}
+	defer md.showFailureReplacements(replaced)
 	for i, e := range updateEntries {
-		lm := e.leasableMachine
-		launchInput := e.launchInput
+		// Don't start updating more machines once the deployment is canceled or timed out
+		if err := ctx.Err(); err != nil {
+			return interruptedUpdateError(err, updateEntries, i)
+		}
+		if err := md.checkNewerRelease(ctx); err != nil {
+			return err
+		}
 		indexStr := formatIndex(i, len(updateEntries))
-
-		if launchInput.ID != lm.Machine().ID {
-			// If IDs don't match, destroy the original machine and launch a new one
-			// This can be the case for machines that changes its volumes or any other immutable config
-			fmt.Fprintf(md.io.ErrOut, "  %s Replacing %s by new machine\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
-			if err := lm.Destroy(ctx, true); err != nil {
-				if md.strategy != "immediate" {
-					return err
-				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+		err := md.updateOrReplaceMachine(ctx, e, indexStr, replacedIDs, replaced)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return interruptedUpdateError(ctxErr, updateEntries, i)
+			}
+			if !md.continuesOnError() {
+				return err
 			}
+			md.progressf("  %s Continuing after error: %s\n", indexStr, err)
+			failures = append(failures, machineUpdateFailure{machineID: e.leasableMachine.Machine().ID, err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return md.updateFailuresError(failures, len(updateEntries))
+	}
 
-			newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
-			if err != nil {
-				if md.strategy != "immediate" {
+	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	return nil
+}
+
+// updateMachinesInBatches updates up to batchSize machines at once, then waits for the machines of the batch together
+// before the next one.
+// Machines being replaced and standbys are updated one by one after the batches, standbys need the IDs of the
+// machines replaced before them. Batches shrink when the machines API rate limits the deployment
+func (md *machineDeployment) updateMachinesInBatches(ctx context.Context, updateEntries []*machineUpdateEntry, batchSize int) error {
+	md.phasef(PhaseUpdateMachines, "Updating existing machines in '%s' in batches of %d\n", md.colorize.Bold(md.app.Name), batchSize)
+	concurrent := lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return keepsMachineID(e) })
+	sequential := lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return !keepsMachineID(e) })
+	// Keep the order the entries are updated in, interrupted deployments report the ones after the last batch
+	updateEntries = append(concurrent, sequential...)
+
+	rateLimited := md.flapsClient.RateLimitedCount()
+	var (
+		failures   []machineUpdateFailure
+		failuresMu sync.Mutex
+	)
+	replaced := &failureReplacements{}
+	defer md.showFailureReplacements(replaced)
+	md.concurrentUpdates = batchSize > 1
+	defer func() { md.concurrentUpdates = false }()
+	for start := 0; start < len(concurrent); {
+		if err := ctx.Err(); err != nil {
+			return interruptedUpdateError(err, updateEntries, start)
+		}
+		if err := md.checkNewerRelease(ctx); err != nil {
+			return err
+		}
+		batch := concurrent[start:lo.Min([]int{start + batchSize, len(concurrent)})]
+		eg, egCtx := errgroup.WithContext(ctx)
+		// The first failure stops the waits of the batch unless the failed machines are replaced or skipped
+		waits := newBatchWait(egCtx, md.waitOptions(len(batch), !md.continuesOnError() && !md.replaceOnFailure), len(batch))
+		for i, e := range batch {
+			e := e
+			e.batch = waits.member()
+			indexStr := formatIndex(start+i, len(updateEntries))
+			eg.Go(func() error {
+				defer e.batch.leave()
+				err := md.updateOrReplaceMachine(egCtx, e, indexStr, nil, replaced)
+				if err == nil || !md.continuesOnError() || egCtx.Err() != nil {
 					return err
 				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
-				continue
+				md.progressf("  %s Continuing after error: %s\n", indexStr, err)
+				failuresMu.Lock()
+				defer failuresMu.Unlock()
+				failures = append(failures, machineUpdateFailure{machineID: e.leasableMachine.Machine().ID, err: err})
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return interruptedUpdateError(ctxErr, updateEntries, start)
 			}
+			return err
+		}
+		start += len(batch)
 
-			lm = machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
-			fmt.Fprintf(md.io.ErrOut, "  %s Created machine %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+		if n := md.flapsClient.RateLimitedCount(); n > rateLimited && batchSize > 1 {
+			rateLimited = n
+			batchSize = (batchSize + 1) / 2
+			md.infof("Machines API is rate limiting the deployment, continuing in batches of %d\n", batchSize)
+		}
+	}
 
-		} else {
-			fmt.Fprintf(md.io.ErrOut, "  %s Updating %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
-			if err := lm.Update(ctx, *launchInput); err != nil {
-				if md.strategy != "immediate" {
-					return err
-				}
-				fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+	// Standbys of the machines replaced in the batches point to their replacements
+	md.concurrentUpdates = false
+	replacedIDs := replaced.replacedIDs()
+	for i, e := range sequential {
+		if err := ctx.Err(); err != nil {
+			return interruptedUpdateError(err, updateEntries, len(concurrent)+i)
+		}
+		indexStr := formatIndex(len(concurrent)+i, len(updateEntries))
+		if err := md.updateOrReplaceMachine(ctx, e, indexStr, replacedIDs, replaced); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return interruptedUpdateError(ctxErr, updateEntries, len(concurrent)+i)
 			}
+			if !md.continuesOnError() {
+				return err
+			}
+			md.progressf("  %s Continuing after error: %s\n", indexStr, err)
+			failures = append(failures, machineUpdateFailure{machineID: e.leasableMachine.Machine().ID, err: err})
 		}
+	}
+	if len(failures) > 0 {
+		return md.updateFailuresError(failures, len(updateEntries))
+	}
 
-		// Don't wait for Standby machines, they are updated but not started
-		if len(launchInput.Config.Standbys) > 0 {
-			md.logClearLinesAbove(1)
-			fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
-				indexStr,
-				md.colorize.Bold(lm.FormattedMachineId()),
-				md.colorize.Green("success"),
-			)
-			continue
+	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	return nil
+}
+
+// keepsMachineID tells if the entry updates its machine in place without pointing to other machines, those
+// updates don't depend on each other and can run concurrently
+func keepsMachineID(e *machineUpdateEntry) bool {
+	return e.launchInput.ID == e.leasableMachine.Machine().ID && len(e.launchInput.Config.Standbys) == 0
+}
+
+// interruptedUpdateError wraps the context error of a stopped deployment with which machines were updated.
+// The first n entries finished updating, the rest weren't updated or their update was aborted
+func interruptedUpdateError(err error, updateEntries []*machineUpdateEntry, n int) error {
+	notUpdated := lo.Map(updateEntries[n:], func(e *machineUpdateEntry, _ int) string {
+		return e.leasableMachine.Machine().ID
+	})
+	return fmt.Errorf("deployment stopped after updating %d of %d machines, not updated: %s: %w",
+		n, len(updateEntries), strings.Join(notUpdated, ", "), err)
+}
+
+// updateMachine updates or replaces a single machine, then waits for it to be healthy
+func (md *machineDeployment) updateMachine(ctx context.Context, e *machineUpdateEntry, indexStr string, replacedIDs map[string]string) (err error) {
+	lm := e.leasableMachine
+	started := time.Now()
+	ctx, span := tracing.Start(ctx, "update machine", md.machineSpanAttributes(lm.Machine())...)
+	defer func() { span.End(err) }()
+	leftStopped := false
+	defer func() {
+		action := lo.Ternary(e.launchInput.ID != e.leasableMachine.Machine().ID, "replaced", "updated")
+		if leftStopped {
+			action = actionLeftStopped
+		}
+		md.recordOutcome(lm.Machine(), action, started, err)
+		if err != nil {
+			md.machinef(lm.Machine().ID, MachineStateFailed, "")
 		}
+	}()
+	if md.hooks.AfterMachineUpdate != nil {
+		// lm is the new machine when the machine is replaced
+		defer func() {
+			err = md.hooks.AfterMachineUpdate(ctx, lm.Machine(), err)
+		}()
+	}
+	launchInput := e.launchInput
+	waitTimeout := md.waitTimeoutFor(lm.Machine().ProcessGroup())
 
-		if md.strategy == "immediate" {
-			continue
+	isStandby := len(launchInput.Config.Standbys) > 0
+	if isStandby {
+		launchInput.Config.Standbys = lo.Map(launchInput.Config.Standbys, func(id string, _ int) string {
+			return lo.Ternary(replacedIDs[id] != "", replacedIDs[id], id)
+		})
+	}
+	kind := lo.Ternary(isStandby, "standby ", "")
+
+	// Stopped machines are updated without starting them, the proxy boots them with the new image on demand
+	leaveStopped := md.leavesStopped(lm.Machine(), launchInput)
+	if leaveStopped {
+		launchInput.SkipLaunch = true
+	}
+
+	if launchInput.ID != lm.Machine().ID {
+		// If IDs don't match, destroy the original machine and launch a new one
+		// This can be the case for machines that changes its volumes or any other immutable config
+		md.machinef(lm.Machine().ID, MachineStateReplacing, "  %s Replacing %s%s by new machine\n", indexStr, kind, md.colorize.Bold(lm.FormattedMachineId()))
+		if err := lm.Destroy(ctx, true); err != nil {
+			md.machines.invalidate(lm.Machine().ID)
+			if md.strategy != "immediate" {
+				return err
+			}
+			fmt.Fprintf(md.io.ErrOut, "Continuing after error: %s\n", err)
+		} else {
+			md.machines.destroyed(lm.Machine().ID)
 		}
 
-		if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
-			return err
+		newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
+		if err != nil {
+			return md.wrapMachineError(ctx, err, launchInput.Region)
 		}
+		md.machines.put(newMachineRaw)
 
-		if !md.skipHealthChecks {
-			if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
+		replacedIDs[lm.Machine().ID] = newMachineRaw.ID
+		span.SetAttributes(tracing.String("machine.replaced_by", newMachineRaw.ID))
+		lm = machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
+		md.machinef(lm.Machine().ID, MachineStateCreated, "  %s Created %smachine %s\n", indexStr, kind, md.colorize.Bold(lm.FormattedMachineId()))
+
+	} else {
+		if e.stopSignal != "" && lm.Machine().State == api.MachineStateStarted {
+			md.machinef(lm.Machine().ID, MachineStateStopping, "  %s Stopping %s%s with %s\n", indexStr, kind, md.colorize.Bold(lm.FormattedMachineId()), e.stopSignal)
+			if err := lm.Stop(ctx, e.stopSignal); err != nil {
 				return err
 			}
-			// FIXME: combine this wait with the wait for start as one update line (or two per in noninteractive case)
-			md.logClearLinesAbove(1)
-			fmt.Fprintf(md.io.ErrOut, "  %s Machine %s update finished: %s\n",
-				indexStr,
-				md.colorize.Bold(lm.FormattedMachineId()),
-				md.colorize.Green("success"),
-			)
+			if err := lm.WaitForState(ctx, api.MachineStateStopped, waitTimeout, indexStr); err != nil {
+				return err
+			}
+		}
+		md.machinef(lm.Machine().ID, MachineStateUpdating, "  %s Updating %s%s\n", indexStr, kind, md.colorize.Bold(lm.FormattedMachineId()))
+		if err := lm.Update(ctx, *launchInput); err != nil {
+			md.machines.invalidate(lm.Machine().ID)
+			return md.wrapMachineError(ctx, err, lm.Machine().Region)
 		}
+		md.machines.put(lm.Machine())
 	}
+	finishLogs := md.followLogs(lm.Machine().ID)
+	defer func() { err = finishLogs(err) }()
 
-	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	// Don't wait for Standby machines, they are updated but not started
+	if isStandby {
+		md.logClearLinesAbove(1)
+		md.machinef(lm.Machine().ID, MachineStateUpdated, "  %s Standby machine %s update finished: %s\n",
+			indexStr,
+			md.colorize.Bold(lm.FormattedMachineId()),
+			md.colorize.Green("success"),
+		)
+		return nil
+	}
+
+	if leaveStopped {
+		leftStopped = true
+		md.logClearLinesAbove(1)
+		md.machinef(lm.Machine().ID, MachineStateLeftStopped, "  %s Machine %s updated (left stopped)\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()))
+		return nil
+	}
+
+	// Scheduled machines are stopped between runs and don't serve traffic, don't wait for them either
+	if launchInput.Config.Schedule != "" {
+		md.machinef(lm.Machine().ID, MachineStateScheduled, "  %s Machine %s scheduled to run %s\n", indexStr, md.colorize.Bold(lm.FormattedMachineId()), launchInput.Config.Schedule)
+		return nil
+	}
+
+	if !md.waitsForMachines() {
+		if md.deferredWaits != nil {
+			md.deferredWaits.add(lm, indexStr)
+		}
+		return nil
+	}
+
+	_, waitSpan := tracing.Start(ctx, "wait for machine", md.machineSpanAttributes(lm.Machine())...)
+	if err := md.waitForUpdatedMachine(ctx, e, lm, indexStr); err != nil {
+		waitSpan.End(err)
+		return err
+	}
+
+	if !md.skipHealthChecks {
+		// FIXME: combine this wait with the wait for start as one update line (or two per in noninteractive case)
+		md.logClearLinesAbove(1)
+		md.machinef(lm.Machine().ID, MachineStateUpdated, "  %s Machine %s update finished: %s\n",
+			indexStr,
+			md.colorize.Bold(lm.FormattedMachineId()),
+			md.colorize.Green("success"),
+		)
+	}
+	waitSpan.End(nil)
 	return nil
 }
 
-func (md *machineDeployment) spawnMachineInGroup(ctx context.Context, groupName string, i, total int, standbyFor []string) (string, error) {
-	launchInput, err := md.launchInputForLaunch(groupName, md.machineGuest, standbyFor)
+// leavesStopped tells if the stopped machine m is updated with launchInput without being started.
+// Standbys and scheduled machines are stopped on purpose and handled apart
+func (md *machineDeployment) leavesStopped(m *api.Machine, launchInput *api.LaunchMachineInput) bool {
+	if md.restartOnly || md.startStopped || m.State != api.MachineStateStopped {
+		return false
+	}
+	return len(launchInput.Config.Standbys) == 0 && launchInput.Config.Schedule == ""
+}
+
+func (md *machineDeployment) spawnMachineInGroup(ctx context.Context, groupName, region string, i, total int, standbyFor []string) (_ *api.Machine, err error) {
+	md.volumesMu.Lock()
+	md.refreshVolumesIfStale(ctx)
+	launchInput, err := md.launchInputForLaunch(groupName, region, md.machineGuest, standbyFor)
+	md.volumesMu.Unlock()
 	if err != nil {
-		return "", fmt.Errorf("error creating machine configuration: %w", err)
+		return nil, fmt.Errorf("error creating machine configuration: %w", err)
 	}
 
+	started := time.Now()
 	newMachineRaw, err := md.flapsClient.Launch(ctx, *launchInput)
 	if err != nil {
 		relCmdWarning := ""
 		if strings.Contains(err.Error(), "please add a payment method") && !md.releaseCommandMachine.IsEmpty() {
 			relCmdWarning = "\nPlease note that release commands run in their own ephemeral machine, and therefore count towards the machine limit."
 		}
-		return "", fmt.Errorf("error creating a new machine: %w%s", err, relCmdWarning)
+		return nil, fmt.Errorf("error creating a new machine: %w%s", md.wrapMachineError(ctx, err, region), relCmdWarning)
 	}
+	md.machines.put(newMachineRaw)
+	defer func() { md.recordOutcome(newMachineRaw, "created", started, err) }()
+	finishLogs := md.followLogs(newMachineRaw.ID)
+	defer func() { err = finishLogs(err) }()
 
 	lm := machine.NewLeasableMachine(md.flapsClient, md.io, newMachineRaw)
-	fmt.Fprintf(md.io.ErrOut, "  Machine %s was created\n", md.colorize.Bold(lm.FormattedMachineId()))
 
 	// Don't wait for Standby machines, they are created but not started
 	if len(launchInput.Config.Standbys) > 0 {
-		return newMachineRaw.ID, nil
+		md.machinef(newMachineRaw.ID, MachineStateCreated, "  Standby machine %s was created in region %s\n", md.colorize.Bold(lm.FormattedMachineId()), newMachineRaw.Region)
+		return newMachineRaw, nil
+	}
+	md.machinef(newMachineRaw.ID, MachineStateCreated, "  Machine %s was created in region %s\n", md.colorize.Bold(lm.FormattedMachineId()), newMachineRaw.Region)
+
+	// Scheduled machines are stopped between runs, don't wait for them to start
+	if launchInput.Config.Schedule != "" {
+		md.machinef(newMachineRaw.ID, MachineStateScheduled, "  Machine %s is scheduled to run %s\n", md.colorize.Bold(lm.FormattedMachineId()), launchInput.Config.Schedule)
+		return newMachineRaw, nil
 	}
 
-	// Roll up as fast as possible when using immediate strategy
-	if md.strategy == "immediate" {
-		return newMachineRaw.ID, nil
+	// Roll up as fast as possible when using immediate strategy or detached
+	if !md.waitsForMachines() {
+		return newMachineRaw, nil
 	}
 
 	// Otherwise wait for the machine to start
 	indexStr := formatIndex(i, total)
-	if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeout, indexStr); err != nil {
-		return "", err
+	if err := lm.WaitForState(ctx, api.MachineStateStarted, md.waitTimeoutFor(groupName), indexStr); err != nil {
+		return nil, err
 	}
 
 	// And wait (or not) for successful health checks
 	if !md.skipHealthChecks {
-		if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeout, indexStr); err != nil {
-			return "", err
+		if err := lm.WaitForHealthchecksToPass(ctx, md.waitTimeoutFor(groupName), indexStr); err != nil {
+			return nil, err
 		}
 
 		md.logClearLinesAbove(1)
-		fmt.Fprintf(md.io.ErrOut, "  Machine %s update finished: %s\n",
+		md.machinef(newMachineRaw.ID, MachineStateUpdated, "  Machine %s update finished: %s\n",
 			md.colorize.Bold(lm.FormattedMachineId()),
 			md.colorize.Green("success"),
 		)
 	}
 
-	return newMachineRaw.ID, nil
+	return newMachineRaw, nil
+}
+
+// haSpare is what --ha adds to a new process group besides the machines of its placements
+type haSpare int
+
+const (
+	haSpareNone haSpare = iota
+	// haSpareMachine is a second machine in the spare region
+	haSpareMachine
+	// haSpareStandby is a standby of the first machine in the spare region
+	haSpareStandby
+	// haSpareNoVolume is a second machine skipped because no unattached volume is left for it
+	haSpareNoVolume
+)
+
+// haSpareFor picks the spare of a new group placed in placements. We strive to provide a HA setup according to:
+//   - Create a second machine for groups with mounts only if there is a spare volume for it
+//   - Create 2 machines for groups with services
+//   - Create 1 always-on and 1 standby machine for groups without services
+func (md *machineDeployment) haSpareFor(groupConfig *appconfig.Config, settings *appconfig.MachineSettings, hasCount bool, placements []string) haSpare {
+	// Spreading machines over multiple regions or an explicit count already provides redundancy
+	if !md.increasedAvailability || hasCount || len(placements) > 1 || wantsStandbys(groupConfig, settings) {
+		return haSpareNone
+	}
+	switch {
+	case len(groupConfig.Mounts) > 0:
+		if !md.hasUnattachedVolumesFor(groupConfig, lo.Ternary(md.volumesInAnyRegion() && md.spareRegion == "", "", md.haSpareRegion(placements))) {
+			return haSpareNoVolume
+		}
+		return haSpareMachine
+	case len(groupConfig.AllServices()) > 0:
+		return haSpareMachine
+	case settings.Standby != nil:
+		// Standby machines were explicitly disabled for this group
+		return haSpareNone
+	default:
+		return haSpareStandby
+	}
+}
+
+// haSpareRegion is the region of the spare machine added by --ha next to the first machine of a group,
+// it goes to the same region as that machine unless --ha-region says otherwise
+func (md *machineDeployment) haSpareRegion(placements []string) string {
+	if md.spareRegion != "" || len(placements) == 0 {
+		return md.spareRegion
+	}
+	return placements[0]
+}
+
+// wantsStandbys tells if a group without services or mounts asks for a standby of each of its machines
+func wantsStandbys(groupConfig *appconfig.Config, settings *appconfig.MachineSettings) bool {
+	return len(groupConfig.AllServices()) == 0 && len(groupConfig.Mounts) == 0 && settings.Standby != nil && *settings.Standby
+}
+
+// standbyRegionFor picks the region for a standby of a machine running on activeRegion,
+// preferring a region other than activeRegion when more than one is configured
+func (md *machineDeployment) standbyRegionFor(settings *appconfig.MachineSettings, activeRegion string) string {
+	if settings.StandbyRegion != "" {
+		return settings.StandbyRegion
+	}
+	for _, region := range md.deployRegions() {
+		if region != "" && region != activeRegion {
+			return region
+		}
+	}
+	return lo.Ternary(md.spareRegion != "", md.spareRegion, activeRegion)
+}
+
+// hasUnattachedVolumesFor reports if there are unattached volumes left in region for every mount in groupConfig
+func (md *machineDeployment) hasUnattachedVolumesFor(groupConfig *appconfig.Config, region string) bool {
+	for _, m := range groupConfig.Mounts {
+		if !lo.ContainsBy(md.volumes[m.Source], func(v api.Volume) bool {
+			return region == "" || v.Region == region
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (md *machineDeployment) logCreatedMachines(machines []*api.Machine) {
+	if len(machines) == 0 {
+		return
+	}
+	fmt.Fprintf(md.io.Out, "Created %d machine%s:\n", len(machines), lo.Ternary(len(machines) == 1, "", "s"))
+	for _, m := range machines {
+		kind := ""
+		if m.Config != nil && len(m.Config.Standbys) > 0 {
+			kind = " (standby)"
+		}
+		fmt.Fprintf(md.io.Out, "  %s [%s] in region %s%s\n", md.colorize.Bold(m.ID), m.ProcessGroup(), m.Region, kind)
+	}
 }
 
 func (md *machineDeployment) resolveProcessGroupChanges() ProcessGroupsDiff {
@@ -325,18 +1138,23 @@ func (md *machineDeployment) resolveProcessGroupChanges() ProcessGroupsDiff {
 	groupsInConfig := md.appConfig.ProcessNames()
 	groupHasMachine := map[string]bool{}
 
-	for _, leasableMachine := range md.machineSet.GetMachines() {
-		name := leasableMachine.Machine().ProcessGroup()
-		if slices.Contains(groupsInConfig, name) {
-			groupHasMachine[name] = true
-		} else {
-			output.groupsToRemove[name] += 1
-			output.machinesToRemove = append(output.machinesToRemove, leasableMachine)
-		}
+	inConfig, removed := md.machineSet.Partition(func(lm machine.LeasableMachine) bool {
+		return slices.Contains(groupsInConfig, lm.Machine().ProcessGroup())
+	})
+	for _, leasableMachine := range inConfig.GetMachines() {
+		groupHasMachine[leasableMachine.Machine().ProcessGroup()] = true
+	}
+	// Groups only having skipped machines still have machines, don't create new ones
+	for _, m := range md.skippedMachines {
+		groupHasMachine[m.ProcessGroup()] = true
+	}
+	for _, leasableMachine := range removed.GetMachines() {
+		output.groupsToRemove[leasableMachine.Machine().ProcessGroup()] += 1
+		output.machinesToRemove = append(output.machinesToRemove, leasableMachine)
 	}
 
 	for _, name := range groupsInConfig {
-		if ok := groupHasMachine[name]; !ok {
+		if ok := groupHasMachine[name]; !ok && !md.skipsProvisioning(name) {
 			output.groupsNeedingMachines[name] = true
 		}
 	}
@@ -364,8 +1182,113 @@ func (md *machineDeployment) warnAboutProcessGroupChanges(ctx context.Context, d
 	if willAddMachines {
 		bullet := md.colorize.Green("*")
 		for name := range diff.groupsNeedingMachines {
-			fmt.Fprintf(md.io.Out, " %s create 1 \"%s\" machine\n", bullet, name)
+			numMach := 1
+			if placements, hasCount, err := md.machineRegionsFor(name); err == nil && hasCount {
+				numMach = len(placements)
+			}
+			pluralS := lo.Ternary(numMach == 1, "", "s")
+			fmt.Fprintf(md.io.Out, " %s create %d \"%s\" machine%s\n", bullet, numMach, name, pluralS)
 		}
 	}
 	fmt.Fprint(md.io.Out, "\n")
 }
+
+type machineCountDiff struct {
+	current        int
+	desired        int
+	missingRegions []string
+}
+
+// resolveMachineCountChanges compares the number of machines of each existing process group
+// with the count set for it in fly.toml. Groups without count or machines are skipped.
+func (md *machineDeployment) resolveMachineCountChanges() (map[string]*machineCountDiff, error) {
+	output := map[string]*machineCountDiff{}
+
+	for _, name := range md.appConfig.ProcessNames() {
+		machines := md.machineSet.FilterByProcessGroup(name).GetMachines()
+		if len(machines) == 0 {
+			continue
+		}
+
+		placements, hasCount, err := md.machineRegionsFor(name)
+		if err != nil {
+			return nil, err
+		}
+		// Groups with zero count keep and update its machines
+		if !hasCount || len(placements) == 0 || len(placements) == len(machines) {
+			continue
+		}
+
+		diff := &machineCountDiff{current: len(machines), desired: len(placements)}
+		existing := map[string]int{}
+		for _, lm := range machines {
+			existing[lm.Machine().Region]++
+		}
+		for _, region := range placements {
+			if existing[region] > 0 {
+				existing[region]--
+				continue
+			}
+			diff.missingRegions = append(diff.missingRegions, region)
+		}
+		if missing := diff.desired - diff.current; len(diff.missingRegions) > missing {
+			diff.missingRegions = diff.missingRegions[:lo.Max([]int{missing, 0})]
+		}
+		output[name] = diff
+	}
+	return output, nil
+}
+
+// warnAboutRegionStragglers lists the machines running in regions that aren't primary_region
+// or in the configured regions, deployments update them where they are
+func (md *machineDeployment) warnAboutRegionStragglers(plan *DeploymentPlan) {
+	machines := lo.Map(plan.updateEntries, func(e *machineUpdateEntry, _ int) *api.Machine {
+		return e.leasableMachine.Machine()
+	})
+	stragglers := regionStragglers(machines, md.appConfig.AllRegions())
+	if len(stragglers) == 0 {
+		return
+	}
+	regions := lo.Keys(stragglers)
+	slices.Sort(regions)
+	md.warnf("Some machines run outside of your configured regions [%s]:\n", strings.Join(md.appConfig.AllRegions(), ", "))
+	for _, region := range regions {
+		n := len(stragglers[region])
+		fmt.Fprintf(md.io.ErrOut, "  %d machine%s in %s: %s\n", n, lo.Ternary(n == 1, "", "s"), region, strings.Join(stragglers[region], ", "))
+	}
+	fmt.Fprintf(md.io.ErrOut, "  They are updated where they are, remove them with `fly machine destroy <id>` or add their regions to fly.toml\n")
+}
+
+// regionStragglers groups by region the IDs of the machines outside of regions, nothing is a
+// straggler when no region is configured
+func regionStragglers(machines []*api.Machine, regions []string) map[string][]string {
+	stragglers := map[string][]string{}
+	if len(regions) == 0 {
+		return stragglers
+	}
+	for _, m := range machines {
+		if !slices.Contains(regions, m.Region) {
+			stragglers[m.Region] = append(stragglers[m.Region], m.ID)
+		}
+	}
+	return stragglers
+}
+
+func (md *machineDeployment) warnAboutMachineCountChanges(diffs map[string]*machineCountDiff) {
+	groupNames := lo.Keys(diffs)
+	slices.Sort(groupNames)
+	for _, name := range groupNames {
+		diff := diffs[name]
+		switch {
+		case diff.current < diff.desired && md.reconcileCounts:
+			fmt.Fprintf(md.io.Out, "%s has %d machines, config wants %d; %d machines will be created\n",
+				md.colorize.Bold(name), diff.current, diff.desired, len(diff.missingRegions))
+		case diff.current < diff.desired:
+			md.warnf("%s has %d machines, config wants %d; run `fly scale count %s=%d` or deploy with --reconcile-counts\n",
+				name, diff.current, diff.desired, name, diff.desired)
+		default:
+			md.warnf("%s has %d machines, config wants %d; run `fly scale count %s=%d`\n",
+				name, diff.current, diff.desired, name, diff.desired)
+		}
+	}
+}
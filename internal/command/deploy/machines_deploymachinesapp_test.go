@@ -0,0 +1,114 @@
+package deploy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+	"golang.org/x/exp/slices"
+)
+
+func Test_updateMachinesInBatches_replacement(t *testing.T) {
+	standby := platformMachine("m4", "app")
+	standby.State = "stopped"
+	standby.Config.Standbys = []string{"m2"}
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"), platformMachine("m3", "app"), standby)
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra"})
+	args := fb.args()
+	args.DeploymentImage = ""
+	args.RestartOnly = true
+	args.MaxConcurrent = 2
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	mdImpl := md.(*machineDeployment)
+	require.NoError(t, mdImpl.machineSet.AcquireLeases(ctx, mdImpl.leaseTimeout))
+
+	entries := lo.Map(mdImpl.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) *machineUpdateEntry {
+		return &machineUpdateEntry{leasableMachine: lm, launchInput: mdImpl.launchInputForRestart(lm.Machine())}
+	})
+	// m2 gets replaced in the middle of the batches
+	entries[1].launchInput.ID = ""
+	require.NoError(t, mdImpl.updateMachinesInBatches(ctx, entries, 2))
+
+	assert.Nil(t, fb.machine("m2"))
+	require.NotNil(t, fb.machine("new1"))
+	// The standby is updated after the machine it stands for was replaced
+	assert.Equal(t, []string{"new1"}, fb.machine("m4").Config.Standbys)
+	assert.Contains(t, fb.requested(), "DELETE /machines/m2")
+}
+
+func Test_deployMachinesApp_destroysRemovedGroups(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "old"))
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra"})
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	assert.Nil(t, fb.machine("m2"))
+	// The machine gets to stop gracefully before it is destroyed
+	requests := fb.requested()
+	stop := slices.Index(requests, "POST /machines/m2/stop")
+	require.GreaterOrEqual(t, stop, 0)
+	assert.Greater(t, slices.Index(requests, "DELETE /machines/m2"), stop)
+	assert.NotContains(t, requests, "DELETE /machines/m2?kill=true")
+}
+
+func Test_updateMachinesInBatches_waitsTogether(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"))
+	var m1Updated atomic.Bool
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/apps/my-cool-app/machines/m1":
+			time.Sleep(50 * time.Millisecond)
+			m1Updated.Store(true)
+		case "GET /v1/apps/my-cool-app/machines/m2/wait":
+			// m2 is updated first, it's waited for with m1 once m1 is updated too
+			assert.True(t, m1Updated.Load())
+		}
+		return false
+	}
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra"})
+	args := fb.args()
+	args.DeploymentImage = ""
+	args.RestartOnly = true
+	args.MaxConcurrent = 2
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+	assert.Contains(t, fb.requested(), "GET /machines/m1/wait")
+	assert.Contains(t, fb.requested(), "GET /machines/m2/wait")
+}
+
+func Test_deployMachinesApp_recordsSkippedReleaseCommand(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	var definition map[string]any
+	fb.onGraphQL("MachinesCreateRelease", func(vars map[string]any) any {
+		definition = vars["input"].(map[string]any)["definition"].(map[string]any)
+		return map[string]any{"createRelease": map[string]any{"release": map[string]any{"id": "rel_1", "version": 2}}}
+	})
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra", Deploy: &appconfig.Deploy{ReleaseCommand: "bin/migrate"}})
+	args := fb.args()
+	args.DeploymentImage = ""
+	args.RestartOnly = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+	assert.Contains(t, fb.ErrOut.String(), "release_command on restart")
+	assert.Equal(t, "bin/migrate", definition["skipped_release_command"])
+	assert.Equal(t, "bin/migrate", definition["deploy"].(map[string]any)["release_command"])
+
+	// Restarts running the release command record the config as is
+	mdImpl := md.(*machineDeployment)
+	mdImpl.withReleaseCommand = true
+	config, err := mdImpl.releaseDefinition(mdImpl.appConfig)
+	require.NoError(t, err)
+	assert.Equal(t, mdImpl.appConfig, config)
+}
@@ -0,0 +1,82 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/prompt"
+	"golang.org/x/exp/slices"
+)
+
+// checkConfigDrift finds the machines changed out of band since their last deployment. Drifted
+// fields listed in --keep-drift are kept, overwriting the others asks for confirmation in interactive sessions
+func (md *machineDeployment) checkConfigDrift(ctx context.Context, entries []*machineUpdateEntry) error {
+	overwritten := 0
+	for _, e := range entries {
+		m := e.leasableMachine.Machine()
+		drifted := machine.DriftedFields(m.Config)
+		if len(drifted) == 0 {
+			continue
+		}
+		kept := lo.Intersect(drifted, md.keepDrift)
+		lost := lo.Without(drifted, md.keepDrift...)
+		if md.keepMachineEnv {
+			// The env vars added to the machine are kept by keepMachineLocalEnv
+			lost = lo.Without(lost, "env")
+		}
+		if len(kept) > 0 {
+			machine.KeepFields(e.launchInput.Config, m.Config, kept)
+			machine.StampDeployedConfig(e.launchInput.Config)
+			md.infof("Keeping the %s of machine %s changed outside of fly deploy\n", strings.Join(kept, ", "), m.ID)
+		}
+		if len(lost) > 0 {
+			md.warnf("Machine %s was changed outside of fly deploy, fly.toml overwrites its %s\n", m.ID, strings.Join(lost, ", "))
+			overwritten++
+		}
+	}
+	if overwritten == 0 || !md.io.IsInteractive() {
+		return nil
+	}
+	confirmed, err := prompt.Confirm(ctx, fmt.Sprintf("Overwrite the changes to %d machines?", overwritten))
+	switch {
+	case err != nil:
+		return err
+	case !confirmed:
+		return fmt.Errorf("deployment aborted, update fly.toml with the changes or keep them with --keep-drift")
+	}
+	return nil
+}
+
+// keepMachineLocalEnv sets the env vars of the running config set outside of fly.toml, like with
+// `fly machine update --env`, on the target config and returns their keys. Env vars in fly.toml are still set from it
+func keepMachineLocalEnv(running, target *api.MachineConfig) []string {
+	kept := machineLocalEnv(running, target)
+	if len(kept) == 0 {
+		return nil
+	}
+	for _, key := range kept {
+		target.Env[key] = running.Env[key]
+	}
+	machine.StampDeployedConfig(target)
+	return kept
+}
+
+// machineLocalEnv returns the env keys of the running config that neither the config built from fly.toml
+// nor the fly.toml of the previous deployment set, keys removed from fly.toml aren't kept. All the keys
+// missing from fly.toml are returned for machines deployed before the keys set from fly.toml were recorded
+func machineLocalEnv(running, target *api.MachineConfig) []string {
+	var fromToml []string
+	if keys := running.Metadata[api.MachineConfigMetadataKeyFlyTomlEnvKeys]; keys != "" {
+		fromToml = strings.Split(keys, ",")
+	}
+	keys := lo.Filter(lo.Keys(running.Env), func(key string, _ int) bool {
+		_, ok := target.Env[key]
+		return !ok && !slices.Contains(fromToml, key)
+	})
+	slices.Sort(keys)
+	return keys
+}
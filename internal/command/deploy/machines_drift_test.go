@@ -0,0 +1,53 @@
+package deploy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_keepMachineLocalEnv(t *testing.T) {
+	m1 := platformMachine("m1", "app")
+	// OLD was set by the fly.toml of the previous deployment, DEBUG with fly machine update
+	m1.Config.Env = map[string]string{"FOO": "manual", "OLD": "toml", "DEBUG": "1"}
+	m1.Config.Metadata["fly_toml_env_keys"] = "FOO,OLD"
+	fb := newFakeBackend(t, m1)
+	ctx := fb.context(&appconfig.Config{Env: map[string]string{"FOO": "toml"}})
+
+	// Deployments are deterministic by default
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	plan, err := md.Plan(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Update[0].KeptEnv)
+	assert.NotContains(t, plan.Update[0].Config.Env, "DEBUG")
+
+	args := fb.args()
+	args.KeepMachineEnv = true
+	md, err = NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	plan, err = md.Plan(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"DEBUG"}, plan.Update[0].KeptEnv)
+	var b bytes.Buffer
+	renderPlan(&b, plan)
+	assert.Contains(t, b.String(), "    keeps env DEBUG set outside of fly.toml\n")
+
+	require.NoError(t, md.DeployMachinesApp(ctx))
+	env := fb.machine("m1").Config.Env
+	assert.Equal(t, "1", env["DEBUG"])
+	assert.Equal(t, "toml", env["FOO"])
+	assert.NotContains(t, env, "OLD")
+	assert.Equal(t, "FOO", fb.machine("m1").Config.Metadata["fly_toml_env_keys"])
+	assert.Contains(t, fb.ErrOut.String()+fb.Out.String(), "Keeping env DEBUG set on machine m1 outside of fly.toml")
+
+	// The kept env doesn't change the machine again
+	md, err = NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	plan, err = md.Plan(ctx)
+	require.NoError(t, err)
+	assert.True(t, planIsUnchanged(plan))
+}
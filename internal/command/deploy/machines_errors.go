@@ -0,0 +1,112 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+type machineErrorCategory string
+
+const (
+	machineErrorCapacity  machineErrorCategory = "capacity"
+	machineErrorSize      machineErrorCategory = "machine size"
+	machineErrorPlacement machineErrorCategory = "volume placement"
+	machineErrorOther     machineErrorCategory = "other"
+)
+
+// machineError is a machine create or update error with guidance on how to get past it
+type machineError struct {
+	category machineErrorCategory
+	guidance string
+	err      error
+}
+
+func (e *machineError) Error() string {
+	return fmt.Sprintf("%s\n%s", e.err, e.guidance)
+}
+
+func (e *machineError) Unwrap() error {
+	return e.err
+}
+
+// machineErrorCategoryOf returns the category of a classified error, other for the rest
+func machineErrorCategoryOf(err error) machineErrorCategory {
+	var mErr *machineError
+	if errors.As(err, &mErr) {
+		return mErr.category
+	}
+	return machineErrorOther
+}
+
+// classifyMachineError tells capacity, size and volume placement errors from flaps apart by their message
+func classifyMachineError(err error) machineErrorCategory {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "insufficient resources"), strings.Contains(msg, "insufficient capacity"),
+		strings.Contains(msg, "no capacity"), strings.Contains(msg, "could not reserve resource"):
+		return machineErrorCapacity
+	case strings.Contains(msg, "volume") && (strings.Contains(msg, "region") || strings.Contains(msg, "host") ||
+		strings.Contains(msg, "zone") || strings.Contains(msg, "attached") || strings.Contains(msg, "not found")):
+		return machineErrorPlacement
+	case strings.Contains(msg, "invalid guest"), strings.Contains(msg, "machine size"), strings.Contains(msg, "cpu_kind"),
+		strings.Contains(msg, "memory_mb"), strings.Contains(msg, "cpus"):
+		return machineErrorSize
+	default:
+		return machineErrorOther
+	}
+}
+
+// wrapMachineError adds guidance to capacity, size and volume placement errors, others are returned as they are
+func (md *machineDeployment) wrapMachineError(ctx context.Context, err error, region string) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	switch category := classifyMachineError(err); category {
+	case machineErrorCapacity:
+		guidance := fmt.Sprintf("Region %s is out of capacity for this machine, retry later or deploy to another region", region)
+		if regions, _, rErr := md.apiClient.PlatformRegions(ctx); rErr == nil {
+			if nearby := nearbyRegions(regions, region, 3); len(nearby) > 0 {
+				guidance += fmt.Sprintf(", nearby regions are %s", strings.Join(nearby, ", "))
+			}
+		}
+		return &machineError{category: category, guidance: guidance, err: err}
+	case machineErrorSize:
+		sizes := maps.Keys(api.MachinePresets)
+		slices.Sort(sizes)
+		return &machineError{
+			category: category,
+			guidance: fmt.Sprintf("Check the machine size, valid sizes are %s", strings.Join(sizes, ", ")),
+			err:      err,
+		}
+	case machineErrorPlacement:
+		return &machineError{
+			category: category,
+			guidance: "Machines with volumes run on the host of their volume, in its region. Create a volume in the target region with `fly volumes create` or deploy to the region of the volume",
+			err:      err,
+		}
+	default:
+		return err
+	}
+}
+
+// nearbyRegions returns the codes of the n regions closest to the given one
+func nearbyRegions(regions []api.Region, code string, n int) []string {
+	from, ok := lo.Find(regions, func(r api.Region) bool { return r.Code == code })
+	if !ok {
+		return nil
+	}
+	others := lo.Filter(regions, func(r api.Region, _ int) bool { return r.Code != code })
+	distance := func(r api.Region) float64 {
+		return math.Hypot(float64(r.Latitude-from.Latitude), float64(r.Longitude-from.Longitude))
+	}
+	slices.SortFunc(others, func(a, b api.Region) bool { return distance(a) < distance(b) })
+	return lo.Map(others[:lo.Min([]int{n, len(others)})], func(r api.Region, _ int) string { return r.Code })
+}
@@ -0,0 +1,43 @@
+package deploy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_classifyMachineError(t *testing.T) {
+	cases := map[string]machineErrorCategory{
+		"failed to launch VM: insufficient resources to create machine":        machineErrorCapacity,
+		"could not reserve resource for machine: no capacity":                  machineErrorCapacity,
+		"volume vol_123 is in region ams, machine is in region cdg":            machineErrorPlacement,
+		"failed to update VM: volume vol_123 is already attached to a machine": machineErrorPlacement,
+		"invalid guest config: memory_mb must be a multiple of 256":            machineErrorSize,
+		"failed to update VM: unauthorized":                                    machineErrorOther,
+	}
+	for msg, want := range cases {
+		assert.Equal(t, want, classifyMachineError(errors.New(msg)), msg)
+	}
+}
+
+func Test_machineErrorCategoryOf(t *testing.T) {
+	err := &machineError{category: machineErrorSize, guidance: "Check the machine size", err: errors.New("invalid guest")}
+	assert.Equal(t, machineErrorSize, machineErrorCategoryOf(err))
+	assert.Equal(t, machineErrorOther, machineErrorCategoryOf(errors.New("boom")))
+	assert.Equal(t, "invalid guest\nCheck the machine size", err.Error())
+}
+
+func Test_nearbyRegions(t *testing.T) {
+	regions := []api.Region{
+		{Code: "ams", Latitude: 52.37, Longitude: 4.89},
+		{Code: "cdg", Latitude: 48.86, Longitude: 2.35},
+		{Code: "fra", Latitude: 50.11, Longitude: 8.68},
+		{Code: "syd", Latitude: -33.87, Longitude: 151.21},
+		{Code: "lhr", Latitude: 51.51, Longitude: -0.13},
+	}
+	assert.Equal(t, []string{"cdg", "fra"}, nearbyRegions(regions, "ams", 2))
+	assert.Len(t, nearbyRegions(regions, "ams", 10), 4)
+	assert.Empty(t, nearbyRegions(regions, "xyz", 2))
+}
@@ -0,0 +1,136 @@
+package deploy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/superfly/flyctl/terminal"
+)
+
+type DeployEventType string
+
+const (
+	// DeployEventPhase is sent when the deployment enters a phase
+	DeployEventPhase DeployEventType = "phase"
+	// DeployEventMachine is sent when a machine changes state during the deployment
+	DeployEventMachine DeployEventType = "machine"
+	DeployEventWarning DeployEventType = "warning"
+	DeployEventInfo    DeployEventType = "info"
+)
+
+// Phases of a deployment, sent on phase events
+const (
+	PhaseReleaseCommand  = "release_command"
+	PhaseDestroyMachines = "destroy_machines"
+	PhaseCreateMachines  = "create_machines"
+	PhaseUpdateMachines  = "update_machines"
+	PhaseFinished        = "finished"
+)
+
+// States of the machines, sent on machine events
+const (
+	MachineStateReplacing = "replacing"
+	MachineStateCreated   = "created"
+	MachineStateStopping  = "stopping"
+	MachineStateUpdating  = "updating"
+	MachineStateUpdated   = "updated"
+	// MachineStateLeftStopped is sent for stopped machines updated without starting them
+	MachineStateLeftStopped = "left_stopped"
+	MachineStateScheduled   = "scheduled"
+	MachineStateFailed      = "failed"
+	MachineStateDestroyed   = "destroyed"
+)
+
+// DeployEvent is something that happened during a deployment, the CLI renders the same events it sends
+type DeployEvent struct {
+	Type DeployEventType `json:"type"`
+	Time time.Time       `json:"time"`
+	// Phase is set on phase events
+	Phase string `json:"phase,omitempty"`
+	// MachineID is set on machine events
+	MachineID string `json:"machine_id,omitempty"`
+	// State is the new machine state on machine events and the release status on the finished phase
+	State string `json:"state,omitempty"`
+	// Message is what the CLI shows for the event, events without message aren't shown
+	Message string `json:"message,omitempty"`
+}
+
+// publish renders an event and sends it to the events channel of the deployment.
+// Sends don't block, events that don't fit in the channel buffer are dropped
+func (md *machineDeployment) publish(ev DeployEvent) {
+	md.outputMu.Lock()
+	defer md.outputMu.Unlock()
+	ev.Time = time.Now()
+	md.renderEvent(ev)
+	if md.events == nil {
+		return
+	}
+	select {
+	case md.events <- ev:
+	default:
+		md.droppedEvents.Add(1)
+	}
+}
+
+func (md *machineDeployment) renderEvent(ev DeployEvent) {
+	if ev.Message == "" {
+		return
+	}
+	switch ev.Type {
+	case DeployEventPhase:
+		fmt.Fprint(md.io.Out, ev.Message)
+	case DeployEventWarning:
+		fmt.Fprintf(md.io.ErrOut, "%s %s", md.colorize.Yellow("WARN"), ev.Message)
+	case DeployEventInfo:
+		fmt.Fprint(md.io.ErrOut, "INFO "+ev.Message)
+	default:
+		fmt.Fprint(md.io.ErrOut, ev.Message)
+	}
+}
+
+// phasef publishes the deployment entering a phase
+func (md *machineDeployment) phasef(phase string, format string, v ...any) {
+	md.publish(DeployEvent{Type: DeployEventPhase, Phase: phase, Message: fmt.Sprintf(format, v...)})
+}
+
+// machinef publishes a machine changing state
+func (md *machineDeployment) machinef(machineID, state string, format string, v ...any) {
+	md.publish(DeployEvent{Type: DeployEventMachine, MachineID: machineID, State: state, Message: fmt.Sprintf(format, v...)})
+}
+
+// infof writes a notice to the error output of the deployment
+func (md *machineDeployment) infof(format string, v ...any) {
+	md.publish(DeployEvent{Type: DeployEventInfo, Message: fmt.Sprintf(format, v...)})
+}
+
+// warnf writes a warning to the error output of the deployment
+func (md *machineDeployment) warnf(format string, v ...any) {
+	md.publish(DeployEvent{Type: DeployEventWarning, Message: fmt.Sprintf(format, v...)})
+}
+
+// progressf writes a progress line of the machine updates to the error output,
+// it is safe to call from machines updated concurrently
+func (md *machineDeployment) progressf(format string, v ...any) {
+	md.outputMu.Lock()
+	defer md.outputMu.Unlock()
+	fmt.Fprintf(md.io.ErrOut, format, v...)
+}
+
+// warnOncef is warnf for warnings found again each time the machines are planned, they are shown once per deployment
+func (md *machineDeployment) warnOncef(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	if md.warned[msg] {
+		return
+	}
+	if md.warned == nil {
+		md.warned = map[string]bool{}
+	}
+	md.warned[msg] = true
+	md.publish(DeployEvent{Type: DeployEventWarning, Message: msg})
+}
+
+func (md *machineDeployment) logDroppedEvents() {
+	if n := md.droppedEvents.Load(); n > 0 {
+		terminal.Debugf("Dropped %d deployment events, the events channel was full\n", n)
+	}
+}
@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// gpuTarget is a process group deployed to machines with a GPU kind
+type gpuTarget struct {
+	group   string
+	gpuKind string
+}
+
+// validateGPURegions fails the deployment early when machines with a GPU would be created or updated
+// in regions without hosts having it, the Machines API fails each of them late with a capacity error
+// otherwise. Each process group is checked on its own as only some of them may have a GPU
+func (md *machineDeployment) validateGPURegions() error {
+	if md.restartOnly {
+		return nil
+	}
+
+	unsupported := map[gpuTarget][]string{}
+	check := func(group, region string, guest *api.MachineGuest) {
+		if guest == nil || guest.GPUKind == "" || lo.Contains(api.MachineGPURegions[guest.GPUKind], region) {
+			return
+		}
+		target := gpuTarget{group: group, gpuKind: guest.GPUKind}
+		if !lo.Contains(unsupported[target], region) {
+			unsupported[target] = append(unsupported[target], region)
+		}
+	}
+
+	for _, lm := range md.machineSet.GetMachines() {
+		m := lm.Machine()
+		guest, err := md.guestFor(machine.CloneConfig(m.Config))
+		if err != nil {
+			return err
+		}
+		check(m.ProcessGroup(), m.Region, guest)
+	}
+
+	newRegions, err := md.newMachineRegions()
+	if err != nil {
+		return err
+	}
+	for group, regions := range newRegions {
+		mConfig, err := md.appConfig.ToMachineConfig(group, nil)
+		if err != nil {
+			return err
+		}
+		if md.machineGuest != nil {
+			guest := *md.machineGuest
+			mConfig.Guest = &guest
+		}
+		guest, err := md.guestFor(mConfig)
+		if err != nil {
+			return err
+		}
+		for _, region := range regions {
+			check(group, region, guest)
+		}
+	}
+
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return gpuRegionsError(unsupported)
+}
+
+// guestFor returns the guest of mConfig once --machine-config and the config mutator are applied
+func (md *machineDeployment) guestFor(mConfig *api.MachineConfig) (*api.MachineGuest, error) {
+	if mConfig == nil {
+		return nil, nil
+	}
+	if err := md.mutateConfig(mConfig); err != nil {
+		return nil, err
+	}
+	return mConfig.Guest, nil
+}
+
+// newMachineRegions returns the regions of the machines the deployment creates by process group
+func (md *machineDeployment) newMachineRegions() (map[string][]string, error) {
+	regions := map[string][]string{}
+	for name := range md.resolveProcessGroupChanges().groupsNeedingMachines {
+		placements, _, err := md.machineRegionsFor(name)
+		if err != nil {
+			return nil, err
+		}
+		regions[name] = append(regions[name], placements...)
+	}
+	if md.reconcileCounts {
+		diffs, err := md.resolveMachineCountChanges()
+		if err != nil {
+			return nil, err
+		}
+		for name, diff := range diffs {
+			regions[name] = append(regions[name], diff.missingRegions...)
+		}
+	}
+	return regions, nil
+}
+
+func gpuRegionsError(unsupported map[gpuTarget][]string) error {
+	targets := maps.Keys(unsupported)
+	slices.SortFunc(targets, func(a, b gpuTarget) bool {
+		return a.group < b.group || (a.group == b.group && a.gpuKind < b.gpuKind)
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "machines with a GPU can't be deployed to regions without it:\n")
+	for _, t := range targets {
+		regions := unsupported[t]
+		slices.Sort(regions)
+		supported := api.MachineGPURegions[t.gpuKind]
+		fmt.Fprintf(&b, "  process group '%s' runs on %s GPUs, they aren't available in %s",
+			t.group, t.gpuKind, strings.Join(regions, ", "))
+		if len(supported) > 0 {
+			fmt.Fprintf(&b, ", supported regions are %s", strings.Join(supported, ", "))
+		} else {
+			fmt.Fprintf(&b, ", no region has them")
+		}
+		b.WriteString("\n")
+	}
+	return fmt.Errorf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
@@ -0,0 +1,32 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_verifyImage_beforeProvisioning(t *testing.T) {
+	fb := newFakeBackend(t)
+	fb.app.Deployed = false
+	fb.onGraphQL("image(ref", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"id": fb.app.ID, "image": nil}}
+	})
+	// A first deploy of public services would allocate IPs, the fake answers no request for them
+	ctx := fb.context(&appconfig.Config{HTTPService: &appconfig.HTTPService{InternalPort: 8080}})
+	args := fb.args()
+	args.DeploymentImage = "registry.fly.io/my-cool-app:deploymnet-1"
+	args.ListImageTags = func(_ context.Context, imageRef string) ([]string, error) {
+		assert.Equal(t, args.DeploymentImage, imageRef)
+		return []string{"deployment-1", "latest"}, nil
+	}
+
+	_, err := NewMachineDeployment(ctx, args)
+	require.Error(t, err)
+	assert.Equal(t, "image not found: registry.fly.io/my-cool-app:deploymnet-1, did you mean registry.fly.io/my-cool-app:deployment-1?", err.Error())
+	// Machines were only listed, nothing was created for the missing image
+	assert.Equal(t, []string{"GET /machines"}, fb.requested())
+}
@@ -0,0 +1,145 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/samber/lo"
+	"golang.org/x/sync/errgroup"
+)
+
+// immediateProgress counts the machine updates of the immediate strategy, it is safe for concurrent use
+type immediateProgress struct {
+	mu         sync.Mutex
+	total      int
+	dispatched int
+	completed  int
+	failed     int
+}
+
+func (p *immediateProgress) dispatch() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dispatched++
+}
+
+// finish counts a finished update and returns the progress line to show
+func (p *immediateProgress) finish(err error) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.failed++
+	} else {
+		p.completed++
+	}
+	return fmt.Sprintf("%d/%d dispatched, %d completed, %d failed", p.dispatched, p.total, p.completed, p.failed)
+}
+
+// updateMachinesImmediately dispatches the updates of the immediate strategy with up to limit of them in flight,
+// without waiting for machines to be healthy. Machines being replaced and standbys are updated one by one
+// afterwards, standbys need the IDs of the machines replaced before them. Failures don't stop other updates.
+// With --immediate-wait the updated machines are waited for once every update was dispatched
+func (md *machineDeployment) updateMachinesImmediately(ctx context.Context, updateEntries []*machineUpdateEntry, limit int) error {
+	md.phasef(PhaseUpdateMachines, "Updating existing machines in '%s' with immediate strategy, %d at once\n", md.colorize.Bold(md.app.Name), limit)
+	if err := md.checkNewerRelease(ctx); err != nil {
+		return err
+	}
+
+	concurrent := lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return keepsMachineID(e) })
+	sequential := lo.Filter(updateEntries, func(e *machineUpdateEntry, _ int) bool { return !keepsMachineID(e) })
+	// Keep the order the entries are updated in, indexes of the progress lines follow it
+	updateEntries = append(concurrent, sequential...)
+
+	progress := &immediateProgress{total: len(updateEntries)}
+	var (
+		failures   []machineUpdateFailure
+		failuresMu sync.Mutex
+		// updated marks the entries whose update succeeded, each is only set by the goroutine updating it
+		updated = make([]bool, len(updateEntries))
+	)
+	replaced := &failureReplacements{}
+	defer md.showFailureReplacements(replaced)
+	if md.immediateWait && !md.detach {
+		md.deferredWaits = &deferredWaits{}
+		defer func() { md.deferredWaits = nil }()
+	}
+	update := func(i int, e *machineUpdateEntry, replacedIDs map[string]string) {
+		// Updates waiting for a slot when the deployment is interrupted aren't dispatched
+		if ctx.Err() != nil {
+			return
+		}
+		indexStr := formatIndex(i, len(updateEntries))
+		progress.dispatch()
+		err := md.updateOrReplaceMachine(ctx, e, indexStr, replacedIDs, replaced)
+		md.progressf("  %s %s\n", indexStr, progress.finish(err))
+		updated[i] = err == nil
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		md.progressf("  %s Continuing after error: %s\n", indexStr, err)
+		failuresMu.Lock()
+		defer failuresMu.Unlock()
+		failures = append(failures, machineUpdateFailure{machineID: e.leasableMachine.Machine().ID, err: err})
+	}
+
+	// Updates in flight when the deployment is interrupted are aborted, the next ones aren't dispatched
+	md.concurrentUpdates = true
+	var eg errgroup.Group
+	eg.SetLimit(limit)
+	for i, e := range concurrent {
+		i, e := i, e
+		if ctx.Err() != nil {
+			break
+		}
+		eg.Go(func() error {
+			update(i, e, nil)
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	md.concurrentUpdates = false
+	if err := ctx.Err(); err != nil {
+		entries, n := updatedFirst(updateEntries, updated)
+		return interruptedUpdateError(err, entries, n)
+	}
+
+	// Standbys of the machines replaced above point to their replacements
+	replacedIDs := replaced.replacedIDs()
+	for i, e := range sequential {
+		update(len(concurrent)+i, e, replacedIDs)
+		if err := ctx.Err(); err != nil {
+			entries, n := updatedFirst(updateEntries, updated)
+			return interruptedUpdateError(err, entries, n)
+		}
+	}
+
+	if waits := md.deferredWaits; waits != nil && len(waits.machines) > 0 {
+		fmt.Fprintf(md.io.ErrOut, "  Waiting for %d updated machine%s to be healthy, %s\n", len(waits.machines), lo.Ternary(len(waits.machines) == 1, "", "s"),
+			lo.Ternary(md.immediateConcurrency > 0, fmt.Sprintf("%d at once", md.immediateConcurrency), "all at once"))
+		failures = append(failures, waits.wait(ctx, md.waitOptions(md.immediateConcurrency, false))...)
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("deployment stopped waiting for the updated machines: %w", err)
+		}
+	}
+
+	if len(failures) > 0 {
+		return md.updateFailuresError(failures, len(updateEntries))
+	}
+	fmt.Fprintf(md.io.ErrOut, "  Finished deploying\n")
+	return nil
+}
+
+// updatedFirst orders the entries updated concurrently with the updated ones first, followed by the ones
+// that failed, were aborted or weren't dispatched, and returns how many were updated
+func updatedFirst(updateEntries []*machineUpdateEntry, updated []bool) ([]*machineUpdateEntry, int) {
+	var done, rest []*machineUpdateEntry
+	for i, e := range updateEntries {
+		if updated[i] {
+			done = append(done, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	return append(done, rest...), len(done)
+}
@@ -0,0 +1,173 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"golang.org/x/exp/slices"
+)
+
+// isUpdate tells if r updates a machine of the fake backend, and which one
+func isUpdate(r *http.Request) (string, bool) {
+	id, ok := strings.CutPrefix(r.URL.Path, "/v1/apps/my-cool-app/machines/")
+	return id, ok && r.Method == http.MethodPost && !strings.Contains(id, "/")
+}
+
+func immediateArgs(fb *fakeBackend, limit int) MachineDeploymentArgs {
+	args := fb.args()
+	args.Strategy = "immediate"
+	args.ImmediateConcurrency = limit
+	return args
+}
+
+func Test_updateMachinesImmediately_limit(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"), platformMachine("m3", "app"), platformMachine("m4", "app"))
+	var (
+		mu                  sync.Mutex
+		inFlight, maxFlight int
+	)
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if _, ok := isUpdate(r); ok {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxFlight {
+				maxFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}
+		return false
+	}
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, immediateArgs(fb, 2))
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	assert.Equal(t, 2, maxFlight)
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		assert.Equal(t, "registry.fly.io/my-cool-app:deployment-1", fb.machine(id).Config.Image)
+	}
+	assert.Contains(t, fb.ErrOut.String(), "4/4 dispatched, 4 completed, 0 failed")
+}
+
+func Test_updateMachinesImmediately_standbysLast(t *testing.T) {
+	standby := platformMachine("m1", "app")
+	standby.State = api.MachineStateStopped
+	standby.Config.Standbys = []string{"m3"}
+	fb := newFakeBackend(t, standby, platformMachine("m2", "app"), platformMachine("m3", "app"))
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, immediateArgs(fb, 2))
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	var updates []string
+	for _, req := range fb.requested() {
+		if id, ok := strings.CutPrefix(req, "POST /machines/"); ok && !strings.Contains(id, "/") {
+			updates = append(updates, id)
+		}
+	}
+	require.Len(t, updates, 3)
+	// The standby is updated once the machines it stands for are
+	assert.Equal(t, "m1", updates[2])
+	assert.ElementsMatch(t, []string{"m2", "m3"}, updates[:2])
+}
+
+func Test_updateMachinesImmediately_interrupted(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"), platformMachine("m3", "app"), platformMachine("m4", "app"))
+	ctx, cancel := context.WithCancel(fb.context(&appconfig.Config{}))
+	defer cancel()
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		id, ok := isUpdate(r)
+		switch {
+		case !ok, id == "m1":
+			return false
+		case id == "m2":
+			// m2 hangs until the deployment is interrupted, m1 is updated meanwhile
+			assert.Eventually(t, func() bool { return fb.machine("m1").InstanceID != "v1" }, time.Second, 5*time.Millisecond)
+			cancel()
+		}
+		<-ctx.Done()
+		return true
+	}
+
+	md, err := NewMachineDeployment(ctx, immediateArgs(fb, 2))
+	require.NoError(t, err)
+	err = md.DeployMachinesApp(ctx)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.ErrorContains(t, err, "deployment stopped after updating 1 of 4 machines, not updated: m2, m3, m4")
+}
+
+func Test_updateMachinesImmediately_plainOutput(t *testing.T) {
+	stopped := func(id string) *api.Machine {
+		m := platformMachine(id, "app")
+		m.State = api.MachineStateStopped
+		return m
+	}
+	fb := newFakeBackend(t, stopped("m1"), stopped("m2"))
+	fb.ios.SetStdinTTY(true)
+	fb.ios.SetStdoutTTY(true)
+	fb.ios.SetStderrTTY(true)
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, immediateArgs(fb, 2))
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	// Lines of machines updated concurrently interleave, clearing the line above would erase another machine's
+	assert.Contains(t, fb.ErrOut.String(), "updated (left stopped)")
+	assert.NotContains(t, fb.ErrOut.String(), "\x1b[1A")
+}
+
+func Test_immediateProgress(t *testing.T) {
+	progress := &immediateProgress{total: 3}
+	progress.dispatch()
+	progress.dispatch()
+	assert.Equal(t, "2/3 dispatched, 1 completed, 0 failed", progress.finish(nil))
+	progress.dispatch()
+	assert.Equal(t, "3/3 dispatched, 1 completed, 1 failed", progress.finish(errors.New("boom")))
+	assert.Equal(t, "3/3 dispatched, 2 completed, 1 failed", progress.finish(nil))
+}
+
+func Test_updateMachinesImmediately_wait(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"), platformMachine("m3", "app"))
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/apps/my-cool-app/machines/m2/wait" {
+			return false
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"machine not found"}`))
+		return true
+	}
+	ctx := fb.context(&appconfig.Config{})
+	args := immediateArgs(fb, 0)
+	args.ImmediateWait = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	err = md.DeployMachinesApp(ctx)
+	assert.EqualError(t, err, "1 of 3 machines failed to update (1 other)")
+	assert.Regexp(t, `m2\s.*machine not found`, fb.ErrOut.String())
+
+	// Machines are waited for once every update was dispatched, all of them at once without a limit
+	requests := fb.requested()
+	lastUpdate := slices.IndexFunc(requests, func(req string) bool { return req == "POST /machines/m3" })
+	for _, id := range []string{"m1", "m2", "m3"} {
+		assert.Greater(t, slices.Index(requests, "GET /machines/"+id+"/wait"), lastUpdate, id)
+		assert.Equal(t, "registry.fly.io/my-cool-app:deployment-1", fb.machine(id).Config.Image)
+	}
+	assert.Contains(t, fb.ErrOut.String(), "Waiting for 3 updated machines to be healthy, all at once")
+}
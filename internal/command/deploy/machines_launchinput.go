@@ -3,19 +3,23 @@ package deploy
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/internal/machine"
-	"github.com/superfly/flyctl/terminal"
 )
 
 func (md *machineDeployment) launchInputForRestart(origMachineRaw *api.Machine) *api.LaunchMachineInput {
 	Config := machine.CloneConfig(origMachineRaw.Config)
+	if md.useLatestRelease {
+		Config.Image = md.img
+	}
 	md.setMachineReleaseData(Config)
 
 	return &api.LaunchMachineInput{
 		ID:      origMachineRaw.ID,
+		Name:    origMachineRaw.Name,
 		AppID:   md.app.Name,
 		OrgSlug: md.app.Organization.ID,
 		Config:  Config,
@@ -23,7 +27,11 @@ func (md *machineDeployment) launchInputForRestart(origMachineRaw *api.Machine)
 	}
 }
 
-func (md *machineDeployment) launchInputForLaunch(processGroup string, guest *api.MachineGuest, standbyFor []string) (*api.LaunchMachineInput, error) {
+func (md *machineDeployment) launchInputForLaunch(processGroup, region string, guest *api.MachineGuest, standbyFor []string) (*api.LaunchMachineInput, error) {
+	if region == "" {
+		region = md.appConfig.PrimaryRegion
+	}
+
 	mConfig, err := md.appConfig.ToMachineConfig(processGroup, nil)
 	if err != nil {
 		return nil, err
@@ -36,7 +44,14 @@ func (md *machineDeployment) launchInputForLaunch(processGroup string, guest *ap
 
 	if len(mConfig.Mounts) > 0 {
 		mount0 := &mConfig.Mounts[0]
-		vol := md.popVolumeFor(mount0.Name)
+		vol := md.popVolumeFor(mount0.Name, region)
+		if vol == nil && md.volumesInAnyRegion() {
+			// Create the machine where the volume lives
+			if vol = md.popVolumeFor(mount0.Name, ""); vol != nil && vol.Region != region {
+				md.infof("Volume %s is in region %s, the new machine in group '%s' will be created there\n", vol.ID, vol.Region, processGroup)
+				region = vol.Region
+			}
+		}
 		if vol == nil {
 			return nil, fmt.Errorf("New machine in group '%s' needs an unattached volume named '%s'", processGroup, mount0.Name)
 		}
@@ -46,11 +61,16 @@ func (md *machineDeployment) launchInputForLaunch(processGroup string, guest *ap
 	if len(standbyFor) > 0 {
 		mConfig.Standbys = standbyFor
 	}
+	if err := md.mutateConfig(mConfig); err != nil {
+		return nil, err
+	}
+	machine.StampDeployedConfig(mConfig)
 
 	return &api.LaunchMachineInput{
 		AppID:      md.app.Name,
+		Name:       md.machineNameFor(processGroup, region),
 		OrgSlug:    md.app.Organization.ID,
-		Region:     md.appConfig.PrimaryRegion,
+		Region:     region,
 		Config:     mConfig,
 		SkipLaunch: len(standbyFor) > 0,
 	}, nil
@@ -66,6 +86,10 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 	}
 	mConfig.Image = md.img
 	md.setMachineReleaseData(mConfig)
+	// Metadata added to the machine by users or other tools is kept unless asked for a clean slate
+	if md.replaceMetadata {
+		mConfig.Metadata = managedMetadata(mConfig.Metadata)
+	}
 	// Get the final process group and prevent empty string
 	processGroup = mConfig.ProcessGroup()
 
@@ -80,7 +104,7 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 		case len(mMounts) == 0:
 			// The mounts section was removed from fly.toml
 			mID = "" // Forces machine replacement
-			terminal.Warnf("Machine %s has a volume attached but fly.toml doesn't have a [mounts] section\n", mID)
+			md.warnOncef("Machine %s has a volume attached but fly.toml doesn't have a [mounts] section\n", mID)
 		case oMounts[0].Name == "":
 			// It's rare but can happen, we don't know the mounted volume name
 			// so can't be sure it matches the mounts defined in fly.toml, in this
@@ -91,8 +115,8 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 			// As we can't change the volume for a running machine, the only
 			// way is to destroy the current machine and launch a new one with the new volume attached
 			mount0 := &mMounts[0]
-			terminal.Warnf("Machine %s has volume '%s' attached but fly.toml have a different name: '%s'\n", mID, oMounts[0].Name, mount0.Name)
-			vol := md.popVolumeFor(mount0.Name)
+			md.warnOncef("Machine %s has volume '%s' attached but fly.toml have a different name: '%s'\n", mID, oMounts[0].Name, mount0.Name)
+			vol := md.popVolumeFor(mount0.Name, "")
 			if vol == nil {
 				return nil, fmt.Errorf("machine in group '%s' needs an unattached volume named '%s'", processGroup, mount0.Name)
 			}
@@ -100,7 +124,7 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 			mID = "" // Forces machine replacement
 		case mMounts[0].Path != oMounts[0].Path:
 			// The volume is the same but its mount path changed. Not a big deal.
-			terminal.Warnf(
+			md.warnOncef(
 				"Updating the mount path for volume %s on machine %s from %s to %s due to fly.toml [mounts] destination value\n",
 				oMounts[0].Volume, mID, oMounts[0].Path, mMounts[0].Path,
 			)
@@ -115,16 +139,31 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 		// and it is not possible to attach a volume to an existing machine.
 		// The volume could be in a different zone than the machine.
 		mount0 := &mMounts[0]
-		vol := md.popVolumeFor(mount0.Name)
+		vol := md.popVolumeFor(mount0.Name, "")
 		if vol == nil {
 			return nil, fmt.Errorf("machine in group '%s' needs an unattached volume named '%s'", processGroup, mMounts[0].Name)
 		}
 		mount0.Volume = vol.ID
 		mID = "" // Forces machine replacement
 	}
+	// Keep what the machine ran so it can be rolled back without the previous fly.toml
+	if err := machine.StashPreviousConfig(mConfig, origMachineRaw.Config); err != nil {
+		return nil, err
+	}
+	if err := md.mutateConfig(mConfig); err != nil {
+		return nil, err
+	}
+	machine.StampDeployedConfig(mConfig)
+
+	// Updates always send the name of the machine so it is never blanked, replacements inherit it with a suffix
+	name := origMachineRaw.Name
+	if mID != origMachineRaw.ID {
+		name = md.replacementNameFor(name)
+	}
 
 	return &api.LaunchMachineInput{
 		ID:         mID,
+		Name:       name,
 		AppID:      md.app.Name,
 		OrgSlug:    md.app.Organization.ID,
 		Region:     origMachineRaw.Region,
@@ -133,6 +172,30 @@ func (md *machineDeployment) launchInputForUpdate(origMachineRaw *api.Machine) (
 	}, nil
 }
 
+// mutateConfig runs the config mutator of the deployment, then restores the fly_* metadata keys it changed
+func (md *machineDeployment) mutateConfig(mConfig *api.MachineConfig) error {
+	if md.configMutator == nil {
+		return nil
+	}
+	group := mConfig.ProcessGroup()
+	isFlyKey := func(key string, _ string) bool { return strings.HasPrefix(key, "fly_") }
+	flyMetadata := lo.PickBy(mConfig.Metadata, isFlyKey)
+
+	if err := md.configMutator(group, mConfig); err != nil {
+		return fmt.Errorf("failed to mutate the config of a machine in group '%s': %w", group, err)
+	}
+	mConfig.Metadata = lo.Assign(lo.OmitBy(mConfig.Metadata, isFlyKey), flyMetadata)
+	return nil
+}
+
+// managedMetadata returns the metadata keys set by the platform and fly.toml
+func managedMetadata(metadata map[string]string) map[string]string {
+	tomlKeys := strings.Split(metadata[api.MachineConfigMetadataKeyFlyTomlKeys], ",")
+	return lo.PickBy(metadata, func(key string, _ string) bool {
+		return strings.HasPrefix(key, "fly_") || key == api.MachineConfigMetadataKeyFlyManagedPostgres || lo.Contains(tomlKeys, key)
+	})
+}
+
 func (md *machineDeployment) setMachineReleaseData(mConfig *api.MachineConfig) {
 	mConfig.Metadata = lo.Assign(mConfig.Metadata, map[string]string{
 		api.MachineConfigMetadataKeyFlyReleaseId:      md.releaseId,
@@ -8,6 +8,7 @@ import (
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/helpers"
 	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
 )
 
 // Test the basic flow of launching, restarting and updating a machine for default process group
@@ -39,10 +40,12 @@ func Test_launchInputFor_Basic(t *testing.T) {
 				"fly_process_group":    "app",
 				"fly_release_id":       "release_id",
 				"fly_release_version":  "3",
+				"fly_toml_env_keys":    "OTHER,PRIMARY_REGION",
 			},
 		},
 	}
-	li, err := md.launchInputForLaunch("", nil, nil)
+	machine.StampDeployedConfig(want.Config)
+	li, err := md.launchInputForLaunch("", "", nil, nil)
 	require.NoError(t, err)
 	assert.Equal(t, want, li)
 
@@ -77,8 +80,19 @@ func Test_launchInputFor_Basic(t *testing.T) {
 	}
 	want.Config.Image = "super/globe"
 	want.Config.Env["NOT_SET_ON_RESTART_ONLY"] = "true"
+	want.Config.Metadata["fly_toml_env_keys"] = "NOT_SET_ON_RESTART_ONLY,OTHER,PRIMARY_REGION"
 	li, err = md.launchInputForUpdate(origMachineRaw)
 	require.NoError(t, err)
+	// The update keeps the config it replaces, its env values are the ones the update keeps
+	prev, unknownEnv, err := machine.RestoredConfig(&api.Machine{Config: li.Config})
+	require.NoError(t, err)
+	assert.Empty(t, unknownEnv)
+	assert.Equal(t, origMachineRaw.Config, prev)
+	assert.Equal(t, "super/balloon", li.Config.Metadata["fly_previous_image"])
+	want.Config.Metadata["fly_previous_image"] = "super/balloon"
+	want.Config.Metadata["fly_previous_config"] = li.Config.Metadata["fly_previous_config"]
+	want.Config.Metadata["fly_previous_config_hash"] = li.Config.Metadata["fly_previous_config_hash"]
+	machine.StampDeployedConfig(want.Config)
 	assert.Equal(t, want, li)
 }
 
@@ -97,7 +111,7 @@ func Test_launchInputFor_onMounts(t *testing.T) {
 	}
 
 	// New machine must get a volume attached
-	li, err := md.launchInputForLaunch("", nil, nil)
+	li, err := md.launchInputForLaunch("", "", nil, nil)
 	require.NoError(t, err)
 	require.NotEmpty(t, li.Config.Mounts)
 	assert.Equal(t, api.MachineMount{Volume: "vol_10001", Path: "/data", Name: "data"}, li.Config.Mounts[0])
@@ -214,3 +228,40 @@ func Test_launchInputForUpdate_keepUnmanagedFields(t *testing.T) {
 	assert.Equal(t, &api.DNSConfig{SkipRegistration: true}, li.Config.DNS)
 	assert.Equal(t, []api.MachineProcess{{CmdOverride: []string{"foo"}}}, li.Config.Processes)
 }
+
+// Test metadata added to machines between deployments survives updates unless replaced
+func Test_launchInputForUpdate_keepUnmanagedMetadata(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		AppName:         "my-cool-app",
+		PrimaryRegion:   "scl",
+		MachineMetadata: map[string]string{"team": "infra"},
+	})
+	require.NoError(t, err)
+	md.releaseId = "release_id"
+	md.releaseVersion = 3
+
+	li, err := md.launchInputForLaunch("", "", nil, nil)
+	require.NoError(t, err)
+	// Stamped out of band after the first deployment
+	li.Config.Metadata["owner"] = "jane"
+	li.Config.Metadata["cost-center"] = "42"
+	li.Config.Metadata["fly-managed-postgres"] = "true"
+
+	md.releaseId = "new_release_id"
+	li, err = md.launchInputForUpdate(&api.Machine{ID: "ab1234567890", Config: li.Config})
+	require.NoError(t, err)
+	assert.Equal(t, "jane", li.Config.Metadata["owner"])
+	assert.Equal(t, "42", li.Config.Metadata["cost-center"])
+	assert.Equal(t, "infra", li.Config.Metadata["team"])
+	assert.Equal(t, "new_release_id", li.Config.Metadata["fly_release_id"])
+	// Not a postgres app, the platform key is still cleaned up
+	assert.NotContains(t, li.Config.Metadata, "fly-managed-postgres")
+
+	md.replaceMetadata = true
+	li, err = md.launchInputForUpdate(&api.Machine{ID: "ab1234567890", Config: li.Config})
+	require.NoError(t, err)
+	assert.NotContains(t, li.Config.Metadata, "owner")
+	assert.NotContains(t, li.Config.Metadata, "cost-center")
+	assert.Equal(t, "infra", li.Config.Metadata["team"])
+	assert.Equal(t, "app", li.Config.Metadata["fly_process_group"])
+}
@@ -0,0 +1,92 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/logs"
+)
+
+// maxWatchedLogLines is how many of the last log lines of a machine are kept for its errors
+const maxWatchedLogLines = 20
+
+// machineLogWatcher shows the logs of the machines being updated, from the update until they pass checks or fail
+type machineLogWatcher struct {
+	w        io.Writer
+	colorize *iostreams.ColorScheme
+	mu       sync.Mutex
+	// watching has the last log lines of each watched machine
+	watching map[string][]string
+}
+
+// startLogWatcher streams the logs of the app in the background until the context is done
+func (md *machineDeployment) startLogWatcher(ctx context.Context) {
+	md.logWatcher = &machineLogWatcher{
+		w:        md.io.ErrOut,
+		colorize: md.colorize,
+		watching: map[string][]string{},
+	}
+	entries := make(chan logs.LogEntry)
+	go func() {
+		defer close(entries)
+		err := logs.Poll(ctx, entries, md.apiClient, &logs.LogOptions{AppName: md.app.Name})
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			md.warnf("stopped watching logs: %v\n", err)
+		}
+	}()
+	go func() {
+		for entry := range entries {
+			md.logWatcher.handle(entry)
+		}
+	}()
+}
+
+// followLogs shows the logs of the machine until the returned func is called, which wraps
+// errors with the last lines of the machine. Without --watch-logs it returns errors as they are
+func (md *machineDeployment) followLogs(machineID string) func(error) error {
+	if md.logWatcher == nil {
+		return func(err error) error { return err }
+	}
+	md.logWatcher.watch(machineID)
+	return func(err error) error {
+		lines := md.logWatcher.unwatch(machineID)
+		if err == nil || len(lines) == 0 {
+			return err
+		}
+		return fmt.Errorf("%w\nLast logs of machine %s:\n  %s", err, machineID, strings.Join(lines, "\n  "))
+	}
+}
+
+func (w *machineLogWatcher) watch(machineID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watching[machineID] = []string{}
+}
+
+func (w *machineLogWatcher) unwatch(machineID string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := w.watching[machineID]
+	delete(w.watching, machineID)
+	return lines
+}
+
+func (w *machineLogWatcher) handle(entry logs.LogEntry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines, ok := w.watching[entry.Instance]
+	if !ok {
+		return
+	}
+	fmt.Fprintf(w.w, "    %s | %s\n", w.colorize.Gray(entry.Instance), entry.Message)
+	lines = append(lines, entry.Message)
+	if len(lines) > maxWatchedLogLines {
+		lines = lines[len(lines)-maxWatchedLogLines:]
+	}
+	w.watching[entry.Instance] = lines
+}
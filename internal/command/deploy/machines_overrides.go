@@ -0,0 +1,124 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+)
+
+// machineConfigOverrides are partial machine configs merged over the configs built from fly.toml,
+// for settings fly.toml doesn't have. The file given to --machine-config is a JSON machine config
+// applied to every machine, its "process_groups" key maps process groups to configs merged after it:
+//
+//	{"restart": {"policy": "always"}, "process_groups": {"worker": {"guest": {"cpus": 2}}}}
+//
+// Objects are merged key by key, any other value including arrays replaces the one from fly.toml
+type machineConfigOverrides struct {
+	all    map[string]any
+	groups map[string]map[string]any
+}
+
+// loadMachineConfigOverrides reads and validates the overrides of --machine-config
+func loadMachineConfigOverrides(path string) (*machineConfigOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read machine config overrides: %w", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse machine config overrides %s: %w", path, err)
+	}
+
+	overrides := &machineConfigOverrides{groups: map[string]map[string]any{}}
+	if groups, ok := raw["process_groups"]; ok {
+		delete(raw, "process_groups")
+		groupsMap, ok := groups.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid machine config overrides %s: process_groups must map process groups to machine configs", path)
+		}
+		for name, v := range groupsMap {
+			cfg, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("invalid machine config overrides %s: process group '%s' must be a machine config", path, name)
+			}
+			overrides.groups[name] = cfg
+		}
+	}
+	overrides.all = raw
+
+	for _, cfg := range append([]map[string]any{overrides.all}, lo.Values(overrides.groups)...) {
+		if err := validateMachineConfigOverride(cfg); err != nil {
+			return nil, fmt.Errorf("invalid machine config overrides %s: %w", path, err)
+		}
+	}
+	return overrides, nil
+}
+
+// validateMachineConfigOverride rejects the fields owned by deployments and anything that isn't a machine config
+func validateMachineConfigOverride(cfg map[string]any) error {
+	if _, ok := cfg["image"]; ok {
+		return fmt.Errorf("image is set by the deployment, use --image instead")
+	}
+	if metadata, ok := cfg["metadata"].(map[string]any); ok {
+		for key := range metadata {
+			if strings.HasPrefix(key, "fly_") || key == api.MachineConfigMetadataKeyFlyManagedPostgres {
+				return fmt.Errorf("metadata key '%s' is managed by the platform", key)
+			}
+		}
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(&api.MachineConfig{})
+}
+
+// apply merges the overrides for groupName over cfg, it is the config mutator of --machine-config
+func (o *machineConfigOverrides) apply(groupName string, cfg *api.MachineConfig) error {
+	group, hasGroup := o.groups[groupName]
+	if len(o.all) == 0 && !hasGroup {
+		return nil
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var merged map[string]any
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return err
+	}
+	merged = mergeJSON(mergeJSON(merged, o.all), group)
+	if data, err = json.Marshal(merged); err != nil {
+		return err
+	}
+	var result api.MachineConfig
+	if err := json.Unmarshal(data, &result); err != nil {
+		return err
+	}
+	*cfg = result
+	return nil
+}
+
+// mergeJSON merges src into dst, objects are merged recursively and other values replaced
+func mergeJSON(dst, src map[string]any) map[string]any {
+	if dst == nil {
+		dst = map[string]any{}
+	}
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]any)
+		dstMap, dstIsMap := dst[k].(map[string]any)
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeJSON(dstMap, srcMap)
+		} else {
+			dst[k] = v
+		}
+	}
+	return dst
+}
@@ -0,0 +1,276 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// DeploymentPlan is what a deployment would do to the machines of the app, new machines
+// include the standbys and the spare machines added by --ha
+type DeploymentPlan struct {
+	Create []PlannedMachine `json:"create"`
+	Update []PlannedUpdate  `json:"update"`
+	Remove []PlannedMachine `json:"remove"`
+
+	processGroupsDiff ProcessGroupsDiff
+	machineCountDiffs map[string]*machineCountDiff
+	updateEntries     []*machineUpdateEntry
+}
+
+// PlannedMachine is a machine to create or remove, machines to create have no ID yet. Standby is set for
+// machines created stopped to take over another one, Spare for the ones added by --ha
+type PlannedMachine struct {
+	ID           string `json:"id,omitempty"`
+	ProcessGroup string `json:"process_group"`
+	Region       string `json:"region"`
+	Standby      bool   `json:"standby,omitempty"`
+	Spare        bool   `json:"spare,omitempty"`
+}
+
+// PlannedUpdate is an existing machine to update, Replace is set when it is replaced by a new machine.
+// KeptEnv lists the env keys set on the machine outside of fly.toml that --keep-machine-env keeps
+type PlannedUpdate struct {
+	ID           string             `json:"id"`
+	ProcessGroup string             `json:"process_group"`
+	Region       string             `json:"region"`
+	Replace      bool               `json:"replace"`
+	Changes      []string           `json:"changes,omitempty"`
+	KeptEnv      []string           `json:"kept_env,omitempty"`
+	Config       *api.MachineConfig `json:"config"`
+}
+
+// Plan computes the deployment plan from the machines and app config without changing anything
+func (md *machineDeployment) Plan(ctx context.Context) (*DeploymentPlan, error) {
+	if md.restartOnly {
+		return nil, fmt.Errorf("restarts don't have a deployment plan")
+	}
+
+	plan := &DeploymentPlan{
+		Create:            []PlannedMachine{},
+		Update:            []PlannedUpdate{},
+		Remove:            []PlannedMachine{},
+		processGroupsDiff: md.resolveProcessGroupChanges(),
+	}
+	var err error
+	if plan.machineCountDiffs, err = md.resolveMachineCountChanges(); err != nil {
+		return nil, err
+	}
+
+	// New and updated machines take volumes, plan on a copy of them so the deployment can still take them
+	volumes, claimedVolumes := md.volumes, md.claimedVolumes
+	md.volumes, md.claimedVolumes = cloneVolumes(volumes), maps.Clone(claimedVolumes)
+	defer func() { md.volumes, md.claimedVolumes = volumes, claimedVolumes }()
+
+	for _, lm := range plan.processGroupsDiff.machinesToRemove {
+		plan.Remove = append(plan.Remove, plannedMachine(lm.Machine()))
+	}
+
+	// Machines are planned in the order the deployment creates them so they get the same volumes
+	groupNames := maps.Keys(plan.processGroupsDiff.groupsNeedingMachines)
+	slices.Sort(groupNames)
+	for _, name := range groupNames {
+		groupConfig, err := md.appConfig.Flatten(name)
+		if err != nil {
+			return nil, err
+		}
+		placements, hasCount, err := md.machineRegionsFor(name)
+		if err != nil {
+			return nil, err
+		}
+		settings, err := md.appConfig.MachineSettingsFor(name)
+		if err != nil {
+			return nil, err
+		}
+		active := make([]PlannedMachine, 0, len(placements))
+		for _, region := range placements {
+			active = append(active, md.plannedCreate(groupConfig, name, region))
+		}
+		plan.Create = append(plan.Create, active...)
+
+		if wantsStandbys(groupConfig, settings) {
+			for _, m := range active {
+				standby := md.plannedCreate(groupConfig, name, md.standbyRegionFor(settings, m.Region))
+				standby.Standby = true
+				plan.Create = append(plan.Create, standby)
+			}
+		}
+		switch md.haSpareFor(groupConfig, settings, hasCount, placements) {
+		case haSpareMachine:
+			spare := md.plannedCreate(groupConfig, name, md.haSpareRegion(placements))
+			spare.Spare = true
+			plan.Create = append(plan.Create, spare)
+		case haSpareStandby:
+			spare := md.plannedCreate(groupConfig, name, md.haSpareRegion(placements))
+			spare.Spare, spare.Standby = true, true
+			plan.Create = append(plan.Create, spare)
+		}
+	}
+	if md.reconcileCounts {
+		countGroups := maps.Keys(plan.machineCountDiffs)
+		slices.Sort(countGroups)
+		for _, name := range countGroups {
+			groupConfig, err := md.appConfig.Flatten(name)
+			if err != nil {
+				return nil, err
+			}
+			for _, region := range plan.machineCountDiffs[name].missingRegions {
+				plan.Create = append(plan.Create, md.plannedCreate(groupConfig, name, region))
+			}
+		}
+	}
+
+	removed := lo.SliceToMap(plan.processGroupsDiff.machinesToRemove, func(lm machine.LeasableMachine) (string, bool) {
+		return lm.Machine().ID, true
+	})
+	kept := lo.Filter(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) bool {
+		return !removed[lm.Machine().ID]
+	})
+	if plan.updateEntries, err = md.updateEntriesFor(kept); err != nil {
+		return nil, err
+	}
+	for _, e := range plan.updateEntries {
+		m := e.leasableMachine.Machine()
+		plan.Update = append(plan.Update, PlannedUpdate{
+			ID:           m.ID,
+			ProcessGroup: m.ProcessGroup(),
+			Region:       m.Region,
+			Replace:      e.launchInput.ID != m.ID,
+			Changes:      configChanges(m.Config, e.launchInput.Config),
+			KeptEnv:      e.keptEnv,
+			Config:       e.launchInput.Config,
+		})
+	}
+	return plan, nil
+}
+
+// plannedCreate is a new machine of group in region, it takes the volume it would mount and moves
+// to the region of that volume when none is left in region, like launchInputForLaunch does
+func (md *machineDeployment) plannedCreate(groupConfig *appconfig.Config, group, region string) PlannedMachine {
+	if region == "" {
+		region = md.appConfig.PrimaryRegion
+	}
+	if len(groupConfig.Mounts) > 0 {
+		name := groupConfig.Mounts[0].Source
+		if vol := md.popVolumeFor(name, region); vol == nil && md.volumesInAnyRegion() {
+			if vol = md.popVolumeFor(name, ""); vol != nil {
+				region = vol.Region
+			}
+		}
+	}
+	return PlannedMachine{ProcessGroup: group, Region: region}
+}
+
+// updateEntriesFor computes the launch input updating each of machines, standbys come last so they
+// can follow the machines they watch if these are replaced. With --keep-machine-env the env set on
+// machines outside of fly.toml is kept
+func (md *machineDeployment) updateEntriesFor(machines []machine.LeasableMachine) ([]*machineUpdateEntry, error) {
+	entries := make([]*machineUpdateEntry, 0, len(machines))
+	for _, lm := range machines {
+		li, err := md.launchInputForUpdate(lm.Machine())
+		if err != nil {
+			return nil, fmt.Errorf("failed to update machine configuration for %s: %w", lm.FormattedMachineId(), err)
+		}
+		entry := &machineUpdateEntry{leasableMachine: lm, launchInput: li}
+		if md.keepMachineEnv {
+			entry.keptEnv = keepMachineLocalEnv(lm.Machine().Config, li.Config)
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return len(entries[i].launchInput.Config.Standbys) == 0 && len(entries[j].launchInput.Config.Standbys) > 0
+	})
+	return entries, nil
+}
+
+// cloneVolumes copies the unattached volumes by name so taking volumes from the copy leaves them untouched
+func cloneVolumes(volumes map[string][]api.Volume) map[string][]api.Volume {
+	if volumes == nil {
+		return nil
+	}
+	clone := make(map[string][]api.Volume, len(volumes))
+	for name, vs := range volumes {
+		clone[name] = slices.Clone(vs)
+	}
+	return clone
+}
+
+// isUnchanged tells if the deployment would only bump the release of the machines: the image is the one
+// of the current release, no machine is created or removed and every machine already runs the config
+func (md *machineDeployment) isUnchanged(ctx context.Context) (bool, error) {
+	if md.isFirstDeploy || len(md.stagedSecrets) > 0 {
+		return false, nil
+	}
+	if current, err := md.latestImage(ctx); err != nil || current != md.img {
+		return false, nil
+	}
+	plan, err := md.Plan(ctx)
+	if err != nil {
+		return false, err
+	}
+	return planIsUnchanged(plan), nil
+}
+
+func planIsUnchanged(plan *DeploymentPlan) bool {
+	if len(plan.Create) > 0 || len(plan.Remove) > 0 || len(plan.updateEntries) == 0 {
+		return false
+	}
+	// Release data and the stashed previous config change on every deployment, they are ignored
+	return lo.EveryBy(plan.updateEntries, func(e *machineUpdateEntry) bool {
+		m := e.leasableMachine.Machine()
+		return e.launchInput.ID == m.ID && m.Config.Image == e.launchInput.Config.Image && sameMachineConfig(m.Config, e.launchInput.Config)
+	})
+}
+
+func plannedMachine(m *api.Machine) PlannedMachine {
+	return PlannedMachine{ID: m.ID, ProcessGroup: m.ProcessGroup(), Region: m.Region}
+}
+
+// configChanges lists the changes between two machine configs that aren't obvious from fly.toml
+func configChanges(from, to *api.MachineConfig) []string {
+	changes := append(autostopChanges(from, to), concurrencyChanges(from, to)...)
+	if change := metricsChange(from, to); change != "" {
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// renderPlan shows the machines a deployment would create, update and remove
+func renderPlan(w io.Writer, plan *DeploymentPlan) {
+	if len(plan.Create)+len(plan.Update)+len(plan.Remove) == 0 {
+		fmt.Fprintln(w, "No machines would change")
+		return
+	}
+	for _, m := range plan.Remove {
+		fmt.Fprintf(w, "- remove machine %s of group %s in %s\n", m.ID, m.ProcessGroup, m.Region)
+	}
+	for _, m := range plan.Create {
+		kind := ""
+		switch {
+		case m.Standby:
+			kind = "standby "
+		case m.Spare:
+			kind = "spare "
+		}
+		fmt.Fprintf(w, "+ create a %smachine of group %s in %s\n", kind, m.ProcessGroup, m.Region)
+	}
+	for _, u := range plan.Update {
+		action := lo.Ternary(u.Replace, "replace", "update")
+		fmt.Fprintf(w, "~ %s machine %s of group %s in %s\n", action, u.ID, u.ProcessGroup, u.Region)
+		if len(u.Changes) > 0 {
+			fmt.Fprintf(w, "    %s\n", strings.Join(u.Changes, "\n    "))
+		}
+		if len(u.KeptEnv) > 0 {
+			fmt.Fprintf(w, "    keeps env %s set outside of fly.toml\n", strings.Join(u.KeptEnv, ", "))
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+func Test_Plan(t *testing.T) {
+	cfg := &appconfig.Config{
+		PrimaryRegion: "fra",
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Command: "run worker"},
+		},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{
+		{ID: "m1", Region: "fra", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m2", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "old"}}},
+	})
+
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedMachine{{ID: "m2", ProcessGroup: "old", Region: "ams"}}, plan.Remove)
+	assert.Equal(t, []PlannedMachine{{ProcessGroup: "worker", Region: "fra"}}, plan.Create)
+	require.Len(t, plan.Update, 1)
+	assert.Equal(t, "m1", plan.Update[0].ID)
+	assert.False(t, plan.Update[0].Replace)
+	assert.Equal(t, "super/balloon", plan.Update[0].Config.Image)
+
+	var b bytes.Buffer
+	renderPlan(&b, plan)
+	assert.Equal(t, "- remove machine m2 of group old in ams\n"+
+		"+ create a machine of group worker in fra\n"+
+		"~ update machine m1 of group web in fra\n", b.String())
+
+	md.restartOnly = true
+	_, err = md.Plan(context.Background())
+	assert.Error(t, err)
+}
+
+func Test_planIsUnchanged(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Env:           map[string]string{"FOO": "bar"},
+	})
+	require.NoError(t, err)
+	md.releaseId = "rel_1"
+	li, err := md.launchInputForLaunch("", "fra", nil, nil)
+	require.NoError(t, err)
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{{ID: "m1", Region: "fra", Config: li.Config}})
+
+	// A new release alone doesn't change anything
+	md.releaseId = "rel_2"
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.True(t, planIsUnchanged(plan))
+
+	md.appConfig.Env["FOO"] = "changed"
+	plan, err = md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.False(t, planIsUnchanged(plan))
+
+	md.appConfig.Env["FOO"] = "bar"
+	md.img = "super/other"
+	plan, err = md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.False(t, planIsUnchanged(plan))
+}
+
+func Test_Plan_keepsVolumes(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Mounts:        []appconfig.Mount{{Source: "data", Destination: "/data"}},
+	})
+	require.NoError(t, err)
+	md.volumes = map[string][]api.Volume{"data": {{ID: "vol_1", Name: "data", Region: "fra"}}}
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{platformMachine("m1", "app")})
+
+	// Adding a mount replaces m1 by a machine with the only volume, every plan finds it
+	for i := 0; i < 2; i++ {
+		plan, err := md.Plan(context.Background())
+		require.NoError(t, err)
+		require.Len(t, plan.Update, 1)
+		assert.True(t, plan.Update[0].Replace)
+		assert.Equal(t, "vol_1", plan.Update[0].Config.Mounts[0].Volume)
+	}
+	assert.Len(t, md.volumes["data"], 1)
+	assert.Empty(t, md.claimedVolumes)
+
+	// The deployment takes it for real
+	entries, err := md.updateEntriesFor(md.machineSet.GetMachines())
+	require.NoError(t, err)
+	assert.Equal(t, "vol_1", entries[0].launchInput.Config.Mounts[0].Volume)
+	assert.Empty(t, md.volumes["data"])
+	assert.True(t, md.claimedVolumes["vol_1"])
+}
+
+func Test_Plan_spares(t *testing.T) {
+	standby := true
+	cfg := &appconfig.Config{
+		PrimaryRegion: "fra",
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Command: "run worker"},
+			"db":     {Command: "run db"},
+			"queue":  {Command: "run queue"},
+		},
+		HTTPService: &appconfig.HTTPService{InternalPort: 8080, Processes: []string{"web"}},
+		Mounts:      []appconfig.Mount{{Source: "data", Destination: "/data", Processes: []string{"db"}}},
+		// db has mounts, its standby setting is ignored
+		Machines: []appconfig.MachineSettings{{Processes: []string{"queue", "db"}, Standby: &standby}},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.increasedAvailability = true
+	md.spareRegion = "ams"
+	md.volumes = map[string][]api.Volume{"data": {{ID: "vol_1", Name: "data", Region: "fra"}}}
+	md.machineSet = machine.NewMachineSet(nil, md.io, nil)
+
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedMachine{
+		// The only volume goes to the first machine of db, there is none left for a spare
+		{ProcessGroup: "db", Region: "fra"},
+		{ProcessGroup: "queue", Region: "fra"},
+		{ProcessGroup: "queue", Region: "ams", Standby: true},
+		{ProcessGroup: "web", Region: "fra"},
+		{ProcessGroup: "web", Region: "ams", Spare: true},
+		{ProcessGroup: "worker", Region: "fra"},
+		{ProcessGroup: "worker", Region: "ams", Standby: true, Spare: true},
+	}, plan.Create)
+	assert.Len(t, md.volumes["data"], 1)
+
+	var b bytes.Buffer
+	renderPlan(&b, plan)
+	assert.Contains(t, b.String(), "+ create a standby machine of group queue in ams\n")
+	assert.Contains(t, b.String(), "+ create a spare machine of group web in ams\n")
+}
+
+func Test_Plan_spareInFirstRegion(t *testing.T) {
+	cfg := &appconfig.Config{
+		Regions:     []string{"lhr"},
+		HTTPService: &appconfig.HTTPService{InternalPort: 8080},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.increasedAvailability = true
+	md.machineSet = machine.NewMachineSet(nil, md.io, nil)
+
+	// Without a primary region or --ha-region the spare joins the first machine
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []PlannedMachine{
+		{ProcessGroup: "app", Region: "lhr"},
+		{ProcessGroup: "app", Region: "lhr", Spare: true},
+	}, plan.Create)
+}
+
+func Test_Plan_dryRunDoesNotPrompt(t *testing.T) {
+	fb := newFakeBackend(t)
+	fb.ios.SetStdinTTY(true)
+	fb.ios.SetStdoutTTY(true)
+	fb.app.Deployed = false
+	fb.onGraphQL("secrets", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"secrets": []api.Secret{{Name: "FOO"}}}}
+	})
+	fb.onGraphQL("releasesUnprocessed", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"releases": map[string]any{"nodes": []api.Release{{Status: "complete"}}}}}
+	})
+	fb.volumes = []api.MachineVolume{{ID: "vol_1", Name: "data", Region: "fra", State: "created"}}
+
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra", Env: map[string]string{"FOO": "bar"}})
+	args := fb.args()
+	args.DryRun = true
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	plan, err := md.Plan(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []PlannedMachine{{ProcessGroup: "app", Region: "fra"}}, plan.Create)
+	assert.Contains(t, fb.ErrOut.String(), "[env] values override secrets with the same name: FOO")
+	// The volumes waiting for new machines are listed with the Machines API, not GraphQL
+	assert.Contains(t, fb.ErrOut.String(), "has no machines but was successfully deployed before and has 1 volumes")
+}
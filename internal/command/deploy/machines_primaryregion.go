@@ -0,0 +1,97 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+// primaryRegionMismatch returns the machines of the default process group left in the previous
+// primary region when none of them runs in the primary region of fly.toml. Machines deployed with
+// a different PRIMARY_REGION than the region they run in were placed elsewhere on purpose and stay
+func (md *machineDeployment) primaryRegionMismatch(entries []*machineUpdateEntry) []*machineUpdateEntry {
+	primary := md.appConfig.PrimaryRegion
+	group := md.appConfig.DefaultProcessName()
+	inGroup := lo.Filter(entries, func(e *machineUpdateEntry, _ int) bool {
+		m := e.leasableMachine.Machine()
+		return m.ProcessGroup() == group && e.launchInput.ID == m.ID && len(m.Config.Standbys) == 0
+	})
+	if primary == "" || len(inGroup) == 0 || lo.ContainsBy(inGroup, func(e *machineUpdateEntry) bool {
+		return e.leasableMachine.Machine().Region == primary
+	}) {
+		return nil
+	}
+	return lo.Filter(inGroup, func(e *machineUpdateEntry, _ int) bool {
+		m := e.leasableMachine.Machine()
+		previous := m.Config.Env["PRIMARY_REGION"]
+		return previous == "" || previous == m.Region
+	})
+}
+
+// handlePrimaryRegionChange warns about the machines left out of the primary region, or moves them
+// with --migrate-primary-region. It returns the update entries of the machines that weren't moved
+func (md *machineDeployment) handlePrimaryRegionChange(ctx context.Context, entries []*machineUpdateEntry) ([]*machineUpdateEntry, error) {
+	mismatched := md.primaryRegionMismatch(entries)
+	if len(mismatched) == 0 {
+		return entries, nil
+	}
+	primary := md.appConfig.PrimaryRegion
+	group := md.appConfig.DefaultProcessName()
+	if !md.migratePrimaryRegion {
+		md.warnf("primary_region is %s but %d machines of group '%s' run elsewhere, they are updated where they are. "+
+			"Use --migrate-primary-region to replace them with machines in %s\n", primary, len(mismatched), group, primary)
+		return entries, nil
+	}
+
+	// Machines with volumes can only move when an unattached volume is waiting in the new region
+	movable := lo.Filter(mismatched, func(e *machineUpdateEntry, _ int) bool {
+		m := e.leasableMachine.Machine()
+		if len(m.Config.Mounts) == 0 {
+			return true
+		}
+		if lo.ContainsBy(md.volumes[m.Config.Mounts[0].Name], func(v api.Volume) bool { return v.Region == primary }) {
+			return true
+		}
+		md.warnf("Machine %s stays in %s, it needs an unattached volume named '%s' in %s to move\n",
+			m.ID, m.Region, m.Config.Mounts[0].Name, primary)
+		return false
+	})
+	if len(movable) == 0 {
+		return entries, nil
+	}
+
+	fmt.Fprintf(md.io.Out, "Moving %d machines of group %s to the primary region %s\n", len(movable), md.colorize.Bold(group), primary)
+	moved := map[string]bool{}
+	for i, e := range movable {
+		old := e.leasableMachine
+		newMachine, err := md.spawnMachineInGroup(ctx, group, primary, i, len(movable), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a machine in %s to replace %s: %w", primary, old.FormattedMachineId(), err)
+		}
+		md.infof("Machine %s in %s replaces machine %s in %s\n", newMachine.ID, newMachine.Region, old.Machine().ID, old.Machine().Region)
+
+		started := time.Now()
+		toDestroy, _ := md.machineSet.Partition(func(lm machine.LeasableMachine) bool { return lm.Machine().ID == old.Machine().ID })
+		results, err := toDestroy.DestroyMachines(ctx, md.destroyOptions())
+		for _, r := range results {
+			md.recordOutcome(r.Machine.Machine(), "destroyed", started, r.Err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		md.machinef(old.Machine().ID, MachineStateDestroyed, "  Machine %s was destroyed\n", md.colorize.Bold(old.FormattedMachineId()))
+		moved[old.Machine().ID] = true
+	}
+	if err := md.machineSet.RemoveMachines(ctx, lo.Map(movable, func(e *machineUpdateEntry, _ int) machine.LeasableMachine {
+		return e.leasableMachine
+	})); err != nil {
+		return nil, err
+	}
+	return lo.Filter(entries, func(e *machineUpdateEntry, _ int) bool {
+		return !moved[e.leasableMachine.Machine().ID]
+	}), nil
+}
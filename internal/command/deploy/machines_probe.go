@@ -0,0 +1,90 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jpillora/backoff"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+const (
+	defaultProbeTimeout = 10 * time.Second
+	// probeAttempts rides out the proxy picking up the new machines
+	probeAttempts = 5
+)
+
+// postDeployProbe is an HTTP request to the public URL of the app made once its machines are healthy
+type postDeployProbe struct {
+	url            string
+	expectedStatus int
+	timeout        time.Duration
+}
+
+// newPostDeployProbe returns the probe of the [deploy] section, probeURL overrides its url. It is nil without url
+func newPostDeployProbe(deploy *appconfig.Deploy, probeURL string) *postDeployProbe {
+	probe := &postDeployProbe{url: probeURL, expectedStatus: http.StatusOK, timeout: defaultProbeTimeout}
+	if deploy != nil && deploy.PostDeployProbe != nil {
+		cfg := deploy.PostDeployProbe
+		if probe.url == "" {
+			probe.url = cfg.URL
+		}
+		if cfg.ExpectedStatus != 0 {
+			probe.expectedStatus = cfg.ExpectedStatus
+		}
+		if cfg.Timeout != nil && cfg.Timeout.Duration > 0 {
+			probe.timeout = cfg.Timeout.Duration
+		}
+	}
+	if probe.url == "" {
+		return nil
+	}
+	return probe
+}
+
+// runPostDeployProbe requests the probe URL until it answers the expected status, a probe that never
+// does fails the deployment as users would see the same failure even with healthy machines
+func (md *machineDeployment) runPostDeployProbe(ctx context.Context) error {
+	probe := md.probe
+	fmt.Fprintf(md.io.ErrOut, "Probing %s for status %d\n", probe.url, probe.expectedStatus)
+	b := &backoff.Backoff{Min: time.Second, Max: 10 * time.Second, Factor: 2}
+	var err error
+	for attempt := 1; attempt <= probeAttempts; attempt++ {
+		if err = probe.do(ctx); err == nil {
+			fmt.Fprintf(md.io.ErrOut, "  Probe %s answered %s\n", probe.url, md.colorize.Green(fmt.Sprint(probe.expectedStatus)))
+			return nil
+		}
+		if attempt == probeAttempts {
+			break
+		}
+		fmt.Fprintf(md.io.ErrOut, "  Probe attempt %d of %d failed: %s\n", attempt, probeAttempts, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.Duration()):
+		}
+	}
+	return fmt.Errorf("post deploy probe of %s failed after %d attempts: %w", probe.url, probeAttempts, err)
+}
+
+func (p *postDeployProbe) do(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != p.expectedStatus {
+		return fmt.Errorf("got status %d instead of %d", resp.StatusCode, p.expectedStatus)
+	}
+	return nil
+}
@@ -13,22 +13,52 @@ import (
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/helpers"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/tracing"
 )
 
-func (md *machineDeployment) runReleaseCommand(ctx context.Context) error {
-	if md.appConfig.Deploy == nil || md.appConfig.Deploy.ReleaseCommand == "" {
+// runsReleaseCommand is true when the app has a release command and it isn't a restart,
+// unless explicitly asked to run it on restarts too
+func (md *machineDeployment) runsReleaseCommand() bool {
+	if !md.appConfig.Deploy.HasReleaseCommand() || md.skipReleaseCommand {
+		return false
+	}
+	return !md.restartOnly || md.withReleaseCommand
+}
+
+// releaseCommandPollBackoff polls release command machines faster than the fleet, the deployment waits on them
+var releaseCommandPollBackoff = machine.PollBackoff{Min: 200 * time.Millisecond, Max: time.Second}
+
+func (md *machineDeployment) runReleaseCommand(ctx context.Context) (err error) {
+	if !md.runsReleaseCommand() {
+		if md.restartOnly && md.appConfig.Deploy.HasReleaseCommand() {
+			fmt.Fprintf(md.io.ErrOut, "Skipping %s release_command on restart, use --with-release-command to run it\n", md.colorize.Bold(md.app.Name))
+		}
 		return nil
 	}
 
-	fmt.Fprintf(md.io.ErrOut, "Running %s release_command: %s\n",
+	if md.hooks.BeforeReleaseCommand != nil {
+		if err := md.hooks.BeforeReleaseCommand(ctx); err != nil {
+			return fmt.Errorf("before release command hook: %w", err)
+		}
+	}
+
+	ctx, span := tracing.Start(ctx, "release command", md.spanAttributes()...)
+	defer func() { span.End(err) }()
+
+	md.phasef(PhaseReleaseCommand, "Running %s release_command: %s\n",
 		md.colorize.Bold(md.app.Name),
-		md.appConfig.Deploy.ReleaseCommand,
+		md.appConfig.Deploy.ReleaseCommandString(),
 	)
-	err := md.createOrUpdateReleaseCmdMachine(ctx)
+	err = md.createOrUpdateReleaseCmdMachine(ctx)
 	if err != nil {
 		return fmt.Errorf("error running release_command machine: %w", err)
 	}
 	releaseCmdMachine := md.releaseCommandMachine.GetMachines()[0]
+	span.SetAttributes(
+		tracing.String("machine.id", releaseCmdMachine.Machine().ID),
+		tracing.String("machine.region", releaseCmdMachine.Machine().Region),
+	)
+	releaseCmdMachine.SetPollBackoff(releaseCommandPollBackoff)
 	// FIXME: consolidate this wait stuff with deploy waits? Especially once we improve the outpu
 	err = md.waitForReleaseCommandToFinish(ctx, releaseCmdMachine)
 	if err != nil {
@@ -118,6 +148,7 @@ func (md *machineDeployment) launchInputForReleaseCommand(origMachineRaw *api.Ma
 
 	return &api.LaunchMachineInput{
 		ID:      origMachineRaw.ID,
+		Name:    origMachineRaw.Name,
 		AppID:   md.app.Name,
 		OrgSlug: md.app.Organization.ID,
 		Config:  mConfig,
@@ -0,0 +1,64 @@
+package deploy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_replaceOnFailure(t *testing.T) {
+	for name, configure := range map[string]func(*MachineDeploymentArgs){
+		"rolling": func(args *MachineDeploymentArgs) {},
+		"immediate": func(args *MachineDeploymentArgs) {
+			args.Strategy = "immediate"
+			args.ImmediateConcurrency = 2
+		},
+		"batched restart": func(args *MachineDeploymentArgs) {
+			args.RestartOnly = true
+			args.DeploymentImage = ""
+			args.MaxConcurrent = 2
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"))
+			fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/machines/m1") {
+					return false
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "host is out of capacity"})
+				return true
+			}
+			ctx := fb.context(&appconfig.Config{})
+			args := fb.args()
+			args.ReplaceOnFailure = true
+			configure(&args)
+
+			md, err := NewMachineDeployment(ctx, args)
+			require.NoError(t, err)
+			require.NoError(t, md.DeployMachinesApp(ctx))
+
+			assert.Nil(t, fb.machine("m1"))
+			replacement := fb.machine("new1")
+			require.NotNil(t, replacement)
+			assert.Equal(t, api.MachineStateStarted, replacement.State)
+			assert.Equal(t, "fra", replacement.Region)
+			assert.Contains(t, fb.ErrOut.String(), "Machines replaced after failing to update")
+		})
+	}
+}
+
+func Test_canReplaceOnFailure(t *testing.T) {
+	entry := func(config *api.MachineConfig) *machineUpdateEntry {
+		return &machineUpdateEntry{launchInput: &api.LaunchMachineInput{Config: config}}
+	}
+	assert.True(t, canReplaceOnFailure(entry(&api.MachineConfig{Image: "super/balloon"})))
+	assert.False(t, canReplaceOnFailure(entry(&api.MachineConfig{Mounts: []api.MachineMount{{Volume: "vol_123", Path: "/data"}}})))
+	assert.False(t, canReplaceOnFailure(entry(&api.MachineConfig{Standbys: []string{"m1"}})))
+}
@@ -0,0 +1,124 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+)
+
+// DeploymentResult is what a machines deployment did, filled even when it failed part way
+type DeploymentResult struct {
+	ReleaseID      string           `json:"release_id"`
+	ReleaseVersion int              `json:"release_version"`
+	Status         string           `json:"status"`
+	Image          string           `json:"image"`
+	Machines       []MachineOutcome `json:"machines"`
+	// Skipped are the stopped machines left out of the deployment with --skip-stopped
+	Skipped []SkippedMachine `json:"skipped,omitempty"`
+	// Private is set for apps deployed without public IPs on purpose
+	Private bool   `json:"private,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SkippedMachine is a machine the deployment left untouched, with the image it still runs
+type SkippedMachine struct {
+	ID           string `json:"id"`
+	ProcessGroup string `json:"process_group,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Image        string `json:"image"`
+}
+
+// MachineOutcome is what happened to a single machine during a deployment
+type MachineOutcome struct {
+	ID string `json:"id"`
+	// Action is one of created, updated, replaced or destroyed, stopped machines updated without
+	// starting them are "updated (left stopped)"
+	Action       string        `json:"action"`
+	ProcessGroup string        `json:"process_group,omitempty"`
+	Region       string        `json:"region,omitempty"`
+	Duration     time.Duration `json:"duration"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// MarshalJSON writes the duration in seconds
+func (o MachineOutcome) MarshalJSON() ([]byte, error) {
+	type outcome MachineOutcome
+	return json.Marshal(struct {
+		outcome
+		Duration float64 `json:"duration"`
+	}{outcome(o), o.Duration.Seconds()})
+}
+
+// actionLeftStopped is the action of stopped machines updated without starting them
+const actionLeftStopped = "updated (left stopped)"
+
+// Failed returns the outcomes of the machines that failed
+func (r *DeploymentResult) Failed() []MachineOutcome {
+	return lo.Filter(r.Machines, func(o MachineOutcome, _ int) bool { return o.Error != "" })
+}
+
+// Summary counts the machines by action, failures apart
+func (r *DeploymentResult) Summary() string {
+	failed := len(r.Failed())
+	counts := lo.CountValues(lo.FilterMap(r.Machines, func(o MachineOutcome, _ int) (string, bool) {
+		return o.Action, o.Error == ""
+	}))
+	var parts []string
+	for _, action := range []string{"created", "updated", actionLeftStopped, "replaced", "destroyed"} {
+		if n := counts[action]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, action))
+		}
+	}
+	if len(r.Skipped) > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", len(r.Skipped)))
+	}
+	if failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", failed))
+	}
+	private := lo.Ternary(r.Private, " (private app, no public IPs)", "")
+	if len(parts) == 0 {
+		return fmt.Sprintf("Release v%d %s, no machines changed%s", r.ReleaseVersion, r.Status, private)
+	}
+	return fmt.Sprintf("Release v%d %s: %s%s", r.ReleaseVersion, r.Status, strings.Join(parts, ", "), private)
+}
+
+// recordOutcome adds what happened to a machine to the result of the deployment, it is safe for concurrent use
+func (md *machineDeployment) recordOutcome(m *api.Machine, action string, started time.Time, err error) {
+	outcome := MachineOutcome{
+		ID:           m.ID,
+		Action:       action,
+		ProcessGroup: m.ProcessGroup(),
+		Region:       m.Region,
+		Duration:     time.Since(started),
+	}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	md.outcomesMu.Lock()
+	defer md.outcomesMu.Unlock()
+	md.outcomes = append(md.outcomes, outcome)
+}
+
+func (md *machineDeployment) result(status string, err error) *DeploymentResult {
+	md.outcomesMu.Lock()
+	defer md.outcomesMu.Unlock()
+	result := &DeploymentResult{
+		ReleaseID:      md.releaseId,
+		ReleaseVersion: md.releaseVersion,
+		Status:         status,
+		Image:          md.img,
+		Private:        md.noPublicIPs,
+		Machines:       append([]MachineOutcome{}, md.outcomes...),
+		Skipped: lo.Map(md.skippedMachines, func(m *api.Machine, _ int) SkippedMachine {
+			return SkippedMachine{ID: m.ID, ProcessGroup: m.ProcessGroup(), Region: m.Region, Image: m.FullImageRef()}
+		}),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,40 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_deploymentResult(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.releaseId = "rel_123"
+	md.releaseVersion = 7
+
+	started := time.Now()
+	md.recordOutcome(&api.Machine{ID: "m1", Region: "ams"}, "updated", started, nil)
+	md.recordOutcome(&api.Machine{ID: "m2", Region: "ams"}, "updated", started, errors.New("failed to update VM m2"))
+	md.recordOutcome(&api.Machine{ID: "m3", Region: "cdg"}, "created", started, nil)
+
+	result := md.result("failed", errors.New("1 of 2 machines failed to update"))
+	assert.Equal(t, "rel_123", result.ReleaseID)
+	assert.Equal(t, "super/balloon", result.Image)
+	assert.Len(t, result.Machines, 3)
+	assert.Equal(t, []string{"m2"}, lo.Map(result.Failed(), func(o MachineOutcome, _ int) string { return o.ID }))
+	assert.Equal(t, "Release v7 failed: 1 created, 1 updated, 1 failed", result.Summary())
+	assert.Equal(t, "Release v7 complete, no machines changed", (&DeploymentResult{ReleaseVersion: 7, Status: "complete"}).Summary())
+}
+
+func Test_MachineOutcome_json(t *testing.T) {
+	b, err := json.Marshal(MachineOutcome{ID: "m1", Action: "updated", Duration: 1500 * time.Millisecond})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"m1","action":"updated","duration":1.5}`, string(b))
+}
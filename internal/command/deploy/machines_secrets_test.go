@@ -0,0 +1,68 @@
+package deploy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+// stagedSecret makes the backend answer with a secret set after its latest complete release, and a machine
+// restarted since, which doesn't apply it
+func stagedSecret(fb *fakeBackend) {
+	released := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	fb.onGraphQL("secrets", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"secrets": []api.Secret{
+			{Name: "OLD", CreatedAt: released.Add(-time.Hour)},
+			{Name: "STAGED", CreatedAt: released.Add(time.Hour)},
+		}}}
+	})
+	fb.onGraphQL("releasesUnprocessed", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"releases": map[string]any{"nodes": []api.Release{
+			{Version: 1, Status: "complete", CreatedAt: released},
+		}}}}
+	})
+}
+
+func Test_stagedSecrets_applied(t *testing.T) {
+	m1 := platformMachine("m1", "app")
+	m1.UpdatedAt = "2023-05-01T12:00:00Z"
+	fb := newFakeBackend(t, m1)
+	stagedSecret(fb)
+	ctx := fb.context(&appconfig.Config{})
+	// Staged secrets are found whether required secrets are checked or not
+	args := fb.args()
+	args.SkipSecretCheck = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	assert.Contains(t, fb.Out.String(), "Staged secrets will be applied with this release: STAGED\n")
+	assert.Contains(t, fb.Out.String(), "1 staged secret was applied with release v2\n")
+}
+
+func Test_stagedSecrets_failedDeployment(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	stagedSecret(fb)
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/v1/apps/my-cool-app/machines/m1/wait" {
+			return false
+		}
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"machine not found"}`))
+		return true
+	}
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	require.Error(t, md.DeployMachinesApp(ctx))
+
+	assert.NotContains(t, fb.Out.String(), "applied with release")
+	assert.Contains(t, fb.ErrOut.String(), "1 staged secret remains set but only machines updated before the failure use them, they will be applied by the next deployment")
+}
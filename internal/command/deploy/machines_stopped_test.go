@@ -0,0 +1,95 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func stoppedMachine(id string) *api.Machine {
+	m := platformMachine(id, "app")
+	m.State = api.MachineStateStopped
+	return m
+}
+
+func Test_stoppedMachines(t *testing.T) {
+	fb := newFakeBackend(t, stoppedMachine("m1"), platformMachine("m2", "app"))
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	// The stopped machine gets the new image without being started or waited for
+	m1 := fb.machine("m1")
+	assert.Equal(t, "registry.fly.io/my-cool-app:deployment-1", m1.Config.Image)
+	assert.Equal(t, api.MachineStateStopped, m1.State)
+	assert.NotContains(t, fb.requested(), "POST /machines/m1/start")
+	assert.NotContains(t, fb.requested(), "GET /machines/m1/wait")
+	assert.Contains(t, fb.requested(), "GET /machines/m2/wait")
+	assert.Contains(t, fb.ErrOut.String(), "Machine m1 [app] updated (left stopped)")
+	assert.Contains(t, fb.ErrOut.String(), "Release v2 complete: 1 updated, 1 updated (left stopped)")
+}
+
+func Test_stoppedMachines_startStopped(t *testing.T) {
+	fb := newFakeBackend(t, stoppedMachine("m1"), platformMachine("m2", "app"))
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.StartStopped = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	assert.Equal(t, api.MachineStateStarted, fb.machine("m1").State)
+	assert.Contains(t, fb.requested(), "GET /machines/m1/wait")
+	assert.Contains(t, fb.ErrOut.String(), "Release v2 complete: 2 updated")
+}
+
+func Test_stoppedMachines_standby(t *testing.T) {
+	standby := stoppedMachine("m1")
+	standby.Config.Standbys = []string{"m2"}
+	fb := newFakeBackend(t, standby, platformMachine("m2", "app"))
+	ctx := fb.context(&appconfig.Config{})
+
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	// Standbys are stopped on purpose, they are updated as standbys rather than left stopped
+	assert.Equal(t, api.MachineStateStopped, fb.machine("m1").State)
+	assert.Contains(t, fb.ErrOut.String(), "Standby machine m1 [app] update finished")
+	assert.NotContains(t, fb.ErrOut.String(), "left stopped")
+}
+
+func Test_stoppedMachines_skipStopped(t *testing.T) {
+	fb := newFakeBackend(t, stoppedMachine("m1"), platformMachine("m2", "app"))
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.SkipStopped = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	// Skipped machines aren't leased nor updated
+	assert.Equal(t, "registry.fly.io/my-cool-app:deployment-0", fb.machine("m1").Config.Image)
+	assert.NotContains(t, fb.requested(), "POST /machines/m1/lease")
+	assert.NotContains(t, fb.requested(), "POST /machines/m1")
+	assert.Contains(t, fb.ErrOut.String(), "Release v2 complete: 1 updated, 1 skipped")
+	assert.Contains(t, fb.ErrOut.String(), "Skipped stopped machine m1 in 'app' running")
+}
+
+func Test_stoppedMachines_startAndSkip(t *testing.T) {
+	fb := newFakeBackend(t, stoppedMachine("m1"))
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.StartStopped = true
+	args.SkipStopped = true
+
+	_, err := NewMachineDeployment(ctx, args)
+	assert.EqualError(t, err, "--start-stopped can't be used with --skip-stopped, stopped machines are either started or left out of the deployment")
+}
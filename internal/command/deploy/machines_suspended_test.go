@@ -0,0 +1,64 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_NewMachineDeployment_suspendedApp(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	fb.app.Status = "suspended"
+	ctx := fb.context(&appconfig.Config{})
+
+	_, err := NewMachineDeployment(ctx, fb.args())
+	require.Error(t, err)
+	assert.Equal(t, "app my-cool-app is suspended; run `fly apps resume my-cool-app` or deploy with --resume", err.Error())
+	// The machines were listed, nothing else was asked of them
+	assert.Equal(t, []string{"GET /machines"}, fb.requested())
+}
+
+func Test_NewMachineDeployment_suspendedWithoutMachines(t *testing.T) {
+	// Machines apps scaled to zero are reported suspended, deploying them isn't an error
+	fb := newFakeBackend(t)
+	fb.app.Status = "suspended"
+	ctx := fb.context(&appconfig.Config{})
+
+	_, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+
+	// Neither are the restarts of `fly secrets`
+	fb = newFakeBackend(t, platformMachine("m1", "app"))
+	fb.app.Status = "suspended"
+	ctx = fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.DeploymentImage = ""
+	args.RestartOnly = true
+
+	_, err = NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+}
+
+func Test_NewMachineDeployment_resumesSuspendedApp(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	fb.app.Status = "suspended"
+	resumed := 0
+	fb.onGraphQL("resumeApp", func(vars map[string]any) any {
+		resumed++
+		assert.Equal(t, map[string]any{"appId": "my-cool-app"}, vars["input"])
+		return map[string]any{"resumeApp": map[string]any{"app": api.AppCompact{ID: fb.app.ID, Name: fb.app.Name, Status: "pending"}}}
+	})
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.ResumeSuspended = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	assert.NotNil(t, md)
+	assert.Equal(t, 1, resumed)
+	assert.Equal(t, "pending", fb.app.Status)
+	assert.Contains(t, fb.ErrOut.String(), "App my-cool-app is suspended, resuming it\n")
+}
@@ -1,17 +1,37 @@
 package deploy
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/helpers"
 	"github.com/superfly/flyctl/internal/appconfig"
 	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
+	"github.com/superfly/flyctl/logs"
 )
 
 func stabMachineDeployment(appConfig *appconfig.Config) (*machineDeployment, error) {
+	ios, _, _, _ := iostreams.Test()
 	md := &machineDeployment{
+		io:       ios,
+		colorize: ios.ColorScheme(),
 		app: &api.AppCompact{
 			ID: "my-cool-app",
 			Organization: &api.OrganizationBasic{
@@ -34,9 +54,9 @@ func Test_resolveUpdatedMachineConfig_Basic(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	li, err := md.launchInputForLaunch("", nil, nil)
+	li, err := md.launchInputForLaunch("", "", nil, nil)
 	require.NoError(t, err)
-	assert.Equal(t, &api.LaunchMachineInput{
+	want := &api.LaunchMachineInput{
 		OrgSlug: "my-dangling-org",
 		Config: &api.MachineConfig{
 			Env: map[string]string{
@@ -50,9 +70,12 @@ func Test_resolveUpdatedMachineConfig_Basic(t *testing.T) {
 				"fly_process_group":    "app",
 				"fly_release_id":       "",
 				"fly_release_version":  "0",
+				"fly_toml_env_keys":    "OTHER,PRIMARY_REGION",
 			},
 		},
-	}, li)
+	}
+	machine.StampDeployedConfig(want.Config)
+	assert.Equal(t, want, li)
 }
 
 // Test any LaunchMachineInput field that must not be set on a machine
@@ -64,10 +87,10 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 			"PRIMARY_REGION": "scl",
 			"OTHER":          "value",
 		},
-		Metrics: &api.MachineMetrics{
+		Metrics: []*appconfig.Metrics{{
 			Port: 9000,
 			Path: "/prometheus",
-		},
+		}},
 		Deploy: &appconfig.Deploy{
 			ReleaseCommand: "touch sky",
 		},
@@ -97,9 +120,9 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 	}
 
 	// New app machine
-	li, err := md.launchInputForLaunch("", nil, nil)
+	li, err := md.launchInputForLaunch("", "", nil, nil)
 	require.NoError(t, err)
-	assert.Equal(t, &api.LaunchMachineInput{
+	want := &api.LaunchMachineInput{
 		OrgSlug: "my-dangling-org",
 		Config: &api.MachineConfig{
 			Env: map[string]string{
@@ -113,6 +136,7 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 				"fly_process_group":    "app",
 				"fly_release_id":       "",
 				"fly_release_version":  "0",
+				"fly_toml_env_keys":    "OTHER,PRIMARY_REGION",
 			},
 			Metrics: &api.MachineMetrics{
 				Port: 9000,
@@ -138,7 +162,9 @@ func Test_resolveUpdatedMachineConfig_ReleaseCommand(t *testing.T) {
 				},
 			},
 		},
-	}, li)
+	}
+	machine.StampDeployedConfig(want.Config)
+	assert.Equal(t, want, li)
 
 	// New release command machine
 	assert.Equal(t, &api.LaunchMachineInput{
@@ -231,9 +257,9 @@ func Test_resolveUpdatedMachineConfig_Mounts(t *testing.T) {
 	}
 
 	// New app machine
-	li, err := md.launchInputForLaunch("", nil, nil)
+	li, err := md.launchInputForLaunch("", "", nil, nil)
 	require.NoError(t, err)
-	assert.Equal(t, &api.LaunchMachineInput{
+	want := &api.LaunchMachineInput{
 		OrgSlug: "my-dangling-org",
 		Config: &api.MachineConfig{
 			Image: "super/balloon",
@@ -252,7 +278,9 @@ func Test_resolveUpdatedMachineConfig_Mounts(t *testing.T) {
 				Name:   "data",
 			}},
 		},
-	}, li)
+	}
+	machine.StampDeployedConfig(want.Config)
+	assert.Equal(t, want, li)
 
 	origMachine := &api.Machine{
 		Config: &api.MachineConfig{
@@ -266,7 +294,7 @@ func Test_resolveUpdatedMachineConfig_Mounts(t *testing.T) {
 	// Reuse app machine
 	li, err = md.launchInputForUpdate(origMachine)
 	require.NoError(t, err)
-	assert.Equal(t, &api.LaunchMachineInput{
+	want = &api.LaunchMachineInput{
 		OrgSlug: "my-dangling-org",
 		Config: &api.MachineConfig{
 			Image: "super/balloon",
@@ -284,7 +312,12 @@ func Test_resolveUpdatedMachineConfig_Mounts(t *testing.T) {
 				Path:   "/data",
 			}},
 		},
-	}, li)
+	}
+	want.Config.Metadata["fly_previous_image"] = ""
+	want.Config.Metadata["fly_previous_config"] = li.Config.Metadata["fly_previous_config"]
+	want.Config.Metadata["fly_previous_config_hash"] = li.Config.Metadata["fly_previous_config_hash"]
+	machine.StampDeployedConfig(want.Config)
+	assert.Equal(t, want, li)
 }
 
 // Test machineDeployment.restartOnly
@@ -323,6 +356,24 @@ func Test_resolveUpdatedMachineConfig_restartOnly(t *testing.T) {
 	}, md.launchInputForRestart(origMachine))
 }
 
+// Test machineDeployment.restartOnly with useLatestRelease
+func Test_resolveUpdatedMachineConfig_restartOnlyLatestRelease(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	assert.NoError(t, err)
+	md.useLatestRelease = true
+
+	origMachine := &api.Machine{
+		ID: "OrigID",
+		Config: &api.MachineConfig{
+			Image: "instead-use/the-redmoon",
+		},
+	}
+
+	li := md.launchInputForRestart(origMachine)
+	assert.Equal(t, "super/balloon", li.Config.Image)
+	assert.Equal(t, "instead-use/the-redmoon", origMachine.Config.Image)
+}
+
 // Test machineDeployment.restartOnlyProcessGroup
 func Test_resolveUpdatedMachineConfig_restartOnlyProcessGroup(t *testing.T) {
 	md, err := stabMachineDeployment(&appconfig.Config{
@@ -366,3 +417,994 @@ func Test_resolveUpdatedMachineConfig_restartOnlyProcessGroup(t *testing.T) {
 		},
 	}, md.launchInputForRestart(origMachine))
 }
+
+// Test volumes are picked from the machine region
+func Test_resolveUpdatedMachineConfig_MountsInRegion(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Regions:       []string{"fra", "iad"},
+		Mounts: []appconfig.Mount{{
+			Source:      "data",
+			Destination: "/data",
+		}},
+	})
+	require.NoError(t, err)
+	md.volumes = map[string][]api.Volume{
+		"data": {{ID: "vol_fra", Region: "fra"}, {ID: "vol_iad", Region: "iad"}},
+	}
+
+	li, err := md.launchInputForLaunch("", "iad", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "iad", li.Region)
+	assert.Equal(t, "vol_iad", li.Config.Mounts[0].Volume)
+
+	li, err = md.launchInputForLaunch("", "", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "fra", li.Region)
+	assert.Equal(t, "vol_fra", li.Config.Mounts[0].Volume)
+
+	_, err = md.launchInputForLaunch("", "nrt", nil, nil)
+	assert.Error(t, err)
+}
+
+func Test_machineRegionsFor(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Regions:       []string{"iad"},
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Command: "run worker"},
+		},
+		Machines: []appconfig.MachineSettings{{
+			Processes: []string{"web"},
+			Count:     api.Pointer(3),
+		}},
+	})
+	require.NoError(t, err)
+
+	placements, hasCount, err := md.machineRegionsFor("web")
+	require.NoError(t, err)
+	assert.True(t, hasCount)
+	assert.Equal(t, []string{"fra", "iad", "fra"}, placements)
+
+	placements, hasCount, err = md.machineRegionsFor("worker")
+	require.NoError(t, err)
+	assert.False(t, hasCount)
+	assert.Equal(t, []string{"fra", "iad"}, placements)
+}
+
+func Test_resolveProcessGroupChanges_ZeroCount(t *testing.T) {
+	cfg := &appconfig.Config{
+		Processes: map[string]appconfig.Process{
+			"web":     {Command: "run web"},
+			"migrate": {Command: "run migrations"},
+		},
+		Machines: []appconfig.MachineSettings{{
+			Processes: []string{"migrate"},
+			Count:     api.Pointer(0),
+		}},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+
+	diff := md.resolveProcessGroupChanges()
+	assert.Equal(t, map[string]bool{"web": true}, diff.groupsNeedingMachines)
+	assert.NoError(t, md.validateVolumeConfig())
+}
+
+// Test new machines are created where its volume lives unless the region was forced
+func Test_resolveUpdatedMachineConfig_MountsFollowVolumeRegion(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Mounts: []appconfig.Mount{{
+			Source:      "data",
+			Destination: "/data",
+		}},
+	})
+	require.NoError(t, err)
+	md.volumes = map[string][]api.Volume{
+		"data": {{ID: "vol_ams", Region: "ams"}},
+	}
+
+	md.regionForced = true
+	assert.Error(t, md.validateVolumeConfig())
+
+	md.regionForced = false
+	require.NoError(t, md.validateVolumeConfig())
+	li, err := md.launchInputForLaunch("", "", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ams", li.Region)
+	assert.Equal(t, "vol_ams", li.Config.Mounts[0].Volume)
+}
+
+func Test_machineNameFor(t *testing.T) {
+	cfg := &appconfig.Config{
+		MachineNamePrefix: "cool",
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Command: "run worker"},
+		},
+		Machines: []appconfig.MachineSettings{{
+			Processes:  []string{"web"},
+			NamePrefix: "web",
+		}},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	ios, _, _, _ := iostreams.Test()
+	md.machineSet = machine.NewMachineSet(nil, ios, []*api.Machine{
+		{ID: "m1", Name: "web-fra-01", Config: &api.MachineConfig{}},
+	})
+
+	assert.Equal(t, "web-fra-02", md.machineNameFor("web", "fra"))
+	assert.Equal(t, "web-fra-03", md.machineNameFor("web", "fra"))
+	assert.Equal(t, "web-iad-01", md.machineNameFor("web", "iad"))
+	assert.Equal(t, "cool-worker-fra-01", md.machineNameFor("worker", "fra"))
+
+	md.appConfig.MachineNamePrefix = ""
+	assert.Equal(t, "", md.machineNameFor("worker", "fra"))
+}
+
+func Test_filterMachinesByProcessGroups(t *testing.T) {
+	groupMachine := func(id, group string) *api.Machine {
+		return &api.Machine{ID: id, Config: &api.MachineConfig{
+			Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: group},
+		}}
+	}
+	machines := []*api.Machine{
+		groupMachine("m1", "web"),
+		groupMachine("m2", "worker"),
+		groupMachine("m3", "worker"),
+	}
+
+	filtered, err := filterMachinesByProcessGroups(machines, []string{"worker"})
+	require.NoError(t, err)
+	assert.Equal(t, []*api.Machine{machines[1], machines[2]}, filtered)
+
+	_, err = filterMachinesByProcessGroups(machines, []string{"worker", "cron"})
+	assert.ErrorContains(t, err, "process group 'cron' has no machines")
+}
+
+func Test_runsReleaseCommand(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	assert.False(t, md.runsReleaseCommand())
+
+	md.restartOnly = true
+	assert.False(t, md.runsReleaseCommand())
+
+	md.appConfig.Deploy = &appconfig.Deploy{ReleaseCommand: "migrate"}
+	md.restartOnly = false
+	assert.True(t, md.runsReleaseCommand())
+
+	md.restartOnly = true
+	assert.False(t, md.runsReleaseCommand())
+
+	md.withReleaseCommand = true
+	assert.True(t, md.runsReleaseCommand())
+
+	md.skipReleaseCommand = true
+	assert.False(t, md.runsReleaseCommand())
+}
+
+func Test_waitTimeoutFor(t *testing.T) {
+	cfg := &appconfig.Config{
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Cmd: []string{"run", "worker"}, WaitTimeout: api.MustParseDuration("6m")},
+		},
+	}
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.waitTimeout = 20 * time.Second
+	md.groupWaitTimeouts = processWaitTimeouts(cfg)
+
+	assert.Equal(t, 20*time.Second, md.waitTimeoutFor("web"))
+	assert.Equal(t, 6*time.Minute, md.waitTimeoutFor("worker"))
+	assert.Equal(t, "20s (worker=6m0s)", formatWaitTimeouts(md.waitTimeout, md.groupWaitTimeouts))
+	assert.Equal(t, "20s", formatWaitTimeouts(md.waitTimeout, nil))
+}
+
+func Test_interruptedUpdateError(t *testing.T) {
+	ios, _, _, _ := iostreams.Test()
+	entries := lo.Map([]string{"m1", "m2", "m3"}, func(id string, _ int) *machineUpdateEntry {
+		return &machineUpdateEntry{leasableMachine: machine.NewLeasableMachine(nil, ios, &api.Machine{ID: id})}
+	})
+
+	err := interruptedUpdateError(context.Canceled, entries, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "deployment stopped after updating 1 of 3 machines, not updated: m2, m3: context canceled", err.Error())
+	assert.Equal(t, "interrupted", releaseStatusFor(err))
+}
+
+func Test_isFlyRegistryImage(t *testing.T) {
+	assert.True(t, isFlyRegistryImage("registry.fly.io/my-cool-app:deployment-01H"))
+	assert.False(t, isFlyRegistryImage("docker.io/library/nginx:latest"))
+	assert.False(t, isFlyRegistryImage("ghcr.io/registry.fly.io/app:v1"))
+	assert.False(t, isFlyRegistryImage("nginx"))
+}
+
+func Test_pinImageDigest(t *testing.T) {
+	const digest = "sha256:0123abcd"
+	assert.Equal(t, "registry.fly.io/app@sha256:0123abcd", pinImageDigest("registry.fly.io/app:deployment-01H", digest))
+	assert.Equal(t, "localhost:5000/app@sha256:0123abcd", pinImageDigest("localhost:5000/app", digest))
+	assert.Equal(t, "localhost:5000/app@sha256:0123abcd", pinImageDigest("localhost:5000/app:latest", digest))
+	assert.Equal(t, "nginx@sha256:ffff", pinImageDigest("nginx@sha256:ffff", digest))
+	assert.Equal(t, "nginx:latest", pinImageDigest("nginx:latest", ""))
+}
+
+func Test_nearbyTags(t *testing.T) {
+	tags := []string{"deployment-2", "latest", "deployment-1", "v1", "deployment-10", "deployment-100"}
+	assert.Equal(t, []string{"deployment-1", "deployment-10", "deployment-2"}, nearbyTags("deploymnet-1", tags))
+	assert.Equal(t, []string{"v1"}, nearbyTags("v2", tags))
+	assert.Empty(t, nearbyTags("production", tags))
+}
+
+func Test_imageRepository(t *testing.T) {
+	assert.Equal(t, "ghcr.io/acme/app", imageRepository("ghcr.io/acme/app:stable"))
+	assert.Equal(t, "localhost:5000/app", imageRepository("localhost:5000/app"))
+	assert.Equal(t, "nginx", imageRepository("nginx:1.25@sha256:ffff"))
+}
+
+func Test_continuesOnError(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.strategy = "rolling"
+	assert.False(t, md.continuesOnError())
+
+	md.continueOnError = true
+	assert.True(t, md.continuesOnError())
+
+	md.continueOnError = false
+	md.strategy = "immediate"
+	assert.True(t, md.continuesOnError())
+}
+
+func Test_updateFailuresError(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	ios, _, _, errOut := iostreams.Test()
+	md.io = ios
+
+	err = md.updateFailuresError([]machineUpdateFailure{
+		{machineID: "m1", err: errors.New("failed to update VM m1: unknown")},
+		{machineID: "m3", err: errors.New("connection reset by peer")},
+	}, 30)
+	assert.EqualError(t, err, "2 of 30 machines failed to update (2 other)")
+	assert.Equal(t, "failed", releaseStatusFor(err))
+	assert.Contains(t, errOut.String(), "m1")
+	assert.Contains(t, errOut.String(), "connection reset by peer")
+}
+
+func Test_hooksBeforeReleaseCommand(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		Deploy: &appconfig.Deploy{ReleaseCommand: "migrate"},
+	})
+	require.NoError(t, err)
+
+	hookErr := errors.New("maintenance window closed")
+	var called bool
+	md.hooks.BeforeReleaseCommand = func(ctx context.Context) error {
+		called = true
+		return hookErr
+	}
+	err = md.runReleaseCommand(context.Background())
+	assert.True(t, called)
+	assert.ErrorIs(t, err, hookErr)
+
+	// The hook doesn't run when there's no release command to run
+	called = false
+	md.skipReleaseCommand = true
+	assert.NoError(t, md.runReleaseCommand(context.Background()))
+	assert.False(t, called)
+}
+
+func Test_mutateConfig(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		Processes: map[string]appconfig.Process{
+			"web": {Command: "run web"},
+		},
+	})
+	require.NoError(t, err)
+	md.releaseId = "rel_1"
+	md.configMutator = func(groupName string, cfg *api.MachineConfig) error {
+		cfg.Files = append(cfg.Files, &api.File{GuestPath: "/etc/sidecar.conf", RawValue: api.Pointer("ZW5hYmxlZA==")})
+		cfg.Env = lo.Assign(cfg.Env, map[string]string{"SIDECAR_GROUP": groupName})
+		cfg.Metadata["fly_release_id"] = "rel_other"
+		cfg.Metadata["fly_extra"] = "value"
+		cfg.Metadata["team"] = "platform"
+		return nil
+	}
+
+	li, err := md.launchInputForLaunch("web", "", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "web", li.Config.Env["SIDECAR_GROUP"])
+	assert.Equal(t, "/etc/sidecar.conf", li.Config.Files[0].GuestPath)
+	assert.Equal(t, "rel_1", li.Config.Metadata["fly_release_id"])
+	assert.NotContains(t, li.Config.Metadata, "fly_extra")
+	assert.Equal(t, "platform", li.Config.Metadata["team"])
+
+	md.configMutator = func(string, *api.MachineConfig) error { return errors.New("boom") }
+	_, err = md.launchInputForUpdate(&api.Machine{ID: "m1", Config: li.Config})
+	assert.ErrorContains(t, err, "boom")
+}
+
+func Test_setMachinesForDeployment(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/apps/my-cool-app/machines", r.URL.Path)
+		requests++
+		json.NewEncoder(w).Encode([]*api.Machine{
+			{ID: "m1", State: "started", Config: &api.MachineConfig{Metadata: map[string]string{
+				"fly_platform_version": "v2",
+			}}},
+			{ID: "m2", State: "stopped", Config: &api.MachineConfig{Metadata: map[string]string{
+				"fly_platform_version": "v2", "fly_process_group": "worker",
+			}}},
+			{ID: "m3", State: "stopped", Config: &api.MachineConfig{Metadata: map[string]string{
+				"fly_platform_version": "v2", "fly_process_group": "fly_app_release_command",
+			}}},
+		})
+	}))
+	defer server.Close()
+
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.app.Name = "my-cool-app"
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	md.flapsClient, err = flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:   md.app.Name,
+		BaseURL:   baseURL,
+		AuthToken: "test",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, md.setMachinesForDeployment(context.Background()))
+	assert.Equal(t, []string{"m1", "m2"}, lo.Map(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) string {
+		return lm.Machine().ID
+	}))
+	assert.Equal(t, "app", md.machineSet.GetMachines()[0].Machine().ProcessGroup())
+	assert.Equal(t, "m3", md.releaseCommandMachine.GetMachines()[0].Machine().ID)
+	assert.Equal(t, 1, requests)
+}
+
+// failingListDeployment returns a deployment whose machine listings fail with the statuses of failures before
+// listing the machines of the app. It returns how many listings were requested
+func failingListDeployment(t *testing.T, failures ...int) (*machineDeployment, *int) {
+	platform := map[string]string{"fly_platform_version": "v2"}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every machine is listed in one request
+		assert.Empty(t, r.URL.RawQuery)
+		requests++
+		if len(failures) > 0 {
+			w.WriteHeader(failures[0])
+			failures = failures[1:]
+			return
+		}
+		json.NewEncoder(w).Encode([]*api.Machine{
+			{ID: "m1", State: "started", Config: &api.MachineConfig{Metadata: platform}},
+			{ID: "u1", State: "started", Config: &api.MachineConfig{}},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.app.Name = "my-cool-app"
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	md.flapsClient, err = flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:        md.app.Name,
+		BaseURL:        baseURL,
+		AuthToken:      "test",
+		RequestOptions: flaps.RequestOptions{MaxRetries: -1},
+	})
+	require.NoError(t, err)
+	return md, &requests
+}
+
+func Test_setMachinesForDeployment_retries(t *testing.T) {
+	md, requests := failingListDeployment(t, http.StatusInternalServerError)
+
+	require.NoError(t, md.setMachinesForDeployment(context.Background()))
+	assert.Equal(t, []string{"m1"}, lo.Map(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) string {
+		return lm.Machine().ID
+	}))
+	assert.Equal(t, 2, *requests)
+}
+
+func Test_setMachinesForDeployment_listErrors(t *testing.T) {
+	// Client errors aren't retried
+	md, requests := failingListDeployment(t, http.StatusBadRequest)
+	assert.Error(t, md.setMachinesForDeployment(context.Background()))
+	assert.Equal(t, 1, *requests)
+
+	// Temporary errors are retried a few times
+	md, requests = failingListDeployment(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	assert.Error(t, md.setMachinesForDeployment(context.Background()))
+	assert.Equal(t, machineListAttempts, *requests)
+}
+
+func Test_flapsConnectionReuse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The trailing newline of the encoder is left unread by the client decoder
+		json.NewEncoder(w).Encode([]*api.Machine{{ID: "m1", State: "started"}})
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	flapsClient, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:   "my-cool-app",
+		BaseURL:   baseURL,
+		AuthToken: "test",
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := flapsClient.List(context.Background(), "")
+		require.NoError(t, err)
+	}
+	established, reused := flapsClient.ConnectionStats()
+	assert.Equal(t, int64(1), established)
+	assert.Equal(t, int64(2), reused)
+}
+
+func Test_flapsRequestOptions(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/wait"):
+			time.Sleep(200 * time.Millisecond)
+		case r.URL.RawQuery == "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			time.Sleep(200 * time.Millisecond)
+			json.NewEncoder(w).Encode([]*api.Machine{})
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	newClient := func(opts flaps.RequestOptions) *flaps.Client {
+		flapsClient, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+			AppName:        "my-cool-app",
+			BaseURL:        baseURL,
+			AuthToken:      "test",
+			RequestOptions: opts,
+		})
+		require.NoError(t, err)
+		return flapsClient
+	}
+
+	flapsClient := newClient(flaps.RequestOptions{Timeout: 50 * time.Millisecond, MaxRetries: -1})
+	_, err = flapsClient.List(context.Background(), "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	// Waits last longer than the request timeout
+	assert.NoError(t, flapsClient.Wait(context.Background(), &api.Machine{ID: "m1"}, "started", time.Second))
+
+	requests.Store(0)
+	_, err = flapsClient.List(context.Background(), "unavailable")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), requests.Load())
+
+	requests.Store(0)
+	_, err = newClient(flaps.RequestOptions{MaxRetries: 1}).List(context.Background(), "unavailable")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func Test_publish(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	ios, _, out, errOut := iostreams.Test()
+	md.io = ios
+	events := make(chan DeployEvent, 2)
+	md.events = events
+
+	md.phasef(PhaseUpdateMachines, "Updating existing machines\n")
+	md.machinef("m1", MachineStateUpdating, "  Updating m1\n")
+	md.warnf("slow consumer\n")
+
+	assert.Equal(t, "Updating existing machines\n", out.String())
+	assert.Equal(t, "  Updating m1\nWARN slow consumer\n", errOut.String())
+	assert.Equal(t, int64(1), md.droppedEvents.Load())
+	ev := <-events
+	assert.Equal(t, PhaseUpdateMachines, ev.Phase)
+	ev = <-events
+	assert.Equal(t, DeployEvent{Type: DeployEventMachine, Time: ev.Time, MachineID: "m1", State: MachineStateUpdating, Message: "  Updating m1\n"}, ev)
+}
+
+func Test_followLogs(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	assert.EqualError(t, md.followLogs("m1")(errors.New("boom")), "boom")
+
+	ios, _, _, errOut := iostreams.Test()
+	md.logWatcher = &machineLogWatcher{w: ios.ErrOut, colorize: md.colorize, watching: map[string][]string{}}
+	finish := md.followLogs("m1")
+	md.logWatcher.handle(logs.LogEntry{Instance: "m1", Message: "panic: missing DATABASE_URL"})
+	md.logWatcher.handle(logs.LogEntry{Instance: "m2", Message: "serving"})
+
+	assert.Equal(t, "    m1 | panic: missing DATABASE_URL\n", errOut.String())
+	assert.EqualError(t, finish(errors.New("boom")), "boom\nLast logs of machine m1:\n  panic: missing DATABASE_URL")
+	assert.Empty(t, md.logWatcher.watching)
+}
+
+func Test_waitsForMachines(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.strategy = "rolling"
+	assert.True(t, md.waitsForMachines())
+
+	md.detach = true
+	assert.False(t, md.waitsForMachines())
+
+	md.detach = false
+	md.strategy = "immediate"
+	assert.False(t, md.waitsForMachines())
+}
+
+func Test_releaseStatusFor(t *testing.T) {
+	assert.Equal(t, "complete", releaseStatusFor(nil))
+	assert.Equal(t, "interrupted", releaseStatusFor(fmt.Errorf("wait: %w", context.Canceled)))
+	assert.Equal(t, "failed", releaseStatusFor(errors.New("boom")))
+
+	// A timed out deployment fails even when the in-flight update was canceled by it
+	err := fmt.Errorf("%w after %s: %v", ErrDeployTimeout, time.Minute, context.Canceled)
+	assert.Equal(t, "failed", releaseStatusFor(err))
+	assert.ErrorIs(t, err, ErrDeployTimeout)
+	assert.Contains(t, err.Error(), "timed out after 1m0s")
+
+	assert.Equal(t, "superseded", releaseStatusFor(fmt.Errorf("%w: v8", ErrNewerRelease)))
+}
+
+func Test_newerReleaseError(t *testing.T) {
+	releases := []api.Release{{Version: 7}, {Version: 6}}
+	assert.NoError(t, newerReleaseError(releases, 7))
+	assert.NoError(t, newerReleaseError(nil, 7))
+
+	releases = append(releases, api.Release{Version: 8})
+	err := newerReleaseError(releases, 7)
+	assert.ErrorIs(t, err, ErrNewerRelease)
+	assert.Contains(t, err.Error(), "v8 was created while deploying v7")
+}
+
+func Test_redactReleaseRequest(t *testing.T) {
+	cfg := &appconfig.Config{Env: map[string]string{"API_URL": "https://hunter2@example.com"}}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+
+	body := `{"query":"mutation MachinesCreateRelease","variables":{"input":{"appId":"my-cool-app","definition":{"env":{"API_URL":"https://hunter2@example.com"}}}}}`
+	redacted := string(md.redactReleaseRequest([]byte(body)))
+	assert.NotContains(t, redacted, "hunter2")
+	assert.Contains(t, redacted, `"appId":"my-cool-app"`)
+	assert.Contains(t, redacted, `"API_URL":"sha256:`)
+
+	assert.NotContains(t, string(md.redactReleaseRequest([]byte(`{"env":"hunter2`))), "hunter2")
+}
+
+func Test_missingSecrets(t *testing.T) {
+	secrets := []api.Secret{{Name: "DATABASE_URL"}, {Name: "OTHER"}}
+	assert.Empty(t, missingSecrets([]string{"DATABASE_URL"}, secrets))
+	assert.Equal(t, []string{"REDIS_URL", "API_KEY"}, missingSecrets([]string{"REDIS_URL", "DATABASE_URL", "API_KEY", "REDIS_URL"}, secrets))
+}
+
+func Test_shadowedSecrets(t *testing.T) {
+	secrets := []api.Secret{{Name: "DATABASE_URL"}, {Name: "API_KEY"}}
+	assert.Empty(t, shadowedSecrets([]string{"FOO"}, secrets))
+	assert.Equal(t, []string{"API_KEY", "DATABASE_URL"}, shadowedSecrets([]string{"DATABASE_URL", "FOO", "API_KEY", "DATABASE_URL"}, secrets))
+}
+
+func Test_stagedSecretNames(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		require.NoError(t, err)
+		return v
+	}
+	secrets := []api.Secret{
+		{Name: "OLD", CreatedAt: at("2023-05-01T10:00:00Z")},
+		{Name: "STAGED_B", CreatedAt: at("2023-05-03T10:00:00Z")},
+		{Name: "STAGED_A", CreatedAt: at("2023-05-02T12:00:00Z")},
+	}
+	releases := []api.Release{
+		// Failed releases didn't update every machine, in progress ones are being deployed
+		{Version: 4, Status: "failed", CreatedAt: at("2023-05-04T10:00:00Z")},
+		{Version: 3, Status: "running", CreatedAt: at("2023-05-03T12:00:00Z")},
+		{Version: 2, Status: "complete", CreatedAt: at("2023-05-02T10:00:00Z")},
+		{Version: 1, Status: "complete", CreatedAt: at("2023-04-30T10:00:00Z")},
+	}
+
+	assert.Equal(t, []string{"STAGED_A", "STAGED_B"}, stagedSecretNames(secrets, releases))
+	assert.Empty(t, stagedSecretNames(secrets, nil))
+	assert.Empty(t, stagedSecretNames(secrets, releases[:2]))
+
+	// Secrets set again keep being told apart by their CreatedAt, an older one isn't staged
+	reset := []api.Secret{{Name: "OLD", CreatedAt: at("2023-05-01T10:00:00Z")}}
+	assert.Empty(t, stagedSecretNames(reset, releases))
+}
+
+func Test_autostopChanges(t *testing.T) {
+	from := &api.MachineConfig{Services: []api.MachineService{
+		{InternalPort: 8080, Autostop: api.Pointer(false)},
+		{InternalPort: 9090},
+	}}
+	to := &api.MachineConfig{Services: []api.MachineService{
+		{InternalPort: 8080, Autostop: api.Pointer(true), MinMachinesRunning: api.Pointer(2)},
+		{InternalPort: 9090},
+		{InternalPort: 7070, Autostop: api.Pointer(true)},
+	}}
+	assert.Equal(t, []string{
+		"service on port 8080 changes auto_stop_machines from false to true",
+		"service on port 8080 changes min_machines_running from unset to 2",
+	}, autostopChanges(from, to))
+	assert.Empty(t, autostopChanges(to, to))
+}
+
+func Test_metricsChange(t *testing.T) {
+	from := &api.MachineConfig{Metrics: &api.MachineMetrics{Port: 9091, Path: "/metrics"}}
+	assert.Equal(t, "metrics change from port 9091 path /metrics to unset", metricsChange(from, &api.MachineConfig{}))
+	assert.Empty(t, metricsChange(from, from))
+}
+
+func Test_concurrencyChanges(t *testing.T) {
+	from := &api.MachineConfig{Services: []api.MachineService{
+		{InternalPort: 8080, Concurrency: &api.MachineServiceConcurrency{Type: "connections", SoftLimit: 20, HardLimit: 25}},
+	}}
+	to := &api.MachineConfig{Services: []api.MachineService{
+		{InternalPort: 8080, Concurrency: &api.MachineServiceConcurrency{Type: "requests", SoftLimit: 20, HardLimit: 25}},
+	}}
+	assert.Equal(t, []string{
+		"service on port 8080 changes concurrency from type=connections soft_limit=20 hard_limit=25 to type=requests soft_limit=20 hard_limit=25",
+	}, concurrencyChanges(from, to))
+	assert.Empty(t, concurrencyChanges(to, to))
+}
+
+// Mounts scoped to a group don't expect volumes on machines of other groups
+func Test_validateVolumeConfig_ScopedMounts(t *testing.T) {
+	cfg := &appconfig.Config{
+		Processes: map[string]appconfig.Process{
+			"web":    {Command: "run web"},
+			"worker": {Command: "run worker"},
+		},
+		Mounts: []appconfig.Mount{{
+			Source:      "data",
+			Destination: "/data",
+			Processes:   []string{"worker"},
+		}},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	ios, _, _, _ := iostreams.Test()
+	groupMachine := func(id, group string, mounts []api.MachineMount) *api.Machine {
+		return &api.Machine{ID: id, Config: &api.MachineConfig{
+			Metadata: map[string]string{api.MachineConfigMetadataKeyFlyProcessGroup: group},
+			Mounts:   mounts,
+		}}
+	}
+	md.machineSet = machine.NewMachineSet(nil, ios, []*api.Machine{
+		groupMachine("m1", "web", nil),
+		groupMachine("m2", "worker", []api.MachineMount{{Name: "data", Path: "/data", Volume: "vol_1"}}),
+	})
+	assert.NoError(t, md.validateVolumeConfig())
+
+	md.machineSet = machine.NewMachineSet(nil, ios, []*api.Machine{
+		groupMachine("m1", "web", []api.MachineMount{{Name: "data", Path: "/data", Volume: "vol_1"}}),
+	})
+	assert.ErrorContains(t, md.validateVolumeConfig(), "app config does not specify a volume")
+}
+
+func Test_findRelease(t *testing.T) {
+	releases := []api.Release{{Version: 3}, {Version: 5}, {Version: 4}}
+
+	release, err := findRelease(releases, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 5, release.Version)
+
+	release, err = findRelease(releases, 4)
+	require.NoError(t, err)
+	assert.Equal(t, 4, release.Version)
+
+	_, err = findRelease(releases, 9)
+	assert.ErrorContains(t, err, "release v9 not found")
+}
+
+func Test_primaryRegionMismatch(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{PrimaryRegion: "iad"})
+	require.NoError(t, err)
+	entry := func(id, region, primaryEnv string) *machineUpdateEntry {
+		m := &api.Machine{ID: id, Region: region, Config: &api.MachineConfig{
+			Env:      map[string]string{"PRIMARY_REGION": primaryEnv},
+			Metadata: map[string]string{"fly_process_group": "app"},
+		}}
+		return &machineUpdateEntry{
+			leasableMachine: machine.NewLeasableMachine(nil, md.io, m),
+			launchInput:     &api.LaunchMachineInput{ID: id},
+		}
+	}
+	ids := func(entries []*machineUpdateEntry) []string {
+		return lo.Map(entries, func(e *machineUpdateEntry, _ int) string { return e.leasableMachine.Machine().ID })
+	}
+
+	// Machines deployed with another primary region stay where they were placed
+	entries := []*machineUpdateEntry{entry("m1", "ams", "ams"), entry("m2", "ams", "ams"), entry("m3", "syd", "ams")}
+	assert.Equal(t, []string{"m1", "m2"}, ids(md.primaryRegionMismatch(entries)))
+
+	entries = append(entries, entry("m4", "iad", "iad"))
+	assert.Empty(t, md.primaryRegionMismatch(entries))
+
+	md.appConfig.PrimaryRegion = ""
+	assert.Empty(t, md.primaryRegionMismatch(entries[:3]))
+}
+
+func Test_regionStragglers(t *testing.T) {
+	machines := []*api.Machine{
+		{ID: "m1", Region: "fra"},
+		{ID: "m2", Region: "syd"},
+		{ID: "m3", Region: "ams"},
+		{ID: "m4", Region: "syd"},
+	}
+	assert.Equal(t, map[string][]string{"syd": {"m2", "m4"}}, regionStragglers(machines, []string{"fra", "ams"}))
+	assert.Empty(t, regionStragglers(machines, nil))
+}
+
+func Test_checkConfigDrift(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		Env: map[string]string{"FOO": "toml"},
+	})
+	require.NoError(t, err)
+	md.keepDrift = []string{"env"}
+	li, err := md.launchInputForLaunch("", "fra", nil, nil)
+	require.NoError(t, err)
+	running := helpers.Clone(li.Config)
+	running.Env["FOO"] = "manual"
+	running.Metrics = &api.MachineMetrics{Port: 9091, Path: "/metrics"}
+
+	entry := &machineUpdateEntry{
+		leasableMachine: machine.NewLeasableMachine(nil, md.io, &api.Machine{ID: "m1", Config: running}),
+		launchInput:     li,
+	}
+	require.NoError(t, md.checkConfigDrift(context.Background(), []*machineUpdateEntry{entry}))
+	assert.Equal(t, "manual", li.Config.Env["FOO"])
+	assert.Nil(t, li.Config.Metrics)
+	assert.Empty(t, machine.DriftedFields(li.Config))
+}
+
+func Test_machineConfigOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	write := func(s string) {
+		require.NoError(t, os.WriteFile(path, []byte(s), 0o644))
+	}
+
+	write(`{
+		"restart": {"policy": "always"},
+		"env": {"EXTRA": "1"},
+		"process_groups": {"worker": {"guest": {"cpu_kind": "performance", "cpus": 2, "memory_mb": 4096}}}
+	}`)
+	overrides, err := loadMachineConfigOverrides(path)
+	require.NoError(t, err)
+
+	cfg := &api.MachineConfig{
+		Image:    "super/balloon",
+		Env:      map[string]string{"FOO": "bar"},
+		Metadata: map[string]string{"fly_process_group": "worker"},
+		Guest:    &api.MachineGuest{CPUKind: "shared", CPUs: 1, MemoryMB: 256},
+	}
+	require.NoError(t, overrides.apply("worker", cfg))
+	assert.Equal(t, map[string]string{"FOO": "bar", "EXTRA": "1"}, cfg.Env)
+	assert.Equal(t, api.MachineRestart{Policy: api.MachineRestartPolicyAlways}, cfg.Restart)
+	assert.Equal(t, &api.MachineGuest{CPUKind: "performance", CPUs: 2, MemoryMB: 4096}, cfg.Guest)
+	assert.Equal(t, "super/balloon", cfg.Image)
+
+	web := &api.MachineConfig{Guest: &api.MachineGuest{CPUKind: "shared", CPUs: 1, MemoryMB: 256}}
+	require.NoError(t, overrides.apply("web", web))
+	assert.Equal(t, 1, web.Guest.CPUs)
+
+	write(`{"image": "other"}`)
+	_, err = loadMachineConfigOverrides(path)
+	assert.ErrorContains(t, err, "image is set by the deployment")
+
+	write(`{"metadata": {"fly_release_id": "x"}}`)
+	_, err = loadMachineConfigOverrides(path)
+	assert.ErrorContains(t, err, "metadata key 'fly_release_id' is managed by the platform")
+
+	write(`{"process_groups": {"web": {"not_a_field": true}}}`)
+	_, err = loadMachineConfigOverrides(path)
+	assert.ErrorContains(t, err, "unknown field")
+}
+
+func Test_machineNamesPreserved(t *testing.T) {
+	cfg := &appconfig.Config{
+		PrimaryRegion: "fra",
+		Mounts:        []appconfig.Mount{{Source: "data", Destination: "/data"}},
+		Processes:     map[string]appconfig.Process{"web": {Command: "run web"}},
+	}
+	require.NoError(t, cfg.SetMachinesPlatform())
+	md, err := stabMachineDeployment(cfg)
+	require.NoError(t, err)
+	md.volumes = map[string][]api.Volume{"data": {{ID: "vol_new", Name: "data", Region: "fra"}}}
+	mounted := func(name string) []api.MachineMount {
+		return []api.MachineMount{{Volume: "vol_" + name, Path: "/data", Name: name}}
+	}
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{
+		{ID: "m1", Name: "web-fra-01", Region: "fra", Config: &api.MachineConfig{Mounts: mounted("data"), Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m2", Name: "web-fra-02", Region: "fra", Config: &api.MachineConfig{Mounts: mounted("data"), Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m3", Name: "web-fra-03-r1", Region: "fra", Config: &api.MachineConfig{Mounts: mounted("old"), Metadata: map[string]string{"fly_process_group": "web"}}},
+	})
+
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	names := lo.Map(plan.updateEntries, func(e *machineUpdateEntry, _ int) string { return e.launchInput.Name })
+	// Updates keep the machine names, replacements inherit them with a suffix
+	assert.Equal(t, []string{"web-fra-01", "web-fra-02", "web-fra-03-r2"}, names)
+
+	for _, lm := range md.machineSet.GetMachines() {
+		assert.Equal(t, lm.Machine().Name, md.launchInputForRestart(lm.Machine()).Name)
+	}
+
+	assert.Equal(t, "web-fra-01-r1", md.replacementNameFor("web-fra-01"))
+	assert.Equal(t, "", md.replacementNameFor(""))
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{{ID: "m4", Name: "api-r1", Config: &api.MachineConfig{}}})
+	assert.Equal(t, "api-r2", md.replacementNameFor("api"))
+}
+
+func Test_postDeployProbe(t *testing.T) {
+	assert.Nil(t, newPostDeployProbe(nil, ""))
+	assert.Equal(t, &postDeployProbe{url: "https://a.example", expectedStatus: 200, timeout: defaultProbeTimeout}, newPostDeployProbe(nil, "https://a.example"))
+	deploy := &appconfig.Deploy{PostDeployProbe: &appconfig.PostDeployProbe{
+		URL:            "https://b.example/health",
+		ExpectedStatus: 204,
+		Timeout:        api.MustParseDuration("3s"),
+	}}
+	assert.Equal(t, &postDeployProbe{url: "https://b.example/health", expectedStatus: 204, timeout: 3 * time.Second}, newPostDeployProbe(deploy, ""))
+	assert.Equal(t, "https://a.example", newPostDeployProbe(deploy, "https://a.example").url)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	probe := &postDeployProbe{url: server.URL + "/health", expectedStatus: 204, timeout: time.Second}
+	assert.NoError(t, probe.do(context.Background()))
+	probe.url = server.URL + "/other"
+	assert.ErrorContains(t, probe.do(context.Background()), "got status 502 instead of 204")
+
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	md.probe = &postDeployProbe{url: server.URL + "/health", expectedStatus: 204, timeout: time.Second}
+	assert.NoError(t, md.runPostDeployProbe(context.Background()))
+}
+
+func Test_notifyWebhook(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer server.Close()
+
+	md, err := stabMachineDeployment(&appconfig.Config{Env: map[string]string{"SECRET_TOKEN": "hunter2"}})
+	require.NoError(t, err)
+	md.app.Name = "my-cool-app"
+	md.notifyWebhookURL = server.URL
+	result := &DeploymentResult{
+		ReleaseVersion: 7,
+		Status:         "failed",
+		Image:          "super/balloon",
+		Machines: []MachineOutcome{
+			{ID: "m1", Action: "updated"},
+			{ID: "m2", Action: "updated", Error: "SECRET_TOKEN=hunter2 rejected"},
+		},
+		Error: "machine m2 failed",
+	}
+	md.notifyWebhook(result, 83*time.Second)
+	assert.Equal(t, map[string]any{
+		"app":             "my-cool-app",
+		"release_version": float64(7),
+		"image":           "super/balloon",
+		"outcome":         "failed",
+		"duration":        "1m23s",
+		"failed_machines": []any{"m2"},
+	}, <-received)
+
+	// Unreachable webhooks are only warned about
+	md.notifyWebhookURL = "http://127.0.0.1:1"
+	md.notifyWebhook(result, time.Second)
+}
+
+func Test_validateGPURegions(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Processes: map[string]appconfig.Process{
+			"web":       {Command: "run web"},
+			"inference": {Command: "run inference"},
+			"trainer":   {Command: "run trainer"},
+		},
+	})
+	require.NoError(t, err)
+	gpuGuest := func(kind string) *api.MachineGuest {
+		return &api.MachineGuest{CPUKind: "performance", CPUs: 8, GPUKind: kind}
+	}
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{
+		{ID: "m1", Region: "fra", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m2", Region: "ord", Config: &api.MachineConfig{Guest: gpuGuest("a100-pcie-40gb"), Metadata: map[string]string{"fly_process_group": "inference"}}},
+		{ID: "m3", Region: "lhr", Config: &api.MachineConfig{Guest: gpuGuest("a100-sxm4-80gb"), Metadata: map[string]string{"fly_process_group": "trainer"}}},
+		{ID: "m4", Region: "fra", Config: &api.MachineConfig{Guest: gpuGuest("a100-sxm4-80gb"), Metadata: map[string]string{"fly_process_group": "trainer"}}},
+	})
+	err = md.validateGPURegions()
+	assert.EqualError(t, err, "machines with a GPU can't be deployed to regions without it:\n"+
+		"  process group 'trainer' runs on a100-sxm4-80gb GPUs, they aren't available in fra, lhr, supported regions are ams, iad, mia, sjc, syd")
+
+	// Only the process group given a GPU by --machine-config is checked
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{
+		{ID: "m1", Region: "fra", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m2", Region: "fra", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "trainer"}}},
+	})
+	md.configMutator = func(group string, cfg *api.MachineConfig) error {
+		if group == "inference" {
+			cfg.Guest = gpuGuest("a100-pcie-40gb")
+		}
+		return nil
+	}
+	err = md.validateGPURegions()
+	assert.EqualError(t, err, "machines with a GPU can't be deployed to regions without it:\n"+
+		"  process group 'inference' runs on a100-pcie-40gb GPUs, they aren't available in fra, supported regions are ord")
+
+	md.configMutator = nil
+	assert.NoError(t, md.validateGPURegions())
+}
+
+func Test_checkOrganization(t *testing.T) {
+	app := &api.AppCompact{Name: "my-cool-app", Organization: &api.OrganizationBasic{Slug: "personal", RawSlug: "jane-doe"}}
+	assert.NoError(t, checkOrganization(app, ""))
+	assert.NoError(t, checkOrganization(app, "personal"))
+	assert.NoError(t, checkOrganization(app, "jane-doe"))
+	assert.EqualError(t, checkOrganization(app, "acme-prod"), "app my-cool-app belongs to organization jane-doe, not acme-prod; check the app name and FLY_APP")
+	assert.Error(t, checkOrganization(&api.AppCompact{Name: "my-cool-app"}, "acme-prod"))
+}
+
+func Test_noPublicIPs(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{HTTPService: &appconfig.HTTPService{InternalPort: 8080}})
+	require.NoError(t, err)
+	md.noPublicIPs = true
+	// Private apps aren't checked for public IPs, the nil API client would panic otherwise
+	md.warnAboutMissingPublicIPs(context.Background())
+
+	result := md.result("complete", nil)
+	assert.True(t, result.Private)
+	assert.Equal(t, "Release v0 complete, no machines changed (private app, no public IPs)", result.Summary())
+}
+
+func Test_confirmRollingWithoutHealthChecks(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{})
+	require.NoError(t, err)
+	ios, _, _, errOut := iostreams.Test()
+	md.io = ios
+	md.strategy = "rolling"
+	assert.False(t, md.rollsWithoutHealthChecks())
+
+	md.skipHealthChecks = true
+	assert.True(t, md.rollsWithoutHealthChecks())
+	// Non interactive deployments only warn
+	require.NoError(t, md.confirmRollingWithoutHealthChecks(context.Background(), false))
+	assert.Contains(t, errOut.String(), "Health checks are skipped with the rolling strategy")
+
+	md.strategy = "immediate"
+	assert.False(t, md.rollsWithoutHealthChecks())
+	md.strategy = "rolling"
+	md.detach = true
+	assert.False(t, md.rollsWithoutHealthChecks())
+}
@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+// hangingWaits makes the waits for m1 hang until the request is canceled, and records the final release status
+func hangingWaits(fb *fakeBackend) func() string {
+	fb.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/machines/m1/wait") {
+			return false
+		}
+		<-r.Context().Done()
+		return true
+	}
+	var (
+		mu     sync.Mutex
+		status string
+	)
+	fb.onGraphQL("MachinesUpdateRelease", func(vars map[string]any) any {
+		mu.Lock()
+		defer mu.Unlock()
+		status, _ = vars["input"].(map[string]any)["status"].(string)
+		return map[string]any{"updateRelease": map[string]any{"release": map[string]any{"id": "rel_1"}}}
+	})
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return status
+	}
+}
+
+func Test_deployTimeout(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"))
+	releaseStatus := hangingWaits(fb)
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.DeployTimeout = 200 * time.Millisecond
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	err = md.DeployMachinesApp(ctx)
+	assert.True(t, errors.Is(err, ErrDeployTimeout), "got %v", err)
+
+	// m2 isn't updated once the deployment timed out on m1, leases are released and the release failed
+	assert.Equal(t, "registry.fly.io/my-cool-app:deployment-0", fb.machine("m2").Config.Image)
+	assert.Contains(t, fb.requested(), "DELETE /machines/m1/lease")
+	assert.Equal(t, "failed", releaseStatus())
+	assert.Contains(t, fb.ErrOut.String(), "Release v2 was marked failed, timed out after 200ms")
+}
+
+func Test_deployTimeout_releaseNotUpdated(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	hangingWaits(fb)
+	fb.onGraphQL("MachinesUpdateRelease", func(vars map[string]any) any {
+		if vars["input"].(map[string]any)["status"] == "failed" {
+			return errors.New("release not found")
+		}
+		return map[string]any{"updateRelease": map[string]any{"release": map[string]any{"id": "rel_1"}}}
+	})
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.DeployTimeout = 200 * time.Millisecond
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	err = md.DeployMachinesApp(ctx)
+	assert.True(t, errors.Is(err, ErrDeployTimeout), "got %v", err)
+
+	// The release isn't said to be marked failed when its status couldn't be set
+	assert.Contains(t, fb.ErrOut.String(), "failed to set final release status after deployment failure")
+	assert.Contains(t, fb.ErrOut.String(), "Release v2 timed out after 200ms, its status could not be updated")
+	assert.NotContains(t, fb.ErrOut.String(), "was marked failed")
+}
+
+func Test_deployTimeoutWithDetach(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"))
+	releaseStatus := hangingWaits(fb)
+	ctx := fb.context(&appconfig.Config{})
+	args := fb.args()
+	args.DeployTimeout = 200 * time.Millisecond
+	args.Detach = true
+
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+
+	// Detached deployments don't wait for machines, the timeout only bounds dispatching the updates
+	for _, id := range []string{"m1", "m2"} {
+		assert.Equal(t, "registry.fly.io/my-cool-app:deployment-1", fb.machine(id).Config.Image)
+	}
+	assert.NotContains(t, fb.requested(), "GET /machines/m1/wait")
+	assert.Equal(t, "complete", releaseStatus())
+}
@@ -0,0 +1,39 @@
+package deploy
+
+import (
+	"context"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/internal/tracing"
+)
+
+// spanAttributes describes the deployment on its spans, the release version is only known once the release is created
+func (md *machineDeployment) spanAttributes(attrs ...tracing.Attribute) []tracing.Attribute {
+	base := []tracing.Attribute{tracing.String("app.name", md.app.Name)}
+	if md.releaseVersion > 0 {
+		base = append(base, tracing.Int("release.version", md.releaseVersion))
+	}
+	return append(base, attrs...)
+}
+
+// machineSpanAttributes describes a machine on the spans of the deployment
+func (md *machineDeployment) machineSpanAttributes(m *api.Machine) []tracing.Attribute {
+	return md.spanAttributes(
+		tracing.String("machine.id", m.ID),
+		tracing.String("machine.region", m.Region),
+		tracing.String("machine.process_group", m.ProcessGroup()),
+	)
+}
+
+// acquireLeases takes the leases of every machine of the app, each lease is traced as a child of the span
+func (md *machineDeployment) acquireLeases(ctx context.Context) (err error) {
+	machines := lo.Map(md.machineSet.GetMachines(), func(lm machine.LeasableMachine, _ int) *api.Machine { return lm.Machine() })
+	ctx, span := tracing.Start(ctx, "acquire leases", md.spanAttributes(
+		tracing.String("machine.regions", strings.Join(lo.Uniq(lo.Map(machines, func(m *api.Machine, _ int) string { return m.Region })), ",")),
+	)...)
+	defer func() { span.End(err) }()
+	return md.machineSet.AcquireLeases(ctx, md.leaseTimeout)
+}
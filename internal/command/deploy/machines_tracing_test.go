@@ -0,0 +1,99 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/tracing"
+)
+
+// exportedSpan is the part of the OTLP JSON encoding of a span the tests look at
+type exportedSpan struct {
+	Name         string `json:"name"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Attributes   []struct {
+		Key   string            `json:"key"`
+		Value map[string]string `json:"value"`
+	} `json:"attributes"`
+}
+
+func (s exportedSpan) attribute(key string) string {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			for _, v := range attr.Value {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// collectSpans returns a tracer exporting to a fake collector and the spans it received
+func collectSpans(t *testing.T) (*tracing.Tracer, func() []exportedSpan) {
+	var spans []exportedSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []exportedSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(collector.Close)
+	tracer := tracing.NewTracer(collector.URL, nil)
+	return tracer, func() []exportedSpan {
+		require.NoError(t, tracer.Flush(context.Background()))
+		return spans
+	}
+}
+
+func Test_deploymentSpans(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"), platformMachine("m2", "app"))
+	tracer, flush := collectSpans(t)
+	ctx := tracing.NewContext(fb.context(&appconfig.Config{}), tracer)
+	ctx, deploySpan := tracing.Start(ctx, "deploy")
+
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	require.NoError(t, md.DeployMachinesApp(ctx))
+	deploySpan.End(nil)
+
+	byName := map[string][]exportedSpan{}
+	for _, s := range flush() {
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	require.Len(t, byName["deploy"], 1)
+	assert.Equal(t, "2", byName["deploy"][0].attribute("release.version"))
+
+	// Every machine is leased in its own span
+	require.Len(t, byName["acquire leases"], 1)
+	leases := byName["acquire leases"][0]
+	assert.Equal(t, "2", leases.attribute("release.version"))
+	require.Len(t, byName["acquire lease"], 2)
+	var leased []string
+	for _, s := range byName["acquire lease"] {
+		assert.Equal(t, leases.SpanID, s.ParentSpanID)
+		assert.Equal(t, "fra", s.attribute("machine.region"))
+		leased = append(leased, s.attribute("machine.id"))
+	}
+	assert.ElementsMatch(t, []string{"m1", "m2"}, leased)
+
+	require.Len(t, byName["update machine"], 2)
+	for _, s := range byName["update machine"] {
+		assert.Equal(t, "2", s.attribute("release.version"))
+	}
+}
@@ -0,0 +1,30 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/internal/appconfig"
+)
+
+func Test_NewMachineDeployment_validation(t *testing.T) {
+	fb := newFakeBackend(t, platformMachine("m1", "app"))
+	// max_retries only applies to the on-failure policy, it's a warning
+	ctx := fb.context(&appconfig.Config{Restart: []appconfig.Restart{{Policy: "always", MaxRetries: 3}}})
+
+	_, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+
+	args := fb.args()
+	args.Strict = true
+	_, err = NewMachineDeployment(ctx, args)
+	assert.EqualError(t, err, "app configuration has 1 warnings, they are errors with --strict")
+	assert.Contains(t, fb.ErrOut.String(), "restart max_retries is only used with the 'on-failure' policy")
+
+	fb.ErrOut.Reset()
+	ctx = fb.context(&appconfig.Config{Restart: []appconfig.Restart{{Policy: "sometimes"}}})
+	_, err = NewMachineDeployment(ctx, fb.args())
+	assert.EqualError(t, err, "App configuration is not valid")
+	assert.Contains(t, fb.ErrOut.String(), "Invalid restart policy 'sometimes'")
+}
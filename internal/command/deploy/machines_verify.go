@@ -0,0 +1,135 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+// VerifyIssue is something off between a machine of the app and fly.toml, machines
+// missing from the app have no ID
+type VerifyIssue struct {
+	MachineID    string `json:"machine_id,omitempty"`
+	ProcessGroup string `json:"process_group"`
+	Region       string `json:"region"`
+	Problem      string `json:"problem"`
+}
+
+// VerifyReport is the result of checking the machines of an app against fly.toml without deploying
+type VerifyReport struct {
+	Image    string        `json:"image"`
+	Machines int           `json:"machines"`
+	Issues   []VerifyIssue `json:"issues"`
+}
+
+// Verify checks every machine matches the app config and passes its checks. It only reads
+// machines and their last reported checks, no release is created and no lease is taken
+func (md *machineDeployment) Verify(ctx context.Context) (*VerifyReport, error) {
+	plan, err := md.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Deployments pin the image to its digest, a machine running the pinned image is up to date
+	pinnedImg := md.img
+	if img, err := md.apiClient.ResolveImageForApp(ctx, md.app.Name, md.img); err == nil && img != nil {
+		pinnedImg = pinImageDigest(md.img, img.Digest)
+	}
+	return md.verifyReport(plan, pinnedImg), nil
+}
+
+func (md *machineDeployment) verifyReport(plan *DeploymentPlan, pinnedImg string) *VerifyReport {
+	report := &VerifyReport{Image: md.img, Machines: len(md.machineSet.GetMachines()), Issues: []VerifyIssue{}}
+	addIssue := func(m PlannedMachine, format string, v ...any) {
+		report.Issues = append(report.Issues, VerifyIssue{
+			MachineID:    m.ID,
+			ProcessGroup: m.ProcessGroup,
+			Region:       m.Region,
+			Problem:      fmt.Sprintf(format, v...),
+		})
+	}
+
+	for _, m := range plan.Remove {
+		addIssue(m, "process group '%s' isn't in fly.toml", m.ProcessGroup)
+	}
+	for _, m := range plan.Create {
+		addIssue(m, "missing machine for process group '%s'", m.ProcessGroup)
+	}
+
+	machines := lo.SliceToMap(md.machineSet.GetMachines(), func(lm machine.LeasableMachine) (string, *api.Machine) {
+		return lm.Machine().ID, lm.Machine()
+	})
+	for _, u := range plan.Update {
+		pm := PlannedMachine{ID: u.ID, ProcessGroup: u.ProcessGroup, Region: u.Region}
+		m := machines[u.ID]
+		switch {
+		case u.Replace:
+			addIssue(pm, "its volume doesn't match [mounts], it would be replaced")
+		case m.Config.Image != md.img && m.Config.Image != pinnedImg:
+			addIssue(pm, "runs image %s instead of %s", m.Config.Image, md.img)
+		case !sameMachineConfig(m.Config, u.Config):
+			addIssue(pm, "config differs from fly.toml")
+		}
+
+		switch m.State {
+		case api.MachineStateStarted:
+			for _, check := range m.Checks {
+				if check.Status != "passing" {
+					addIssue(pm, "check %s is %s", check.Name, check.Status)
+				}
+			}
+		case api.MachineStateStopped:
+			// Stopped machines are fine, they may be standbys or stopped by autostop
+		default:
+			addIssue(pm, "machine is %s", m.State)
+		}
+	}
+	return report
+}
+
+// sameMachineConfig compares machine configs ignoring the image and the metadata that changes on every deployment
+func sameMachineConfig(running, expected *api.MachineConfig) bool {
+	normalize := func(c *api.MachineConfig) *api.MachineConfig {
+		c = machine.CloneConfig(c)
+		c.Image = ""
+		c.Metadata = lo.OmitByKeys(c.Metadata, []string{
+			api.MachineConfigMetadataKeyFlyReleaseId,
+			api.MachineConfigMetadataKeyFlyReleaseVersion,
+			api.MachineConfigMetadataKeyFlyPreviousImage,
+			api.MachineConfigMetadataKeyFlyPreviousConfig,
+			api.MachineConfigMetadataKeyFlyPreviousHash,
+			api.MachineConfigMetadataKeyFlyDeployedConfig,
+		})
+		return c
+	}
+	// Compare the JSON sent to the Machines API so empty and missing fields are the same
+	a, errA := json.Marshal(normalize(running))
+	b, errB := json.Marshal(normalize(expected))
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// renderVerifyReport shows the issues found by Verify, one per line
+func renderVerifyReport(w io.Writer, report *VerifyReport) {
+	if len(report.Issues) == 0 {
+		fmt.Fprintf(w, "All %d machines match fly.toml and pass their checks\n", report.Machines)
+		return
+	}
+	fmt.Fprintf(w, "Found %d issues with the machines of the app:\n", len(report.Issues))
+	for _, issue := range report.Issues {
+		who := lo.Ternary(issue.MachineID != "", "machine "+issue.MachineID, "group "+issue.ProcessGroup)
+		fmt.Fprintf(w, "  %s in %s: %s\n", who, issue.Region, issue.Problem)
+	}
+}
+
+// verifyError summarizes a report with issues, Verify callers exit non-zero with it
+func verifyError(report *VerifyReport) error {
+	if len(report.Issues) == 0 {
+		return nil
+	}
+	return fmt.Errorf("found %d issues with the machines of app, they don't match fly.toml or fail their checks", len(report.Issues))
+}
@@ -0,0 +1,86 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/helpers"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+func Test_Verify(t *testing.T) {
+	current := platformMachine("m1", "app")
+	current.Checks = []*api.MachineCheckStatus{{Name: "http", Status: "critical"}}
+	stale := platformMachine("m2", "app")
+	stale.Config.Image = "registry.fly.io/my-cool-app:deployment-old"
+	fb := newFakeBackend(t, current, stale)
+	fb.onGraphQL("FlyctlDeployGetLatestImage", func(map[string]any) any {
+		return map[string]any{"app": map[string]any{"currentReleaseUnprocessed": map[string]any{
+			"id": "rel_0", "version": 1, "imageRef": "registry.fly.io/my-cool-app:deployment-0",
+		}}}
+	})
+	fb.onGraphQL("MachinesCreateRelease", func(map[string]any) any {
+		t.Error("verifying created a release")
+		return nil
+	})
+
+	ctx := fb.context(&appconfig.Config{PrimaryRegion: "fra"})
+	args := fb.args()
+	// Nothing is built to verify, machines are checked against the current release
+	args.DeploymentImage = ""
+	args.Verify = true
+	md, err := NewMachineDeployment(ctx, args)
+	require.NoError(t, err)
+	report, err := md.Verify(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, "registry.fly.io/my-cool-app:deployment-0", report.Image)
+	assert.Equal(t, 2, report.Machines)
+	assert.Contains(t, report.Issues, VerifyIssue{MachineID: "m1", ProcessGroup: "app", Region: "fra", Problem: "check http is critical"})
+	assert.Contains(t, report.Issues, VerifyIssue{
+		MachineID: "m2", ProcessGroup: "app", Region: "fra",
+		Problem: "runs image registry.fly.io/my-cool-app:deployment-old instead of registry.fly.io/my-cool-app:deployment-0",
+	})
+	for _, r := range fb.requested() {
+		assert.True(t, strings.HasPrefix(r, "GET "), "verifying sent %s", r)
+	}
+}
+
+func Test_verifyReport(t *testing.T) {
+	md, err := stabMachineDeployment(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Processes: map[string]appconfig.Process{
+			"web": {Command: "run web"},
+		},
+	})
+	require.NoError(t, err)
+	upToDate, err := md.launchInputForLaunch("web", "fra", nil, nil)
+	require.NoError(t, err)
+	stale := helpers.Clone(upToDate.Config)
+	stale.Env["OLD"] = "value"
+	md.machineSet = machine.NewMachineSet(nil, md.io, []*api.Machine{
+		{ID: "m1", Region: "fra", State: "started", Config: upToDate.Config},
+		{ID: "m2", Region: "fra", State: "started", Config: stale, Checks: []*api.MachineCheckStatus{{Name: "http", Status: "critical"}}},
+		{ID: "m3", Region: "ams", State: "stopped", Config: &api.MachineConfig{Image: "super/old", Metadata: map[string]string{"fly_process_group": "web"}}},
+	})
+
+	plan, err := md.Plan(context.Background())
+	require.NoError(t, err)
+	report := md.verifyReport(plan, "super/balloon@sha256:abc")
+	assert.Equal(t, []VerifyIssue{
+		{MachineID: "m2", ProcessGroup: "web", Region: "fra", Problem: "config differs from fly.toml"},
+		{MachineID: "m2", ProcessGroup: "web", Region: "fra", Problem: "check http is critical"},
+		{MachineID: "m3", ProcessGroup: "web", Region: "ams", Problem: "runs image super/old instead of super/balloon"},
+	}, report.Issues)
+	assert.Error(t, verifyError(report))
+
+	var b bytes.Buffer
+	renderVerifyReport(&b, report)
+	assert.Contains(t, b.String(), "  machine m2 in fra: check http is critical\n")
+}
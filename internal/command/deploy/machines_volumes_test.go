@@ -0,0 +1,127 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+func Test_setVolumes_staleAttachment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/apps/my-cool-app/machines":
+			json.NewEncoder(w).Encode([]*api.Machine{
+				{ID: "m1", State: "started", Config: &api.MachineConfig{
+					Metadata: map[string]string{"fly_platform_version": "v2"},
+					Mounts:   []api.MachineMount{{Volume: "vol_1", Name: "data", Path: "/data"}},
+				}},
+				{ID: "m2", State: "started", Config: &api.MachineConfig{
+					Metadata: map[string]string{"fly_platform_version": "v2"},
+					Mounts:   []api.MachineMount{{Volume: "vol_3", Name: "data", Path: "/data"}},
+				}},
+			})
+		case "/v1/apps/my-cool-app/volumes":
+			// vol_2 is still attached to m_gone which was just destroyed, vol_3 attachment isn't reported yet
+			json.NewEncoder(w).Encode([]api.MachineVolume{
+				{ID: "vol_1", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m1")},
+				{ID: "vol_2", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m_gone")},
+				{ID: "vol_3", Name: "data", Region: "fra", State: "created"},
+				{ID: "vol_4", Name: "data", Region: "fra", State: "pending_destroy"},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	md, err := stabMachineDeployment(&appconfig.Config{
+		Mounts: []appconfig.Mount{{Source: "data", Destination: "/data"}},
+	})
+	require.NoError(t, err)
+	md.app.Name = "my-cool-app"
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	md.flapsClient, err = flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:   md.app.Name,
+		BaseURL:   baseURL,
+		AuthToken: "test",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, md.setMachinesForDeployment(context.Background()))
+	require.NoError(t, md.setVolumes(context.Background()))
+	assert.Equal(t, []string{"vol_2"}, lo.Map(md.volumes["data"], func(v api.Volume, _ int) string { return v.ID }))
+}
+
+func Test_refreshVolumes(t *testing.T) {
+	m1 := platformMachine("m1", "app")
+	m1.Config.Mounts = []api.MachineMount{{Volume: "vol_1", Name: "data", Path: "/data"}}
+	fb := newFakeBackend(t, m1)
+	fb.volumes = []api.MachineVolume{
+		{ID: "vol_1", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m1")},
+		{ID: "vol_2", Name: "data", Region: "fra", State: "created"},
+	}
+	ctx := fb.context(&appconfig.Config{
+		PrimaryRegion: "fra",
+		Mounts:        []appconfig.Mount{{Source: "data", Destination: "/data"}},
+	})
+	md, err := NewMachineDeployment(ctx, fb.args())
+	require.NoError(t, err)
+	mdImpl := md.(*machineDeployment)
+
+	// The new machine takes vol_2, the deployment knows it mounts it once it is reported attached
+	created, err := mdImpl.spawnMachineInGroup(ctx, "app", "fra", 0, 1, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "vol_2", created.Config.Mounts[0].Volume)
+
+	// Someone else created m9 with the new vol_3 and freed vol_4 by destroying m_gone
+	m9 := platformMachine("m9", "app")
+	m9.Config.Mounts = []api.MachineMount{{Volume: "vol_3", Name: "data", Path: "/data"}}
+	fb.mu.Lock()
+	fb.machines = append(fb.machines, m9)
+	fb.volumes = []api.MachineVolume{
+		{ID: "vol_1", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m1")},
+		{ID: "vol_2", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer(created.ID)},
+		{ID: "vol_3", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m9")},
+		{ID: "vol_4", Name: "data", Region: "fra", State: "created", AttachedMachineID: api.Pointer("m_gone")},
+	}
+	fb.mu.Unlock()
+	mdImpl.volumesListedAt = time.Now().Add(-time.Minute)
+	mdImpl.refreshVolumesIfStale(ctx)
+	assert.Equal(t, "vol_4", mdImpl.popVolumeFor("data", "fra").ID)
+	assert.Nil(t, mdImpl.popVolumeFor("data", "fra"))
+
+	// Only the machines attached to volumes unknown to the deployment are fetched
+	requests := fb.requested()
+	assert.Equal(t, 1, lo.Count(requests, "GET /machines"))
+	assert.Contains(t, requests, "GET /machines/m9")
+	assert.Contains(t, requests, "GET /machines/m_gone")
+	assert.NotContains(t, requests, "GET /machines/"+created.ID)
+
+	// Machines destroyed by the deployment free their volumes, machines seen before aren't fetched again
+	m1Set, _ := mdImpl.machineSet.Partition(func(m machine.LeasableMachine) bool { return m.Machine().ID == "m1" })
+	_, err = m1Set.DestroyMachines(ctx, mdImpl.destroyOptions())
+	require.NoError(t, err)
+	mdImpl.volumesListedAt = time.Now().Add(-time.Minute)
+	mdImpl.refreshVolumesIfStale(ctx)
+	assert.Equal(t, "vol_1", mdImpl.popVolumeFor("data", "fra").ID)
+	machineGets := func(requests []string) []string {
+		return lo.Filter(requests, func(r string, _ int) bool {
+			return strings.HasPrefix(r, "GET /machines") && strings.Count(r, "/") <= 2
+		})
+	}
+	assert.Equal(t, machineGets(requests), machineGets(fb.requested()))
+}
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/internal/machine"
+)
+
+// waitOptions waits for machines like updates of a single machine do, until they are started and pass their checks
+func (md *machineDeployment) waitOptions(maxConcurrent int, failFast bool) machine.WaitOptions {
+	return machine.WaitOptions{
+		State:         api.MachineStateStarted,
+		Timeout:       md.waitTimeout,
+		GroupTimeouts: md.groupWaitTimeouts,
+		CheckHealth:   !md.skipHealthChecks,
+		MaxConcurrent: maxConcurrent,
+		FailFast:      failFast,
+	}
+}
+
+// waitForUpdatedMachine waits for a machine updated by e, with the other machines of its batch when it has one
+func (md *machineDeployment) waitForUpdatedMachine(ctx context.Context, e *machineUpdateEntry, lm machine.LeasableMachine, indexStr string) error {
+	if e.batch != nil {
+		return e.batch.wait(lm, indexStr)
+	}
+	waitTimeout := md.waitTimeoutFor(lm.Machine().ProcessGroup())
+	if err := lm.WaitForState(ctx, api.MachineStateStarted, waitTimeout, indexStr); err != nil {
+		return err
+	}
+	if md.skipHealthChecks {
+		return nil
+	}
+	return lm.WaitForHealthchecksToPass(ctx, waitTimeout, indexStr)
+}
+
+// batchWait waits for the machines updated in a batch together with MachineSet.WaitForMachines, once each update
+// of the batch reached its wait or finished without one. Updates block in their wait until then
+type batchWait struct {
+	ctx  context.Context
+	opts machine.WaitOptions
+
+	mu       sync.Mutex
+	pending  int
+	machines []machine.LeasableMachine
+	prefixes []string
+	results  []machine.WaitResult
+	waited   chan struct{}
+}
+
+func newBatchWait(ctx context.Context, opts machine.WaitOptions, size int) *batchWait {
+	return &batchWait{ctx: ctx, opts: opts, pending: size, waited: make(chan struct{})}
+}
+
+// batchMember is the part of a batchWait of a single update, only the goroutine of the update uses it
+type batchMember struct {
+	bw      *batchWait
+	arrived bool
+}
+
+func (bw *batchWait) member() *batchMember {
+	return &batchMember{bw: bw}
+}
+
+// wait adds lm to the machines of the batch and returns the outcome of its wait once the batch was waited for
+func (m *batchMember) wait(lm machine.LeasableMachine, indexStr string) error {
+	bw := m.bw
+	bw.mu.Lock()
+	i := len(bw.machines)
+	bw.machines = append(bw.machines, lm)
+	bw.prefixes = append(bw.prefixes, indexStr)
+	bw.mu.Unlock()
+	m.arrived = true
+	bw.arrive()
+	<-bw.waited
+	return bw.results[i].Err
+}
+
+// leave is called once the update finished, updates that failed or didn't wait stop holding the batch back
+func (m *batchMember) leave() {
+	if !m.arrived {
+		m.arrived = true
+		m.bw.arrive()
+	}
+}
+
+// arrive counts an update that reached its wait or finished, the last one waits for the machines of the batch
+func (bw *batchWait) arrive() {
+	bw.mu.Lock()
+	bw.pending--
+	last := bw.pending == 0
+	bw.mu.Unlock()
+	if !last {
+		return
+	}
+	opts := bw.opts
+	opts.LogPrefix = func(i int) string { return bw.prefixes[i] }
+	bw.results, _ = machine.NewLeasableMachineSet(bw.machines).WaitForMachines(bw.ctx, opts)
+	close(bw.waited)
+}
+
+// deferredWaits collects the machines the immediate strategy updated to wait for them once all are dispatched,
+// it is safe for concurrent use
+type deferredWaits struct {
+	mu       sync.Mutex
+	machines []machine.LeasableMachine
+	prefixes []string
+}
+
+func (d *deferredWaits) add(lm machine.LeasableMachine, indexStr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.machines = append(d.machines, lm)
+	d.prefixes = append(d.prefixes, indexStr)
+}
+
+// wait waits for the collected machines, up to maxConcurrent at once, and returns the ones that failed
+func (d *deferredWaits) wait(ctx context.Context, opts machine.WaitOptions) []machineUpdateFailure {
+	opts.LogPrefix = func(i int) string { return d.prefixes[i] }
+	results, _ := machine.NewLeasableMachineSet(d.machines).WaitForMachines(ctx, opts)
+	var failures []machineUpdateFailure
+	for _, result := range results {
+		if result.Err != nil {
+			failures = append(failures, machineUpdateFailure{machineID: result.Machine.Machine().ID, err: result.Err})
+		}
+	}
+	return failures
+}
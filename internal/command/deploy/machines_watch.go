@@ -0,0 +1,121 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/client"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/machine"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+// watchPollInterval is how often the release status is checked while it's running
+const watchPollInterval = 5 * time.Second
+
+// WatchMachinesRelease follows a release started somewhere else, like a detached deploy from a CI job.
+// It waits for the release to finish dispatching, then for its machines to start and pass health checks,
+// and fails unless the release completed and every machine is healthy. Version 0 follows the latest release.
+func WatchMachinesRelease(ctx context.Context, appCompact *api.AppCompact, version int, waitTimeout time.Duration) error {
+	io := iostreams.FromContext(ctx)
+	colorize := io.ColorScheme()
+	apiClient := client.FromContext(ctx).API()
+
+	if waitTimeout == 0 {
+		waitTimeout = DefaultWaitTimeout
+	}
+
+	release, err := waitForReleaseDispatch(ctx, apiClient, appCompact.Name, version, waitTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(io.Out, "Release v%d of '%s' is %s\n", release.Version, colorize.Bold(appCompact.Name), release.Status)
+
+	flapsClient, err := flaps.New(ctx, appCompact)
+	if err != nil {
+		return err
+	}
+	machines, err := flapsClient.ListActive(ctx)
+	if err != nil {
+		return err
+	}
+	// Standbys and scheduled machines are stopped on purpose, the release command machine is gone already
+	machines = lo.Filter(machines, func(m *api.Machine, _ int) bool {
+		return m.Config != nil && !m.IsReleaseCommandMachine() && len(m.Config.Standbys) == 0 && m.Config.Schedule == ""
+	})
+
+	var unhealthy int
+	for i, m := range machines {
+		indexStr := formatIndex(i, len(machines))
+		if v := m.Config.Metadata[api.MachineConfigMetadataKeyFlyReleaseVersion]; v != strconv.Itoa(release.Version) {
+			fmt.Fprintf(io.ErrOut, "  %s Machine %s runs release v%s\n", indexStr, colorize.Bold(m.ID), v)
+			unhealthy++
+			continue
+		}
+
+		lm := machine.NewLeasableMachine(flapsClient, io, m)
+		if err := lm.WaitForState(ctx, api.MachineStateStarted, waitTimeout, indexStr); err != nil {
+			fmt.Fprintf(io.ErrOut, "  %s Machine %s failed to start: %s\n", indexStr, colorize.Bold(m.ID), err)
+			unhealthy++
+			continue
+		}
+		if err := lm.WaitForHealthchecksToPass(ctx, waitTimeout, indexStr); err != nil {
+			fmt.Fprintf(io.ErrOut, "  %s Machine %s failed its health checks: %s\n", indexStr, colorize.Bold(m.ID), err)
+			unhealthy++
+			continue
+		}
+		fmt.Fprintf(io.ErrOut, "  %s Machine %s is healthy on release v%d\n", indexStr, colorize.Bold(m.ID), release.Version)
+	}
+
+	switch {
+	case release.Status != "complete":
+		return fmt.Errorf("release v%d finished with status '%s'", release.Version, release.Status)
+	case unhealthy > 0:
+		return fmt.Errorf("%d of %d machines aren't healthy on release v%d", unhealthy, len(machines), release.Version)
+	}
+	fmt.Fprintf(io.Out, "Release v%d is %s on all machines\n", release.Version, colorize.Green("healthy"))
+	return nil
+}
+
+// waitForReleaseDispatch polls the release until it isn't running anymore
+func waitForReleaseDispatch(ctx context.Context, apiClient *api.Client, appName string, version int, timeout time.Duration) (*api.Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		releases, err := apiClient.GetAppReleasesMachines(ctx, appName, 25)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch releases: %w", err)
+		}
+		release, err := findRelease(releases, version)
+		if err != nil {
+			return nil, err
+		}
+		if release.Status != "running" && release.Status != "pending" {
+			return release, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("release v%d is still %s: %w", release.Version, release.Status, ctx.Err())
+		case <-time.After(watchPollInterval):
+		}
+	}
+}
+
+// findRelease picks the release with the given version, or the latest one for version 0
+func findRelease(releases []api.Release, version int) (*api.Release, error) {
+	if version == 0 && len(releases) > 0 {
+		latest := lo.MaxBy(releases, func(a, b api.Release) bool { return a.Version > b.Version })
+		return &latest, nil
+	}
+	release, ok := lo.Find(releases, func(r api.Release) bool { return r.Version == version })
+	if !ok {
+		return nil, fmt.Errorf("release v%d not found among the recent releases of the app", version)
+	}
+	return &release, nil
+}
@@ -0,0 +1,65 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// webhookPayload is the JSON posted to --notify-webhook when a deployment finishes. It only has
+// these fields, error messages and configs aren't sent so env vars and secrets can't leak through it
+type webhookPayload struct {
+	App            string   `json:"app"`
+	ReleaseVersion int      `json:"release_version"`
+	Image          string   `json:"image"`
+	Outcome        string   `json:"outcome"`
+	Duration       string   `json:"duration"`
+	FailedMachines []string `json:"failed_machines"`
+}
+
+func newWebhookPayload(app string, result *DeploymentResult, duration time.Duration) webhookPayload {
+	return webhookPayload{
+		App:            app,
+		ReleaseVersion: result.ReleaseVersion,
+		Image:          result.Image,
+		Outcome:        result.Status,
+		Duration:       duration.Round(time.Second).String(),
+		FailedMachines: lo.Map(result.Failed(), func(o MachineOutcome, _ int) string { return o.ID }),
+	}
+}
+
+// notifyWebhook posts the outcome of the deployment to the webhook, failing to reach it is only a warning
+func (md *machineDeployment) notifyWebhook(result *DeploymentResult, duration time.Duration) {
+	if md.notifyWebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(newWebhookPayload(md.app.Name, result, duration))
+	if err != nil {
+		md.warnf("Failed to notify the deployment webhook: %s\n", err)
+		return
+	}
+	// The deployment context may be done already, the notification gets its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.notifyWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		md.warnf("Failed to notify the deployment webhook: %s\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		md.warnf("Failed to notify the deployment webhook: %s\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		md.warnf("Failed to notify the deployment webhook: got status %d\n", resp.StatusCode)
+	}
+}
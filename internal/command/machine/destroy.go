@@ -116,7 +116,7 @@ func Destroy(ctx context.Context, app *api.AppCompact, machine *api.Machine, for
 	}
 
 	// Best effort post-deletion hook.
-	runOnDeletionHook(ctx, app, machine)
+	RunOnDeletionHook(ctx, app, machine)
 
 	return nil
 }
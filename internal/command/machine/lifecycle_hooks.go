@@ -10,7 +10,8 @@ import (
 	"github.com/superfly/flyctl/iostreams"
 )
 
-func runOnDeletionHook(ctx context.Context, app *api.AppCompact, machine *api.Machine) {
+// RunOnDeletionHook unregisters Postgres members from their cluster once their machine is destroyed
+func RunOnDeletionHook(ctx context.Context, app *api.AppCompact, machine *api.Machine) {
 	var (
 		io     = iostreams.FromContext(ctx)
 		labels = machine.ImageRef.Labels
@@ -29,6 +29,7 @@ func New() *cobra.Command {
 		newProxy(),
 		newClone(),
 		newUpdate(),
+		newRollback(),
 		newRestart(),
 		newLeases(),
 		newMachineExec(),
@@ -0,0 +1,108 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/iostreams"
+
+	"github.com/superfly/flyctl/internal/appconfig"
+	"github.com/superfly/flyctl/internal/command"
+	"github.com/superfly/flyctl/internal/flag"
+	mach "github.com/superfly/flyctl/internal/machine"
+)
+
+func newRollback() *cobra.Command {
+	const (
+		short = "Roll a machine back to the config it ran before its last deploy"
+		long  = short + `
+
+Deploys keep the previous image and config of each machine they update in its metadata,
+this restores them without the fly.toml they were deployed from. Env values aren't kept,
+the current ones are used.`
+
+		usage = "rollback <machine_id>"
+	)
+
+	cmd := command.New(usage, short, long, runRollback,
+		command.RequireSession,
+		command.LoadAppNameIfPresent,
+	)
+
+	flag.Add(
+		cmd,
+		flag.App(),
+		flag.AppConfig(),
+		flag.Yes(),
+		selectFlag,
+		flag.Bool{
+			Name:        "skip-health-checks",
+			Description: "Rolls the machine back without waiting for health checks.",
+			Default:     false,
+		},
+	)
+
+	cmd.Args = cobra.RangeArgs(0, 1)
+
+	return cmd
+}
+
+func runRollback(ctx context.Context) error {
+	var (
+		io       = iostreams.FromContext(ctx)
+		colorize = io.ColorScheme()
+	)
+
+	machineID := flag.FirstArg(ctx)
+	haveMachineID := len(flag.Args(ctx)) > 0
+	machine, ctx, err := selectOneMachine(ctx, nil, machineID, haveMachineID)
+	if err != nil {
+		return err
+	}
+	appName := appconfig.NameFromContext(ctx)
+
+	machine, releaseLeaseFunc, err := mach.AcquireLease(ctx, machine)
+	defer releaseLeaseFunc(ctx, machine)
+	if err != nil {
+		return err
+	}
+
+	machineConf, unknownEnv, err := mach.RestoredConfig(machine)
+	if err != nil {
+		return err
+	}
+	if len(unknownEnv) > 0 {
+		fmt.Fprintf(io.ErrOut, "%s The previous values of %s aren't known, they keep their current value or stay unset\n",
+			colorize.Yellow("WARN"), strings.Join(unknownEnv, ", "))
+	}
+
+	if !flag.GetYes(ctx) {
+		confirmed, err := mach.ConfirmConfigChanges(ctx, machine, *machineConf, "")
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			fmt.Fprintf(io.Out, "No changes to apply\n")
+			return nil
+		}
+	}
+
+	input := &api.LaunchMachineInput{
+		ID:               machine.ID,
+		AppID:            appName,
+		Name:             machine.Name,
+		Region:           machine.Region,
+		Config:           machineConf,
+		SkipHealthChecks: flag.GetBool(ctx, "skip-health-checks"),
+	}
+	if err := mach.Update(ctx, machine, input); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(io.Out, "Machine %s was rolled back to image %s\n", colorize.Bold(machine.ID), machineConf.Image)
+	return nil
+}
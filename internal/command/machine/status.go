@@ -9,6 +9,7 @@ import (
 
 	"github.com/alecthomas/chroma/quick"
 	"github.com/spf13/cobra"
+	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/internal/command"
 	"github.com/superfly/flyctl/internal/flag"
 	"github.com/superfly/flyctl/internal/render"
@@ -85,6 +86,12 @@ func runMachineStatus(ctx context.Context) (err error) {
 		obj[0] = append(obj[0], machine.Config.Mounts[0].Volume)
 	}
 
+	// Deployments stamp the release that last updated the machine
+	if version := machine.Config.Metadata[api.MachineConfigMetadataKeyFlyReleaseVersion]; version != "" {
+		cols = append(cols, "Release")
+		obj[0] = append(obj[0], fmt.Sprintf("v%s (%s)", version, machine.Config.Metadata[api.MachineConfigMetadataKeyFlyReleaseId]))
+	}
+
 	if err = render.VerticalTable(io.Out, "VM", obj, cols...); err != nil {
 		return
 	}
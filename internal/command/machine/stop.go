@@ -3,7 +3,6 @@ package machine
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -77,12 +76,11 @@ func Stop(ctx context.Context, machineID string, signal string, timeout int) (er
 		ID: machineID,
 	}
 
-	if sig := strings.ToUpper(signal); sig != "" {
-		if _, ok := signalSyscallMap[sig]; !ok {
-			return fmt.Errorf("invalid signal %s", signal)
+	if signal != "" {
+		machineStopInput.Signal, err = api.ValidateSignal(signal)
+		if err != nil {
+			return err
 		}
-
-		machineStopInput.Signal = strings.ToUpper(sig)
 	}
 
 	if timeout > 0 {
@@ -99,18 +97,3 @@ func Stop(ctx context.Context, machineID string, signal string, timeout int) (er
 
 	return
 }
-
-var signalSyscallMap = map[string]struct{}{
-	"SIGABRT": {},
-	"SIGALRM": {},
-	"SIGFPE":  {},
-	"SIGILL":  {},
-	"SIGINT":  {},
-	"SIGKILL": {},
-	"SIGPIPE": {},
-	"SIGQUIT": {},
-	"SIGSEGV": {},
-	"SIGTERM": {},
-	"SIGTRAP": {},
-	"SIGUSR1": {},
-}
@@ -26,6 +26,27 @@ var sharedFlags = flag.Set{
 		Name:        "stage",
 		Description: "Set secrets but skip deployment for machine apps",
 	},
+	flag.String{
+		Name:        "signal",
+		Description: "Signal to stop machines with before restarting them, defaults to the kill_signal of the app",
+	},
+	flag.StringSlice{
+		Name:        "process-groups",
+		Description: "Only restart the machines in these process groups, comma separated",
+	},
+	flag.Bool{
+		Name:        "with-release-command",
+		Description: "Run the release_command before restarting machines",
+	},
+	flag.Int{
+		Name:        "max-concurrent",
+		Description: "Maximum number of machines to restart at once, the rest keep serving",
+		Default:     1,
+	},
+	flag.Bool{
+		Name:        "use-latest-release",
+		Description: "Restart machines with the image of the latest release instead of the image they are running",
+	},
 }
 
 func New() *cobra.Command {
@@ -87,9 +108,14 @@ func deployForSecrets(ctx context.Context, app *api.AppCompact, release *api.Rel
 			return fmt.Errorf("error loading appv2 config: %w", err)
 		}
 		md, err := deploy.NewMachineDeployment(ctx, deploy.MachineDeploymentArgs{
-			AppCompact:       app,
-			RestartOnly:      true,
-			SkipHealthChecks: flag.GetBool(ctx, "detach"),
+			AppCompact:         app,
+			RestartOnly:        true,
+			SkipHealthChecks:   flag.GetBool(ctx, "detach"),
+			RestartSignal:      flag.GetString(ctx, "signal"),
+			ProcessGroups:      flag.GetStringSlice(ctx, "process-groups"),
+			WithReleaseCommand: flag.GetBool(ctx, "with-release-command"),
+			MaxConcurrent:      flag.GetInt(ctx, "max-concurrent"),
+			UseLatestRelease:   flag.GetBool(ctx, "use-latest-release"),
 		})
 		if err != nil {
 			sentry.CaptureExceptionWithAppInfo(err, "secrets", app)
@@ -356,7 +356,7 @@ func Ignorefile() String {
 func ImageLabel() String {
 	return String{
 		Name:        "image-label",
-		Description: `Image label to use when tagging and pushing to the fly registry. Defaults to "deployment-{timestamp}".`,
+		Description: `Image label to use when tagging and pushing to the fly registry. Defaults to "deployment-{timestamp}". With an image set in the [build] section of fly.toml, the tag of that image to deploy.`,
 	}
 }
 
@@ -0,0 +1,105 @@
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/superfly/flyctl/api"
+	"golang.org/x/exp/slices"
+)
+
+// DriftFields are the fields of a machine config set from fly.toml by deployments, changes made
+// to them out of band by `fly machine update` are overwritten by the next deployment
+var DriftFields = []string{"checks", "env", "files", "init", "metrics", "services", "statics", "stop_config"}
+
+func driftField(c *api.MachineConfig, field string) any {
+	switch field {
+	case "checks":
+		return c.Checks
+	case "env":
+		return c.Env
+	case "files":
+		return c.Files
+	case "init":
+		return c.Init
+	case "metrics":
+		return c.Metrics
+	case "services":
+		return c.Services
+	case "statics":
+		return c.Statics
+	case "stop_config":
+		return c.StopConfig
+	}
+	return nil
+}
+
+// StampDeployedConfig records in the metadata a short hash of each field in DriftFields,
+// the next deployment compares them with the running config to find out of band changes
+func StampDeployedConfig(c *api.MachineConfig) {
+	hashes := make([]string, 0, len(DriftFields))
+	for _, field := range DriftFields {
+		hashes = append(hashes, field+"="+fieldHash(c, field))
+	}
+	if c.Metadata == nil {
+		c.Metadata = map[string]string{}
+	}
+	c.Metadata[api.MachineConfigMetadataKeyFlyDeployedConfig] = strings.Join(hashes, ",")
+}
+
+// DriftedFields returns the fields changed since the config was deployed, configs
+// deployed before the stamp existed never drift
+func DriftedFields(c *api.MachineConfig) []string {
+	stamp, ok := c.Metadata[api.MachineConfigMetadataKeyFlyDeployedConfig]
+	if !ok {
+		return nil
+	}
+	var drifted []string
+	for _, pair := range strings.Split(stamp, ",") {
+		field, hash, _ := strings.Cut(pair, "=")
+		if slices.Contains(DriftFields, field) && fieldHash(c, field) != hash {
+			drifted = append(drifted, field)
+		}
+	}
+	return drifted
+}
+
+// KeepFields copies fields from the running config into the config about to be deployed
+func KeepFields(to, from *api.MachineConfig, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "checks":
+			to.Checks = from.Checks
+		case "env":
+			to.Env = from.Env
+		case "files":
+			to.Files = from.Files
+		case "init":
+			to.Init = from.Init
+		case "metrics":
+			to.Metrics = from.Metrics
+		case "services":
+			to.Services = from.Services
+		case "statics":
+			to.Statics = from.Statics
+		case "stop_config":
+			to.StopConfig = from.StopConfig
+		}
+	}
+}
+
+// ValidateDriftFields checks fields are known drift fields
+func ValidateDriftFields(fields []string) error {
+	for _, field := range fields {
+		if !slices.Contains(DriftFields, field) {
+			return fmt.Errorf("unknown config field '%s', valid fields are %s", field, strings.Join(DriftFields, ", "))
+		}
+	}
+	return nil
+}
+
+func fieldHash(c *api.MachineConfig, field string) string {
+	raw, _ := json.Marshal(driftField(c, field))
+	return configHash(raw)[:8]
+}
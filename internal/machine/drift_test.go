@@ -0,0 +1,35 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_DriftedFields(t *testing.T) {
+	deployed := &api.MachineConfig{
+		Env:      map[string]string{"FOO": "bar"},
+		Services: []api.MachineService{{InternalPort: 8080}},
+	}
+	assert.Empty(t, DriftedFields(deployed), "configs without stamp never drift")
+
+	StampDeployedConfig(deployed)
+	assert.Empty(t, DriftedFields(deployed))
+
+	running := CloneConfig(deployed)
+	running.Env["FOO"] = "changed"
+	running.Services[0].InternalPort = 9090
+	running.Guest = &api.MachineGuest{CPUs: 4}
+	assert.Equal(t, []string{"env", "services"}, DriftedFields(running))
+
+	next := CloneConfig(deployed)
+	KeepFields(next, running, []string{"env"})
+	assert.Equal(t, "changed", next.Env["FOO"])
+	assert.Equal(t, 8080, next.Services[0].InternalPort)
+
+	require.NoError(t, ValidateDriftFields([]string{"env", "stop_config"}))
+	assert.EqualError(t, ValidateDriftFields([]string{"guest"}),
+		"unknown config field 'guest', valid fields are checks, env, files, init, metrics, services, statics, stop_config")
+}
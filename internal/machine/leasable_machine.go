@@ -4,13 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jpillora/backoff"
 	"github.com/morikuni/aec"
+	"github.com/samber/lo"
 	"github.com/superfly/flyctl/api"
 	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/internal/tracing"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/terminal"
 	"golang.org/x/exp/maps"
@@ -25,11 +31,39 @@ type LeasableMachine interface {
 	StartBackgroundLeaseRefresh(context.Context, time.Duration, time.Duration)
 	Update(context.Context, api.LaunchMachineInput) error
 	Start(context.Context) error
+	Stop(context.Context, string) error
 	Destroy(context.Context, bool) error
 	WaitForState(context.Context, string, time.Duration, string) error
 	WaitForHealthchecksToPass(context.Context, time.Duration, string) error
 	WaitForEventTypeAfterType(context.Context, string, string, time.Duration) (*api.MachineEvent, error)
 	FormattedMachineId() string
+	SetPollBackoff(PollBackoff)
+}
+
+// PollBackoff is how often a machine is polled while waiting on it. The interval grows
+// exponentially with jitter from Min to Max, and resets when the machine is seen changing
+type PollBackoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+const (
+	// waitCallTimeout is how long a single call to the flaps wait endpoint waits server side
+	waitCallTimeout = 30 * time.Second
+	// waitProgressInterval is how often the waiting line is refreshed while waiting for a state
+	waitProgressInterval = 5 * time.Second
+)
+
+// DefaultPollBackoff suits fleet machines, deployments can poll short lived machines faster
+var DefaultPollBackoff = PollBackoff{Min: 500 * time.Millisecond, Max: 3 * time.Second}
+
+func (p PollBackoff) backoff() *backoff.Backoff {
+	return &backoff.Backoff{
+		Min:    p.Min,
+		Max:    p.Max,
+		Factor: 2,
+		Jitter: true,
+	}
 }
 
 type leasableMachine struct {
@@ -39,18 +73,36 @@ type leasableMachine struct {
 	machine                *api.Machine
 	leaseNonce             string
 	leaseRefreshCancelFunc context.CancelFunc
-	destroyed              bool
+	// leaseExpiresAt is the unix time the lease expires at unless refreshed, the background refresh updates it
+	leaseExpiresAt atomic.Int64
+	destroyed      bool
+	pollBackoff    PollBackoff
+	// eventsSeen is the timestamp of the newest event shown by the waits, zero until one starts following them
+	eventsSeen atomic.Int64
 }
 
+const (
+	// maxUpdateAttempts caps how many times a machine update is tried when flaps fails transiently
+	maxUpdateAttempts = 4
+	// maxRetryAfter caps how long a rate limited update waits, whatever Retry-After asks for
+	maxRetryAfter = 30 * time.Second
+)
+
 func NewLeasableMachine(flapsClient *flaps.Client, io *iostreams.IOStreams, machine *api.Machine) LeasableMachine {
 	return &leasableMachine{
 		flapsClient: flapsClient,
 		io:          io,
 		colorize:    io.ColorScheme(),
 		machine:     machine,
+		pollBackoff: DefaultPollBackoff,
 	}
 }
 
+// SetPollBackoff changes how often the machine is polled by the waits
+func (lm *leasableMachine) SetPollBackoff(p PollBackoff) {
+	lm.pollBackoff = p
+}
+
 func (lm *leasableMachine) Update(ctx context.Context, input api.LaunchMachineInput) error {
 	if lm.IsDestroyed() {
 		return fmt.Errorf("error cannot update machine %s that was already destroyed", lm.machine.ID)
@@ -58,12 +110,74 @@ func (lm *leasableMachine) Update(ctx context.Context, input api.LaunchMachineIn
 	if !lm.HasLease() {
 		return fmt.Errorf("no current lease for machine %s", lm.machine.ID)
 	}
-	updateMachine, err := lm.flapsClient.Update(ctx, input, lm.leaseNonce)
-	if err != nil {
-		return err
+	b := &backoff.Backoff{
+		Min:    500 * time.Millisecond,
+		Max:    5 * time.Second,
+		Factor: 2,
+		Jitter: true,
 	}
-	lm.machine = updateMachine
-	return nil
+	for attempt := 1; ; attempt++ {
+		updateMachine, err := lm.flapsClient.Update(ctx, input, lm.leaseNonce)
+		if err == nil {
+			lm.machine = updateMachine
+			return nil
+		}
+		if attempt >= maxUpdateAttempts || !isRetryableUpdateError(err) {
+			return err
+		}
+		// Don't retry past the lease, a new update without it would fail anyway
+		delay := retryDelay(err, b.Duration())
+		if expiresAt := lm.leaseExpiresAt.Load(); expiresAt != 0 && time.Now().Add(delay).Unix() >= expiresAt {
+			return fmt.Errorf("giving up updating machine %s before its lease expires: %w", lm.machine.ID, err)
+		}
+		terminal.Debugf("retrying update of machine %s in %s after attempt %d failed: %v\n", lm.machine.ID, delay, attempt, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w while retrying the update of machine %s: %w", ctx.Err(), lm.machine.ID, err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryableUpdateError is isRetryableFlapsError without 502 and 503 responses, the transport of the flaps
+// client already retried them and updates shouldn't multiply its retries
+func isRetryableUpdateError(err error) bool {
+	var flapsErr *flaps.FlapsError
+	if errors.As(err, &flapsErr) {
+		switch flapsErr.ResponseStatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable:
+			return false
+		}
+	}
+	return isRetryableFlapsError(err)
+}
+
+// retryDelay waits what a rate limited response asked for, up to maxRetryAfter, and the backoff otherwise
+func retryDelay(err error, backoffDelay time.Duration) time.Duration {
+	var flapsErr *flaps.FlapsError
+	if errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusTooManyRequests && flapsErr.RetryAfter > backoffDelay {
+		return lo.Min([]time.Duration{flapsErr.RetryAfter, maxRetryAfter})
+	}
+	return backoffDelay
+}
+
+// isRetryableFlapsError tells network errors, 5xx responses, 409 conflicts and 429 rate limits, which are worth retrying,
+// from other 4xx responses and errors that won't go away
+func isRetryableFlapsError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var flapsErr *flaps.FlapsError
+	if errors.As(err, &flapsErr) {
+		switch flapsErr.ResponseStatusCode {
+		case http.StatusConflict, http.StatusTooManyRequests:
+			return true
+		default:
+			return flapsErr.ResponseStatusCode >= 500
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 func (lm *leasableMachine) Destroy(ctx context.Context, kill bool) error {
@@ -74,7 +188,8 @@ func (lm *leasableMachine) Destroy(ctx context.Context, kill bool) error {
 		ID:   lm.machine.ID,
 		Kill: kill,
 	}
-	err := lm.flapsClient.Destroy(ctx, input, lm.machine.LeaseNonce)
+	nonce := lo.Ternary(lm.leaseNonce != "", lm.leaseNonce, lm.machine.LeaseNonce)
+	err := lm.flapsClient.Destroy(ctx, input, nonce)
 	if err != nil {
 		return err
 	}
@@ -153,52 +268,241 @@ func (lm *leasableMachine) Start(ctx context.Context) error {
 	return nil
 }
 
+// Stop stops the machine sending signal to its main process, or the default signal when empty
+func (lm *leasableMachine) Stop(ctx context.Context, signal string) error {
+	if lm.IsDestroyed() {
+		return fmt.Errorf("error cannot stop machine %s that was already destroyed", lm.machine.ID)
+	}
+	if !lm.HasLease() {
+		return fmt.Errorf("no current lease for machine %s", lm.machine.ID)
+	}
+	input := api.StopMachineInput{
+		ID:     lm.machine.ID,
+		Signal: signal,
+	}
+	return lm.flapsClient.Stop(ctx, input, lm.leaseNonce)
+}
+
+// WaitForState waits server side for the machine to reach the state with the flaps wait endpoint,
+// falling back to polling the machine when the endpoint fails. When the machine fails to start its events
+// are fetched once and shown, a process exiting with an error explains the failure
 func (lm *leasableMachine) WaitForState(ctx context.Context, desiredState string, timeout time.Duration, logPrefix string) error {
+	if desiredState != api.MachineStateStarted {
+		return lm.waitForState(ctx, desiredState, timeout, logPrefix)
+	}
+	// Events the machine had before the wait were already there to see
+	lm.eventsSeen.CompareAndSwap(0, newestEventTimestamp(lm.Machine()))
+	err := lm.waitForState(ctx, desiredState, timeout, logPrefix)
+	if err != nil && ctx.Err() == nil {
+		return lm.startFailure(ctx, err)
+	}
+	return err
+}
+
+func (lm *leasableMachine) waitForState(ctx context.Context, desiredState string, timeout time.Duration, logPrefix string) error {
 	waitCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	b := &backoff.Backoff{
-		Min:    500 * time.Millisecond,
-		Max:    2 * time.Second,
-		Factor: 2,
-		Jitter: true,
-	}
+	b := lm.pollBackoff.backoff()
 	lm.logClearLinesAbove(1)
 	lm.logStatusWaiting(desiredState, logPrefix)
+	stopProgress := lm.startWaitProgress(desiredState, logPrefix)
+	defer stopProgress()
 	for {
-		err := lm.flapsClient.Wait(waitCtx, lm.Machine(), desiredState, timeout)
+		err := lm.flapsClient.Wait(waitCtx, lm.Machine(), desiredState, waitCallTimeout)
 		notFoundResponse := false
+		endpointFailed := false
 		if err != nil {
 			var flapsErr *flaps.FlapsError
 			if errors.As(err, &flapsErr) {
 				notFoundResponse = flapsErr.ResponseStatusCode == http.StatusNotFound
+				// Gateway timeouts are long polls running out, not failures of the endpoint
+				endpointFailed = flapsErr.ResponseStatusCode >= 500 && flapsErr.ResponseStatusCode != http.StatusGatewayTimeout
 			}
 		}
+		if endpointFailed {
+			terminal.Debugf("waiting for machine %s failed, polling its state instead: %v\n", lm.Machine().ID, err)
+			err = lm.pollForState(waitCtx, desiredState)
+		}
 		switch {
 		case errors.Is(waitCtx.Err(), context.Canceled):
 			return err
 		case errors.Is(waitCtx.Err(), context.DeadlineExceeded):
 			return fmt.Errorf("timeout reached waiting for machine to %s %w", desiredState, err)
+		case endpointFailed && err != nil:
+			return err
 		case notFoundResponse && desiredState != api.MachineStateDestroyed:
 			return err
 		case !notFoundResponse && err != nil:
 			time.Sleep(b.Duration())
 			continue
 		}
+		stopProgress()
 		lm.logClearLinesAbove(1)
 		lm.logStatusFinished(desiredState)
 		return nil
 	}
 }
 
+// startFailure shows the events of a machine whose wait to start failed with waitErr, they are only fetched then.
+// The process of the machine exiting with an error explains the failure better than the wait
+func (lm *leasableMachine) startFailure(ctx context.Context, waitErr error) error {
+	m, err := lm.flapsClient.Get(ctx, lm.Machine().ID)
+	if err != nil {
+		terminal.Debugf("failed to get the events of machine %s: %v\n", lm.Machine().ID, err)
+		return waitErr
+	}
+	events := lm.newEvents(m)
+	lm.logMachineEvents(events)
+	for _, e := range events {
+		if err := exitFailure(e); err != nil {
+			return fmt.Errorf("machine %s failed while starting: %w", lm.Machine().ID, err)
+		}
+	}
+	return waitErr
+}
+
+// pollForState gets the machine until it has the state, destroyed machines may not be found anymore
+func (lm *leasableMachine) pollForState(ctx context.Context, desiredState string) error {
+	b := lm.pollBackoff.backoff()
+	lastState := lm.Machine().State
+	for {
+		m, err := lm.flapsClient.Get(ctx, lm.Machine().ID)
+		var flapsErr *flaps.FlapsError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusNotFound:
+			if desiredState == api.MachineStateDestroyed {
+				return nil
+			}
+			return err
+		case err == nil && m.State == desiredState:
+			return nil
+		case err == nil && m.State != lastState:
+			lastState = m.State
+			b.Reset()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.Duration()):
+		}
+	}
+}
+
+// startWaitProgress refreshes the waiting line with the elapsed time on interactive terminals,
+// the returned func stops it and can be called more than once
+func (lm *leasableMachine) startWaitProgress(desiredState, logPrefix string) func() {
+	if !lm.io.IsInteractive() {
+		return func() {}
+	}
+	started := time.Now()
+	ticker := time.NewTicker(waitProgressInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lm.logClearLinesAbove(1)
+				prefix := fmt.Sprintf("(%s)", time.Since(started).Round(time.Second))
+				if logPrefix != "" {
+					prefix = logPrefix + " " + prefix
+				}
+				lm.logStatusWaiting(desiredState, prefix)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// newEvents returns the events of the machine newer than the ones shown already, oldest first
+func (lm *leasableMachine) newEvents(m *api.Machine) []*api.MachineEvent {
+	events := eventsSince(m, lm.eventsSeen.Load())
+	if len(events) > 0 {
+		lm.eventsSeen.Store(events[len(events)-1].Timestamp)
+	}
+	return events
+}
+
+// WaitForHealthchecksToPass waits for the checks of the machine to pass, showing the machine events meanwhile.
+// It fails as soon as the machine process exits with an error
 func (lm *leasableMachine) WaitForHealthchecksToPass(ctx context.Context, timeout time.Duration, logPrefix string) error {
 	if len(lm.Machine().Checks) == 0 {
 		return nil
 	}
 	waitCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	b := healthCheckBackoff(lm.Machine())
 
-	checkDefs := maps.Values(lm.Machine().Config.Checks)
-	for _, s := range lm.Machine().Config.Services {
+	printedFirst := false
+	// The last line is the waiting status of the machine
+	replaceLine := true
+	var lastStatus api.HealthCheckStatus
+	for {
+		updateMachine, err := lm.flapsClient.Get(waitCtx, lm.Machine().ID)
+		var events []*api.MachineEvent
+		if err == nil {
+			// Poll faster again when checks change, the rest are likely to follow
+			if status := *updateMachine.HealthCheckStatus(); status != lastStatus {
+				lastStatus = status
+				b.Reset()
+			}
+			// Without a wait for the machine to start before, events up to its last start happened before this one
+			lm.eventsSeen.CompareAndSwap(0, lastStartTimestamp(updateMachine))
+			events = lm.newEvents(updateMachine)
+		}
+		switch {
+		case errors.Is(waitCtx.Err(), context.Canceled):
+			return err
+		case errors.Is(waitCtx.Err(), context.DeadlineExceeded):
+			return fmt.Errorf("timeout reached waiting for healthchecks to pass for machine %s %w", lm.Machine().ID, err)
+		case err != nil:
+			return fmt.Errorf("error getting machine %s from api: %w", lm.Machine().ID, err)
+		}
+		// The status line is replaced as it changes, event lines are kept
+		if len(events) > 0 {
+			if replaceLine {
+				lm.logClearLinesAbove(1)
+			}
+			lm.logMachineEvents(events)
+			printedFirst, replaceLine = false, false
+		}
+		for _, e := range events {
+			if err := exitFailure(e); err != nil {
+				return fmt.Errorf("machine %s failed before passing health checks: %w", lm.Machine().ID, err)
+			}
+		}
+		allPassing := updateMachine.HealthCheckStatus().AllPassing()
+		if allPassing || !printedFirst || lm.io.IsInteractive() {
+			if replaceLine {
+				lm.logClearLinesAbove(1)
+			}
+			lm.logHealthCheckStatus(updateMachine.HealthCheckStatus(), logPrefix)
+			printedFirst, replaceLine = true, true
+		}
+		if allPassing {
+			return nil
+		}
+		time.Sleep(b.Duration())
+	}
+}
+
+// healthCheckBackoff polls the checks of the machine about as often as the most frequent one runs,
+// their status can't change faster than that
+func healthCheckBackoff(m *api.Machine) *backoff.Backoff {
+	checkDefs := maps.Values(m.Config.Checks)
+	for _, s := range m.Config.Services {
 		checkDefs = append(checkDefs, s.Checks...)
 	}
 	shortestInterval := 120 * time.Second
@@ -207,48 +511,81 @@ func (lm *leasableMachine) WaitForHealthchecksToPass(ctx context.Context, timeou
 			shortestInterval = c.Interval.Duration
 		}
 	}
-	b := &backoff.Backoff{
+	return &backoff.Backoff{
 		Min:    shortestInterval / 2,
 		Max:    2 * shortestInterval,
 		Factor: 2,
 		Jitter: true,
 	}
+}
 
-	printedFirst := false
-	for {
-		updateMachine, err := lm.flapsClient.Get(waitCtx, lm.Machine().ID)
-		switch {
-		case errors.Is(waitCtx.Err(), context.Canceled):
-			return err
-		case errors.Is(waitCtx.Err(), context.DeadlineExceeded):
-			return fmt.Errorf("timeout reached waiting for healthchecks to pass for machine %s %w", lm.Machine().ID, err)
-		case err != nil:
-			return fmt.Errorf("error getting machine %s from api: %w", lm.Machine().ID, err)
-		case !updateMachine.HealthCheckStatus().AllPassing():
-			if !printedFirst || lm.io.IsInteractive() {
-				lm.logClearLinesAbove(1)
-				lm.logHealthCheckStatus(updateMachine.HealthCheckStatus(), logPrefix)
-				printedFirst = true
-			}
-			time.Sleep(b.Duration())
-			continue
+// lastStartTimestamp returns the timestamp of the last start event of the machine, zero if it has none
+func lastStartTimestamp(m *api.Machine) int64 {
+	// Events come newest first
+	for _, e := range m.Events {
+		if e.Type == "start" {
+			return e.Timestamp
 		}
-		lm.logClearLinesAbove(1)
-		lm.logHealthCheckStatus(updateMachine.HealthCheckStatus(), logPrefix)
+	}
+	return 0
+}
+
+// newestEventTimestamp returns the timestamp of the newest event of the machine, zero if it has none
+func newestEventTimestamp(m *api.Machine) int64 {
+	// Events come newest first
+	if len(m.Events) == 0 {
+		return 0
+	}
+	return m.Events[0].Timestamp
+}
+
+// eventsSince returns the events of the machine newer than the timestamp, oldest first
+func eventsSince(m *api.Machine, since int64) []*api.MachineEvent {
+	events := lo.Filter(m.Events, func(e *api.MachineEvent, _ int) bool { return e.Timestamp > since })
+	return lo.Reverse(events)
+}
+
+// exitFailure returns an error for exit events of processes that failed, nil for the rest
+func exitFailure(e *api.MachineEvent) error {
+	if e.Type != "exit" || e.Request == nil {
+		return nil
+	}
+	code, err := e.Request.GetExitCode()
+	if err != nil || code == 0 {
 		return nil
 	}
+	if exit := e.Request.MonitorEvent; exit != nil && exit.ExitEvent != nil {
+		if exit.ExitEvent.RequestedStop {
+			return nil
+		}
+		if exit.ExitEvent.OOMKilled {
+			return fmt.Errorf("process ran out of memory and exited with code %d", code)
+		}
+	}
+	return fmt.Errorf("process exited with code %d", code)
+}
+
+func (lm *leasableMachine) logMachineEvents(events []*api.MachineEvent) {
+	for _, e := range events {
+		description := e.Type
+		if e.Status != "" {
+			description += " " + e.Status
+		}
+		if e.Type == "exit" && e.Request != nil {
+			if code, err := e.Request.GetExitCode(); err == nil {
+				description += fmt.Sprintf(" with code %d", code)
+			}
+		}
+		fmt.Fprintf(lm.io.ErrOut, "    %s %s\n", lm.colorize.Gray(lm.Machine().ID), description)
+	}
 }
 
 // waits for an eventType1 type event to show up after we see a eventType2 event, and returns it
 func (lm *leasableMachine) WaitForEventTypeAfterType(ctx context.Context, eventType1, eventType2 string, timeout time.Duration) (*api.MachineEvent, error) {
 	waitCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	b := &backoff.Backoff{
-		Min:    500 * time.Millisecond,
-		Max:    2 * time.Second,
-		Factor: 2,
-		Jitter: true,
-	}
+	b := lm.pollBackoff.backoff()
+	lastState := lm.Machine().State
 	lm.logClearLinesAbove(1)
 	fmt.Fprintf(lm.io.ErrOut, "  Waiting for %s to get %s event\n",
 		lm.colorize.Bold(lm.FormattedMachineId()),
@@ -267,9 +604,12 @@ func (lm *leasableMachine) WaitForEventTypeAfterType(ctx context.Context, eventT
 		exitEvent := updateMachine.GetLatestEventOfTypeAfterType(eventType1, eventType2)
 		if exitEvent != nil {
 			return exitEvent, nil
-		} else {
-			time.Sleep(b.Duration())
 		}
+		if updateMachine.State != lastState {
+			lastState = updateMachine.State
+			b.Reset()
+		}
+		time.Sleep(b.Duration())
 	}
 }
 
@@ -285,10 +625,13 @@ func (lm *leasableMachine) IsDestroyed() bool {
 	return lm.destroyed
 }
 
-func (lm *leasableMachine) AcquireLease(ctx context.Context, duration time.Duration) error {
+func (lm *leasableMachine) AcquireLease(ctx context.Context, duration time.Duration) (err error) {
 	if lm.HasLease() {
 		return nil
 	}
+	ctx, span := tracing.Start(ctx, "acquire lease", tracing.String("machine.id", lm.machine.ID), tracing.String("machine.region", lm.machine.Region))
+	defer func() { span.End(err) }()
+
 	seconds := int(duration.Seconds())
 	lease, err := lm.flapsClient.AcquireLease(ctx, lm.machine.ID, &seconds)
 	if err != nil {
@@ -301,31 +644,46 @@ func (lm *leasableMachine) AcquireLease(ctx context.Context, duration time.Durat
 		return fmt.Errorf("missing data from lease response for machine %s, assuming not successful", lm.machine.ID)
 	}
 	lm.leaseNonce = lease.Data.Nonce
+	lm.setLeaseExpiresAt(lease.Data.ExpiresAt, duration)
 	return nil
 }
 
 func (lm *leasableMachine) RefreshLease(ctx context.Context, duration time.Duration) error {
+	return lm.refreshLease(ctx, lm.machine.ID, lm.leaseNonce, duration)
+}
+
+func (lm *leasableMachine) refreshLease(ctx context.Context, machineID, nonce string, duration time.Duration) error {
 	seconds := int(duration.Seconds())
-	refreshedLease, err := lm.flapsClient.RefreshLease(ctx, lm.machine.ID, &seconds, lm.leaseNonce)
+	refreshedLease, err := lm.flapsClient.RefreshLease(ctx, machineID, &seconds, nonce)
 	if err != nil {
 		return err
 	}
 	if refreshedLease.Status != "success" {
-		return fmt.Errorf("did not acquire lease for machine %s status: %s code: %s message: %s", lm.machine.ID, refreshedLease.Status, refreshedLease.Code, refreshedLease.Message)
+		return fmt.Errorf("did not acquire lease for machine %s status: %s code: %s message: %s", machineID, refreshedLease.Status, refreshedLease.Code, refreshedLease.Message)
 	} else if refreshedLease.Data == nil {
-		return fmt.Errorf("missing data from lease response for machine %s, assuming not successful", lm.machine.ID)
-	} else if refreshedLease.Data.Nonce != lm.leaseNonce {
-		return fmt.Errorf("unexpectedly received a new nonce when trying to refresh lease on machine %s", lm.machine.ID)
+		return fmt.Errorf("missing data from lease response for machine %s, assuming not successful", machineID)
+	} else if refreshedLease.Data.Nonce != nonce {
+		return fmt.Errorf("unexpectedly received a new nonce when trying to refresh lease on machine %s", machineID)
 	}
+	lm.setLeaseExpiresAt(refreshedLease.Data.ExpiresAt, duration)
 	return nil
 }
 
+func (lm *leasableMachine) setLeaseExpiresAt(expiresAt int64, duration time.Duration) {
+	if expiresAt == 0 {
+		expiresAt = time.Now().Add(duration).Unix()
+	}
+	lm.leaseExpiresAt.Store(expiresAt)
+}
+
+// StartBackgroundLeaseRefresh keeps refreshing the lease held when it is called until the lease is released,
+// the refreshes don't look at the machine again as it is updated and its lease reset meanwhile
 func (lm *leasableMachine) StartBackgroundLeaseRefresh(ctx context.Context, leaseDuration time.Duration, delayBetween time.Duration) {
 	ctx, lm.leaseRefreshCancelFunc = context.WithCancel(ctx)
-	go lm.refreshLeaseUntilCanceled(ctx, leaseDuration, delayBetween)
+	go lm.refreshLeaseUntilCanceled(ctx, lm.machine.ID, lm.leaseNonce, leaseDuration, delayBetween)
 }
 
-func (lm *leasableMachine) refreshLeaseUntilCanceled(ctx context.Context, duration time.Duration, delayBetween time.Duration) {
+func (lm *leasableMachine) refreshLeaseUntilCanceled(ctx context.Context, machineID, nonce string, duration time.Duration, delayBetween time.Duration) {
 	var (
 		err error
 		b   = &backoff.Backoff{
@@ -335,12 +693,12 @@ func (lm *leasableMachine) refreshLeaseUntilCanceled(ctx context.Context, durati
 		}
 	)
 	for {
-		err = lm.RefreshLease(ctx, duration)
+		err = lm.refreshLease(ctx, machineID, nonce, duration)
 		switch {
 		case errors.Is(err, context.Canceled):
 			return
 		case err != nil:
-			terminal.Warnf("error refreshing lease for machine %s: %v\n", lm.machine.ID, err)
+			terminal.Warnf("error refreshing lease for machine %s: %v\n", machineID, err)
 		}
 		time.Sleep(b.Duration())
 	}
@@ -349,7 +707,8 @@ func (lm *leasableMachine) refreshLeaseUntilCanceled(ctx context.Context, durati
 func (lm *leasableMachine) ReleaseLease(ctx context.Context) error {
 	nonce := lm.leaseNonce
 	lm.resetLease()
-	if nonce == "" {
+	// Leases go away with their machine
+	if nonce == "" || lm.destroyed {
 		return nil
 	}
 	err := lm.flapsClient.ReleaseLease(ctx, lm.machine.ID, nonce)
@@ -362,6 +721,7 @@ func (lm *leasableMachine) ReleaseLease(ctx context.Context) error {
 
 func (lm *leasableMachine) resetLease() {
 	lm.leaseNonce = ""
+	lm.leaseExpiresAt.Store(0)
 	if lm.leaseRefreshCancelFunc != nil {
 		lm.leaseRefreshCancelFunc()
 	}
@@ -0,0 +1,255 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+func Test_isRetryableFlapsError(t *testing.T) {
+	flapsErr := func(code int) error {
+		return &flaps.FlapsError{OriginalError: fmt.Errorf("status %d", code), ResponseStatusCode: code}
+	}
+
+	assert.True(t, isRetryableFlapsError(flapsErr(502)))
+	assert.True(t, isRetryableFlapsError(flapsErr(503)))
+	assert.True(t, isRetryableFlapsError(flapsErr(409)))
+	assert.True(t, isRetryableFlapsError(flapsErr(429)))
+	assert.True(t, isRetryableFlapsError(&url.Error{Op: "Post", URL: "https://api.machines.dev", Err: syscall.ECONNRESET}))
+	assert.True(t, isRetryableFlapsError(fmt.Errorf("reading response: %w", io.ErrUnexpectedEOF)))
+
+	assert.False(t, isRetryableFlapsError(flapsErr(400)))
+	assert.False(t, isRetryableFlapsError(flapsErr(404)))
+	assert.False(t, isRetryableFlapsError(flapsErr(412)))
+	assert.False(t, isRetryableFlapsError(context.Canceled))
+	assert.False(t, isRetryableFlapsError(&url.Error{Op: "Post", URL: "https://api.machines.dev", Err: context.DeadlineExceeded}))
+	assert.False(t, isRetryableFlapsError(errors.New("invalid config")))
+}
+
+func Test_isRetryableUpdateError(t *testing.T) {
+	flapsErr := func(status int) error {
+		return &flaps.FlapsError{ResponseStatusCode: status}
+	}
+	// The transport already retried these
+	assert.False(t, isRetryableUpdateError(flapsErr(502)))
+	assert.False(t, isRetryableUpdateError(flapsErr(503)))
+
+	assert.True(t, isRetryableUpdateError(flapsErr(500)))
+	assert.True(t, isRetryableUpdateError(flapsErr(409)))
+	assert.False(t, isRetryableUpdateError(flapsErr(400)))
+}
+
+// updatingMachine returns a leased machine whose updates are answered with the statuses, once per update
+func updatingMachine(t *testing.T, statuses ...int) (LeasableMachine, *atomic.Int64) {
+	var updates atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(updates.Add(1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		json.NewEncoder(w).Encode(&api.Machine{ID: "m1", State: api.MachineStateStarted, Config: &api.MachineConfig{}})
+	}))
+	t.Cleanup(server.Close)
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{
+		AppName:        "my-app",
+		BaseURL:        baseURL,
+		AuthToken:      "test",
+		RequestOptions: flaps.RequestOptions{MaxRetries: -1},
+	})
+	require.NoError(t, err)
+	ios, _, _, _ := iostreams.Test()
+	lm := NewLeasableMachine(client, ios, &api.Machine{ID: "m1", Config: &api.MachineConfig{}})
+	lm.(*leasableMachine).leaseNonce = "nonce"
+	return lm, &updates
+}
+
+func Test_UpdateRetries(t *testing.T) {
+	lm, updates := updatingMachine(t, http.StatusConflict)
+	require.NoError(t, lm.Update(context.Background(), api.LaunchMachineInput{ID: "m1"}))
+	assert.Equal(t, int64(2), updates.Load())
+
+	// 503s were retried by the transport of the client already
+	lm, updates = updatingMachine(t, http.StatusServiceUnavailable)
+	assert.Error(t, lm.Update(context.Background(), api.LaunchMachineInput{ID: "m1"}))
+	assert.Equal(t, int64(1), updates.Load())
+}
+
+func Test_UpdateCanceledWhileRetrying(t *testing.T) {
+	lm, _ := updatingMachine(t, http.StatusConflict, http.StatusConflict)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := lm.Update(ctx, api.LaunchMachineInput{ID: "m1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var flapsErr *flaps.FlapsError
+	require.ErrorAs(t, err, &flapsErr)
+	assert.Equal(t, http.StatusConflict, flapsErr.ResponseStatusCode)
+}
+
+func Test_retryDelay(t *testing.T) {
+	rateLimited := func(retryAfter time.Duration) error {
+		return &flaps.FlapsError{OriginalError: errors.New("rate limited"), ResponseStatusCode: 429, RetryAfter: retryAfter}
+	}
+
+	assert.Equal(t, 5*time.Second, retryDelay(rateLimited(5*time.Second), time.Second))
+	assert.Equal(t, maxRetryAfter, retryDelay(rateLimited(10*time.Minute), time.Second))
+	assert.Equal(t, 2*time.Second, retryDelay(rateLimited(0), 2*time.Second))
+	assert.Equal(t, time.Second, retryDelay(&flaps.FlapsError{ResponseStatusCode: 503, RetryAfter: time.Minute}, time.Second))
+}
+
+func Test_PollBackoff(t *testing.T) {
+	b := PollBackoff{Min: 100 * time.Millisecond, Max: time.Second}.backoff()
+	for i := 0; i < 10; i++ {
+		d := b.Duration()
+		assert.GreaterOrEqual(t, d, 100*time.Millisecond)
+		assert.LessOrEqual(t, d, time.Second)
+	}
+	b.Reset()
+	assert.Less(t, b.Duration(), 200*time.Millisecond)
+}
+
+func Test_healthCheckBackoff(t *testing.T) {
+	m := &api.Machine{Config: &api.MachineConfig{
+		Checks: map[string]api.MachineCheck{"status": {Interval: api.MustParseDuration("10s")}},
+		Services: []api.MachineService{{
+			Checks: []api.MachineCheck{{Interval: api.MustParseDuration("4s")}, {}},
+		}},
+	}}
+	b := healthCheckBackoff(m)
+	assert.Equal(t, 2*time.Second, b.Min)
+	assert.Equal(t, 8*time.Second, b.Max)
+
+	b = healthCheckBackoff(&api.Machine{Config: &api.MachineConfig{}})
+	assert.Equal(t, time.Minute, b.Min)
+}
+
+func Test_WaitForStateStartedWithoutGets(t *testing.T) {
+	var gets atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/wait") {
+			gets.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{AppName: "my-app", BaseURL: baseURL, AuthToken: "test"})
+	require.NoError(t, err)
+	ios, _, _, _ := iostreams.Test()
+	lm := NewLeasableMachine(client, ios, &api.Machine{ID: "m1", State: api.MachineStateStopped, Config: &api.MachineConfig{}})
+
+	require.NoError(t, lm.WaitForState(context.Background(), api.MachineStateStarted, 5*time.Second, ""))
+	// Events are only fetched when the machine fails to start
+	assert.Zero(t, gets.Load())
+}
+
+func Test_WaitForStatePollsWhenWaitEndpointFails(t *testing.T) {
+	var gets atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/wait") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		state := api.MachineStateStarted
+		if gets.Add(1) < 3 {
+			state = "starting"
+		}
+		json.NewEncoder(w).Encode(&api.Machine{ID: "m1", State: state, Config: &api.MachineConfig{}})
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{AppName: "my-app", BaseURL: baseURL, AuthToken: "test"})
+	require.NoError(t, err)
+	ios, _, _, errOut := iostreams.Test()
+	lm := NewLeasableMachine(client, ios, &api.Machine{ID: "m1", State: api.MachineStateStopped, Config: &api.MachineConfig{}})
+	lm.SetPollBackoff(PollBackoff{Min: time.Millisecond, Max: 10 * time.Millisecond})
+
+	require.NoError(t, lm.WaitForState(context.Background(), api.MachineStateStarted, 5*time.Second, ""))
+	// The machine is polled while the wait endpoint fails
+	assert.GreaterOrEqual(t, gets.Load(), int64(3))
+	assert.Contains(t, errOut.String(), "has state: started")
+}
+
+func Test_WaitForStateFailsWhenMachineExits(t *testing.T) {
+	update := &api.MachineEvent{Type: "update", Status: "updated", Timestamp: 10}
+	var gets atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/wait") {
+			// The machine never gets started, the long poll lasts until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		gets.Add(1)
+		events := []*api.MachineEvent{
+			{Type: "exit", Timestamp: 30, Request: &api.MachineRequest{
+				MonitorEvent: &api.MachineMonitorEvent{ExitEvent: &api.MachineExitEvent{ExitCode: 1}},
+			}},
+			{Type: "start", Status: "started", Timestamp: 20},
+			update,
+		}
+		json.NewEncoder(w).Encode(&api.Machine{ID: "m1", State: "starting", Config: &api.MachineConfig{}, Events: events})
+	}))
+	defer server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := flaps.NewWithOptions(context.Background(), flaps.NewClientOpts{AppName: "my-app", BaseURL: baseURL, AuthToken: "test"})
+	require.NoError(t, err)
+	ios, _, _, errOut := iostreams.Test()
+	lm := NewLeasableMachine(client, ios, &api.Machine{ID: "m1", State: api.MachineStateStopped, Config: &api.MachineConfig{}, Events: []*api.MachineEvent{update}})
+	lm.SetPollBackoff(PollBackoff{Min: time.Millisecond, Max: 10 * time.Millisecond})
+
+	err = lm.WaitForState(context.Background(), api.MachineStateStarted, 200*time.Millisecond, "")
+	assert.EqualError(t, err, "machine m1 failed while starting: process exited with code 1")
+	// The events are fetched once, after the wait timed out
+	assert.Equal(t, int64(1), gets.Load())
+	assert.Contains(t, errOut.String(), "m1 start started\n")
+	assert.Contains(t, errOut.String(), "m1 exit with code 1\n")
+	assert.NotContains(t, errOut.String(), "update")
+}
+
+func Test_machineEvents(t *testing.T) {
+	exit := func(code int, requestedStop bool) *api.MachineEvent {
+		return &api.MachineEvent{Type: "exit", Timestamp: 40, Request: &api.MachineRequest{
+			MonitorEvent: &api.MachineMonitorEvent{ExitEvent: &api.MachineExitEvent{ExitCode: code, RequestedStop: requestedStop}},
+		}}
+	}
+	m := &api.Machine{Events: []*api.MachineEvent{
+		exit(1, false),
+		{Type: "start", Status: "started", Timestamp: 30},
+		{Type: "update", Status: "updated", Timestamp: 20},
+		{Type: "start", Status: "started", Timestamp: 10},
+	}}
+
+	assert.Equal(t, int64(30), lastStartTimestamp(m))
+	events := eventsSince(m, 10)
+	assert.Equal(t, []string{"update", "start", "exit"}, []string{events[0].Type, events[1].Type, events[2].Type})
+
+	assert.EqualError(t, exitFailure(exit(1, false)), "process exited with code 1")
+	assert.NoError(t, exitFailure(exit(0, false)))
+	assert.NoError(t, exitFailure(exit(143, true)))
+	assert.NoError(t, exitFailure(&api.MachineEvent{Type: "start"}))
+}
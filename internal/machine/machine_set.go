@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,8 +14,13 @@ import (
 	"github.com/superfly/flyctl/flaps"
 	"github.com/superfly/flyctl/iostreams"
 	"github.com/superfly/flyctl/terminal"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
 )
 
+// maxConcurrentLeases bounds the lease requests sent at once
+const maxConcurrentLeases = 16
+
 type MachineSet interface {
 	AcquireLeases(context.Context, time.Duration) error
 	ReleaseLeases(context.Context) error
@@ -20,6 +28,11 @@ type MachineSet interface {
 	StartBackgroundLeaseRefresh(context.Context, time.Duration, time.Duration)
 	IsEmpty() bool
 	GetMachines() []LeasableMachine
+	FilterByProcessGroup(groups ...string) MachineSet
+	FilterByRegion(regions ...string) MachineSet
+	Partition(func(LeasableMachine) bool) (MachineSet, MachineSet)
+	DestroyMachines(context.Context, DestroyOptions) ([]DestroyResult, error)
+	WaitForMachines(context.Context, WaitOptions) ([]WaitResult, error)
 }
 
 type machineSet struct {
@@ -36,6 +49,11 @@ func NewMachineSet(flapsClient *flaps.Client, io *iostreams.IOStreams, machines
 	}
 }
 
+// NewLeasableMachineSet is a set of machines already leased or created by the caller, their leases are kept
+func NewLeasableMachineSet(machines []LeasableMachine) MachineSet {
+	return &machineSet{machines: machines}
+}
+
 func (ms *machineSet) IsEmpty() bool {
 	return len(ms.machines) == 0
 }
@@ -44,38 +62,71 @@ func (ms *machineSet) GetMachines() []LeasableMachine {
 	return ms.machines
 }
 
+// FilterByProcessGroup returns the machines of the set in any of the groups
+func (ms *machineSet) FilterByProcessGroup(groups ...string) MachineSet {
+	matching, _ := ms.Partition(func(lm LeasableMachine) bool {
+		return slices.Contains(groups, lm.Machine().ProcessGroup())
+	})
+	return matching
+}
+
+// FilterByRegion returns the machines of the set in any of the regions
+func (ms *machineSet) FilterByRegion(regions ...string) MachineSet {
+	matching, _ := ms.Partition(func(lm LeasableMachine) bool {
+		return slices.Contains(regions, lm.Machine().Region)
+	})
+	return matching
+}
+
+// Partition splits the set in the machines matching the predicate and the rest, both keep the order of the set
+func (ms *machineSet) Partition(predicate func(LeasableMachine) bool) (MachineSet, MachineSet) {
+	matching := &machineSet{machines: make([]LeasableMachine, 0)}
+	rest := &machineSet{machines: make([]LeasableMachine, 0)}
+	for _, lm := range ms.machines {
+		if predicate(lm) {
+			matching.machines = append(matching.machines, lm)
+		} else {
+			rest.machines = append(rest.machines, lm)
+		}
+	}
+	return matching, rest
+}
+
+// AcquireLeases leases every machine of the set, up to maxConcurrentLeases at once.
+// If any lease fails, the acquired ones are released and the error names the machines that couldn't be leased
 func (ms *machineSet) AcquireLeases(ctx context.Context, duration time.Duration) error {
 	if len(ms.machines) == 0 {
 		return nil
 	}
 
-	results := make(chan error, len(ms.machines))
-	var wg sync.WaitGroup
+	var (
+		eg       errgroup.Group
+		mu       sync.Mutex
+		failures []string
+	)
+	eg.SetLimit(maxConcurrentLeases)
 	for _, m := range ms.machines {
-		wg.Add(1)
-		go func(m LeasableMachine) {
-			defer wg.Done()
-			results <- m.AcquireLease(ctx, duration)
-		}(m)
+		m := m
+		eg.Go(func() error {
+			if err := m.AcquireLease(ctx, duration); err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				failures = append(failures, fmt.Sprintf("%s: %v", m.Machine().ID, err))
+			}
+			return nil
+		})
 	}
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	hadError := false
-	for err := range results {
-		if err != nil {
-			hadError = true
-			terminal.Warnf("failed to acquire lease: %v\n", err)
-		}
+	_ = eg.Wait()
+	if len(failures) == 0 {
+		return nil
 	}
-	if hadError {
-		if err := ms.ReleaseLeases(ctx); err != nil {
-			terminal.Warnf("error releasing machine leases: %v\n", err)
-		}
-		return fmt.Errorf("error acquiring leases on all machines")
+
+	// Machines without a lease skip the release
+	if err := ms.ReleaseLeases(ctx); err != nil {
+		terminal.Warnf("error releasing machine leases: %v\n", err)
 	}
-	return nil
+	sort.Strings(failures)
+	return fmt.Errorf("failed to acquire leases on %d of %d machines:\n  %s", len(failures), len(ms.machines), strings.Join(failures, "\n  "))
 }
 
 func (ms *machineSet) RemoveMachines(ctx context.Context, machines []LeasableMachine) error {
@@ -108,8 +159,8 @@ func (ms *machineSet) ReleaseLeases(ctx context.Context) error {
 		return nil
 	}
 
-	// when context is canceled, take 500ms to attempt to release the leases
-	contextWasAlreadyCanceled := errors.Is(ctx.Err(), context.Canceled)
+	// when context is canceled or timed out, take 500ms to attempt to release the leases
+	contextWasAlreadyCanceled := errors.Is(ctx.Err(), context.Canceled) || errors.Is(ctx.Err(), context.DeadlineExceeded)
 	if contextWasAlreadyCanceled {
 		var cancel context.CancelFunc
 		cancelTimeout := 500 * time.Millisecond
@@ -150,3 +201,183 @@ func (ms *machineSet) StartBackgroundLeaseRefresh(ctx context.Context, leaseDura
 		m.StartBackgroundLeaseRefresh(ctx, leaseDuration, delayBetween)
 	}
 }
+
+// DestroyOptions sets how DestroyMachines destroys the machines of a set
+type DestroyOptions struct {
+	// StopTimeout gives started machines this long to stop before they are destroyed, they are killed when zero
+	StopTimeout time.Duration
+	// Timeout bounds the wait for each machine to be gone, defaults to a minute
+	Timeout time.Duration
+	// DryRun returns the machines that would be destroyed without touching them
+	DryRun bool
+	// OnDestroy runs for each machine once it is destroyed, like the post-deletion hook of fly machine destroy
+	OnDestroy func(context.Context, *api.Machine)
+}
+
+// DestroyResult is the outcome of destroying a single machine
+type DestroyResult struct {
+	Machine LeasableMachine
+	Err     error
+}
+
+// DestroyMachines leases, stops and destroys the machines of the set, then waits for them to be gone.
+// Machines already destroyed aren't failures. The results follow the order of the machines
+func (ms *machineSet) DestroyMachines(ctx context.Context, opts DestroyOptions) ([]DestroyResult, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Minute
+	}
+	var (
+		eg       errgroup.Group
+		mu       sync.Mutex
+		failures []string
+	)
+	eg.SetLimit(maxConcurrentLeases)
+	results := make([]DestroyResult, len(ms.machines))
+	for i, m := range ms.machines {
+		i, m := i, m
+		results[i] = DestroyResult{Machine: m}
+		if opts.DryRun {
+			continue
+		}
+		eg.Go(func() error {
+			if err := destroyMachine(ctx, m, opts); err != nil {
+				results[i].Err = err
+				mu.Lock()
+				defer mu.Unlock()
+				failures = append(failures, fmt.Sprintf("%s: %v", m.Machine().ID, err))
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return results, fmt.Errorf("failed to destroy %d of %d machines:\n  %s", len(failures), len(ms.machines), strings.Join(failures, "\n  "))
+	}
+	return results, nil
+}
+
+func destroyMachine(ctx context.Context, lm LeasableMachine, opts DestroyOptions) error {
+	if !lm.HasLease() {
+		if err := lm.AcquireLease(ctx, opts.StopTimeout+opts.Timeout); err != nil {
+			if isNotFoundError(err) {
+				return nil
+			}
+			return fmt.Errorf("failed to acquire lease: %w", err)
+		}
+	}
+	kill := true
+	if opts.StopTimeout > 0 && lm.Machine().State == api.MachineStateStarted {
+		if err := lm.Stop(ctx, ""); err == nil {
+			kill = lm.WaitForState(ctx, api.MachineStateStopped, opts.StopTimeout, "") != nil
+		}
+	}
+	if err := lm.Destroy(ctx, kill); err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if opts.OnDestroy != nil {
+		opts.OnDestroy(ctx, lm.Machine())
+	}
+	if err := lm.WaitForState(ctx, api.MachineStateDestroyed, opts.Timeout, ""); err != nil {
+		return fmt.Errorf("machine wasn't destroyed: %w", err)
+	}
+	return nil
+}
+
+func isNotFoundError(err error) bool {
+	var flapsErr *flaps.FlapsError
+	return errors.As(err, &flapsErr) && flapsErr.ResponseStatusCode == http.StatusNotFound
+}
+
+// WaitOptions sets what WaitForMachines waits for
+type WaitOptions struct {
+	// State to wait for, defaults to started
+	State string
+	// Timeout bounds the wait of each machine, GroupTimeouts overrides it for the machines of some process groups
+	Timeout       time.Duration
+	GroupTimeouts map[string]time.Duration
+	// CheckHealth waits for the health checks of each machine to pass once it reaches the state
+	CheckHealth bool
+	// MaxConcurrent bounds how many machines are waited for at once, all of them when zero
+	MaxConcurrent int
+	// FailFast cancels the remaining waits once a machine fails
+	FailFast bool
+	// LogPrefix prefixes the progress lines of the machine at index i of the set, defaults to [i+1/n]
+	LogPrefix func(i int) string
+}
+
+// WaitResult is the outcome of waiting for a single machine
+type WaitResult struct {
+	Machine LeasableMachine
+	Err     error
+}
+
+// WaitForMachines waits for the machines of the set concurrently. The results follow the order of the machines,
+// waits canceled by FailFast fail with context.Canceled but aren't counted as failures of their own
+func (ms *machineSet) WaitForMachines(ctx context.Context, opts WaitOptions) ([]WaitResult, error) {
+	if opts.State == "" {
+		opts.State = api.MachineStateStarted
+	}
+	if opts.LogPrefix == nil {
+		opts.LogPrefix = func(i int) string { return fmt.Sprintf("[%d/%d]", i+1, len(ms.machines)) }
+	}
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		eg       errgroup.Group
+		mu       sync.Mutex
+		failures []string
+	)
+	if opts.MaxConcurrent > 0 {
+		eg.SetLimit(opts.MaxConcurrent)
+	}
+	results := make([]WaitResult, len(ms.machines))
+	for i, m := range ms.machines {
+		i, m := i, m
+		results[i] = WaitResult{Machine: m}
+		eg.Go(func() error {
+			err := waitForMachine(waitCtx, m, opts, opts.LogPrefix(i))
+			if err == nil {
+				return nil
+			}
+			results[i].Err = err
+			mu.Lock()
+			defer mu.Unlock()
+			if waitCtx.Err() != nil && ctx.Err() == nil && errors.Is(err, context.Canceled) {
+				return nil
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", m.Machine().ID, err))
+			if opts.FailFast {
+				cancel()
+			}
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return results, fmt.Errorf("failed waiting for %d of %d machines:\n  %s", len(failures), len(ms.machines), strings.Join(failures, "\n  "))
+	}
+	return results, nil
+}
+
+func waitForMachine(ctx context.Context, lm LeasableMachine, opts WaitOptions, logPrefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout := opts.Timeout
+	if groupTimeout, ok := opts.GroupTimeouts[lm.Machine().ProcessGroup()]; ok {
+		timeout = groupTimeout
+	}
+	if err := lm.WaitForState(ctx, opts.State, timeout, logPrefix); err != nil {
+		return err
+	}
+	if opts.CheckHealth {
+		return lm.WaitForHealthchecksToPass(ctx, timeout, logPrefix)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+	"github.com/superfly/flyctl/flaps"
+	"github.com/superfly/flyctl/iostreams"
+)
+
+type fakeLeasableMachine struct {
+	LeasableMachine
+	machine  *api.Machine
+	leaseErr error
+	leased   bool
+	// destroyErr fails Destroy, killed records how the machine was destroyed
+	destroyErr error
+	stopped    bool
+	killed     bool
+	inFlight   *atomic.Int64
+	maxSeen    *atomic.Int64
+	updated    *api.LaunchMachineInput
+	// waitFor delays WaitForState, which then fails with waitErr. waitedFor is the timeout it got
+	waitFor   time.Duration
+	waitErr   error
+	waitedFor time.Duration
+	healthy   bool
+}
+
+func (f *fakeLeasableMachine) Machine() *api.Machine {
+	return f.machine
+}
+
+func (f *fakeLeasableMachine) AcquireLease(context.Context, time.Duration) error {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		max := f.maxSeen.Load()
+		if n <= max || f.maxSeen.CompareAndSwap(max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	if f.leaseErr != nil {
+		return f.leaseErr
+	}
+	f.leased = true
+	return nil
+}
+
+func (f *fakeLeasableMachine) HasLease() bool {
+	return f.leased
+}
+
+func (f *fakeLeasableMachine) Stop(context.Context, string) error {
+	f.stopped = true
+	return nil
+}
+
+func (f *fakeLeasableMachine) WaitForState(ctx context.Context, _ string, timeout time.Duration, _ string) error {
+	if f.inFlight != nil {
+		n := f.inFlight.Add(1)
+		defer f.inFlight.Add(-1)
+		for {
+			max := f.maxSeen.Load()
+			if n <= max || f.maxSeen.CompareAndSwap(max, n) {
+				break
+			}
+		}
+	}
+	f.waitedFor = timeout
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(f.waitFor):
+	}
+	return f.waitErr
+}
+
+func (f *fakeLeasableMachine) WaitForHealthchecksToPass(context.Context, time.Duration, string) error {
+	f.healthy = true
+	return nil
+}
+
+func (f *fakeLeasableMachine) Destroy(_ context.Context, kill bool) error {
+	f.killed = kill
+	return f.destroyErr
+}
+
+func (f *fakeLeasableMachine) Update(_ context.Context, input api.LaunchMachineInput) error {
+	f.updated = &input
+	return nil
+}
+
+func (f *fakeLeasableMachine) ReleaseLease(context.Context) error {
+	f.leased = false
+	return nil
+}
+
+func Test_AcquireLeases(t *testing.T) {
+	var inFlight, maxSeen atomic.Int64
+	var machines []LeasableMachine
+	for i := 0; i < 50; i++ {
+		f := &fakeLeasableMachine{machine: &api.Machine{ID: fmt.Sprintf("m%02d", i)}, inFlight: &inFlight, maxSeen: &maxSeen}
+		if i == 7 || i == 3 {
+			f.leaseErr = errors.New("already leased")
+		}
+		machines = append(machines, f)
+	}
+	ms := &machineSet{machines: machines}
+
+	err := ms.AcquireLeases(context.Background(), time.Minute)
+	require.Error(t, err)
+	assert.Equal(t, "failed to acquire leases on 2 of 50 machines:\n  m03: already leased\n  m07: already leased", err.Error())
+	assert.LessOrEqual(t, maxSeen.Load(), int64(maxConcurrentLeases))
+	for _, m := range machines {
+		assert.False(t, m.(*fakeLeasableMachine).leased, m.Machine().ID)
+	}
+
+	ms.machines = machines[10:]
+	require.NoError(t, ms.AcquireLeases(context.Background(), time.Minute))
+	assert.True(t, machines[10].(*fakeLeasableMachine).leased)
+}
+
+func Test_MachineSetFilters(t *testing.T) {
+	ios, _, _, _ := iostreams.Test()
+	ms := NewMachineSet(nil, ios, []*api.Machine{
+		{ID: "m1", Region: "fra", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "web"}}},
+		{ID: "m2", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "worker"}}},
+		{ID: "m3", Region: "ams", Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "web"}}},
+	})
+	ids := func(ms MachineSet) (ids []string) {
+		for _, lm := range ms.GetMachines() {
+			ids = append(ids, lm.Machine().ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"m1", "m3"}, ids(ms.FilterByProcessGroup("web")))
+	assert.Equal(t, []string{"m3"}, ids(ms.FilterByProcessGroup("web").FilterByRegion("ams")))
+	assert.True(t, ms.FilterByRegion("iad").IsEmpty())
+
+	matching, rest := ms.Partition(func(lm LeasableMachine) bool { return lm.Machine().ID == "m2" })
+	assert.Equal(t, []string{"m2"}, ids(matching))
+	assert.Equal(t, []string{"m1", "m3"}, ids(rest))
+}
+
+func Test_DestroyMachines(t *testing.T) {
+	var inFlight, maxSeen atomic.Int64
+	started := &fakeLeasableMachine{machine: &api.Machine{ID: "started", State: api.MachineStateStarted}, leased: true}
+	gone := &fakeLeasableMachine{
+		machine:    &api.Machine{ID: "gone", State: api.MachineStateStopped},
+		inFlight:   &inFlight,
+		maxSeen:    &maxSeen,
+		destroyErr: &flaps.FlapsError{OriginalError: errors.New("not found"), ResponseStatusCode: http.StatusNotFound},
+	}
+	failing := &fakeLeasableMachine{machine: &api.Machine{ID: "failing"}, leased: true, destroyErr: errors.New("boom")}
+	ms := &machineSet{machines: []LeasableMachine{started, gone, failing}}
+
+	results, err := ms.DestroyMachines(context.Background(), DestroyOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.False(t, started.stopped)
+
+	var hooked []string
+	results, err = ms.DestroyMachines(context.Background(), DestroyOptions{
+		StopTimeout: time.Second,
+		OnDestroy:   func(_ context.Context, m *api.Machine) { hooked = append(hooked, m.ID) },
+	})
+	assert.EqualError(t, err, "failed to destroy 1 of 3 machines:\n  failing: boom")
+	assert.NoError(t, results[0].Err)
+	assert.True(t, started.stopped)
+	assert.False(t, started.killed)
+	assert.NoError(t, results[1].Err)
+	assert.True(t, gone.leased)
+	assert.EqualError(t, results[2].Err, "boom")
+	assert.True(t, failing.killed)
+	// Machines already gone or failing to be destroyed don't run the hook
+	assert.Equal(t, []string{"started"}, hooked)
+}
+
+func Test_WaitForMachines(t *testing.T) {
+	var inFlight, maxSeen atomic.Int64
+	var machines []LeasableMachine
+	for i := 0; i < 6; i++ {
+		machines = append(machines, &fakeLeasableMachine{
+			machine:  &api.Machine{ID: fmt.Sprintf("m%d", i), Config: &api.MachineConfig{Metadata: map[string]string{"fly_process_group": "app"}}},
+			waitFor:  5 * time.Millisecond,
+			inFlight: &inFlight,
+			maxSeen:  &maxSeen,
+		})
+	}
+	machines[4].Machine().Config.Metadata["fly_process_group"] = "worker"
+	machines[2].(*fakeLeasableMachine).waitErr = errors.New("timeout")
+	ms := NewLeasableMachineSet(machines)
+
+	results, err := ms.WaitForMachines(context.Background(), WaitOptions{
+		Timeout:       time.Minute,
+		GroupTimeouts: map[string]time.Duration{"worker": time.Hour},
+		CheckHealth:   true,
+		MaxConcurrent: 2,
+	})
+	require.Error(t, err)
+	assert.Equal(t, "failed waiting for 1 of 6 machines:\n  m2: timeout", err.Error())
+	assert.Equal(t, int64(2), maxSeen.Load())
+	require.Len(t, results, 6)
+	for i, result := range results {
+		f := machines[i].(*fakeLeasableMachine)
+		assert.Equal(t, machines[i], result.Machine)
+		assert.Equal(t, lo.Ternary(i == 4, time.Hour, time.Minute), f.waitedFor)
+		if i == 2 {
+			assert.EqualError(t, result.Err, "timeout")
+			assert.False(t, f.healthy)
+		} else {
+			assert.NoError(t, result.Err)
+			assert.True(t, f.healthy)
+		}
+	}
+}
+
+func Test_WaitForMachines_failFast(t *testing.T) {
+	ok := &fakeLeasableMachine{machine: &api.Machine{ID: "ok"}}
+	failed := &fakeLeasableMachine{machine: &api.Machine{ID: "failed"}, waitFor: time.Millisecond, waitErr: errors.New("timeout")}
+	slow := &fakeLeasableMachine{machine: &api.Machine{ID: "slow"}, waitFor: time.Minute}
+	ms := NewLeasableMachineSet([]LeasableMachine{ok, failed, slow})
+
+	results, err := ms.WaitForMachines(context.Background(), WaitOptions{FailFast: true})
+	require.Error(t, err)
+	// The canceled wait isn't a failure of its own
+	assert.Equal(t, "failed waiting for 1 of 3 machines:\n  failed: timeout", err.Error())
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.EqualError(t, results[1].Err, "timeout")
+	assert.ErrorIs(t, results[2].Err, context.Canceled)
+}
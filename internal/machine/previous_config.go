@@ -0,0 +1,142 @@
+package machine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/samber/lo"
+	"github.com/superfly/flyctl/api"
+	"golang.org/x/exp/slices"
+)
+
+// maxPreviousConfigSize is the largest encoded config stashed in the metadata of a machine,
+// metadata is sent on every machine read so larger configs only keep their image and hash
+const maxPreviousConfigSize = 4096
+
+var previousConfigKeys = []string{
+	api.MachineConfigMetadataKeyFlyPreviousImage,
+	api.MachineConfigMetadataKeyFlyPreviousConfig,
+	api.MachineConfigMetadataKeyFlyPreviousHash,
+}
+
+// StashPreviousConfig keeps the image and config a machine ran before an update in the metadata
+// of its new config, so it can be rolled back without the fly.toml it was deployed from.
+// The config is stored gzipped and base64 encoded when it fits in maxPreviousConfigSize,
+// with the digests of its env values instead of the values
+func StashPreviousConfig(mConfig, prev *api.MachineConfig) error {
+	if mConfig == nil || prev == nil {
+		return nil
+	}
+	prev = CloneConfig(prev)
+	// Don't nest the config stashed by the previous update
+	prev.Metadata = lo.OmitByKeys(prev.Metadata, previousConfigKeys)
+	if prev.Env != nil {
+		prev.Env = lo.MapValues(prev.Env, func(v string, _ string) string { return envDigest(v) })
+	}
+
+	raw, err := json.Marshal(prev)
+	if err != nil {
+		return fmt.Errorf("failed to encode previous machine config: %w", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return fmt.Errorf("failed to compress previous machine config: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to compress previous machine config: %w", err)
+	}
+
+	if mConfig.Metadata == nil {
+		mConfig.Metadata = map[string]string{}
+	}
+	mConfig.Metadata[api.MachineConfigMetadataKeyFlyPreviousImage] = prev.Image
+	mConfig.Metadata[api.MachineConfigMetadataKeyFlyPreviousHash] = configHash(raw)
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	if len(encoded) <= maxPreviousConfigSize {
+		mConfig.Metadata[api.MachineConfigMetadataKeyFlyPreviousConfig] = encoded
+	} else {
+		delete(mConfig.Metadata, api.MachineConfigMetadataKeyFlyPreviousConfig)
+	}
+	return nil
+}
+
+// PreviousConfig decodes the config stashed in the metadata of the machine by its last update,
+// its env has the digests of the values
+func PreviousConfig(m *api.Machine) (*api.MachineConfig, error) {
+	if m.Config == nil {
+		return nil, fmt.Errorf("machine %s has no config", m.ID)
+	}
+	metadata := m.Config.Metadata
+	encoded, ok := metadata[api.MachineConfigMetadataKeyFlyPreviousConfig]
+	if !ok {
+		if image := metadata[api.MachineConfigMetadataKeyFlyPreviousImage]; image != "" {
+			return nil, fmt.Errorf("machine %s previous config was too large to be kept, only its image is known: %s", m.ID, image)
+		}
+		return nil, fmt.Errorf("machine %s has no previous config", m.ID)
+	}
+
+	zipped, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode previous config of machine %s: %w", m.ID, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(zipped))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress previous config of machine %s: %w", m.ID, err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress previous config of machine %s: %w", m.ID, err)
+	}
+	if hash := metadata[api.MachineConfigMetadataKeyFlyPreviousHash]; hash != configHash(raw) {
+		return nil, fmt.Errorf("previous config of machine %s doesn't match its hash %s", m.ID, hash)
+	}
+
+	var prev api.MachineConfig
+	if err := json.Unmarshal(raw, &prev); err != nil {
+		return nil, fmt.Errorf("failed to decode previous config of machine %s: %w", m.ID, err)
+	}
+	return &prev, nil
+}
+
+// RestoredConfig is the config restoring the one the machine ran before its last update. Env values
+// are taken from its current config where their digest matches the stashed one, the keys whose previous
+// value is unknown are returned: they keep their current value, or are left out when they are gone
+func RestoredConfig(m *api.Machine) (*api.MachineConfig, []string, error) {
+	prev, err := PreviousConfig(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unknown []string
+	for key, digest := range prev.Env {
+		current, ok := m.Config.Env[key]
+		switch {
+		case !ok:
+			delete(prev.Env, key)
+		case envDigest(current) == digest:
+			prev.Env[key] = current
+			continue
+		default:
+			prev.Env[key] = current
+		}
+		unknown = append(unknown, key)
+	}
+	slices.Sort(unknown)
+	return prev, unknown, nil
+}
+
+// envDigest is what the stashed config keeps of an env value, like release definitions with redacted env
+func envDigest(value string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(value)))
+}
+
+func configHash(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,78 @@
+package machine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/superfly/flyctl/api"
+)
+
+func Test_StashPreviousConfig(t *testing.T) {
+	prev := &api.MachineConfig{
+		Image: "registry.fly.io/app:deployment-1",
+		Env:   map[string]string{"FOO": "bar"},
+		Metadata: map[string]string{
+			"fly_release_version": "1",
+			"fly_previous_image":  "registry.fly.io/app:deployment-0",
+		},
+	}
+	next := &api.MachineConfig{Image: "registry.fly.io/app:deployment-2"}
+	require.NoError(t, StashPreviousConfig(next, prev))
+	assert.Equal(t, "registry.fly.io/app:deployment-1", next.Metadata["fly_previous_image"])
+	assert.Len(t, next.Metadata["fly_previous_config_hash"], 16)
+
+	// Env values aren't kept, only their digests
+	assert.NotContains(t, next.Metadata["fly_previous_config"], "bar")
+	m := &api.Machine{ID: "m1", Region: "fra", Config: next}
+	prevConfig, err := PreviousConfig(m)
+	require.NoError(t, err)
+	assert.Equal(t, envDigest("bar"), prevConfig.Env["FOO"])
+
+	// The env values come from the current config, the config stashed by the update before isn't nested
+	next.Env = map[string]string{"FOO": "bar"}
+	restored, unknown, err := RestoredConfig(m)
+	require.NoError(t, err)
+	assert.Empty(t, unknown)
+	assert.Equal(t, &api.MachineConfig{
+		Image:    "registry.fly.io/app:deployment-1",
+		Env:      map[string]string{"FOO": "bar"},
+		Metadata: map[string]string{"fly_release_version": "1"},
+	}, restored)
+
+	next.Metadata["fly_previous_config_hash"] = "0000000000000000"
+	_, err = PreviousConfig(m)
+	assert.ErrorContains(t, err, "doesn't match its hash")
+
+	// Large configs only keep their image
+	prev.Init.Cmd = []string{randomString(2 * maxPreviousConfigSize)}
+	require.NoError(t, StashPreviousConfig(next, prev))
+	assert.NotContains(t, next.Metadata, "fly_previous_config")
+	_, err = PreviousConfig(m)
+	assert.EqualError(t, err, "machine m1 previous config was too large to be kept, only its image is known: registry.fly.io/app:deployment-1")
+}
+
+func Test_RestoredConfig_changedEnv(t *testing.T) {
+	prev := &api.MachineConfig{Image: "app:v1", Env: map[string]string{"KEPT": "a", "CHANGED": "b", "REMOVED": "c"}}
+	next := &api.MachineConfig{Image: "app:v2", Env: map[string]string{"KEPT": "a", "CHANGED": "B", "ADDED": "d"}}
+	require.NoError(t, StashPreviousConfig(next, prev))
+
+	restored, unknown, err := RestoredConfig(&api.Machine{ID: "m1", Config: next})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CHANGED", "REMOVED"}, unknown)
+	assert.Equal(t, map[string]string{"KEPT": "a", "CHANGED": "B"}, restored.Env)
+}
+
+// randomString doesn't compress, unlike a repeated string
+func randomString(n int) string {
+	var sb strings.Builder
+	x := uint32(2463534242)
+	for sb.Len() < n {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		sb.WriteByte(byte('a' + x%26))
+	}
+	return sb.String()
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/superfly/flyctl/internal/buildinfo"
+)
+
+const (
+	scopeName = "github.com/superfly/flyctl"
+
+	// OTLP span kind and status codes
+	spanKindInternal = 1
+	statusCodeOK     = 1
+	statusCodeError  = 2
+)
+
+// Flush exports the ended spans to the OTLP endpoint. Spans are dropped once
+// sent, even if the endpoint rejects them.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(exportRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans to %s: got status %d", t.endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// exportRequest builds the JSON encoding of an OTLP ExportTraceServiceRequest.
+func exportRequest(spans []*Span) map[string]any {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, encodeSpan(s))
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": encodeAttributes([]Attribute{
+						String("service.name", "flyctl"),
+						String("service.version", buildinfo.Version().String()),
+					}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": scopeName},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+func encodeSpan(s *Span) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := map[string]any{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              spanKindInternal,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        encodeAttributes(s.attrs),
+		"status":            map[string]any{"code": statusCodeOK},
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		span["status"] = map[string]any{"code": statusCodeError, "message": s.err.Error()}
+		span["events"] = []any{
+			map[string]any{
+				"name":         "exception",
+				"timeUnixNano": strconv.FormatInt(s.end.UnixNano(), 10),
+				"attributes":   encodeAttributes([]Attribute{String("exception.message", s.err.Error())}),
+			},
+		}
+	}
+	return span
+}
+
+func encodeAttributes(attrs []Attribute) []any {
+	encoded := make([]any, 0, len(attrs))
+	for _, attr := range attrs {
+		var value map[string]any
+		switch v := attr.Value.(type) {
+		case int:
+			// OTLP JSON encodes 64 bit integers as strings
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]any{"key": attr.Key, "value": value})
+	}
+	return encoded
+}
@@ -0,0 +1,196 @@
+// Package tracing records OpenTelemetry spans around flyctl operations and
+// exports them over OTLP/HTTP with JSON encoding when the standard
+// OTEL_EXPORTER_OTLP_* env vars are set. Without them no tracer is created and
+// spans are no-ops.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	envTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	envHeaders        = "OTEL_EXPORTER_OTLP_HEADERS"
+	envTracesHeaders  = "OTEL_EXPORTER_OTLP_TRACES_HEADERS"
+	envProtocol       = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	envTracesProtocol = "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL"
+
+	// protocolHTTPJSON is the only OTLP protocol spans are exported with
+	protocolHTTPJSON = "http/json"
+)
+
+// Tracer collects ended spans until they are flushed to the OTLP endpoint.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// NewTracerFromEnv returns a Tracer exporting to the endpoint of the
+// OTEL_EXPORTER_OTLP_* env vars. It returns nil in case none is set, and an
+// error in case they ask for another protocol than http/json.
+func NewTracerFromEnv() (*Tracer, error) {
+	endpoint := os.Getenv(envTracesEndpoint)
+	if endpoint == "" {
+		base := os.Getenv(envEndpoint)
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+
+	protocol := os.Getenv(envTracesProtocol)
+	if protocol == "" {
+		protocol = os.Getenv(envProtocol)
+	}
+	if protocol != "" && protocol != protocolHTTPJSON {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, spans can only be exported with %s", protocol, protocolHTTPJSON)
+	}
+
+	headers := parseHeaders(os.Getenv(envHeaders))
+	for k, v := range parseHeaders(os.Getenv(envTracesHeaders)) {
+		headers[k] = v
+	}
+
+	return NewTracer(endpoint, headers), nil
+}
+
+// NewTracer returns a Tracer exporting to the OTLP/HTTP traces endpoint.
+func NewTracer(endpoint string, headers map[string]string) *Tracer {
+	return &Tracer{
+		endpoint: endpoint,
+		headers:  headers,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// parseHeaders parses the "key1=value1,key2=value2" format of the OTLP
+// headers env vars.
+func parseHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			continue
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+// Attribute is a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string Attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an integer Attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation of a trace. A nil Span is valid and records
+// nothing, it's what Start returns when ctx carries no Tracer.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+
+	mu    sync.Mutex
+	attrs []Attribute
+	err   error
+}
+
+// Start starts a span named name, child of the span ctx carries if any. The
+// returned context carries the new span.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	tracer := FromContext(ctx)
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: tracer,
+		name:   name,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span, marking it failed in case err isn't nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+type (
+	tracerContextKey struct{}
+	spanContextKey   struct{}
+)
+
+// NewContext derives a context that carries t from ctx.
+func NewContext(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerContextKey{}, t)
+}
+
+// FromContext returns the Tracer ctx carries. It returns nil in case ctx
+// carries no Tracer.
+func FromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerContextKey{}).(*Tracer)
+	return t
+}
+
+// SpanFromContext returns the span ctx carries. It returns nil in case ctx
+// carries no span.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanContextKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTracerFromEnv(t *testing.T) {
+	t.Setenv(envEndpoint, "")
+	t.Setenv(envTracesEndpoint, "")
+	t.Setenv(envProtocol, "")
+	t.Setenv(envTracesProtocol, "")
+	tracer, err := NewTracerFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, tracer)
+
+	t.Setenv(envEndpoint, "http://collector:4318/")
+	t.Setenv(envHeaders, "authorization=Bearer abc, x-team=infra")
+	t.Setenv(envTracesHeaders, "x-team=deploys")
+	tracer, err = NewTracerFromEnv()
+	require.NoError(t, err)
+	require.NotNil(t, tracer)
+	assert.Equal(t, "http://collector:4318/v1/traces", tracer.endpoint)
+	assert.Equal(t, map[string]string{"authorization": "Bearer abc", "x-team": "deploys"}, tracer.headers)
+
+	t.Setenv(envTracesEndpoint, "http://traces:4318/custom")
+	tracer, err = NewTracerFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "http://traces:4318/custom", tracer.endpoint)
+
+	t.Setenv(envProtocol, "http/json")
+	_, err = NewTracerFromEnv()
+	assert.NoError(t, err)
+
+	// The traces protocol takes precedence, only JSON over HTTP is exported
+	t.Setenv(envTracesProtocol, "grpc")
+	_, err = NewTracerFromEnv()
+	assert.EqualError(t, err, `unsupported OTLP protocol "grpc", spans can only be exported with http/json`)
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop", String("app", "my-app"))
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	// Nil spans must be safe to use
+	span.SetAttributes(Int("count", 1))
+	span.End(errors.New("boom"))
+}
+
+func TestFlush(t *testing.T) {
+	var got map[string]any
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL, map[string]string{"authorization": "Bearer abc"})
+	ctx := NewContext(context.Background(), tracer)
+
+	ctx, parent := Start(ctx, "deploy", String("app", "my-app"))
+	_, child := Start(ctx, "update machine", String("machine.id", "m1"))
+	child.SetAttributes(Int("release.version", 3))
+	child.End(errors.New("lease expired"))
+	parent.End(nil)
+
+	require.NoError(t, tracer.Flush(context.Background()))
+	assert.Equal(t, "Bearer abc", authorization)
+
+	spans := got["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	require.Len(t, spans, 2)
+
+	updated, deployed := spans[0].(map[string]any), spans[1].(map[string]any)
+	assert.Equal(t, "update machine", updated["name"])
+	assert.Equal(t, deployed["traceId"], updated["traceId"])
+	assert.Equal(t, deployed["spanId"], updated["parentSpanId"])
+	assert.NotContains(t, deployed, "parentSpanId")
+	assert.Equal(t, map[string]any{"code": float64(statusCodeError), "message": "lease expired"}, updated["status"])
+	assert.Equal(t, []any{
+		map[string]any{"key": "machine.id", "value": map[string]any{"stringValue": "m1"}},
+		map[string]any{"key": "release.version", "value": map[string]any{"intValue": "3"}},
+	}, updated["attributes"])
+	assert.Equal(t, map[string]any{"code": float64(statusCodeOK)}, deployed["status"])
+
+	// Spans are only sent once
+	got = nil
+	require.NoError(t, tracer.Flush(context.Background()))
+	assert.Nil(t, got)
+}